@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GoogleAccount is a Google account connected to the widget.
+type GoogleAccount struct {
+	ID      string `json:"id"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+	Active  bool   `json:"active"`
+}
+
+func ensureAccountsTable(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS accounts (
+		id TEXT PRIMARY KEY,
+		email TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		picture TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	// Events synced before accounts existed belong to the single account of that time.
+	if _, err := db.Exec(`UPDATE events SET account_id = ? WHERE account_id IS NULL AND COALESCE(remote_id,'') != ''`, defaultAccountID); err != nil {
+		return fmt.Errorf("backfill event accounts: %w", err)
+	}
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO accounts (id)
+		SELECT ? WHERE EXISTS (SELECT 1 FROM events WHERE account_id = ?) OR EXISTS (SELECT 1 FROM calendars WHERE account_id = ?)
+	`, defaultAccountID, defaultAccountID, defaultAccountID)
+	return err
+}
+
+// ListAccounts returns the connected Google accounts.
+func (a *App) ListAccounts() ([]GoogleAccount, error) {
+	accounts, err := a.loadAccounts()
+	if err != nil {
+		return nil, err
+	}
+	active := a.activeAccountID()
+	for i := range accounts {
+		accounts[i].Active = accounts[i].ID == active
+	}
+	return accounts, nil
+}
+
+// SetActiveAccount selects the account used for new events, holidays and token info.
+func (a *App) SetActiveAccount(id string) error {
+	if a.db == nil {
+		return errors.New("db not initialised")
+	}
+	var exists int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM accounts WHERE id = ?`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return errors.New("account not found")
+	}
+	_, err := a.modifySettings(func(cfg *AppSettings) { cfg.ActiveAccountID = id })
+	return err
+}
+
+// GoogleLogoutAccount disconnects one account and removes only its cached data.
+func (a *App) GoogleLogoutAccount(id string) error {
+	if a.db == nil {
+		return errors.New("db not initialised")
+	}
+	if id == "" {
+		return errors.New("id required")
+	}
+	store, err := accountTokenStore(id)
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(); err != nil {
+		return err
+	}
+	if err := a.clearAccountData(id); err != nil {
+		return err
+	}
+	_, err = a.modifySettings(func(cfg *AppSettings) {
+		if cfg.ActiveAccountID == id {
+			cfg.ActiveAccountID = ""
+		}
+	})
+	return err
+}
+
+// activeAccountID returns the selected account, falling back to the oldest connected one.
+func (a *App) activeAccountID() string {
+	if a.db == nil {
+		return ""
+	}
+	var id string
+	if active := a.currentSettings().ActiveAccountID; active != "" {
+		if err := a.db.QueryRow(`SELECT id FROM accounts WHERE id = ?`, active).Scan(&id); err == nil {
+			return id
+		}
+	}
+	if err := a.db.QueryRow(`SELECT id FROM accounts ORDER BY created_at ASC, id ASC LIMIT 1`).Scan(&id); err != nil {
+		return ""
+	}
+	return id
+}
+
+func (a *App) loadAccounts() ([]GoogleAccount, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	rows, err := a.db.Query(`SELECT id, email, name, picture FROM accounts ORDER BY created_at ASC, id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var accounts []GoogleAccount
+	for rows.Next() {
+		var acc GoogleAccount
+		if err := rows.Scan(&acc.ID, &acc.Email, &acc.Name, &acc.Picture); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, acc)
+	}
+	return accounts, rows.Err()
+}
+
+// googleForAccount returns a sync service reading tokens from the account's own store.
+func (a *App) googleForAccount(id string) (*GoogleSyncService, error) {
+	google := a.googleService()
+	if google == nil {
+		return nil, errors.New("google sync not initialised")
+	}
+	if id == "" {
+		return nil, errors.New("no google account connected")
+	}
+	store, err := accountTokenStore(id)
+	if err != nil {
+		return nil, err
+	}
+	return google.WithTokenStore(store), nil
+}
+
+// connectGoogleAccount exchanges an auth code, identifies the account it belongs to and
+// stores its tokens. Signing in again with a known email reuses that account.
+func (a *App) connectGoogleAccount(ctx context.Context, code string) (OAuthTokens, error) {
+	google := a.googleService()
+	if google == nil {
+		return OAuthTokens{}, errors.New("google sync not initialised")
+	}
+	tokens, err := google.WithTokenStore(nil).ExchangeCode(ctx, code)
+	if err != nil {
+		return OAuthTokens{}, err
+	}
+	user, err := google.FetchUserInfo(ctx, tokens.AccessToken)
+	if err != nil {
+		return OAuthTokens{}, fmt.Errorf("identify account: %w", err)
+	}
+	id, err := a.upsertAccount("", user)
+	if err != nil {
+		return OAuthTokens{}, err
+	}
+	store, err := accountTokenStore(id)
+	if err != nil {
+		return OAuthTokens{}, err
+	}
+	if tokens.RefreshToken == "" {
+		if stored, err := store.Load(); err == nil {
+			tokens.RefreshToken = stored.RefreshToken
+		}
+	}
+	if err := store.Save(tokens); err != nil {
+		return OAuthTokens{}, fmt.Errorf("save tokens: %w", err)
+	}
+	if err := a.SetActiveAccount(id); err != nil {
+		return OAuthTokens{}, err
+	}
+	return tokens, nil
+}
+
+// upsertAccount records profile data for an account. With an empty id the account is
+// matched by email, or created when the email is new.
+func (a *App) upsertAccount(id string, user GoogleUserInfo) (string, error) {
+	if a.db == nil {
+		return "", errors.New("db not initialised")
+	}
+	email := strings.ToLower(strings.TrimSpace(user.Email))
+	if id == "" && email != "" {
+		if err := a.db.QueryRow(`SELECT id FROM accounts WHERE LOWER(email) = ? LIMIT 1`, email).Scan(&id); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+	}
+	if id == "" {
+		id = fmt.Sprintf("acct-%d", time.Now().UnixNano())
+	}
+	_, err := a.db.Exec(`
+		INSERT INTO accounts (id, email, name, picture, created_at, updated_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT(id) DO UPDATE SET email=excluded.email, name=excluded.name, picture=excluded.picture, updated_at=excluded.updated_at
+	`, id, user.Email, user.Name, user.Picture)
+	return id, err
+}
+
+// clearAccountData removes an account's cached events, calendars and sync state.
+// Local events never pushed to that account are left alone.
+func (a *App) clearAccountData(id string) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM event_conflicts WHERE event_id IN (SELECT id FROM events WHERE account_id = ?)`, id); err != nil {
+		return fmt.Errorf("clear conflicts: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM conflict_history WHERE event_id IN (SELECT id FROM events WHERE account_id = ?)`, id); err != nil {
+		return fmt.Errorf("clear conflict history: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM outbox WHERE event_id IN (SELECT id FROM events WHERE account_id = ?)`, id); err != nil {
+		return fmt.Errorf("clear outbox: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM events WHERE account_id = ?`, id); err != nil {
+		return fmt.Errorf("clear events: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM calendars WHERE account_id = ?`, id); err != nil {
+		return fmt.Errorf("clear calendars: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM calendar_sync_state WHERE account_id = ?`, id); err != nil {
+		return fmt.Errorf("clear sync state: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM accounts WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("remove account: %w", err)
+	}
+	return tx.Commit()
+}
+
+// accountTokenStore returns the token file for one account.
+func accountTokenStore(id string) (*FileTokenStore, error) {
+	appDir, err := appConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return &FileTokenStore{path: filepath.Join(appDir, "google_tokens", id+".json")}, nil
+}
+
+// migrateLegacyTokens moves the single-account google_tokens.json to the default account.
+func (a *App) migrateLegacyTokens(appDir string) error {
+	legacy := filepath.Join(appDir, "google_tokens.json")
+	if _, err := os.Stat(legacy); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	store, err := accountTokenStore(defaultAccountID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(store.path), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(legacy, store.path); err != nil {
+		return err
+	}
+	_, err = a.db.Exec(`INSERT OR IGNORE INTO accounts (id) VALUES (?)`, defaultAccountID)
+	return err
+}
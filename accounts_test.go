@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLogoutRemovesOnlyThatAccount(t *testing.T) {
+	a := newTestApp(t)
+	for _, acct := range []string{defaultAccountID, "work"} {
+		if _, err := a.upsertAccount(acct, GoogleUserInfo{Email: acct + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		store, err := accountTokenStore(acct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Save(OAuthTokens{AccessToken: acct + "-token", Expiry: time.Now().Add(time.Hour).Format(time.RFC3339)}); err != nil {
+			t.Fatal(err)
+		}
+		mustExec(t, a, `INSERT INTO calendars (account_id, id, summary, is_primary) VALUES (?, 'primary', ?, 1)`, acct, acct)
+		mustExec(t, a, `INSERT INTO calendar_sync_state (account_id, calendar_id, sync_token) VALUES (?, 'primary', 'token')`, acct)
+		mustExec(t, a, `INSERT INTO events (id, title, start, end, sync_status, provider, account_id, google_calendar_id, remote_id) VALUES (?, ?, '2024-05-02T09:00:00Z', '2024-05-02T10:00:00Z', 'dirty', 'google', ?, 'primary', ?)`, acct+"-event", acct, acct, "g-"+acct)
+		mustExec(t, a, `INSERT INTO outbox (event_id, op, created_at) VALUES (?, 'update', CURRENT_TIMESTAMP)`, acct+"-event")
+	}
+	if err := a.SetActiveAccount("work"); err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, a, `INSERT INTO events (id, title, start, end) VALUES ('offline', 'offline', '2024-05-02T09:00:00Z', '2024-05-02T10:00:00Z')`)
+
+	if err := a.GoogleLogoutAccount("work"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"accounts WHERE id", "events WHERE account_id", "calendars WHERE account_id", "calendar_sync_state WHERE account_id"} {
+		if n := countRows(t, a, `SELECT COUNT(*) FROM `+table+` = 'work'`); n != 0 {
+			t.Fatalf("%d rows left in %s", n, table)
+		}
+		if n := countRows(t, a, `SELECT COUNT(*) FROM `+table+` = ?`, defaultAccountID); n != 1 {
+			t.Fatalf("%d rows of the other account in %s", n, table)
+		}
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM outbox WHERE event_id = 'work-event'`); n != 0 {
+		t.Fatalf("%d outbox entries left", n)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM outbox WHERE event_id = ?`, defaultAccountID+"-event"); n != 1 {
+		t.Fatalf("%d outbox entries of the other account", n)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events WHERE id = 'offline'`); n != 1 {
+		t.Fatal("local event removed")
+	}
+
+	work, err := accountTokenStore("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := work.Load(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("work tokens: %v", err)
+	}
+	kept, err := accountTokenStore(defaultAccountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens, err := kept.Load(); err != nil || tokens.AccessToken != defaultAccountID+"-token" {
+		t.Fatalf("other tokens: %+v %v", tokens, err)
+	}
+	if got := a.currentSettings().ActiveAccountID; got != "" {
+		t.Fatalf("active account %q", got)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultAPIServerAddr applies when AppSettings.APIServerAddr is empty; it only
+	// accepts connections from this machine.
+	defaultAPIServerAddr = "127.0.0.1:5233"
+	// maxAPIRequestBytes bounds the request bodies the API reads.
+	maxAPIRequestBytes = 1 << 20
+)
+
+// apiServer runs the JSON API selected in the settings, letting scripts on this machine
+// read and edit the events.
+type apiServer struct {
+	app  *App
+	emit func(name string, data ...interface{})
+
+	mu     sync.Mutex
+	config apiServerConfig
+	srv    *http.Server
+	// addr is the address listened on, which tells the port chosen for ":0".
+	addr string
+}
+
+// apiServerConfig is what the API server runs with.
+type apiServerConfig struct {
+	enabled bool
+	addr    string
+	token   string
+}
+
+func apiServerConfigOf(cfg AppSettings) apiServerConfig {
+	if !cfg.APIServerEnabled {
+		return apiServerConfig{}
+	}
+	return apiServerConfig{
+		enabled: true,
+		addr:    firstNonEmpty(strings.TrimSpace(cfg.APIServerAddr), defaultAPIServerAddr),
+		token:   cfg.APIServerToken,
+	}
+}
+
+// validateAPIServerSettings checks the API server settings of cfg when it is enabled.
+func validateAPIServerSettings(cfg AppSettings) error {
+	c := apiServerConfigOf(cfg)
+	if !c.enabled {
+		return nil
+	}
+	if c.token == "" {
+		return errors.New("api server needs a token")
+	}
+	if _, _, err := net.SplitHostPort(c.addr); err != nil {
+		return fmt.Errorf("invalid api server address: %w", err)
+	}
+	return nil
+}
+
+// newAPIToken returns a random token for the API server.
+func newAPIToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// startAPIServer starts the API server if the settings enable it; UpdateSettings
+// restarts or stops it as they change.
+func (a *App) startAPIServer(emit func(name string, data ...interface{})) {
+	a.api = &apiServer{app: a, emit: emit}
+	if err := a.api.apply(a.currentSettings()); err != nil {
+		fmt.Printf("api server: %v\n", err)
+	}
+}
+
+// apply brings the server in line with cfg, restarting it when its address or token
+// changed.
+func (s *apiServer) apply(cfg AppSettings) error {
+	want := apiServerConfigOf(cfg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if want == s.config && (s.srv != nil) == want.enabled {
+		return nil
+	}
+	s.stopLocked()
+	if !want.enabled {
+		return nil
+	}
+	h := newAPIHandler(s.app, want.token, func() {
+		if s.emit != nil {
+			s.emit(EventCalendarChanged)
+		}
+	})
+	srv, addr, err := serveLocal("api server", want.addr, h)
+	if err != nil {
+		return err
+	}
+	s.srv, s.config, s.addr = srv, want, addr
+	return nil
+}
+
+// stop shuts the server down.
+func (s *apiServer) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopLocked()
+}
+
+func (s *apiServer) stopLocked() {
+	if s.srv != nil {
+		s.srv.Close()
+	}
+	s.srv, s.config, s.addr = nil, apiServerConfig{}, ""
+}
+
+// apiHandler serves the event methods of App as a JSON API to requests carrying the
+// token as "Authorization: Bearer <token>". Events travel in the CalendarEvent shape the
+// frontend uses, and writes go through the same methods, so they are validated and
+// synced like edits made in the widget.
+//
+//	GET    /api/events                                ListEvents
+//	GET    /api/events/search?q=&start=&end=&limit=   SearchEvents
+//	GET    /api/events/{id}                           one event
+//	POST   /api/events                                CreateEvent
+//	PUT    /api/events/{id}                           UpdateEvent
+//	DELETE /api/events/{id}                           DeleteEvent
+//	POST   /api/sync                                  GoogleSync
+//
+// Failures are answered with {"error": "..."}.
+type apiHandler struct {
+	app   *App
+	token string
+	// changed is called after a request changed events.
+	changed func()
+	mux     *http.ServeMux
+}
+
+func newAPIHandler(a *App, token string, changed func()) *apiHandler {
+	h := &apiHandler{app: a, token: token, changed: changed, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /api/events", h.listEvents)
+	h.mux.HandleFunc("GET /api/events/search", h.searchEvents)
+	h.mux.HandleFunc("GET /api/events/{id}", h.getEvent)
+	h.mux.HandleFunc("POST /api/events", h.createEvent)
+	h.mux.HandleFunc("PUT /api/events/{id}", h.updateEvent)
+	h.mux.HandleFunc("DELETE /api/events/{id}", h.deleteEvent)
+	h.mux.HandleFunc("POST /api/sync", h.sync)
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="calendar widget"`)
+		writeAPIError(w, http.StatusUnauthorized, errors.New("missing or wrong token"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIRequestBytes)
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *apiHandler) listEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := h.app.ListEvents()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAPIEvents(w, events)
+}
+
+func (h *apiHandler) searchEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %w", err))
+			return
+		}
+		limit = n
+	}
+	events, err := h.app.SearchEvents(q.Get("q"), q.Get("start"), q.Get("end"), limit)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeAPIEvents(w, events)
+}
+
+func (h *apiHandler) getEvent(w http.ResponseWriter, r *http.Request) {
+	e, status, err := h.event(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, status, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, e)
+}
+
+func (h *apiHandler) createEvent(w http.ResponseWriter, r *http.Request) {
+	var e CalendarEvent
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid event: %w", err))
+		return
+	}
+	created, err := h.app.CreateEvent(withStoredIdentity(e, CalendarEvent{}))
+	if err != nil {
+		writeAPIError(w, writeErrorStatus(err), err)
+		return
+	}
+	h.wrote()
+	writeAPIJSON(w, http.StatusCreated, created)
+}
+
+func (h *apiHandler) updateEvent(w http.ResponseWriter, r *http.Request) {
+	stored, status, err := h.event(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, status, err)
+		return
+	}
+	var e CalendarEvent
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid event: %w", err))
+		return
+	}
+	updated, err := h.app.UpdateEvent(withStoredIdentity(e, stored))
+	if err != nil {
+		writeAPIError(w, writeErrorStatus(err), err)
+		return
+	}
+	h.wrote()
+	writeAPIJSON(w, http.StatusOK, updated)
+}
+
+func (h *apiHandler) deleteEvent(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, status, err := h.event(id); err != nil {
+		writeAPIError(w, status, err)
+		return
+	}
+	if err := h.app.DeleteEvent(id); err != nil {
+		writeAPIError(w, writeErrorStatus(err), err)
+		return
+	}
+	h.wrote()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *apiHandler) sync(w http.ResponseWriter, r *http.Request) {
+	result, err := h.app.GoogleSync()
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err)
+		return
+	}
+	h.wrote()
+	writeAPIJSON(w, http.StatusOK, result)
+}
+
+// event looks up an event that has not been deleted, returning the status to answer
+// with when there is none.
+func (h *apiHandler) event(id string) (CalendarEvent, int, error) {
+	e, err := h.app.getEvent(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (e.SyncStatus == "deleted" || e.SyncStatus == "cancelled")) {
+		return CalendarEvent{}, http.StatusNotFound, errors.New("event not found")
+	}
+	if err != nil {
+		return CalendarEvent{}, http.StatusInternalServerError, err
+	}
+	return e, http.StatusOK, nil
+}
+
+// withStoredIdentity replaces the ID, sync state and provider identity a client sent
+// with those of stored, the event's row or nothing for a new event, which only sync
+// sets.
+func withStoredIdentity(e, stored CalendarEvent) CalendarEvent {
+	e.ID, e.SyncStatus = stored.ID, stored.SyncStatus
+	e.AccountID, e.Provider, e.GoogleCalendarID = stored.AccountID, stored.Provider, stored.GoogleCalendarID
+	e.GoogleEventID, e.GoogleETag, e.GoogleUpdatedAt = stored.GoogleEventID, stored.GoogleETag, stored.GoogleUpdatedAt
+	e.RecurringEventID, e.OriginalStart, e.ICalUID = stored.RecurringEventID, stored.OriginalStart, stored.ICalUID
+	return e
+}
+
+// wrote tells the app that a request changed events.
+func (h *apiHandler) wrote() {
+	h.app.reminders.reschedule()
+	if h.changed != nil {
+		h.changed()
+	}
+}
+
+// writeAPIEvents answers with a list of events, empty rather than null when there are none.
+func writeAPIEvents(w http.ResponseWriter, events []CalendarEvent) {
+	if events == nil {
+		events = []CalendarEvent{}
+	}
+	writeAPIJSON(w, http.StatusOK, events)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("api server: %v\n", err)
+	}
+}
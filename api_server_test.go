@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"myapp/internal/fakegcal"
+)
+
+// apiTest serves the API of one app and remembers whether a request reported a change.
+type apiTest struct {
+	t       *testing.T
+	app     *App
+	url     string
+	token   string
+	changed bool
+}
+
+func newAPITest(t *testing.T, a *App) *apiTest {
+	t.Helper()
+	if a == nil {
+		a = NewApp()
+		if err := a.openDB(":memory:"); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { a.db.Close() })
+	}
+	api := &apiTest{t: t, app: a, token: "token"}
+	srv := httptest.NewServer(newAPIHandler(a, "token", func() { api.changed = true }))
+	t.Cleanup(srv.Close)
+	api.url = srv.URL
+	return api
+}
+
+// call sends a request and decodes a JSON answer into out, returning the status.
+func (api *apiTest) call(method, path, body string, out any) int {
+	api.t.Helper()
+	req, err := http.NewRequest(method, api.url+path, strings.NewReader(body))
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+api.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			api.t.Fatalf("%s %s: %v in %s", method, path, err, data)
+		}
+	}
+	return resp.StatusCode
+}
+
+// takeChanged reports whether a change was reported since the last call.
+func (api *apiTest) takeChanged() bool {
+	changed := api.changed
+	api.changed = false
+	return changed
+}
+
+func (api *apiTest) create(body string) CalendarEvent {
+	api.t.Helper()
+	var created CalendarEvent
+	if status := api.call(http.MethodPost, "/api/events", body, &created); status != http.StatusCreated || created.ID == "" {
+		api.t.Fatalf("create: %d %+v", status, created)
+	}
+	return created
+}
+
+const focusEventJSON = `{"title":"focus","start":"2024-05-02T09:00:00Z","end":"2024-05-02T11:00:00Z","recurrence":"none","alert":"none"}`
+
+type apiFailure struct {
+	Error string `json:"error"`
+}
+
+func TestAPIServerRejectsWrongToken(t *testing.T) {
+	api := newAPITest(t, nil)
+	api.token = "wrong"
+	if status := api.call(http.MethodGet, "/api/events", "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("wrong token: %d", status)
+	}
+	if status := api.call(http.MethodPost, "/api/events", focusEventJSON, nil); status != http.StatusUnauthorized || api.takeChanged() {
+		t.Fatalf("create with wrong token: %d", status)
+	}
+	if n := countRows(t, api.app, `SELECT COUNT(*) FROM events`); n != 0 {
+		t.Fatalf("%d events created", n)
+	}
+}
+
+func TestAPIServerCreateAndSearch(t *testing.T) {
+	api := newAPITest(t, nil)
+	var events []CalendarEvent
+	if status := api.call(http.MethodGet, "/api/events", "", &events); status != http.StatusOK || events == nil || len(events) != 0 {
+		t.Fatalf("empty list: %d %v", status, events)
+	}
+
+	// Events are validated like those created in the widget.
+	var failure apiFailure
+	if status := api.call(http.MethodPost, "/api/events", `{"title":"focus","start":"later","end":"2024-05-02T10:00:00Z"}`, &failure); status != http.StatusBadRequest || failure.Error == "" {
+		t.Fatalf("invalid event: %d %+v", status, failure)
+	}
+	if api.takeChanged() {
+		t.Fatal("change reported for a rejected event")
+	}
+	created := api.create(focusEventJSON)
+	if created.SyncStatus != "local" || !api.takeChanged() {
+		t.Fatalf("create: %+v", created)
+	}
+
+	var found []CalendarEvent
+	if status := api.call(http.MethodGet, "/api/events/search?q=foc&start=2024-05-01T00:00:00Z&end=2024-05-03T00:00:00Z", "", &found); status != http.StatusOK || len(found) != 1 || found[0].ID != created.ID {
+		t.Fatalf("search: %d %+v", status, found)
+	}
+	if status := api.call(http.MethodGet, "/api/events/search?limit=many", "", nil); status != http.StatusBadRequest {
+		t.Fatalf("invalid limit: %d", status)
+	}
+}
+
+func TestAPIServerUpdateAndDelete(t *testing.T) {
+	api := newAPITest(t, nil)
+	created := api.create(focusEventJSON)
+	api.takeChanged()
+
+	created.Title = "deep focus"
+	update, _ := json.Marshal(created)
+	var updated CalendarEvent
+	if status := api.call(http.MethodPut, "/api/events/"+created.ID, string(update), &updated); status != http.StatusOK || updated.Title != "deep focus" || !api.takeChanged() {
+		t.Fatalf("update: %d %+v", status, updated)
+	}
+	var got CalendarEvent
+	if status := api.call(http.MethodGet, "/api/events/"+created.ID, "", &got); status != http.StatusOK || got.Title != "deep focus" {
+		t.Fatalf("get: %d %+v", status, got)
+	}
+
+	if status := api.call(http.MethodDelete, "/api/events/"+created.ID, "", nil); status != http.StatusNoContent || !api.takeChanged() {
+		t.Fatalf("delete: %d", status)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		if status := api.call(method, "/api/events/"+created.ID, string(update), nil); status != http.StatusNotFound {
+			t.Fatalf("%s after delete: %d", method, status)
+		}
+	}
+	if api.takeChanged() {
+		t.Fatal("change reported for a missing event")
+	}
+}
+
+func TestAPIServerIgnoresSyncFields(t *testing.T) {
+	api := newAPITest(t, nil)
+	forged := `{"id":"forged","title":"standup","start":"2024-05-03T09:00:00Z","end":"2024-05-03T09:30:00Z","syncStatus":"synced","accountId":"someone","provider":"caldav","googleEventId":"g-forged","googleEtag":"\"9\""}`
+	posted := api.create(forged)
+	if posted.ID == "forged" || posted.SyncStatus != "local" || posted.AccountID != "" || posted.Provider != "" || posted.GoogleEventID != "" || posted.GoogleETag != "" {
+		t.Fatalf("forged create: %+v", posted)
+	}
+	var put CalendarEvent
+	if status := api.call(http.MethodPut, "/api/events/"+posted.ID, forged, &put); status != http.StatusOK || put.ID != posted.ID || put.SyncStatus != "local" || put.AccountID != "" || put.GoogleEventID != "" {
+		t.Fatalf("forged update: %d %+v", status, put)
+	}
+	if n := countRows(t, api.app, `SELECT COUNT(*) FROM events WHERE id = 'forged' OR COALESCE(remote_id,'') != ''`); n != 0 {
+		t.Fatalf("%d forged rows", n)
+	}
+}
+
+func TestAPIServerSync(t *testing.T) {
+	t.Run("reports why it failed", func(t *testing.T) {
+		// Without an account to sync with, nothing changes.
+		api := newAPITest(t, nil)
+		var failure apiFailure
+		if status := api.call(http.MethodPost, "/api/sync", "", &failure); status != http.StatusBadGateway || !strings.Contains(failure.Error, "not initialised") {
+			t.Fatalf("sync: %d %+v", status, failure)
+		}
+		if api.takeChanged() {
+			t.Fatal("change reported for a failed sync")
+		}
+	})
+	t.Run("pulls and reports a change", func(t *testing.T) {
+		srv := fakegcal.New()
+		defer srv.Close()
+		api := newAPITest(t, newE2EApp(t, srv))
+		srv.PutEvent(fakegcal.PrimaryCalendarID, remoteEvent("standup"))
+		var result GoogleSyncResult
+		if status := api.call(http.MethodPost, "/api/sync", "", &result); status != http.StatusOK || result.Pulled != 1 {
+			t.Fatalf("sync: %d %+v", status, result)
+		}
+		if !api.takeChanged() {
+			t.Fatal("no change reported after a sync")
+		}
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/base32"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,9 +14,11 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
 	_ "modernc.org/sqlite"
@@ -27,11 +30,22 @@ var AppVersion = "dev"
 
 // App struct
 type App struct {
-	ctx      context.Context
-	db       *sql.DB
+	ctx    context.Context
+	db     *sql.DB
+	cbOnce sync.Once
+
+	// mu guards google and settings, which bound methods may replace at any time.
+	mu       sync.RWMutex
 	google   *GoogleSyncService
-	cbOnce   sync.Once
 	settings AppSettings
+
+	syncs         *syncCoordinator
+	scheduler     *syncScheduler
+	stopScheduler context.CancelFunc
+	reminders     *reminderScheduler
+	stopReminders context.CancelFunc
+	dav           *calDAVServer
+	api           *apiServer
 }
 
 type syncStateStore struct {
@@ -40,7 +54,7 @@ type syncStateStore struct {
 
 // NewApp creates a new App application struct
 func NewApp() *App {
-	return &App{}
+	return &App{syncs: newSyncCoordinator()}
 }
 
 // startup is called when the app starts. The context is saved
@@ -65,41 +79,65 @@ func (a *App) startup(ctx context.Context) {
 	if err := a.loadSettings(); err != nil {
 		fmt.Printf("failed to load settings: %v\n", err)
 	}
-	if err := a.applyAutoStart(a.settings.AutoStart); err != nil {
+	if err := a.applyAutoStart(a.currentSettings().AutoStart); err != nil {
 		fmt.Printf("failed to apply autostart: %v\n", err)
 	}
 	if err := a.initGoogleSync(); err != nil {
 		fmt.Printf("google sync unavailable: %v\n", err)
 	}
+	emit := func(name string, data ...interface{}) {
+		wailsruntime.EventsEmit(ctx, name, data...)
+	}
+	// The sync loop reschedules reminders, so they are set up first.
+	a.startReminderScheduler(ctx, emit)
+	a.startSyncScheduler(ctx, emit)
+	a.startCalDAVServer(emit)
+	a.startAPIServer(emit)
+}
+
+// shutdown is called when the app is closing.
+func (a *App) shutdown(ctx context.Context) {
+	if a.stopScheduler != nil {
+		a.stopScheduler()
+	}
+	if a.stopReminders != nil {
+		a.stopReminders()
+	}
+	if a.dav != nil {
+		a.dav.stop()
+	}
+	if a.api != nil {
+		a.api.stop()
+	}
 }
 
 // Greet returns a greeting for the given name
 // GoogleAuthURL builds an OAuth consent URL for Google Calendar.
 func (a *App) GoogleAuthURL(state string) (string, error) {
-	if a.google == nil {
+	google := a.googleService()
+	if google == nil {
 		return "", errors.New("google sync not initialised")
 	}
-	return a.google.BuildAuthURL(state)
+	return google.BuildAuthURL(state)
 }
 
-// GoogleExchangeCode exchanges an auth code for tokens and stores them.
+// GoogleExchangeCode exchanges an auth code for tokens and stores them under the
+// account they belong to, which becomes the active account.
 func (a *App) GoogleExchangeCode(code string) (OAuthTokens, error) {
-	if a.google == nil {
-		return OAuthTokens{}, errors.New("google sync not initialised")
-	}
 	ctx := a.ctx
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	return a.google.ExchangeCode(ctx, code)
+	return a.connectGoogleAccount(ctx, code)
 }
 
-// GoogleRefreshTokens refreshes tokens using the stored refresh token.
+// GoogleRefreshTokens refreshes the active account's tokens using its stored refresh token.
 func (a *App) GoogleRefreshTokens() (OAuthTokens, error) {
-	if a.google == nil {
-		return OAuthTokens{}, errors.New("google sync not initialised")
+	svc, err := a.googleForAccount(a.activeAccountID())
+	if err != nil {
+		return OAuthTokens{}, err
 	}
-	existing, err := a.google.LoadTokens()
+	existing, err := svc.LoadTokens()
 	if err != nil {
 		return OAuthTokens{}, fmt.Errorf("load tokens: %w", err)
 	}
@@ -107,22 +145,31 @@ func (a *App) GoogleRefreshTokens() (OAuthTokens, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	return a.google.Refresh(ctx, existing.RefreshToken)
+	return svc.Refresh(ctx, existing.RefreshToken)
 }
 
-// GoogleTokenInfo returns current token/user summary.
+// GoogleTokenInfo returns the active account's token/user summary.
 func (a *App) GoogleTokenInfo() (GoogleTokenInfo, error) {
+	google := a.googleService()
 	info := GoogleTokenInfo{
-		ClientConfigured: a.google != nil && a.google.HasClientConfig(),
+		ClientConfigured: google != nil && google.HasClientConfig(),
 	}
-	if a.google == nil {
+	if google == nil {
 		return info, errors.New("google sync not initialised")
 	}
+	accountID := a.activeAccountID()
+	if accountID == "" {
+		return info, nil
+	}
+	svc, err := a.googleForAccount(accountID)
+	if err != nil {
+		return info, err
+	}
 	ctx := a.ctx
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	tokens, err := a.google.EnsureAccessToken(ctx)
+	tokens, err := svc.EnsureAccessToken(ctx)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return info, nil
@@ -130,37 +177,43 @@ func (a *App) GoogleTokenInfo() (GoogleTokenInfo, error) {
 		return info, err
 	}
 	info.Connected = true
+	info.AccountID = accountID
 	info.ExpiresAt = tokens.Expiry
 	info.Scope = tokens.Scope
 	info.HasRefreshToken = tokens.RefreshToken != ""
 
-	if user, err := a.google.FetchUserInfo(ctx, tokens.AccessToken); err == nil {
+	if user, err := svc.FetchUserInfo(ctx, tokens.AccessToken); err == nil {
 		info.UserEmail = user.Email
 		info.UserName = user.Name
 		info.Picture = user.Picture
+		// Fill in profile data for accounts migrated from the single-account token file.
+		if _, err := a.upsertAccount(accountID, user); err != nil {
+			fmt.Printf("update account profile: %v\n", err)
+		}
 	}
 
 	return info, nil
 }
 
-// GoogleLogout clears stored tokens.
+// GoogleLogout disconnects the active account and clears its cached data.
 func (a *App) GoogleLogout() error {
-	if a.google == nil {
+	if a.googleService() == nil {
 		return errors.New("google sync not initialised")
 	}
-	if err := a.google.ClearTokens(); err != nil {
-		return err
+	accountID := a.activeAccountID()
+	if accountID == "" {
+		return nil
 	}
-	// Remove locally cached calendar data so logout leaves no stale entries.
-	return a.clearLocalData()
+	return a.GoogleLogoutAccount(accountID)
 }
 
 // startAuthCallbackServer listens on the redirect URI for OAuth codes and exchanges them automatically.
 func (a *App) startAuthCallbackServer() error {
-	if a.google == nil || !a.google.HasClientConfig() {
+	google := a.googleService()
+	if google == nil || !google.HasClientConfig() {
 		return nil
 	}
-	redirect := a.google.cfg.RedirectURI
+	redirect := google.cfg.RedirectURI
 	u, err := url.Parse(redirect)
 	if err != nil {
 		return fmt.Errorf("parse redirect uri: %w", err)
@@ -189,7 +242,7 @@ func (a *App) startAuthCallbackServer() error {
 			return
 		}
 		ctx := r.Context()
-		if _, err := a.google.ExchangeCode(ctx, code); err != nil {
+		if _, err := a.connectGoogleAccount(ctx, code); err != nil {
 			http.Error(w, "failed to exchange code: "+err.Error(), http.StatusBadGateway)
 			return
 		}
@@ -222,32 +275,99 @@ func (a *App) startAuthCallbackServer() error {
 	return nil
 }
 
-// GoogleSync performs pull/push sync with Google Calendar (primary calendar).
+// GoogleSync performs pull/push sync with every enabled calendar of every connected account.
 func (a *App) GoogleSync() (GoogleSyncResult, error) {
+	ctx := a.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// A second click while a sync is running gets the running sync's result.
+	return a.syncs.do(syncKeyFull, func() (GoogleSyncResult, error) {
+		return a.runSync(ctx)
+	})
+}
+
+// runSync does the work of GoogleSync; use it through the sync coordinator.
+func (a *App) runSync(ctx context.Context) (GoogleSyncResult, error) {
 	if a.db == nil {
 		return GoogleSyncResult{}, errors.New("db not initialised")
 	}
-	if a.google == nil {
-		return GoogleSyncResult{}, errors.New("google sync not initialised")
+	accounts, err := a.providerAccounts()
+	if err != nil {
+		return GoogleSyncResult{Errors: 1, ErrorMessage: err.Error()}, err
 	}
-	ctx := a.ctx
-	if ctx == nil {
-		ctx = context.Background()
+	var result GoogleSyncResult
+	var firstErr error
+	for _, acc := range accounts {
+		if err := a.syncAccount(ctx, acc, &result); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", acc.Label, err)
+		}
+	}
+	return result, firstErr
+}
+
+// syncAccount refreshes one account's calendar list and syncs its enabled calendars.
+func (a *App) syncAccount(ctx context.Context, acc providerAccount, result *GoogleSyncResult) error {
+	if err := a.refreshCalendars(ctx, acc.Provider, acc.ID); err != nil {
+		mergeSyncResult(result, GoogleSyncResult{AccountID: acc.ID, Errors: 1, ErrorMessage: err.Error()})
+		return err
+	}
+	calendars, err := a.loadCalendars(acc.ID, true)
+	if err != nil {
+		return err
 	}
+	var firstErr error
+	for _, cal := range calendars {
+		calResult, err := a.syncCalendar(ctx, acc.Provider, cal)
+		mergeSyncResult(result, calResult)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("calendar %s: %w", cal.ID, err)
+		}
+	}
+	return firstErr
+}
+
+// syncCalendar pulls remote changes for one calendar, then pushes its local changes.
+func (a *App) syncCalendar(ctx context.Context, provider CalendarProvider, cal CalendarInfo) (GoogleSyncResult, error) {
+	unlock := a.syncs.lockCalendar(cal)
+	defer unlock()
+	result, err := a.pullAndPushCalendar(ctx, provider, cal)
+	result.AccountID = cal.AccountID
+	if serr := a.recordCalendarSync(cal.AccountID, cal.ID, err); serr != nil {
+		fmt.Printf("record sync state: %v\n", serr)
+	}
+	return result, err
+}
 
-	calendarID := "primary"
-	syncToken, _ := a.syncStateGet("google_sync_token")
+func (a *App) pullAndPushCalendar(ctx context.Context, provider CalendarProvider, cal CalendarInfo) (GoogleSyncResult, error) {
+	calendarID := cal.ID
+	syncToken, err := a.calendarSyncToken(cal.AccountID, calendarID)
+	if err != nil {
+		return GoogleSyncResult{CalendarID: calendarID, Errors: 1, ErrorMessage: err.Error()}, err
+	}
 	fullSync := syncToken == ""
 
-	events, nextSyncToken, err := a.google.ListEvents(ctx, calendarID, syncToken)
+	events, nextSyncToken, err := provider.ListEvents(ctx, calendarID, syncToken)
+	if errors.Is(err, errSyncTokenExpired) {
+		// Token invalidated by the provider; drop it and fetch everything again.
+		if rerr := a.resetCalendarSyncToken(cal.AccountID, calendarID); rerr != nil {
+			return GoogleSyncResult{CalendarID: calendarID, Errors: 1, ErrorMessage: rerr.Error()}, rerr
+		}
+		fullSync = true
+		events, nextSyncToken, err = provider.ListEvents(ctx, calendarID, "")
+	}
 	if err != nil {
-		return GoogleSyncResult{CalendarID: calendarID, ErrorMessage: err.Error(), FullSync: fullSync}, err
+		return GoogleSyncResult{CalendarID: calendarID, Errors: 1, ErrorMessage: err.Error(), FullSync: fullSync}, err
 	}
 	result := GoogleSyncResult{CalendarID: calendarID, FullSync: fullSync, SyncToken: nextSyncToken}
 
-	// Pull: apply Google events to local DB
+	// Pull: apply remote events to local DB. Series go first so that their exceptions
+	// find the row they belong to.
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].RecurringEventID == "" && events[j].RecurringEventID != ""
+	})
 	for _, ge := range events {
-		if err := a.applyGoogleEvent(ge); err != nil {
+		if err := a.applyGoogleEvent(provider.Name(), cal.AccountID, calendarID, ge); err != nil {
 			result.Errors++
 			result.ErrorMessage = err.Error()
 			continue
@@ -258,12 +378,23 @@ func (a *App) GoogleSync() (GoogleSyncResult, error) {
 			result.Pulled++
 		}
 	}
+	if provider.Capabilities().SnapshotListing {
+		dropped, err := a.dropUnlistedEvents(cal.AccountID, calendarID, events, fullSync)
+		result.Deleted += dropped
+		if err != nil {
+			result.Errors++
+			result.ErrorMessage = err.Error()
+		}
+	}
 	if nextSyncToken != "" {
-		_ = a.syncStateSet("google_sync_token", nextSyncToken)
+		if err := a.setCalendarSyncToken(cal.AccountID, calendarID, nextSyncToken, fullSync); err != nil {
+			result.Errors++
+			result.ErrorMessage = err.Error()
+		}
 	}
 
 	// Push local changes
-	pushed, perr := a.pushLocalChanges(ctx, calendarID)
+	pushed, perr := a.pushLocalChanges(ctx, provider, cal)
 	result.Pushed = pushed
 	if perr != nil {
 		if result.ErrorMessage == "" {
@@ -276,27 +407,65 @@ func (a *App) GoogleSync() (GoogleSyncResult, error) {
 	return result, nil
 }
 
+// mergeSyncResult folds a per-calendar result into the session totals.
+func mergeSyncResult(total *GoogleSyncResult, cal GoogleSyncResult) {
+	total.Pulled += cal.Pulled
+	total.Pushed += cal.Pushed
+	total.Deleted += cal.Deleted
+	total.Errors += cal.Errors
+	total.FullSync = total.FullSync || cal.FullSync
+	if total.ErrorMessage == "" && cal.ErrorMessage != "" {
+		total.ErrorMessage = cal.ErrorMessage
+	}
+	total.Calendars = append(total.Calendars, cal)
+}
+
 // GooglePush pushes local changes without pulling updates (lightweight).
 func (a *App) GooglePush() (GoogleSyncResult, error) {
-	if a.db == nil {
-		return GoogleSyncResult{}, errors.New("db not initialised")
-	}
-	if a.google == nil {
-		return GoogleSyncResult{}, errors.New("google sync not initialised")
-	}
 	ctx := a.ctx
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	calendarID := "primary"
-	pushed, err := a.pushLocalChanges(ctx, calendarID)
-	result := GoogleSyncResult{CalendarID: calendarID, FullSync: false, Pushed: pushed}
+	return a.syncs.do(syncKeyPush, func() (GoogleSyncResult, error) {
+		return a.runPush(ctx)
+	})
+}
+
+func (a *App) runPush(ctx context.Context) (GoogleSyncResult, error) {
+	if a.db == nil {
+		return GoogleSyncResult{}, errors.New("db not initialised")
+	}
+	accounts, err := a.providerAccounts()
 	if err != nil {
-		result.Errors = 1
-		result.ErrorMessage = err.Error()
-		return result, err
+		return GoogleSyncResult{Errors: 1, ErrorMessage: err.Error()}, err
 	}
-	return result, nil
+	var result GoogleSyncResult
+	var firstErr error
+	for _, acc := range accounts {
+		calendars, err := a.loadCalendars(acc.ID, true)
+		if err != nil {
+			return result, err
+		}
+		if len(calendars) == 0 && acc.Provider.Capabilities().PrimaryAlias {
+			// Calendar list not fetched yet; push to the primary calendar as before.
+			calendars = []CalendarInfo{{AccountID: acc.ID, ID: primaryCalendarAlias, Primary: true, Enabled: true}}
+		}
+		for _, cal := range calendars {
+			unlock := a.syncs.lockCalendar(cal)
+			pushed, err := a.pushLocalChanges(ctx, acc.Provider, cal)
+			unlock()
+			calResult := GoogleSyncResult{AccountID: acc.ID, CalendarID: cal.ID, Pushed: pushed}
+			if err != nil {
+				calResult.Errors = 1
+				calResult.ErrorMessage = err.Error()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("calendar %s: %w", cal.ID, err)
+				}
+			}
+			mergeSyncResult(&result, calResult)
+		}
+	}
+	return result, firstErr
 }
 
 func holidayCalendarID(locale string) string {
@@ -345,8 +514,9 @@ var skipHolidayTitles = map[string]bool{
 
 // GoogleListHolidays fetches public holiday events for the current year from Google's holiday calendar.
 func (a *App) GoogleListHolidays(locale string) ([]CalendarEvent, error) {
-	if a.google == nil {
-		return nil, errors.New("google sync not initialised")
+	svc, err := a.googleForAccount(a.activeAccountID())
+	if err != nil {
+		return nil, err
 	}
 	ctx := a.ctx
 	if ctx == nil {
@@ -357,7 +527,7 @@ func (a *App) GoogleListHolidays(locale string) ([]CalendarEvent, error) {
 	start := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
 	// Include next year to cover upcoming holidays (e.g., when viewing late in the year).
 	end := start.AddDate(2, 0, 0)
-	items, err := a.google.ListEventsRange(ctx, calID, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	items, err := svc.ListEventsRange(ctx, calID, start.Format(time.RFC3339), end.Format(time.RFC3339))
 	if err != nil {
 		return nil, err
 	}
@@ -396,22 +566,42 @@ func (a *App) GoogleListHolidays(locale string) ([]CalendarEvent, error) {
 	return out, nil
 }
 
-func (a *App) applyGoogleEvent(ge GoogleEvent) error {
+func (a *App) applyGoogleEvent(provider, accountID, calendarID string, ge GoogleEvent) error {
 	if a.db == nil {
 		return errors.New("db not initialised")
 	}
 
-	var existingID, existingSyncStatus string
-	if err := a.db.QueryRow(`SELECT id, sync_status FROM events WHERE google_event_id = ? LIMIT 1`, ge.ID).Scan(&existingID, &existingSyncStatus); err != nil && !errors.Is(err, sql.ErrNoRows) {
+	var existingID, existingSyncStatus, existingETag string
+	if err := a.db.QueryRow(`SELECT id, sync_status, COALESCE(remote_etag,'') FROM events WHERE remote_id = ? AND google_calendar_id = ? AND account_id = ? LIMIT 1`, ge.ID, calendarID, accountID).Scan(&existingID, &existingSyncStatus, &existingETag); err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return err
 	}
 
-	// Avoid overwriting unsynced local edits; mark conflict instead.
-	if existingID != "" && (existingSyncStatus == "new" || existingSyncStatus == "dirty" || existingSyncStatus == "local") {
-		_, _ = a.db.Exec(`UPDATE events SET sync_status='conflict', google_etag=?, google_updated_at=? WHERE id=?`, ge.Etag, ge.Updated, existingID)
+	// The version a pending row is based on, typically our own write coming back; the
+	// local change still has to be pushed over it.
+	if existingID != "" && (isPendingSyncStatus(existingSyncStatus) || existingSyncStatus == "deleted") && ge.Etag != "" && ge.Etag == existingETag {
 		return nil
 	}
 
+	// Avoid overwriting unsynced local edits; park the remote version as a conflict instead.
+	if existingID != "" && isPendingSyncStatus(existingSyncStatus) {
+		return a.handleConflict(existingID, ge)
+	}
+	if existingID != "" && existingSyncStatus == "deleted" && ge.Status != "cancelled" {
+		return a.handleDeletedConflict(existingID, ge)
+	}
+
+	if ge.RecurringEventID != "" {
+		return a.applyGoogleOccurrence(provider, accountID, calendarID, ge, existingID)
+	}
+
+	if existingID == "" && ge.Status != "cancelled" {
+		// The event may be one we created whose ID never made it back to its row.
+		linked, err := a.linkCreatedEvent(provider, accountID, calendarID, ge)
+		if err != nil || linked {
+			return err
+		}
+	}
+
 	if ge.Status == "cancelled" {
 		if existingID != "" {
 			_, err := a.db.Exec(`UPDATE events SET sync_status='deleted' WHERE id = ?`, existingID)
@@ -422,25 +612,107 @@ func (a *App) applyGoogleEvent(ge GoogleEvent) error {
 
 	eventID := existingID
 	if eventID == "" {
-		eventID = fmt.Sprintf("google-%s", ge.ID)
+		id, err := a.localGoogleEventID(accountID, calendarID, ge.ID)
+		if err != nil {
+			return err
+		}
+		eventID = id
 	}
 
-	start := firstNonEmpty(ge.Start.DateTime, ge.Start.Date)
-	end := firstNonEmpty(ge.End.DateTime, ge.End.Date)
-	if start == "" || end == "" {
+	e := googleToCalendar(accountID, calendarID, ge)
+	e.Provider = provider
+	if e.Start == "" || e.End == "" {
 		return fmt.Errorf("google event missing time: %s", ge.ID)
 	}
+	if err := a.resolveDefaultAlert(&e); err != nil {
+		return err
+	}
+	if err := storeRemoteEvent(a.db, eventID, e); err != nil {
+		return err
+	}
+	if len(ge.Recurrence) == 0 {
+		return nil
+	}
+	return a.linkFlattenedInstances(accountID, calendarID, eventID, ge.ID)
+}
+
+// linkCreatedEvent attaches ge to the local row it was created from, when its ID was
+// derived from a row that has no Google ID yet. The row keeps its local content and is
+// left pending so the next push brings the remote copy up to date.
+func (a *App) linkCreatedEvent(provider, accountID, calendarID string, ge GoogleEvent) (bool, error) {
+	localID, ok := localIDForGoogleEvent(ge.ID)
+	if !ok {
+		return false, nil
+	}
+	res, err := a.db.Exec(`UPDATE events SET provider=?, account_id=?, remote_id=?, google_calendar_id=?, remote_etag=?, google_updated_at=NULLIF(?,''),
+		sync_status = CASE WHEN sync_status IN ('new','local') THEN 'dirty' ELSE sync_status END
+		WHERE id = ? AND COALESCE(remote_id,'') = '' AND COALESCE(account_id,'') IN ('', ?)`,
+		provider, accountID, ge.ID, calendarID, ge.Etag, ge.Updated, localID, accountID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
 
-	recurrence := ""
-	recurrenceCustom := ""
-	if len(ge.Recurrence) > 0 {
-		recurrence = "rrule"
-		recurrenceCustom = strings.Join(ge.Recurrence, "\n")
+// isPendingSyncStatus reports whether a row holds local state that a pull must not overwrite.
+func isPendingSyncStatus(status string) bool {
+	switch status {
+	case "new", "dirty", "local", "conflict":
+		return true
 	}
+	return false
+}
 
-	_, err := a.db.Exec(`
-		INSERT INTO events (id, title, all_day, start, end, recurrence, recurrence_custom, location, alert, alert_offset, color, description, sync_status, google_event_id, google_calendar_id, time_zone, google_etag, google_updated_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'none', 0, ?, ?, 'synced', ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+// googleToCalendar maps a Google event onto the local event shape.
+func googleToCalendar(accountID, calendarID string, ge GoogleEvent) CalendarEvent {
+	e := CalendarEvent{
+		Title:            ge.Summary,
+		AllDay:           ge.Start.Date != "",
+		Start:            firstNonEmpty(ge.Start.DateTime, ge.Start.Date),
+		End:              firstNonEmpty(ge.End.DateTime, ge.End.Date),
+		Location:         ge.Location,
+		Alert:            "none",
+		Color:            ge.ColorID,
+		Description:      ge.Description,
+		SyncStatus:       "synced",
+		AccountID:        accountID,
+		GoogleEventID:    ge.ID,
+		GoogleCalendarID: calendarID,
+		TimeZone:         ge.Start.TimeZone,
+		GoogleETag:       ge.Etag,
+		GoogleUpdatedAt:  ge.Updated,
+		ICalUID:          ge.ICalUID,
+	}
+	if len(ge.Recurrence) > 0 {
+		e.Recurrence = "rrule"
+		e.RecurrenceEx = strings.Join(ge.Recurrence, "\n")
+	}
+	if r := ge.Reminders; r != nil && r.UseDefault {
+		// Default reminders live on the calendar; see resolveDefaultAlert.
+		e.Reminders = []Reminder{}
+		e.UseDefaultReminders = true
+	} else if r != nil {
+		e.Reminders = sortReminders(r.Overrides)
+		e.Alert, e.AlertOffset = alertForReminders(e.Reminders)
+	}
+	return e
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// storeRemoteEvent writes a remote event into the row eventID and marks it synced,
+// dropping any local changes still journaled for it. A row keeps the occurrence it
+// overrides when e does not name one.
+func storeRemoteEvent(db execer, eventID string, e CalendarEvent) error {
+	if _, err := db.Exec(`DELETE FROM outbox WHERE event_id = ?`, eventID); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO events (id, title, all_day, start, end, recurrence, recurrence_custom, location, alert, alert_offset, color, description, sync_status, provider, account_id, remote_id, google_calendar_id, time_zone, remote_etag, google_updated_at, recurring_event_id, original_start, ical_uid, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'synced', ?, ?, ?, ?, ?, ?, NULLIF(?,''), ?, ?, ?, CURRENT_TIMESTAMP)
 		ON CONFLICT(id) DO UPDATE SET
 			title=excluded.title,
 			all_day=excluded.all_day,
@@ -449,89 +721,229 @@ func (a *App) applyGoogleEvent(ge GoogleEvent) error {
 			recurrence=excluded.recurrence,
 			recurrence_custom=excluded.recurrence_custom,
 			location=excluded.location,
+			alert=excluded.alert,
+			alert_offset=excluded.alert_offset,
 			color=excluded.color,
 			description=excluded.description,
 			sync_status='synced',
-			google_event_id=excluded.google_event_id,
+			provider=COALESCE(NULLIF(excluded.provider,''), events.provider),
+			account_id=excluded.account_id,
+			remote_id=excluded.remote_id,
 			google_calendar_id=excluded.google_calendar_id,
 			time_zone=excluded.time_zone,
-			google_etag=excluded.google_etag,
+			remote_etag=excluded.remote_etag,
 			google_updated_at=excluded.google_updated_at,
+			recurring_event_id=COALESCE(NULLIF(excluded.recurring_event_id,''), events.recurring_event_id),
+			original_start=COALESCE(NULLIF(excluded.original_start,''), events.original_start),
+			ical_uid=COALESCE(NULLIF(excluded.ical_uid,''), events.ical_uid),
 			updated_at=excluded.updated_at
-	`, eventID, ge.Summary, boolToInt(ge.Start.Date != ""), start, end, recurrence, recurrenceCustom, ge.Location, ge.ColorID, ge.Description, ge.ID, "primary", ge.Start.TimeZone, ge.Etag, ge.Updated)
-	return err
+	`, eventID, e.Title, boolToInt(e.AllDay), e.Start, e.End, e.Recurrence, e.RecurrenceEx, e.Location, firstNonEmpty(e.Alert, "none"), e.AlertOffset, e.Color, e.Description, e.Provider, e.AccountID, e.GoogleEventID, e.GoogleCalendarID, e.TimeZone, e.GoogleETag, e.GoogleUpdatedAt, e.RecurringEventID, e.OriginalStart, e.ICalUID)
+	if err != nil || (e.Reminders == nil && !e.UseDefaultReminders) {
+		return err
+	}
+	return writeReminders(db, eventID, e)
+}
+
+// pendingPush is a row waiting to be pushed. upto is the newest outbox entry for it when it
+// was read; a successful push settles the entries up to there.
+type pendingPush struct {
+	CalendarEvent
+	start, end time.Time
+	upto       int64
 }
 
-func (a *App) pushLocalChanges(ctx context.Context, calendarID string) (int, error) {
+// pushLocalChanges pushes pending rows that belong to cal, in the order they were first
+// changed. Rows without a calendar go to their account's primary calendar; rows without
+// an account go to the active account.
+func (a *App) pushLocalChanges(ctx context.Context, provider CalendarProvider, cal CalendarInfo) (int, error) {
 	if a.db == nil {
 		return 0, errors.New("db not initialised")
 	}
-	rows, err := a.db.Query(`SELECT id, title, all_day, start, end, COALESCE(recurrence,''), COALESCE(recurrence_custom,''), COALESCE(location,''), alert, alert_offset, COALESCE(color,''), COALESCE(description,''), sync_status, COALESCE(google_event_id,''), COALESCE(google_calendar_id,''), COALESCE(time_zone,''), COALESCE(google_etag,'') FROM events WHERE sync_status IN ('new','dirty','deleted','local')`)
+	if !cal.Writable() {
+		return 0, nil
+	}
+	calendarID := cal.ID
+	claimUnassigned := cal.Primary && cal.AccountID == a.activeAccountID()
+	rows, err := a.db.Query(`SELECT id, title, all_day, start, end, COALESCE(recurrence,''), COALESCE(recurrence_custom,''), COALESCE(location,''), alert, alert_offset, COALESCE(color,''), COALESCE(description,''), sync_status, COALESCE(remote_id,''), COALESCE(google_calendar_id,''), COALESCE(time_zone,''), COALESCE(remote_etag,''), COALESCE(recurring_event_id,''), COALESCE(original_start,''),
+			(SELECT COALESCE(MAX(id), 0) FROM outbox WHERE event_id = events.id)
+		FROM events
+		WHERE sync_status IN ('new','dirty','deleted','local') AND (
+			(account_id = ? AND google_calendar_id = ?)
+			OR (? = 1 AND account_id = ? AND COALESCE(google_calendar_id,'') IN ('', 'primary'))
+			OR (? = 1 AND COALESCE(account_id,'') = '')
+		)
+		ORDER BY (SELECT MIN(id) FROM outbox WHERE event_id = events.id) IS NULL, (SELECT MIN(id) FROM outbox WHERE event_id = events.id), updated_at`,
+		cal.AccountID, calendarID, boolToInt(cal.Primary), cal.AccountID, boolToInt(claimUnassigned))
 	if err != nil {
 		return 0, err
 	}
-	defer rows.Close()
-
-	pushed := 0
+	// Read the batch up front so no cursor stays open across network calls and writes.
+	var pending []pendingPush
 	for rows.Next() {
-		var e CalendarEvent
+		var p pendingPush
 		var allDay int
-		var start, end time.Time
-		if err := rows.Scan(&e.ID, &e.Title, &allDay, &start, &end, &e.Recurrence, &e.RecurrenceEx, &e.Location, &e.Alert, &e.AlertOffset, &e.Color, &e.Description, &e.SyncStatus, &e.GoogleEventID, &e.GoogleCalendarID, &e.TimeZone, &e.GoogleETag); err != nil {
-			return pushed, err
+		e := &p.CalendarEvent
+		if err := rows.Scan(&e.ID, &e.Title, &allDay, &p.start, &p.end, &e.Recurrence, &e.RecurrenceEx, &e.Location, &e.Alert, &e.AlertOffset, &e.Color, &e.Description, &e.SyncStatus, &e.GoogleEventID, &e.GoogleCalendarID, &e.TimeZone, &e.GoogleETag, &e.RecurringEventID, &e.OriginalStart, &p.upto); err != nil {
+			rows.Close()
+			return 0, err
 		}
 		e.AllDay = allDay == 1
-		if e.SyncStatus == "deleted" {
-			if e.GoogleEventID != "" {
-				if err := a.google.DeleteEvent(ctx, calendarID, e.GoogleEventID); err != nil {
-					return pushed, err
-				}
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	pushed := 0
+	// An event Google rejects outright is skipped so it does not hold up the rest;
+	// the first such error is reported once the loop is done.
+	var rejected error
+	for _, p := range pending {
+		ok, err := a.pushEvent(ctx, provider, cal, p)
+		if err != nil {
+			if rerr := a.recordPushAttempt(p.ID, p.upto, err); rerr != nil {
+				fmt.Printf("record push attempt: %v\n", rerr)
 			}
-			if _, err := a.db.Exec(`DELETE FROM events WHERE id = ?`, e.ID); err != nil {
-				return pushed, err
+			if isPermanentGoogleError(err) {
+				rejected = firstError(rejected, err)
+				continue
 			}
+			return pushed, err
+		}
+		if ok {
 			pushed++
-			continue
 		}
+	}
+	return pushed, rejected
+}
 
-		gEvent := calendarToGoogle(e, start, end)
-		var remote GoogleEvent
-		if e.GoogleEventID == "" {
-			r, err := a.google.CreateEvent(ctx, calendarID, gEvent)
-			if err != nil {
-				return pushed, err
-			}
-			remote = r
-			pushed++
-			_, _ = a.db.Exec(`UPDATE events SET google_event_id=?, google_calendar_id=?, google_etag=?, google_updated_at=?, sync_status='synced' WHERE id=?`, r.ID, calendarID, r.Etag, r.Updated, e.ID)
-		} else {
-			r, err := a.google.UpdateEvent(ctx, calendarID, e.GoogleEventID, e.GoogleETag, gEvent)
-			if err != nil {
-				if errors.Is(err, errGoogleConflict) {
-					// Remote has changed; flag conflict and continue without overwriting.
-					_, _ = a.db.Exec(`UPDATE events SET sync_status='conflict' WHERE id=?`, e.ID)
-					continue
+// pushEvent writes one pending row to the provider. It reports false when nothing was pushed
+// because the row was parked as a conflict, or brought back by a remote edit, instead.
+func (a *App) pushEvent(ctx context.Context, provider CalendarProvider, cal CalendarInfo, p pendingPush) (bool, error) {
+	calendarID := cal.ID
+	e := p.CalendarEvent
+	if provider.Capabilities().SeriesResource && e.GoogleEventID != "" {
+		// A write earlier in this push to another row of the series moved its ETag.
+		if err := a.db.QueryRow(`SELECT COALESCE(remote_etag,'') FROM events WHERE id = ?`, e.ID).Scan(&e.GoogleETag); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return false, err
+		}
+	}
+	if e.SyncStatus == "deleted" {
+		if e.GoogleEventID != "" {
+			err := provider.DeleteEvent(ctx, calendarID, e.GoogleEventID, e.GoogleETag)
+			if errors.Is(err, errGoogleConflict) {
+				// Changed remotely since the version deleted here; deleted there, the
+				// deletion is done.
+				current, gerr := provider.GetEvent(ctx, calendarID, e.GoogleEventID)
+				if gerr == nil && current.Status != "cancelled" {
+					return false, a.handleDeletedConflict(e.ID, current)
 				}
-				if errors.Is(err, errGoogleNotFound) {
-					// Remote was deleted; recreate as new.
-					r, err = a.google.CreateEvent(ctx, calendarID, gEvent)
-					if err != nil {
-						return pushed, err
-					}
-					remote = r
-					pushed++
-					_, _ = a.db.Exec(`UPDATE events SET google_event_id=?, google_calendar_id=?, google_etag=?, google_updated_at=?, sync_status='synced' WHERE id=?`, r.ID, calendarID, r.Etag, r.Updated, e.ID)
-					continue
+				if gerr != nil && !errors.Is(gerr, errGoogleNotFound) {
+					return false, gerr
 				}
-				return pushed, err
+			} else if err != nil {
+				return false, err
 			}
-			remote = r
-			pushed++
-			_, _ = a.db.Exec(`UPDATE events SET google_etag=?, google_updated_at=?, sync_status='synced' WHERE id=?`, r.Etag, r.Updated, e.ID)
 		}
-		_ = remote // reserved for future use
+		return true, a.finishDelete(e.ID)
+	}
+
+	gEvent := calendarToGoogle(e, p.start, p.end)
+	reminders, err := a.googleReminders(e)
+	if err != nil {
+		return false, err
+	}
+	if reminders.UseDefault && !provider.Capabilities().DefaultReminders {
+		// The provider has no defaults to follow; write out the ones the event showed.
+		if reminders.Overrides, err = a.calendarDefaultReminders(cal.AccountID, calendarID); err != nil {
+			return false, err
+		}
+		reminders.UseDefault = false
+	}
+	gEvent.Reminders = reminders
+	var r GoogleEvent
+	if e.RecurringEventID != "" && e.GoogleEventID == "" {
+		// An occurrence overridden locally is written onto that instance of the series.
+		instanceID, ok, ierr := a.googleInstanceID(e)
+		if ierr != nil || !ok {
+			// Not before the series itself is on Google.
+			return false, ierr
+		}
+		r, err = provider.UpdateEvent(ctx, calendarID, instanceID, "", gEvent)
+	} else if e.GoogleEventID == "" {
+		r, err = provider.CreateEventWithID(ctx, calendarID, googleEventIDFor(e.ID), gEvent)
+	} else {
+		r, err = provider.UpdateEvent(ctx, calendarID, e.GoogleEventID, e.GoogleETag, gEvent)
+		if errors.Is(err, errGoogleConflict) {
+			// Remote has changed; keep both versions for the user to resolve.
+			current, gerr := provider.GetEvent(ctx, calendarID, e.GoogleEventID)
+			if errors.Is(gerr, errGoogleNotFound) {
+				current = GoogleEvent{ID: e.GoogleEventID, Status: "cancelled"}
+			} else if gerr != nil {
+				return false, gerr
+			}
+			return false, a.handleConflict(e.ID, current)
+		}
+		if errors.Is(err, errGoogleNotFound) && e.RecurringEventID == "" {
+			// Remote was deleted; recreate as new.
+			r, err = provider.CreateEventWithID(ctx, calendarID, googleEventIDFor(e.ID), gEvent)
+		}
+	}
+	if e.RecurringEventID != "" && errors.Is(err, errGoogleNotFound) {
+		// The series has no such occurrence (any more); retrying will not change that.
+		err = &googleAPIError{Op: "write occurrence", Status: "404 Not Found", Code: http.StatusNotFound, Body: e.OriginalStart}
+	}
+	if err != nil {
+		return false, err
+	}
+	exists, err := a.finishPush(e.ID, p.upto, provider.Name(), cal.AccountID, calendarID, r)
+	if err != nil {
+		return true, err
+	}
+	if exists && provider.Capabilities().SeriesResource {
+		if err := a.refreshSeriesETag(e, r.Etag); err != nil {
+			return true, err
+		}
+	}
+	if !exists {
+		// Deleted locally while the write was in flight; take the remote copy back out.
+		return true, provider.DeleteEvent(ctx, calendarID, r.ID, r.Etag)
+	}
+	return true, nil
+}
+
+// googleEventIDHex is base32hex in lower case, the alphabet Google accepts for event IDs.
+var googleEventIDHex = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+// googleEventIDFor derives the Google event ID a local row is created under. Being
+// derived from the local ID, a repeated insert of the same row is refused by Google
+// instead of creating a duplicate, and the row can be found again from the ID. IDs too
+// short for Google (under five characters) come back empty and Google assigns one.
+func googleEventIDFor(localID string) string {
+	id := googleEventIDHex.EncodeToString([]byte(localID))
+	if len(id) < 5 {
+		return ""
+	}
+	return id
+}
+
+// localIDForGoogleEvent reverses googleEventIDFor. Google-assigned IDs usually fail to
+// decode; callers still check that the result names a row.
+func localIDForGoogleEvent(googleEventID string) (string, bool) {
+	raw, err := googleEventIDHex.DecodeString(googleEventID)
+	if err != nil || len(raw) == 0 || !utf8.Valid(raw) {
+		return "", false
+	}
+	return string(raw), true
+}
+
+// firstError returns err unless first is already set.
+func firstError(first, err error) error {
+	if first != nil {
+		return first
 	}
-	return pushed, nil
+	return err
 }
 
 func calendarToGoogle(e CalendarEvent, start, end time.Time) GoogleEvent {
@@ -557,12 +969,6 @@ func calendarToGoogle(e CalendarEvent, start, end time.Time) GoogleEvent {
 		startTime.TimeZone = timezone
 		endTime.TimeZone = timezone
 	}
-	var recurrence []string
-	if e.Recurrence != "" && e.Recurrence != "none" && e.Recurrence != "rrule" {
-		recurrence = []string{e.Recurrence}
-	} else if e.Recurrence == "rrule" && e.RecurrenceEx != "" {
-		recurrence = strings.Split(e.RecurrenceEx, "\n")
-	}
 	return GoogleEvent{
 		Summary:     e.Title,
 		Description: e.Description,
@@ -570,7 +976,7 @@ func calendarToGoogle(e CalendarEvent, start, end time.Time) GoogleEvent {
 		ColorID:     colorID,
 		Start:       startTime,
 		End:         endTime,
-		Recurrence:  recurrence,
+		Recurrence:  recurrenceLines(e),
 	}
 }
 
@@ -591,6 +997,13 @@ func firstNonEmpty(vals ...string) string {
 	return ""
 }
 
+// googleService returns the current sync service, or nil before it is initialised.
+func (a *App) googleService() *GoogleSyncService {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.google
+}
+
 func (a *App) initGoogleSync() error {
 	appDir, err := appConfigDir()
 	if err != nil {
@@ -598,8 +1011,9 @@ func (a *App) initGoogleSync() error {
 	}
 
 	// Prefer settings file values, fall back to environment variables.
-	clientID := a.settings.GoogleClientID
-	clientSecret := a.settings.GoogleClientSecret
+	settings := a.currentSettings()
+	clientID := settings.GoogleClientID
+	clientSecret := settings.GoogleClientSecret
 	if clientID == "" {
 		clientID = os.Getenv("GOOGLE_CLIENT_ID")
 	}
@@ -610,22 +1024,39 @@ func (a *App) initGoogleSync() error {
 	if redirectURI == "" {
 		redirectURI = "http://localhost:34115/oauth2/callback"
 	}
+	// Endpoint overrides let the app run against a fake Google server; empty means Google.
+	authURL := firstNonEmpty(settings.GoogleAuthURL, os.Getenv("GOOGLE_AUTH_URL"))
+	tokenURL := firstNonEmpty(settings.GoogleTokenURL, os.Getenv("GOOGLE_TOKEN_URL"))
+	userInfoURL := firstNonEmpty(settings.GoogleUserInfoURL, os.Getenv("GOOGLE_USERINFO_URL"))
+	calendarBaseURL := firstNonEmpty(settings.GoogleCalendarBaseURL, os.Getenv("GOOGLE_CALENDAR_BASE_URL"))
 
-	a.google = NewGoogleSyncService(GoogleOAuthConfig{
+	google := NewGoogleSyncService(GoogleOAuthConfig{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		RedirectURI:  redirectURI,
 		Scopes: []string{
 			"https://www.googleapis.com/auth/calendar.events",
+			"https://www.googleapis.com/auth/calendar.calendarlist.readonly",
 			"openid",
 			"https://www.googleapis.com/auth/userinfo.email",
 			"https://www.googleapis.com/auth/userinfo.profile",
 		},
-	}, &FileTokenStore{
-		path: filepath.Join(appDir, "google_tokens.json"),
-	})
+		AuthURL:         authURL,
+		TokenURL:        tokenURL,
+		UserInfoURL:     userInfoURL,
+		CalendarBaseURL: calendarBaseURL,
+	}, nil)
+	a.mu.Lock()
+	a.google = google
+	a.mu.Unlock()
+	// Tokens live in one store per account; see googleForAccount.
+	if a.db != nil {
+		if err := a.migrateLegacyTokens(appDir); err != nil {
+			fmt.Printf("migrate google tokens: %v\n", err)
+		}
+	}
 
-	if !a.google.HasClientConfig() {
+	if !google.HasClientConfig() {
 		return errors.New("set GOOGLE_CLIENT_ID/GOOGLE_CLIENT_SECRET to enable Google Calendar")
 	}
 	// Fire up a local callback server to capture OAuth codes automatically.
@@ -641,9 +1072,10 @@ func (a *App) initGoogleSync() error {
 
 // SaveGoogleClientConfig persists the OAuth client credentials and re-initialises the sync service.
 func (a *App) SaveGoogleClientConfig(clientID, clientSecret string) error {
-	a.settings.GoogleClientID = strings.TrimSpace(clientID)
-	a.settings.GoogleClientSecret = strings.TrimSpace(clientSecret)
-	if err := a.saveSettings(a.settings); err != nil {
+	if _, err := a.modifySettings(func(cfg *AppSettings) {
+		cfg.GoogleClientID = strings.TrimSpace(clientID)
+		cfg.GoogleClientSecret = strings.TrimSpace(clientSecret)
+	}); err != nil {
 		return err
 	}
 	// Re-initialise so the new credentials take effect immediately.
@@ -652,7 +1084,7 @@ func (a *App) SaveGoogleClientConfig(clientID, clientSecret string) error {
 
 // GetGoogleClientConfig returns the stored OAuth client ID (secret is not returned for security).
 func (a *App) GetGoogleClientConfig() (string, error) {
-	clientID := a.settings.GoogleClientID
+	clientID := a.currentSettings().GoogleClientID
 	if clientID == "" {
 		clientID = os.Getenv("GOOGLE_CLIENT_ID")
 	}
@@ -661,31 +1093,49 @@ func (a *App) GetGoogleClientConfig() (string, error) {
 
 // CalendarEvent represents a stored event
 type CalendarEvent struct {
-	ID               string `json:"id"`
-	Title            string `json:"title"`
-	AllDay           bool   `json:"allDay"`
-	Start            string `json:"start"`
-	End              string `json:"end"`
-	Recurrence       string `json:"recurrence"`
-	RecurrenceEx     string `json:"recurrenceCustom"`
-	Location         string `json:"location"`
-	Alert            string `json:"alert"`
-	AlertOffset      int    `json:"alertOffset"`
-	Color            string `json:"color"`
-	Description      string `json:"description"`
-	SyncStatus       string `json:"syncStatus"`
+	ID           string `json:"id"`
+	Title        string `json:"title"`
+	AllDay       bool   `json:"allDay"`
+	Start        string `json:"start"`
+	End          string `json:"end"`
+	Recurrence   string `json:"recurrence"`
+	RecurrenceEx string `json:"recurrenceCustom"`
+	Location     string `json:"location"`
+	Alert        string `json:"alert"`
+	AlertOffset  int    `json:"alertOffset"`
+	// Reminders are the event's reminders, nearest to the start first; Alert and
+	// AlertOffset show the nearest popup. With UseDefaultReminders the event follows its
+	// Google calendar's default reminders instead and Reminders is empty.
+	Reminders           []Reminder `json:"reminders"`
+	UseDefaultReminders bool       `json:"useDefaultReminders"`
+	Color               string     `json:"color"`
+	Description         string     `json:"description"`
+	SyncStatus          string     `json:"syncStatus"`
+	AccountID           string     `json:"accountId"`
+	// Provider is the CalendarProvider the row syncs through (ProviderGoogle,
+	// ProviderCalDAV), empty until it first does. GoogleEventID and GoogleETag hold
+	// that provider's event ID and ETag.
+	Provider         string `json:"provider"`
 	GoogleEventID    string `json:"googleEventId"`
 	GoogleCalendarID string `json:"googleCalendarId"`
 	TimeZone         string `json:"timeZone"`
 	GoogleETag       string `json:"googleEtag"`
 	GoogleUpdatedAt  string `json:"googleUpdatedAt"`
-	UpdatedAt        string `json:"updatedAt"`
-	CreatedAt        string `json:"createdAt"`
+	// RecurringEventID and OriginalStart are set on a row that overrides one occurrence
+	// of a recurring event: the series' row ID and the occurrence's original start.
+	RecurringEventID string `json:"recurringEventId"`
+	OriginalStart    string `json:"originalStart"`
+	// ICalUID is the UID of an event imported from an iCalendar file, which a later
+	// import of the same event updates.
+	ICalUID   string `json:"icalUid"`
+	UpdatedAt string `json:"updatedAt"`
+	CreatedAt string `json:"createdAt"`
 }
 
 // GoogleTokenInfo represents the current login state.
 type GoogleTokenInfo struct {
 	Connected        bool   `json:"connected"`
+	AccountID        string `json:"accountId"`
 	ExpiresAt        string `json:"expiresAt"`
 	Scope            string `json:"scope"`
 	HasRefreshToken  bool   `json:"hasRefreshToken"`
@@ -701,13 +1151,19 @@ func (a *App) initDB() error {
 		return err
 	}
 	dbPath := filepath.Join(appDir, "events.db")
-	db, err := sql.Open("sqlite", dbPath)
+	// Reduce sqlite busy errors. Pragmas go in the DSN so every pooled connection gets them.
+	return a.openDB(dbPath + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+}
+
+// openDB opens the database at dsn and brings its schema up to date.
+func (a *App) openDB(dsn string) error {
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return err
 	}
-	// Reduce sqlite busy errors.
-	if _, err := db.Exec(`PRAGMA busy_timeout = 5000; PRAGMA journal_mode = WAL;`); err != nil {
-		fmt.Printf("warn: failed to set sqlite pragmas: %v\n", err)
+	if dsn == ":memory:" {
+		// Each connection would get its own empty in-memory database.
+		db.SetMaxOpenConns(1)
 	}
 	a.db = db
 	schema := `
@@ -725,11 +1181,16 @@ func (a *App) initDB() error {
 		color TEXT NOT NULL DEFAULT 'sky',
 		description TEXT,
 		sync_status TEXT NOT NULL DEFAULT 'local',
-		google_event_id TEXT,
+		provider TEXT NOT NULL DEFAULT '',
+		remote_id TEXT,
 		google_calendar_id TEXT,
 		time_zone TEXT,
-		google_etag TEXT,
+		remote_etag TEXT,
 		google_updated_at TIMESTAMP,
+		recurring_event_id TEXT,
+		original_start TEXT,
+		ical_uid TEXT,
+		reminders_use_default INTEGER NOT NULL DEFAULT 0,
 		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 	);
@@ -741,14 +1202,80 @@ func (a *App) initDB() error {
 	if err := ensureEventsColumns(db); err != nil {
 		return err
 	}
-	return ensureSyncStateTable(db)
+	if err := ensureCalendarsTable(db); err != nil {
+		return err
+	}
+	if err := ensureSyncStateTable(db); err != nil {
+		return err
+	}
+	if err := ensureCalendarSyncStateTable(db); err != nil {
+		return err
+	}
+	if err := ensureAccountsTable(db); err != nil {
+		return err
+	}
+	if err := ensureConflictsTable(db); err != nil {
+		return err
+	}
+	if err := ensureConflictHistoryTable(db); err != nil {
+		return err
+	}
+	if err := ensureOutboxTable(db); err != nil {
+		return err
+	}
+	if err := ensureEventRemindersTable(db); err != nil {
+		return err
+	}
+	if err := migrateAlertReminders(db); err != nil {
+		return err
+	}
+	if err := ensureRemindersFiredTable(db); err != nil {
+		return err
+	}
+	if err := ensureSubscriptionsTable(db); err != nil {
+		return err
+	}
+	if err := ensureCalDAVAccountsTable(db); err != nil {
+		return err
+	}
+	if err := migrateEventProviders(db); err != nil {
+		return err
+	}
+	return migrateFlattenedInstances(db)
+}
+
+// eventColumns is the column list read by scanEvent.
+const eventColumns = `id, title, all_day, start, end, COALESCE(recurrence,'none'), COALESCE(recurrence_custom,''), COALESCE(location,''), alert, alert_offset, COALESCE(color,''), COALESCE(description,''), sync_status, provider, COALESCE(account_id,''), COALESCE(remote_id,''), COALESCE(google_calendar_id,''), COALESCE(time_zone,''), COALESCE(remote_etag,''), google_updated_at, COALESCE(recurring_event_id,''), COALESCE(original_start,''), COALESCE(ical_uid,''), reminders_use_default, updated_at, created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanEvent reads one row selected with eventColumns.
+func scanEvent(row rowScanner) (CalendarEvent, error) {
+	var e CalendarEvent
+	var allDay int
+	var start, end, updatedAt, createdAt time.Time
+	var googleUpdatedAt sql.NullTime
+	if err := row.Scan(&e.ID, &e.Title, &allDay, &start, &end, &e.Recurrence, &e.RecurrenceEx, &e.Location, &e.Alert, &e.AlertOffset, &e.Color, &e.Description, &e.SyncStatus, &e.Provider, &e.AccountID, &e.GoogleEventID, &e.GoogleCalendarID, &e.TimeZone, &e.GoogleETag, &googleUpdatedAt, &e.RecurringEventID, &e.OriginalStart, &e.ICalUID, &e.UseDefaultReminders, &updatedAt, &createdAt); err != nil {
+		return CalendarEvent{}, err
+	}
+	e.AllDay = allDay == 1
+	e.Start = start.Format(time.RFC3339)
+	e.End = end.Format(time.RFC3339)
+	if googleUpdatedAt.Valid {
+		e.GoogleUpdatedAt = googleUpdatedAt.Time.Format(time.RFC3339)
+	}
+	e.UpdatedAt = updatedAt.Format(time.RFC3339)
+	e.CreatedAt = createdAt.Format(time.RFC3339)
+	return e, nil
 }
 
 func (a *App) ListEvents() ([]CalendarEvent, error) {
 	if a.db == nil {
 		return nil, errors.New("db not initialised")
 	}
-	rows, err := a.db.Query(`SELECT id, title, all_day, start, end, COALESCE(recurrence,'none'), COALESCE(recurrence_custom,''), COALESCE(location,''), alert, alert_offset, COALESCE(color,''), COALESCE(description,''), sync_status, COALESCE(google_event_id,''), COALESCE(google_calendar_id,''), COALESCE(time_zone,''), COALESCE(google_etag,''), google_updated_at, updated_at, created_at FROM events WHERE sync_status != 'deleted' ORDER BY start ASC`)
+	rows, err := a.db.Query(`SELECT ` + eventColumns + ` FROM events WHERE sync_status NOT IN ('deleted','cancelled') ORDER BY start ASC`)
 	if err != nil {
 		return nil, err
 	}
@@ -756,24 +1283,30 @@ func (a *App) ListEvents() ([]CalendarEvent, error) {
 
 	var events []CalendarEvent
 	for rows.Next() {
-		var e CalendarEvent
-		var allDay int
-		var start, end, updatedAt, createdAt time.Time
-		var googleUpdatedAt sql.NullTime
-		if err := rows.Scan(&e.ID, &e.Title, &allDay, &start, &end, &e.Recurrence, &e.RecurrenceEx, &e.Location, &e.Alert, &e.AlertOffset, &e.Color, &e.Description, &e.SyncStatus, &e.GoogleEventID, &e.GoogleCalendarID, &e.TimeZone, &e.GoogleETag, &googleUpdatedAt, &updatedAt, &createdAt); err != nil {
+		e, err := scanEvent(rows)
+		if err != nil {
 			return nil, err
 		}
-		e.AllDay = allDay == 1
-		e.Start = start.Format(time.RFC3339)
-		e.End = end.Format(time.RFC3339)
-		if googleUpdatedAt.Valid {
-			e.GoogleUpdatedAt = googleUpdatedAt.Time.Format(time.RFC3339)
-		}
-		e.UpdatedAt = updatedAt.Format(time.RFC3339)
-		e.CreatedAt = createdAt.Format(time.RFC3339)
 		events = append(events, e)
 	}
-	return events, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	return events, a.attachReminders(events)
+}
+
+// getEvent loads one event row, including rows pending deletion.
+func (a *App) getEvent(id string) (CalendarEvent, error) {
+	if a.db == nil {
+		return CalendarEvent{}, errors.New("db not initialised")
+	}
+	e, err := scanEvent(a.db.QueryRow(`SELECT `+eventColumns+` FROM events WHERE id = ?`, id))
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	e.Reminders, err = loadReminders(a.db, id)
+	return e, err
 }
 
 // SearchEvents returns events that match the query within the given time window.
@@ -819,9 +1352,9 @@ func (a *App) SearchEvents(query, start, end string, limit int) ([]CalendarEvent
 	}
 
 	sqlStr := `
-		SELECT id, title, all_day, start, end, COALESCE(recurrence,'none'), COALESCE(recurrence_custom,''), COALESCE(location,''), alert, alert_offset, COALESCE(color,''), COALESCE(description,''), sync_status, COALESCE(google_event_id,''), COALESCE(google_calendar_id,''), COALESCE(time_zone,''), COALESCE(google_etag,''), google_updated_at, updated_at, created_at
+		SELECT ` + eventColumns + `
 		FROM events
-		WHERE sync_status != 'deleted' AND start BETWEEN ? AND ?
+		WHERE sync_status NOT IN ('deleted','cancelled') AND start BETWEEN ? AND ?
 	`
 	args := []interface{}{startTime, endTime}
 
@@ -844,30 +1377,37 @@ func (a *App) SearchEvents(query, start, end string, limit int) ([]CalendarEvent
 
 	var events []CalendarEvent
 	for rows.Next() {
-		var e CalendarEvent
-		var allDay int
-		var startVal, endVal, updatedAt, createdAt time.Time
-		var googleUpdatedAt sql.NullTime
-		if err := rows.Scan(&e.ID, &e.Title, &allDay, &startVal, &endVal, &e.Recurrence, &e.RecurrenceEx, &e.Location, &e.Alert, &e.AlertOffset, &e.Color, &e.Description, &e.SyncStatus, &e.GoogleEventID, &e.GoogleCalendarID, &e.TimeZone, &e.GoogleETag, &googleUpdatedAt, &updatedAt, &createdAt); err != nil {
+		e, err := scanEvent(rows)
+		if err != nil {
 			return nil, err
 		}
-		e.AllDay = allDay == 1
-		e.Start = startVal.Format(time.RFC3339)
-		e.End = endVal.Format(time.RFC3339)
-		if googleUpdatedAt.Valid {
-			e.GoogleUpdatedAt = googleUpdatedAt.Time.Format(time.RFC3339)
-		}
-		e.UpdatedAt = updatedAt.Format(time.RFC3339)
-		e.CreatedAt = createdAt.Format(time.RFC3339)
 		events = append(events, e)
 	}
-	return events, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	return events, a.attachReminders(events)
 }
 
 func (a *App) CreateEvent(e CalendarEvent) (CalendarEvent, error) {
 	if a.db == nil {
 		return CalendarEvent{}, errors.New("db not initialised")
 	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	defer tx.Rollback()
+	e, err = insertEvent(tx, e)
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	return e, tx.Commit()
+}
+
+// insertEvent stores a new event and journals it in tx.
+func insertEvent(tx *sql.Tx, e CalendarEvent) (CalendarEvent, error) {
 	if e.ID == "" {
 		e.ID = fmt.Sprintf("evt-%d", time.Now().UnixNano())
 	}
@@ -884,9 +1424,10 @@ func (a *App) CreateEvent(e CalendarEvent) (CalendarEvent, error) {
 	if e.SyncStatus == "" {
 		e.SyncStatus = "local"
 	}
-	_, err = a.db.Exec(
-		`INSERT INTO events (id, title, all_day, start, end, recurrence, recurrence_custom, location, alert, alert_offset, color, description, sync_status, google_event_id, google_calendar_id, time_zone, google_etag, google_updated_at, updated_at, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+	e = reconcileReminders(e, false)
+	_, err = tx.Exec(
+		`INSERT INTO events (id, title, all_day, start, end, recurrence, recurrence_custom, location, alert, alert_offset, color, description, sync_status, account_id, remote_id, google_calendar_id, time_zone, remote_etag, google_updated_at, recurring_event_id, original_start, ical_uid, updated_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		e.ID,
 		e.Title,
 		boolToInt(e.AllDay),
@@ -900,15 +1441,30 @@ func (a *App) CreateEvent(e CalendarEvent) (CalendarEvent, error) {
 		e.Color,
 		e.Description,
 		e.SyncStatus,
+		e.AccountID,
 		e.GoogleEventID,
 		e.GoogleCalendarID,
 		e.TimeZone,
 		e.GoogleETag,
 		nil,
+		e.RecurringEventID,
+		e.OriginalStart,
+		e.ICalUID,
 		now,
 		now,
 	)
-	return e, err
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	if err := writeReminders(tx, e.ID, e); err != nil {
+		return CalendarEvent{}, err
+	}
+	if op := outboxOpFor(e); op != "" {
+		if err := appendOutbox(tx, e.ID, op); err != nil {
+			return CalendarEvent{}, err
+		}
+	}
+	return e, nil
 }
 
 func (a *App) UpdateEvent(e CalendarEvent) (CalendarEvent, error) {
@@ -918,18 +1474,56 @@ func (a *App) UpdateEvent(e CalendarEvent) (CalendarEvent, error) {
 	if e.ID == "" {
 		return CalendarEvent{}, errors.New("id required")
 	}
-	var dbGoogleEventID, dbGoogleCalendarID, dbTimeZone, dbGoogleETag sql.NullString
+	tx, err := a.db.Begin()
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	defer tx.Rollback()
+	e, err = updateEventRow(tx, e)
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	return e, tx.Commit()
+}
+
+// updateEventRow writes e over its row and journals the change in tx. Google identity
+// fields left empty keep their stored values.
+func updateEventRow(tx *sql.Tx, e CalendarEvent) (CalendarEvent, error) {
+	var dbAccountID, dbGoogleEventID, dbGoogleCalendarID, dbTimeZone, dbGoogleETag, dbAlert, dbICalUID, dbSyncStatus sql.NullString
 	var dbGoogleUpdatedAt sql.NullTime
-	if err := a.db.QueryRow(
-		`SELECT google_event_id, google_calendar_id, time_zone, google_etag, google_updated_at FROM events WHERE id = ?`,
+	var dbAlertOffset sql.NullInt64
+	var dbUseDefaultReminders sql.NullBool
+	if err := tx.QueryRow(
+		`SELECT account_id, remote_id, google_calendar_id, time_zone, remote_etag, google_updated_at, alert, alert_offset, reminders_use_default, ical_uid, sync_status FROM events WHERE id = ?`,
 		e.ID,
-	).Scan(&dbGoogleEventID, &dbGoogleCalendarID, &dbTimeZone, &dbGoogleETag, &dbGoogleUpdatedAt); err != nil && !errors.Is(err, sql.ErrNoRows) {
+	).Scan(&dbAccountID, &dbGoogleEventID, &dbGoogleCalendarID, &dbTimeZone, &dbGoogleETag, &dbGoogleUpdatedAt, &dbAlert, &dbAlertOffset, &dbUseDefaultReminders, &dbICalUID, &dbSyncStatus); err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return CalendarEvent{}, fmt.Errorf("lookup event: %w", err)
 	}
+	if dbSyncStatus.String == "subscribed" {
+		return CalendarEvent{}, errReadOnlyEvent
+	}
+	alertEdited := dbAlert.Valid && (e.Alert != dbAlert.String || int64(e.AlertOffset) != dbAlertOffset.Int64)
+	if e.Reminders == nil && !e.UseDefaultReminders && !alertEdited {
+		// Callers unaware of reminders keep the stored ones.
+		reminders, err := loadReminders(tx, e.ID)
+		if err != nil {
+			return CalendarEvent{}, err
+		}
+		e.Reminders, e.UseDefaultReminders = reminders, dbUseDefaultReminders.Bool
+	}
+	if alertEdited && e.Reminders != nil {
+		// An alert following edited reminders was not edited itself.
+		alert, offset := alertForReminders(e.Reminders)
+		alertEdited = e.Alert != alert || e.AlertOffset != offset
+	}
+	e = reconcileReminders(e, alertEdited)
 	startTime, endTime, err := parseEventTimes(e)
 	if err != nil {
 		return CalendarEvent{}, err
 	}
+	if e.AccountID == "" && dbAccountID.Valid {
+		e.AccountID = dbAccountID.String
+	}
 	if e.GoogleEventID == "" && dbGoogleEventID.Valid {
 		e.GoogleEventID = dbGoogleEventID.String
 	}
@@ -939,6 +1533,9 @@ func (a *App) UpdateEvent(e CalendarEvent) (CalendarEvent, error) {
 	if e.GoogleETag == "" && dbGoogleETag.Valid {
 		e.GoogleETag = dbGoogleETag.String
 	}
+	if e.ICalUID == "" && dbICalUID.Valid {
+		e.ICalUID = dbICalUID.String
+	}
 	var googleUpdatedAt *time.Time
 	if e.GoogleUpdatedAt != "" {
 		parsed, err := time.Parse(time.RFC3339, e.GoogleUpdatedAt)
@@ -964,8 +1561,8 @@ func (a *App) UpdateEvent(e CalendarEvent) (CalendarEvent, error) {
 		// Mark updates as dirty so they are pushed on next sync.
 		e.SyncStatus = "dirty"
 	}
-	res, err := a.db.Exec(
-		`UPDATE events SET title=?, all_day=?, start=?, end=?, recurrence=?, recurrence_custom=?, location=?, alert=?, alert_offset=?, color=?, description=?, sync_status=?, google_event_id=?, google_calendar_id=?, time_zone=?, google_etag=?, google_updated_at=?, updated_at=? WHERE id=?`,
+	res, err := tx.Exec(
+		`UPDATE events SET title=?, all_day=?, start=?, end=?, recurrence=?, recurrence_custom=?, location=?, alert=?, alert_offset=?, color=?, description=?, sync_status=?, account_id=?, remote_id=?, google_calendar_id=?, time_zone=?, remote_etag=?, google_updated_at=?, ical_uid=?, updated_at=? WHERE id=?`,
 		e.Title,
 		boolToInt(e.AllDay),
 		startTime,
@@ -978,11 +1575,13 @@ func (a *App) UpdateEvent(e CalendarEvent) (CalendarEvent, error) {
 		e.Color,
 		e.Description,
 		e.SyncStatus,
+		e.AccountID,
 		e.GoogleEventID,
 		e.GoogleCalendarID,
 		e.TimeZone,
 		e.GoogleETag,
 		googleUpdatedAt,
+		e.ICalUID,
 		now,
 		e.ID,
 	)
@@ -993,6 +1592,14 @@ func (a *App) UpdateEvent(e CalendarEvent) (CalendarEvent, error) {
 	if rows == 0 {
 		return CalendarEvent{}, errors.New("event not found")
 	}
+	if err := writeReminders(tx, e.ID, e); err != nil {
+		return CalendarEvent{}, err
+	}
+	if op := outboxOpFor(e); op != "" {
+		if err := appendOutbox(tx, e.ID, op); err != nil {
+			return CalendarEvent{}, err
+		}
+	}
 	return e, nil
 }
 
@@ -1003,18 +1610,56 @@ func (a *App) DeleteEvent(id string) error {
 	if id == "" {
 		return errors.New("id required")
 	}
+	var masterID, originalStart, syncStatus string
+	if err := a.db.QueryRow(`SELECT COALESCE(recurring_event_id,''), COALESCE(original_start,''), sync_status FROM events WHERE id = ?`, id).Scan(&masterID, &originalStart, &syncStatus); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("lookup event: %w", err)
+	}
+	if syncStatus == "subscribed" {
+		return errReadOnlyEvent
+	}
+	if masterID != "" {
+		// An overridden occurrence; deleting it removes the occurrence from its series.
+		// If the series has lost it already, only the row is left to remove.
+		if err := a.DeleteOccurrence(masterID, originalStart, OccurrenceThis); !errors.Is(err, errNoOccurrence) {
+			return err
+		}
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := removeEvent(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeEvent deletes a row in tx, together with the rows overriding its occurrences.
+func removeEvent(tx *sql.Tx, id string) error {
 	// Check if this event has a Google counterpart that needs remote deletion.
 	var googleEventID sql.NullString
-	if err := a.db.QueryRow(`SELECT google_event_id FROM events WHERE id = ?`, id).Scan(&googleEventID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+	if err := tx.QueryRow(`SELECT remote_id FROM events WHERE id = ?`, id).Scan(&googleEventID); err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return fmt.Errorf("lookup event: %w", err)
 	}
+	// Google drops the exceptions of a deleted series itself.
+	if err := deleteExceptions(tx, id); err != nil {
+		return err
+	}
 	if googleEventID.Valid && googleEventID.String != "" {
-		// Mark for remote deletion on next sync.
-		_, err := a.db.Exec(`UPDATE events SET sync_status='deleted' WHERE id = ?`, id)
+		// Mark for remote deletion on next sync. The time of the deletion is what the
+		// newest-wins conflict policy compares.
+		if _, err := tx.Exec(`UPDATE events SET sync_status='deleted', updated_at=? WHERE id = ?`, time.Now(), id); err != nil {
+			return err
+		}
+		return appendOutbox(tx, id, OutboxDelete)
+	}
+	// Pure local event — remove immediately, along with its unpushed changes. A push
+	// already creating it notices the row is gone and deletes the remote copy.
+	if _, err := tx.Exec(`DELETE FROM events WHERE id = ?`, id); err != nil {
 		return err
 	}
-	// Pure local event — remove immediately.
-	_, err := a.db.Exec(`DELETE FROM events WHERE id = ?`, id)
+	_, err := tx.Exec(`DELETE FROM outbox WHERE event_id = ?`, id)
 	return err
 }
 
@@ -1058,20 +1703,6 @@ func parseEventTimes(e CalendarEvent) (time.Time, time.Time, error) {
 	return startTime, endTime, nil
 }
 
-// clearLocalData removes locally stored calendar data without touching remote calendars.
-func (a *App) clearLocalData() error {
-	if a.db == nil {
-		return errors.New("db not initialised")
-	}
-	if _, err := a.db.Exec(`DELETE FROM events`); err != nil {
-		return fmt.Errorf("clear events: %w", err)
-	}
-	if _, err := a.db.Exec(`DELETE FROM sync_state`); err != nil {
-		return fmt.Errorf("clear sync state: %w", err)
-	}
-	return nil
-}
-
 func appConfigDir() (string, error) {
 	configDir, err := os.UserConfigDir()
 	if err != nil {
@@ -1104,12 +1735,34 @@ func ensureEventsColumns(db *sql.DB) error {
 		columns[name] = true
 	}
 
+	// The remote mapping was Google's only before CalendarProvider.
+	renames := map[string]string{
+		"google_event_id": "remote_id",
+		"google_etag":     "remote_etag",
+	}
+	for old, col := range renames {
+		if !columns[old] || columns[col] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE events RENAME COLUMN %s TO %s", old, col)); err != nil {
+			return fmt.Errorf("rename column %s: %w", old, err)
+		}
+		columns[col] = true
+	}
+
 	additions := map[string]string{
-		"google_event_id":    "TEXT",
+		"account_id":         "TEXT",
+		"provider":           "TEXT NOT NULL DEFAULT ''",
+		"remote_id":          "TEXT",
 		"google_calendar_id": "TEXT",
 		"time_zone":          "TEXT",
-		"google_etag":        "TEXT",
+		"remote_etag":        "TEXT",
 		"google_updated_at":  "TIMESTAMP",
+		"recurring_event_id": "TEXT",
+		"original_start":     "TEXT",
+		"ical_uid":           "TEXT",
+		// Set when the event follows its calendar's default reminders (see event_reminders).
+		"reminders_use_default": "INTEGER NOT NULL DEFAULT 0",
 	}
 
 	for col, definition := range additions {
@@ -1121,7 +1774,11 @@ func ensureEventsColumns(db *sql.DB) error {
 		}
 	}
 
-	return nil
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS events_recurring ON events (recurring_event_id, original_start)`); err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS events_ical_uid ON events (ical_uid)`)
+	return err
 }
 
 func ensureSyncStateTable(db *sql.DB) error {
@@ -1169,6 +1826,27 @@ type AppSettings struct {
 	AutoStart          bool   `json:"autoStart"`
 	GoogleClientID     string `json:"googleClientId,omitempty"`
 	GoogleClientSecret string `json:"googleClientSecret,omitempty"`
+	ActiveAccountID    string `json:"activeAccountId,omitempty"`
+	// ConflictPolicy resolves sync conflicts automatically; empty or "manual" parks them.
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+	// SyncIntervalMinutes sets the background sync interval; 0 uses the default, negative disables it.
+	SyncIntervalMinutes int `json:"syncIntervalMinutes,omitempty"`
+	// Google endpoint overrides, e.g. a local fake server; empty uses Google.
+	GoogleAuthURL         string `json:"googleAuthUrl,omitempty"`
+	GoogleTokenURL        string `json:"googleTokenUrl,omitempty"`
+	GoogleUserInfoURL     string `json:"googleUserInfoUrl,omitempty"`
+	GoogleCalendarBaseURL string `json:"googleCalendarBaseUrl,omitempty"`
+	// CalDAV server letting other calendar clients use the events; the address defaults
+	// to 127.0.0.1:5232 and clients sign in with the username and password.
+	CalDAVServerEnabled  bool   `json:"caldavServerEnabled,omitempty"`
+	CalDAVServerAddr     string `json:"caldavServerAddr,omitempty"`
+	CalDAVServerUsername string `json:"caldavServerUsername,omitempty"`
+	CalDAVServerPassword string `json:"caldavServerPassword,omitempty"`
+	// JSON API for scripts; the address defaults to 127.0.0.1:5233 and requests carry the
+	// token, which is generated when the API is enabled without one.
+	APIServerEnabled bool   `json:"apiServerEnabled,omitempty"`
+	APIServerAddr    string `json:"apiServerAddr,omitempty"`
+	APIServerToken   string `json:"apiServerToken,omitempty"`
 }
 
 func defaultSettings() AppSettings {
@@ -1193,8 +1871,9 @@ func (a *App) loadSettings() error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			a.settings = defaultSettings()
-			return a.saveSettings(a.settings)
+			cfg := defaultSettings()
+			a.setSettings(cfg)
+			return a.saveSettings(cfg)
 		}
 		return err
 	}
@@ -1202,10 +1881,37 @@ func (a *App) loadSettings() error {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return err
 	}
-	a.settings = cfg
+	a.setSettings(cfg)
 	return nil
 }
 
+// currentSettings returns a copy of the settings in effect.
+func (a *App) currentSettings() AppSettings {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.settings
+}
+
+func (a *App) setSettings(cfg AppSettings) {
+	a.mu.Lock()
+	a.settings = cfg
+	a.mu.Unlock()
+}
+
+// modifySettings applies fn to the current settings and saves the result, holding the
+// lock throughout so concurrent changes are not lost.
+func (a *App) modifySettings(fn func(cfg *AppSettings)) (AppSettings, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cfg := a.settings
+	fn(&cfg)
+	if err := a.saveSettings(cfg); err != nil {
+		return AppSettings{}, err
+	}
+	a.settings = cfg
+	return cfg, nil
+}
+
 func (a *App) saveSettings(cfg AppSettings) error {
 	path, err := a.settingsPath()
 	if err != nil {
@@ -1218,31 +1924,85 @@ func (a *App) saveSettings(cfg AppSettings) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0o644)
+	// The file holds client and server secrets, so it is kept private like the tokens.
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0o600)
 }
 
-// GetSettings returns persisted app settings. GoogleClientSecret is omitted from the response.
+// GetSettings returns persisted app settings. GoogleClientSecret and CalDAVServerPassword
+// are omitted from the response.
 func (a *App) GetSettings() (AppSettings, error) {
-	if a.settings == (AppSettings{}) {
+	if a.currentSettings() == (AppSettings{}) {
 		if err := a.loadSettings(); err != nil {
 			return AppSettings{}, err
 		}
 	}
-	safe := a.settings
+	safe := a.currentSettings()
 	safe.GoogleClientSecret = ""
+	safe.CalDAVServerPassword = ""
 	return safe, nil
 }
 
-// UpdateSettings saves new settings and applies side effects like autostart.
+// UpdateSettings saves new settings and applies side effects like autostart. cfg replaces
+// all settings, so callers send back what GetSettings returned with their changes; the
+// secrets it leaves out keep their stored value when empty. ActiveAccountID is kept, as
+// only SetActiveAccount changes it.
 func (a *App) UpdateSettings(cfg AppSettings) (AppSettings, error) {
+	stored := a.currentSettings()
+	if cfg.GoogleClientSecret == "" {
+		cfg.GoogleClientSecret = stored.GoogleClientSecret
+	}
+	if cfg.CalDAVServerPassword == "" {
+		cfg.CalDAVServerPassword = stored.CalDAVServerPassword
+	}
+	if cfg.APIServerToken == "" {
+		cfg.APIServerToken = stored.APIServerToken
+	}
+	if cfg.APIServerEnabled && cfg.APIServerToken == "" {
+		token, err := newAPIToken()
+		if err != nil {
+			return AppSettings{}, err
+		}
+		cfg.APIServerToken = token
+	}
+	if !validConflictPolicy(cfg.ConflictPolicy) {
+		return AppSettings{}, fmt.Errorf("unknown conflict policy: %s", cfg.ConflictPolicy)
+	}
+	if cfg.SyncIntervalMinutes > maxSyncIntervalMinutes {
+		return AppSettings{}, fmt.Errorf("sync interval must be at most %d minutes", maxSyncIntervalMinutes)
+	}
+	if err := validateCalDAVServerSettings(cfg); err != nil {
+		return AppSettings{}, err
+	}
+	if err := validateAPIServerSettings(cfg); err != nil {
+		return AppSettings{}, err
+	}
 	if err := a.applyAutoStart(cfg.AutoStart); err != nil {
 		return AppSettings{}, err
 	}
-	if err := a.saveSettings(cfg); err != nil {
+	saved, err := a.modifySettings(func(current *AppSettings) {
+		cfg.ActiveAccountID = current.ActiveAccountID
+		*current = cfg
+	})
+	if err != nil {
 		return AppSettings{}, err
 	}
-	a.settings = cfg
-	return cfg, nil
+	if a.scheduler != nil {
+		a.scheduler.reschedule()
+	}
+	if a.dav != nil {
+		if err := a.dav.apply(saved); err != nil {
+			return AppSettings{}, fmt.Errorf("caldav server: %w", err)
+		}
+	}
+	if a.api != nil {
+		if err := a.api.apply(saved); err != nil {
+			return AppSettings{}, fmt.Errorf("api server: %w", err)
+		}
+	}
+	return saved, nil
 }
 
 func (a *App) applyAutoStart(enabled bool) error {
@@ -0,0 +1,901 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const caldavRequestTimeout = 30 * time.Second
+
+// Request bodies of the CalDAV client.
+const (
+	caldavHomeQuery = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop><d:current-user-principal/><c:calendar-home-set/></d:prop>
+</d:propfind>`
+	caldavCalendarsQuery = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:a="http://apple.com/ns/ical/">
+  <d:prop>
+    <d:resourcetype/><d:displayname/><a:calendar-color/><c:calendar-timezone/>
+    <c:supported-calendar-component-set/><d:current-user-privilege-set/>
+  </d:prop>
+</d:propfind>`
+	caldavSyncTokenQuery = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:sync-token/></d:prop></d:propfind>`
+	caldavEventsQuery = `<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop><d:getetag/><c:calendar-data/></d:prop>
+  <c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VEVENT"/></c:comp-filter></c:filter>
+</c:calendar-query>`
+)
+
+// CalDAVClient talks to one account on a CalDAV server (RFC 4791), e.g. Nextcloud or
+// Fastmail. It offers the event operations of GoogleSyncService on the same GoogleEvent
+// shape, so CalDAV calendars are pulled, pushed and conflict-checked by the same code.
+//
+// Calendar IDs are collection URLs. Event IDs are resource names without ".ics"; every
+// common server and client names resources that way. An exception of a series lives in
+// the series' resource but is addressed like a Google instance, as
+// "<series ID>_<original start>", and carries the ETag of the whole resource.
+type CalDAVClient struct {
+	baseURL    *url.URL
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+// NewCalDAVClient returns a client for the account at serverURL, which may be the
+// server's DAV root, the user's principal or the calendar home.
+func NewCalDAVClient(serverURL, username, password string) (*CalDAVClient, error) {
+	u, err := url.Parse(strings.TrimSpace(serverURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid caldav url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("caldav url must be an http or https url")
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		u.RawPath = ""
+	}
+	return &CalDAVClient{
+		baseURL:    u,
+		username:   username,
+		password:   password,
+		httpClient: &http.Client{Timeout: caldavRequestTimeout},
+	}, nil
+}
+
+// Name implements CalendarProvider.
+func (c *CalDAVClient) Name() string { return ProviderCalDAV }
+
+// Capabilities implements CalendarProvider. A CalDAV listing is the collection as it is,
+// and alarms only exist on events.
+func (c *CalDAVClient) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{SnapshotListing: true, SeriesResource: true}
+}
+
+// ListCalendars discovers the account's calendar home and returns the calendars in it
+// that hold events. Calendars the user may not write to are listed as readers.
+func (c *CalDAVClient) ListCalendars(ctx context.Context) ([]GoogleCalendarListEntry, error) {
+	home, err := c.calendarHome(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := c.multistatus(ctx, "PROPFIND", home.String(), "1", caldavCalendarsQuery)
+	if err != nil {
+		return nil, err
+	}
+	var entries []GoogleCalendarListEntry
+	for _, r := range ms.Responses {
+		p, ok := r.prop()
+		if !ok || p.ResourceType == nil || p.ResourceType.Calendar == nil || !p.supports("VEVENT") {
+			continue
+		}
+		id, err := resolveHref(home, r.Href)
+		if err != nil {
+			return nil, err
+		}
+		entry := GoogleCalendarListEntry{
+			ID:              id.String(),
+			Summary:         firstNonEmpty(strings.TrimSpace(p.DisplayName), path.Base(id.Path)),
+			BackgroundColor: caldavColor(p.CalendarColor),
+			TimeZone:        caldavTimeZone(p.CalendarTimeZone),
+			AccessRole:      "owner",
+			Selected:        true,
+		}
+		if !p.writable() {
+			entry.AccessRole = "reader"
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// calendarHome finds the calendar home, following the current user's principal when
+// the server URL is not the principal itself.
+func (c *CalDAVClient) calendarHome(ctx context.Context) (*url.URL, error) {
+	target := c.baseURL
+	for i := 0; i < 2; i++ {
+		ms, err := c.multistatus(ctx, "PROPFIND", target.String(), "0", caldavHomeQuery)
+		if err != nil {
+			return nil, err
+		}
+		principal := ""
+		for _, r := range ms.Responses {
+			p, ok := r.prop()
+			if !ok {
+				continue
+			}
+			if p.CalendarHomeSet != nil && p.CalendarHomeSet.Href != "" {
+				return resolveHref(target, p.CalendarHomeSet.Href)
+			}
+			if p.CurrentUserPrincipal != nil && p.CurrentUserPrincipal.Href != "" {
+				principal = p.CurrentUserPrincipal.Href
+			}
+		}
+		if principal == "" {
+			break
+		}
+		next, err := resolveHref(target, principal)
+		if err != nil {
+			return nil, err
+		}
+		if next.String() == target.String() {
+			break
+		}
+		target = next
+	}
+	return nil, errors.New("caldav server reported no calendar home")
+}
+
+// ListEvents returns the events of a calendar. Without a sync token it reads the whole
+// calendar with a calendar-query report; with one it asks for the resources changed since
+// through sync-collection (RFC 6578) and fetches them with calendar-multiget. Resources
+// removed since come back as cancelled events.
+func (c *CalDAVClient) ListEvents(ctx context.Context, calendarID string, syncToken string) ([]GoogleEvent, string, error) {
+	if syncToken != "" {
+		return c.changedEvents(ctx, calendarID, syncToken)
+	}
+	// The token is read first, so changes made while the calendar is being read are seen
+	// again by the next sync rather than missed.
+	ms, err := c.multistatus(ctx, "PROPFIND", calendarID, "0", caldavSyncTokenQuery)
+	if err != nil {
+		return nil, "", err
+	}
+	token := ""
+	for _, r := range ms.Responses {
+		if p, ok := r.prop(); ok && p.SyncToken != "" {
+			token = p.SyncToken
+		}
+	}
+	if ms, err = c.multistatus(ctx, "REPORT", calendarID, "1", caldavEventsQuery); err != nil {
+		return nil, "", err
+	}
+	events, err := resourceEvents(calendarID, ms)
+	if err != nil {
+		return nil, "", err
+	}
+	return events, token, nil
+}
+
+func (c *CalDAVClient) changedEvents(ctx context.Context, calendarID, syncToken string) ([]GoogleEvent, string, error) {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:sync-collection xmlns:d="DAV:"><d:sync-token>` + xmlText(syncToken) + `</d:sync-token><d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop></d:sync-collection>`
+	ms, err := c.multistatus(ctx, "REPORT", calendarID, "1", body)
+	var apiErr *googleAPIError
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.Body, "valid-sync-token") {
+		return nil, "", errSyncTokenExpired
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	base, err := url.Parse(calendarID)
+	if err != nil {
+		return nil, "", err
+	}
+	var events []GoogleEvent
+	var hrefs []string
+	for _, r := range ms.Responses {
+		href, err := resolveHref(base, r.Href)
+		if err != nil {
+			return nil, "", err
+		}
+		if href.String() == calendarID {
+			continue
+		}
+		if davStatusCode(r.Status) == http.StatusNotFound {
+			events = append(events, GoogleEvent{ID: caldavEventID(href), Status: "cancelled"})
+			continue
+		}
+		hrefs = append(hrefs, href.EscapedPath())
+	}
+	if len(hrefs) > 0 {
+		var query strings.Builder
+		query.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:prop><d:getetag/><c:calendar-data/></d:prop>`)
+		for _, href := range hrefs {
+			query.WriteString("<d:href>" + xmlText(href) + "</d:href>")
+		}
+		query.WriteString("</c:calendar-multiget>")
+		fetched, err := c.multistatus(ctx, "REPORT", calendarID, "1", query.String())
+		if err != nil {
+			return nil, "", err
+		}
+		changed, err := resourceEvents(calendarID, fetched)
+		if err != nil {
+			return nil, "", err
+		}
+		events = append(events, changed...)
+	}
+	return events, ms.SyncToken, nil
+}
+
+// resourceEvents reads the events of the resources in a report. Resources that cannot
+// be read are skipped with a note in the log.
+func resourceEvents(calendarID string, ms davMultistatus) ([]GoogleEvent, error) {
+	base, err := url.Parse(calendarID)
+	if err != nil {
+		return nil, err
+	}
+	var events []GoogleEvent
+	for _, r := range ms.Responses {
+		p, ok := r.prop()
+		if !ok || p.CalendarData == "" {
+			continue
+		}
+		href, err := resolveHref(base, r.Href)
+		if err != nil {
+			return nil, err
+		}
+		got, err := caldavEvents(caldavEventID(href), p.GetETag, p.CalendarData)
+		if err != nil {
+			fmt.Printf("caldav: skip %s: %v\n", href.Redacted(), err)
+			continue
+		}
+		events = append(events, got...)
+	}
+	return events, nil
+}
+
+// GetEvent fetches a single event or exception.
+func (c *CalDAVClient) GetEvent(ctx context.Context, calendarID, eventID string) (GoogleEvent, error) {
+	resourceID, _, _ := splitCalDAVInstanceID(eventID)
+	data, etag, err := c.getResource(ctx, calendarID, resourceID)
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	events, err := caldavEvents(resourceID, etag, data)
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	for _, ev := range events {
+		if ev.ID == eventID {
+			return ev, nil
+		}
+	}
+	return GoogleEvent{}, errGoogleNotFound
+}
+
+// CreateEventWithID creates ev as the resource "<eventID>.ics". A resource already there
+// is an earlier copy whose response was lost, and is updated to ev instead.
+func (c *CalDAVClient) CreateEventWithID(ctx context.Context, calendarID, eventID string, ev GoogleEvent) (GoogleEvent, error) {
+	if eventID == "" {
+		eventID = fmt.Sprintf("evt-%d", time.Now().UnixNano())
+	}
+	ev.ID = eventID
+	data, err := caldavResource("", ev, time.Time{}, false, time.Now())
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	etag, err := c.putResource(ctx, calendarID, eventID, data, "")
+	if errors.Is(err, errGoogleConflict) {
+		return c.UpdateEvent(ctx, calendarID, eventID, "", ev)
+	}
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	return c.written(ctx, calendarID, eventID, etag)
+}
+
+// UpdateEvent writes ev over an event or exception. A non-empty etag must match the
+// resource's, or errGoogleConflict is returned. Properties the widget does not manage,
+// such as attendees, are kept.
+func (c *CalDAVClient) UpdateEvent(ctx context.Context, calendarID, eventID, etag string, ev GoogleEvent) (GoogleEvent, error) {
+	resourceID, original, instance := splitCalDAVInstanceID(eventID)
+	current, currentETag, err := c.getResource(ctx, calendarID, resourceID)
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	if etag != "" && etag != currentETag {
+		return GoogleEvent{}, errGoogleConflict
+	}
+	ev.ID = eventID
+	data, err := caldavResource(current, ev, original, instance, time.Now())
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	newETag, err := c.putResource(ctx, calendarID, resourceID, data, currentETag)
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	return c.written(ctx, calendarID, eventID, newETag)
+}
+
+// DeleteEvent deletes an event. A non-empty etag must match the resource's, or
+// errGoogleConflict is returned. Deleting an exception takes its occurrence out of the
+// series, as deleting a Google instance does; the series is rewritten against the ETag
+// just read, as the one stored for an exception goes stale with each write of the series.
+func (c *CalDAVClient) DeleteEvent(ctx context.Context, calendarID, eventID, etag string) error {
+	resourceID, original, instance := splitCalDAVInstanceID(eventID)
+	if !instance {
+		var header map[string]string
+		if etag != "" {
+			header = map[string]string{"If-Match": etag}
+		}
+		resp, err := c.do(ctx, http.MethodDelete, caldavEventURL(calendarID, resourceID), "", header)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+			return nil
+		case resp.StatusCode == http.StatusPreconditionFailed:
+			return errGoogleConflict
+		case resp.StatusCode >= 400:
+			return newGoogleAPIError("caldav delete", resp)
+		}
+		return nil
+	}
+	current, etag, err := c.getResource(ctx, calendarID, resourceID)
+	if errors.Is(err, errGoogleNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := caldavWithoutOccurrence(current, original)
+	if err != nil {
+		return err
+	}
+	_, err = c.putResource(ctx, calendarID, resourceID, data, etag)
+	return err
+}
+
+// written returns what the sync code keeps of a write: the event's ID and the resource's
+// new ETag. Servers that change the data they store send no ETag, so it is read back.
+func (c *CalDAVClient) written(ctx context.Context, calendarID, eventID, etag string) (GoogleEvent, error) {
+	if etag == "" {
+		resourceID, _, _ := splitCalDAVInstanceID(eventID)
+		var err error
+		if _, etag, err = c.getResource(ctx, calendarID, resourceID); err != nil {
+			return GoogleEvent{}, err
+		}
+	}
+	return GoogleEvent{ID: eventID, Etag: etag, Updated: time.Now().UTC().Format(time.RFC3339)}, nil
+}
+
+func (c *CalDAVClient) getResource(ctx context.Context, calendarID, resourceID string) (data, etag string, err error) {
+	resp, err := c.do(ctx, http.MethodGet, caldavEventURL(calendarID, resourceID), "", nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return "", "", errGoogleNotFound
+	}
+	if resp.StatusCode >= 400 {
+		return "", "", newGoogleAPIError("caldav get", resp)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	return string(body), resp.Header.Get("ETag"), nil
+}
+
+// putResource writes a resource. An empty ifMatch creates it and fails with
+// errGoogleConflict if it exists; otherwise the resource must still have that ETag.
+func (c *CalDAVClient) putResource(ctx context.Context, calendarID, resourceID, data, ifMatch string) (string, error) {
+	header := map[string]string{"Content-Type": "text/calendar; charset=utf-8", "If-None-Match": "*"}
+	if ifMatch != "" {
+		header = map[string]string{"Content-Type": "text/calendar; charset=utf-8", "If-Match": ifMatch}
+	}
+	resp, err := c.do(ctx, http.MethodPut, caldavEventURL(calendarID, resourceID), data, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return "", errGoogleConflict
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return "", errGoogleNotFound
+	case resp.StatusCode >= 400:
+		return "", newGoogleAPIError("caldav put", resp)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// multistatus sends a PROPFIND or REPORT and decodes its 207 Multi-Status answer. Other
+// answers are returned as API errors, which the sync code treats like Google's.
+func (c *CalDAVClient) multistatus(ctx context.Context, method, target, depth, body string) (davMultistatus, error) {
+	resp, err := c.do(ctx, method, target, body, map[string]string{"Depth": depth, "Content-Type": "application/xml; charset=utf-8"})
+	if err != nil {
+		return davMultistatus{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return davMultistatus{}, newGoogleAPIError("caldav "+strings.ToLower(method), resp)
+	}
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return davMultistatus{}, fmt.Errorf("caldav %s: %w", strings.ToLower(method), err)
+	}
+	return ms, nil
+}
+
+func (c *CalDAVClient) do(ctx context.Context, method, target, body string, header map[string]string) (*http.Response, error) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return c.httpClient.Do(req)
+}
+
+// caldavEvents reads the VEVENTs of resource id: the series or single event, and the
+// exceptions of a series.
+func caldavEvents(id, etag, data string) ([]GoogleEvent, error) {
+	root, err := parseICS(strings.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var events []GoogleEvent
+	for _, cal := range root.components {
+		for _, c := range cal.components {
+			if cal.name != "VCALENDAR" || c.name != "VEVENT" {
+				continue
+			}
+			ev, err := caldavEvent(c, id, etag)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+// caldavEvent maps a VEVENT of resource id onto the event shape of the sync code.
+func caldavEvent(c *icsComponent, id, etag string) (GoogleEvent, error) {
+	ev, err := parseVEvent(c)
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	start, end, err := parseEventTimes(ev.CalendarEvent)
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	ge := calendarToGoogle(ev.CalendarEvent, start, end)
+	ge.ID, ge.ICalUID, ge.Etag, ge.Status = id, ev.uid, etag, "confirmed"
+	if ev.cancelled {
+		ge.Status = "cancelled"
+	}
+	// An event without alarms has no reminders; CalDAV calendars have no defaults.
+	ge.Reminders = &GoogleReminders{Overrides: ev.Reminders}
+	for _, name := range []string{"LAST-MODIFIED", "DTSTAMP"} {
+		if p, ok := c.prop(name); ok {
+			if t, _, _, err := icsPropTime(p); err == nil {
+				ge.Updated = t.UTC().Format(time.RFC3339)
+				break
+			}
+		}
+	}
+	if p, ok := c.prop("RECURRENCE-ID"); ok {
+		_, dateOnly, _, _ := icsPropTime(p)
+		ge.ID = occurrenceID(id, ev.recurrenceID, dateOnly)
+		ge.RecurringEventID = id
+		if dateOnly {
+			ge.OriginalStartTime = &GoogleEventTime{Date: ev.recurrenceID.Format("2006-01-02")}
+		} else {
+			ge.OriginalStartTime = &GoogleEventTime{DateTime: ev.recurrenceID.UTC().Format(time.RFC3339)}
+		}
+	}
+	return ge, nil
+}
+
+// caldavManagedProps are the VEVENT properties written from the event; the others are
+// kept from the stored copy when an event is rewritten.
+var caldavManagedProps = map[string]bool{
+	"UID": true, "DTSTAMP": true, "DTSTART": true, "DTEND": true, "DURATION": true, "RECURRENCE-ID": true,
+	"SUMMARY": true, "DESCRIPTION": true, "LOCATION": true, "RRULE": true, "RDATE": true, "EXDATE": true,
+	"LAST-MODIFIED": true,
+}
+
+// caldavResource returns the iCalendar data of a resource after writing ev into it, as
+// the series or single event or, when instance is set, as the exception for the
+// occurrence originally starting at original. current is the resource's data, or empty
+// for a new resource.
+func caldavResource(current string, ev GoogleEvent, original time.Time, instance bool, now time.Time) (string, error) {
+	cal, err := caldavCalendar(current)
+	if err != nil {
+		return "", err
+	}
+	master, target := caldavTargets(cal, original, instance)
+	if instance && master == nil {
+		// Exceptions are written after their series; without it there is no occurrence.
+		return "", errGoogleNotFound
+	}
+
+	e := googleToCalendar("", "", ev)
+	e.ICalUID = firstNonEmpty(ev.ICalUID, ev.ID)
+	e.UpdatedAt = now.UTC().Format(time.RFC3339)
+	var series *CalendarEvent
+	if master != nil {
+		m, err := parseVEvent(master)
+		if err != nil {
+			return "", err
+		}
+		m.ICalUID = m.uid
+		e.ICalUID = m.uid
+		if instance {
+			e.OriginalStart = originalStartKey(original)
+			series = &m.CalendarEvent
+		}
+	}
+	var reminders []Reminder
+	if ev.Reminders != nil && !ev.Reminders.UseDefault {
+		reminders = ev.Reminders.Overrides
+	}
+	var buf bytes.Buffer
+	w := &icsWriter{w: &buf}
+	if err := writeVEvent(w, e, series, nil, reminders, "", now); err != nil {
+		return "", err
+	}
+	if w.err != nil {
+		return "", w.err
+	}
+	written, err := parseICS(&buf)
+	if err != nil {
+		return "", err
+	}
+	vevent := written.components[0]
+	if target != nil {
+		for _, p := range target.props {
+			if !caldavManagedProps[p.name] {
+				vevent.props = append(vevent.props, p)
+			}
+		}
+		for _, sub := range target.components {
+			if sub.name != "VALARM" {
+				vevent.components = append(vevent.components, sub)
+			}
+		}
+		*target = *vevent
+	} else {
+		cal.components = append(cal.components, vevent)
+	}
+	if zone := icsTimeZone(e); zone != "" {
+		start, _, err := parseEventTimes(e)
+		if err != nil {
+			return "", err
+		}
+		if err := addVTimezone(cal, zone, start, now); err != nil {
+			return "", err
+		}
+	}
+	return caldavData(cal)
+}
+
+// caldavWithoutOccurrence returns the data of a resource after taking the occurrence
+// originally starting at original out of its series.
+func caldavWithoutOccurrence(current string, original time.Time) (string, error) {
+	cal, err := caldavCalendar(current)
+	if err != nil {
+		return "", err
+	}
+	master, target := caldavTargets(cal, original, true)
+	if target != nil {
+		kept := cal.components[:0]
+		for _, c := range cal.components {
+			if c != target {
+				kept = append(kept, c)
+			}
+		}
+		cal.components = kept
+	}
+	if master != nil {
+		m, err := parseVEvent(master)
+		if err != nil {
+			return "", err
+		}
+		line := "EXDATE" + icsTimeValue(original, m.AllDay, icsTimeZone(m.CalendarEvent))
+		if p, err := parseICSLine(line); err == nil {
+			master.props = append(master.props, p)
+		}
+	}
+	return caldavData(cal)
+}
+
+// caldavCalendar parses resource data into its VCALENDAR, or starts a new one.
+func caldavCalendar(data string) (*icsComponent, error) {
+	if data == "" {
+		cal := &icsComponent{name: "VCALENDAR"}
+		for _, line := range []string{"VERSION:2.0", "PRODID:" + icsProductID} {
+			p, err := parseICSLine(line)
+			if err != nil {
+				return nil, err
+			}
+			cal.props = append(cal.props, p)
+		}
+		return cal, nil
+	}
+	root, err := parseICS(strings.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range root.components {
+		if c.name == "VCALENDAR" {
+			return c, nil
+		}
+	}
+	return nil, errors.New("resource holds no VCALENDAR")
+}
+
+// caldavTargets returns the series VEVENT of cal and the VEVENT an event is written
+// over: the series itself, or the exception for original when instance is set. Either
+// may be nil.
+func caldavTargets(cal *icsComponent, original time.Time, instance bool) (master, target *icsComponent) {
+	for _, c := range cal.components {
+		if c.name != "VEVENT" {
+			continue
+		}
+		p, ok := c.prop("RECURRENCE-ID")
+		if !ok {
+			master = c
+			continue
+		}
+		if t, _, _, err := icsPropTime(p); err == nil && instance && t.Equal(original) {
+			target = c
+		}
+	}
+	if !instance {
+		target = master
+	}
+	return master, target
+}
+
+// addVTimezone adds a VTIMEZONE for zone to cal unless it has one, covering the time
+// from start until two years from now.
+func addVTimezone(cal *icsComponent, zone string, start, now time.Time) error {
+	for _, c := range cal.components {
+		if c.name == "VTIMEZONE" && c.value("TZID") == zone {
+			return nil
+		}
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return err
+	}
+	to := now.AddDate(2, 0, 0)
+	if to.Before(start) {
+		to = start.AddDate(2, 0, 0)
+	}
+	var buf bytes.Buffer
+	w := &icsWriter{w: &buf}
+	writeVTimezone(w, loc, start.In(loc), to)
+	if w.err != nil {
+		return w.err
+	}
+	root, err := parseICS(&buf)
+	if err != nil {
+		return err
+	}
+	cal.components = append([]*icsComponent{root.components[0]}, cal.components...)
+	return nil
+}
+
+// caldavData writes a VCALENDAR back out as iCalendar data.
+func caldavData(cal *icsComponent) (string, error) {
+	var buf bytes.Buffer
+	w := &icsWriter{w: &buf}
+	writeICSComponent(w, cal)
+	return buf.String(), w.err
+}
+
+func writeICSComponent(w *icsWriter, c *icsComponent) {
+	w.line("BEGIN:" + c.name)
+	for _, p := range c.props {
+		w.line(p.raw)
+	}
+	for _, sub := range c.components {
+		writeICSComponent(w, sub)
+	}
+	w.line("END:" + c.name)
+}
+
+// splitCalDAVInstanceID splits an exception's ID into the ID of its series' resource
+// and its original start; other IDs come back unchanged.
+func splitCalDAVInstanceID(id string) (resourceID string, original time.Time, instance bool) {
+	i := strings.LastIndex(id, "_")
+	if i <= 0 {
+		return id, time.Time{}, false
+	}
+	original, ok := instanceOriginalStart(id[i+1:])
+	if !ok {
+		return id, time.Time{}, false
+	}
+	return id[:i], original, true
+}
+
+// caldavEventURL returns the URL of the resource holding event id.
+func caldavEventURL(calendarID, id string) string {
+	return strings.TrimSuffix(calendarID, "/") + "/" + url.PathEscape(id) + ".ics"
+}
+
+// caldavEventID returns the event ID of the resource at href.
+func caldavEventID(href *url.URL) string {
+	return strings.TrimSuffix(path.Base(href.Path), ".ics")
+}
+
+// caldavColor turns an Apple calendar-color (#RRGGBB or #RRGGBBAA) into #RRGGBB.
+func caldavColor(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) == 9 && strings.HasPrefix(v, "#") {
+		return v[:7]
+	}
+	return v
+}
+
+// caldavTimeZone returns the TZID of a calendar-timezone VTIMEZONE when it is a zone
+// known here.
+func caldavTimeZone(data string) string {
+	if strings.TrimSpace(data) == "" {
+		return ""
+	}
+	root, err := parseICS(strings.NewReader(data))
+	if err != nil {
+		return ""
+	}
+	for _, cal := range root.components {
+		for _, c := range cal.components {
+			if c.name != "VTIMEZONE" {
+				continue
+			}
+			if tzid := c.value("TZID"); tzid != "" {
+				if _, err := time.LoadLocation(tzid); err == nil {
+					return tzid
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func resolveHref(base *url.URL, href string) (*url.URL, error) {
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return nil, fmt.Errorf("invalid href %q: %w", href, err)
+	}
+	return base.ResolveReference(ref), nil
+}
+
+func xmlText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// davStatusCode reads the code of a status line such as "HTTP/1.1 404 Not Found".
+func davStatusCode(status string) int {
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(fields[1])
+	return code
+}
+
+// davMultistatus is a WebDAV 207 Multi-Status answer, limited to what the client reads.
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+	SyncToken string        `xml:"DAV: sync-token"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Status    string        `xml:"DAV: status"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"DAV: prop"`
+	Status string  `xml:"DAV: status"`
+}
+
+type davHref struct {
+	Href string `xml:"DAV: href"`
+}
+
+type davProp struct {
+	CurrentUserPrincipal *davHref `xml:"DAV: current-user-principal"`
+	CalendarHomeSet      *davHref `xml:"urn:ietf:params:xml:ns:caldav calendar-home-set"`
+	ResourceType         *struct {
+		Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+	} `xml:"DAV: resourcetype"`
+	DisplayName         string `xml:"DAV: displayname"`
+	CalendarColor       string `xml:"http://apple.com/ns/ical/ calendar-color"`
+	CalendarTimeZone    string `xml:"urn:ietf:params:xml:ns:caldav calendar-timezone"`
+	SupportedComponents *struct {
+		Comps []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"urn:ietf:params:xml:ns:caldav comp"`
+	} `xml:"urn:ietf:params:xml:ns:caldav supported-calendar-component-set"`
+	PrivilegeSet *struct {
+		Privileges []struct {
+			All          *struct{} `xml:"DAV: all"`
+			Write        *struct{} `xml:"DAV: write"`
+			WriteContent *struct{} `xml:"DAV: write-content"`
+		} `xml:"DAV: privilege"`
+	} `xml:"DAV: current-user-privilege-set"`
+	GetETag      string `xml:"DAV: getetag"`
+	CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+	SyncToken    string `xml:"DAV: sync-token"`
+}
+
+// prop returns the properties the server found for the response.
+func (r davResponse) prop() (davProp, bool) {
+	for _, ps := range r.Propstats {
+		if code := davStatusCode(ps.Status); code >= 200 && code < 300 {
+			return ps.Prop, true
+		}
+	}
+	return davProp{}, false
+}
+
+// supports reports whether a calendar takes components called name; calendars that do
+// not say take every kind.
+func (p davProp) supports(name string) bool {
+	if p.SupportedComponents == nil || len(p.SupportedComponents.Comps) == 0 {
+		return true
+	}
+	for _, comp := range p.SupportedComponents.Comps {
+		if strings.EqualFold(comp.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// writable reports whether the user may change a calendar's events; servers that do
+// not report privileges are assumed to allow it.
+func (p davProp) writable() bool {
+	if p.PrivilegeSet == nil {
+		return true
+	}
+	for _, priv := range p.PrivilegeSet.Privileges {
+		if priv.All != nil || priv.Write != nil || priv.WriteContent != nil {
+			return true
+		}
+	}
+	return false
+}
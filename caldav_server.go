@@ -0,0 +1,872 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventCalendarChanged is emitted when another client changed events through the CalDAV
+// server.
+const EventCalendarChanged = "calendar:changed"
+
+const (
+	// defaultCalDAVServerAddr applies when AppSettings.CalDAVServerAddr is empty; it only
+	// accepts connections from this machine.
+	defaultCalDAVServerAddr = "127.0.0.1:5232"
+	// maxCalDAVRequestBytes bounds the request bodies the server reads.
+	maxCalDAVRequestBytes = 10 << 20
+)
+
+// Paths the CalDAV server answers on. The calendar home holds one calendar, the events
+// table.
+const (
+	davPrincipalPath  = "/principal/"
+	davHomePath       = "/calendars/"
+	davCollectionPath = "/calendars/widget/"
+)
+
+const (
+	caldavNS         = "urn:ietf:params:xml:ns:caldav"
+	calendarServerNS = "http://calendarserver.org/ns/"
+	appleICalNS      = "http://apple.com/ns/ical/"
+)
+
+// davPrefixes are the prefixes of the namespaces in the server's answers.
+var davPrefixes = map[string]string{"DAV:": "d", caldavNS: "c", calendarServerNS: "cs", appleICalNS: "a"}
+
+// calDAVServer runs the CalDAV server selected in the settings, letting other calendar
+// clients read and edit the events.
+type calDAVServer struct {
+	app  *App
+	emit func(name string, data ...interface{})
+
+	mu     sync.Mutex
+	config calDAVServerConfig
+	srv    *http.Server
+	// addr is the address listened on, which tells the port chosen for ":0".
+	addr string
+}
+
+// calDAVServerConfig is what the CalDAV server runs with.
+type calDAVServerConfig struct {
+	enabled  bool
+	addr     string
+	username string
+	password string
+}
+
+func calDAVServerConfigOf(cfg AppSettings) calDAVServerConfig {
+	if !cfg.CalDAVServerEnabled {
+		return calDAVServerConfig{}
+	}
+	return calDAVServerConfig{
+		enabled:  true,
+		addr:     firstNonEmpty(strings.TrimSpace(cfg.CalDAVServerAddr), defaultCalDAVServerAddr),
+		username: cfg.CalDAVServerUsername,
+		password: cfg.CalDAVServerPassword,
+	}
+}
+
+// validateCalDAVServerSettings checks the CalDAV server settings of cfg when it is enabled.
+func validateCalDAVServerSettings(cfg AppSettings) error {
+	c := calDAVServerConfigOf(cfg)
+	if !c.enabled {
+		return nil
+	}
+	if c.username == "" || c.password == "" {
+		return errors.New("caldav server needs a username and password")
+	}
+	if _, _, err := net.SplitHostPort(c.addr); err != nil {
+		return fmt.Errorf("invalid caldav server address: %w", err)
+	}
+	return nil
+}
+
+// startCalDAVServer starts the CalDAV server if the settings enable it; UpdateSettings
+// restarts or stops it as they change.
+func (a *App) startCalDAVServer(emit func(name string, data ...interface{})) {
+	a.dav = &calDAVServer{app: a, emit: emit}
+	if err := a.dav.apply(a.currentSettings()); err != nil {
+		fmt.Printf("caldav server: %v\n", err)
+	}
+}
+
+// apply brings the server in line with cfg, restarting it when its address or
+// credentials changed.
+func (s *calDAVServer) apply(cfg AppSettings) error {
+	want := calDAVServerConfigOf(cfg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if want == s.config && (s.srv != nil) == want.enabled {
+		return nil
+	}
+	s.stopLocked()
+	if !want.enabled {
+		return nil
+	}
+	h := &calDAVHandler{app: s.app, username: want.username, password: want.password, changed: func() {
+		if s.emit != nil {
+			s.emit(EventCalendarChanged)
+		}
+	}}
+	srv, addr, err := serveLocal("caldav server", want.addr, h)
+	if err != nil {
+		return err
+	}
+	s.srv, s.config, s.addr = srv, want, addr
+	return nil
+}
+
+// serveLocal starts serving h on addr, returning the server and the address it listens
+// on. name prefixes the errors it logs.
+func serveLocal(name, addr string, h http.Handler) (*http.Server, string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, "", err
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("%s: %v\n", name, err)
+		}
+	}()
+	return srv, ln.Addr().String(), nil
+}
+
+// stop shuts the server down.
+func (s *calDAVServer) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopLocked()
+}
+
+func (s *calDAVServer) stopLocked() {
+	if s.srv != nil {
+		s.srv.Close()
+	}
+	s.srv, s.config, s.addr = nil, calDAVServerConfig{}, ""
+}
+
+// calDAVHandler serves the events as one calendar collection (RFC 4791) to clients signed
+// in with the configured credentials. A series is one resource together with the rows
+// overriding its occurrences, named after the series' ID; events of subscribed feeds are
+// left out. Writes go through CreateEvent, UpdateEvent and the occurrence methods like
+// an ICS import, so they are synced onwards like edits made in the widget.
+type calDAVHandler struct {
+	app      *App
+	username string
+	password string
+	// changed is called after a write changed events.
+	changed func()
+}
+
+// ServeHTTP implements http.Handler.
+func (h *calDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(h.username)) != 1 || subtle.ConstantTimeCompare([]byte(pass), []byte(h.password)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="calendar widget"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxCalDAVRequestBytes)
+	w.Header().Set("DAV", "1, 3, calendar-access")
+
+	p := r.URL.Path
+	switch {
+	case r.Method == http.MethodOptions:
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		w.WriteHeader(http.StatusOK)
+	case p == "/.well-known/caldav":
+		http.Redirect(w, r, davPrincipalPath, http.StatusMovedPermanently)
+	case p == "/" || p == davPrincipalPath || p == davHomePath || p == davCollectionPath:
+		switch {
+		case r.Method == "PROPFIND":
+			h.propfind(w, r, p)
+		case r.Method == "REPORT" && p == davCollectionPath:
+			h.report(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case strings.HasPrefix(p, davCollectionPath) && strings.HasSuffix(p, ".ics") && !strings.Contains(p[len(davCollectionPath):], "/"):
+		h.resource(w, r, strings.TrimSuffix(p[len(davCollectionPath):], ".ics"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// davResource is a series or single event served as one calendar object resource, with
+// the rows overriding its occurrences.
+type davResource struct {
+	events []CalendarEvent
+	etag   string
+}
+
+func (res davResource) id() string { return res.events[0].ID }
+
+func (res davResource) href() string {
+	return davCollectionPath + url.PathEscape(res.id()) + ".ics"
+}
+
+// davResources returns the resources of the collection, ordered by start.
+func (a *App) davResources() ([]davResource, error) {
+	return a.loadDAVResources("")
+}
+
+// davResourceByID returns the resource of the series or single event id.
+func (a *App) davResourceByID(id string) (davResource, bool, error) {
+	resources, err := a.loadDAVResources(`AND (id = ? OR recurring_event_id = ?)`, id, id)
+	if err != nil || len(resources) == 0 {
+		return davResource{}, false, err
+	}
+	return resources[0], true, nil
+}
+
+// loadDAVResources returns the resources made of the rows cond selects, ordered by start.
+func (a *App) loadDAVResources(cond string, args ...any) ([]davResource, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	rows, err := a.db.Query(`SELECT `+eventColumns+` FROM events WHERE sync_status NOT IN ('deleted','cancelled') `+cond+` ORDER BY start ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	var events []CalendarEvent
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := a.attachReminders(events); err != nil {
+		return nil, err
+	}
+	changes, err := a.davChanges(cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	var out []davResource
+	for _, e := range events {
+		if e.RecurringEventID == "" && e.SyncStatus != "subscribed" {
+			index[e.ID] = len(out)
+			out = append(out, davResource{events: []CalendarEvent{e}})
+		}
+	}
+	for _, e := range events {
+		if i, ok := index[e.RecurringEventID]; ok {
+			out[i].events = append(out[i].events, e)
+		}
+	}
+	for i := range out {
+		out[i].etag = davETag(out[i].events[0], changes[out[i].id()])
+	}
+	return out, nil
+}
+
+// davChanges returns, by series or single event, when each of its rows among those
+// cond selects last changed, the rows cancelling its occurrences included.
+func (a *App) davChanges(cond string, args ...any) (map[string][]string, error) {
+	rows, err := a.db.Query(`SELECT id, COALESCE(recurring_event_id,''), updated_at FROM events WHERE sync_status <> 'deleted' `+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := make(map[string][]string)
+	for rows.Next() {
+		var id, masterID string
+		var updated time.Time
+		if err := rows.Scan(&id, &masterID, &updated); err != nil {
+			return nil, err
+		}
+		key := firstNonEmpty(masterID, id)
+		changes[key] = append(changes[key], id+" "+updated.UTC().Format(time.RFC3339Nano))
+	}
+	return changes, rows.Err()
+}
+
+// davETag derives a resource's ETag from the changes of its rows and the UID it is
+// served under, which changes without them when a local event is first synced.
+func davETag(master CalendarEvent, changes []string) string {
+	sort.Strings(changes)
+	h := sha256.New()
+	io.WriteString(h, icalUID(master)+"\n")
+	for _, change := range changes {
+		io.WriteString(h, change+"\n")
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// davCalendarData returns the iCalendar data of a resource. It is stamped with the
+// latest change of its rows rather than the current time, so the data only changes
+// with them.
+func (a *App) davCalendarData(res davResource) (string, error) {
+	var stamp time.Time
+	for _, e := range res.events {
+		if t, err := time.Parse(time.RFC3339, e.UpdatedAt); err == nil && t.After(stamp) {
+			stamp = t
+		}
+	}
+	var b strings.Builder
+	byID := map[string]CalendarEvent{res.id(): res.events[0]}
+	if err := a.writeVCalendar(&b, res.events, byID, stamp); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// davCTag returns the collection's getctag, which changes with any of its resources.
+func davCTag(resources []davResource) string {
+	tags := make([]string, len(resources))
+	for i, res := range resources {
+		tags[i] = res.id() + "=" + res.etag
+	}
+	sort.Strings(tags)
+	h := fnv.New64a()
+	for _, tag := range tags {
+		io.WriteString(h, tag+"\n")
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum64())
+}
+
+// davRequest is the body of a PROPFIND or REPORT, limited to what the server reads.
+type davRequest struct {
+	XMLName xml.Name
+	AllProp *struct{} `xml:"DAV: allprop"`
+	Prop    *struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+	Hrefs  []string `xml:"DAV: href"`
+	Filter *struct {
+		Comp davCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+}
+
+type davCompFilter struct {
+	Name      string          `xml:"name,attr"`
+	Comps     []davCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	TimeRange *struct {
+		Start string `xml:"start,attr"`
+		End   string `xml:"end,attr"`
+	} `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+}
+
+// propNames returns the properties asked for, or nil for all of them.
+func (q davRequest) propNames() []xml.Name {
+	if q.Prop == nil || q.AllProp != nil {
+		return nil
+	}
+	names := make([]xml.Name, 0, len(q.Prop.Names))
+	for _, n := range q.Prop.Names {
+		names = append(names, n.XMLName)
+	}
+	return names
+}
+
+// readDAVRequest decodes the XML body of r; an empty body asks for every property.
+func readDAVRequest(r *http.Request) (davRequest, error) {
+	var q davRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return q, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return q, nil
+	}
+	err = xml.Unmarshal(body, &q)
+	return q, err
+}
+
+func (h *calDAVHandler) propfind(w http.ResponseWriter, r *http.Request, p string) {
+	q, err := readDAVRequest(r)
+	if err != nil {
+		http.Error(w, "invalid PROPFIND body", http.StatusBadRequest)
+		return
+	}
+	names := q.propNames()
+	deep := r.Header.Get("Depth") != "0"
+	principal := davHrefProp("DAV:", "current-user-principal", davPrincipalPath)
+	var responses []string
+	switch p {
+	case "/", davPrincipalPath:
+		responses = append(responses, davResponseXML(p, names,
+			davValue("DAV:", "resourcetype", "<d:collection/><d:principal/>"),
+			principal,
+			davHrefProp("DAV:", "principal-URL", davPrincipalPath),
+			davHrefProp(caldavNS, "calendar-home-set", davHomePath)))
+	case davHomePath:
+		responses = append(responses, davResponseXML(p, names,
+			davValue("DAV:", "resourcetype", "<d:collection/>"), principal))
+		if deep {
+			resources, err := h.app.davResources()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			responses = append(responses, davResponseXML(davCollectionPath, names, davCollectionProps(resources)...))
+		}
+	case davCollectionPath:
+		resources, err := h.app.davResources()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		responses = append(responses, davResponseXML(p, names, davCollectionProps(resources)...))
+		if deep {
+			for _, res := range resources {
+				responses = append(responses, davResponseXML(res.href(), names, davResourceProps(res, "")...))
+			}
+		}
+	}
+	writeDAVMultistatus(w, responses)
+}
+
+func (h *calDAVHandler) report(w http.ResponseWriter, r *http.Request) {
+	q, err := readDAVRequest(r)
+	if err != nil {
+		http.Error(w, "invalid REPORT body", http.StatusBadRequest)
+		return
+	}
+	names := q.propNames()
+	withData := names == nil
+	for _, n := range names {
+		if n.Space == caldavNS && n.Local == "calendar-data" {
+			withData = true
+		}
+	}
+	resources, err := h.app.davResources()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var selected []davResource
+	var responses []string
+	switch q.XMLName {
+	case xml.Name{Space: caldavNS, Local: "calendar-query"}:
+		if selected, err = davQuery(resources, q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case xml.Name{Space: caldavNS, Local: "calendar-multiget"}:
+		byID := make(map[string]davResource, len(resources))
+		for _, res := range resources {
+			byID[res.id()] = res
+		}
+		for _, href := range q.Hrefs {
+			if u, err := url.Parse(strings.TrimSpace(href)); err == nil && strings.HasPrefix(u.Path, davCollectionPath) {
+				if res, ok := byID[strings.TrimSuffix(u.Path[len(davCollectionPath):], ".ics")]; ok {
+					selected = append(selected, res)
+					continue
+				}
+			}
+			responses = append(responses, davStatusResponse(href, http.StatusNotFound))
+		}
+	default:
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+`<d:error xmlns:d="DAV:"><d:supported-report/></d:error>`)
+		return
+	}
+	for _, res := range selected {
+		data := ""
+		if withData {
+			if data, err = h.app.davCalendarData(res); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		responses = append(responses, davResponseXML(res.href(), names, davResourceProps(res, data)...))
+	}
+	writeDAVMultistatus(w, responses)
+}
+
+// davQuery returns the resources matching a calendar-query filter: those with an
+// occurrence in its time range, or none when it asks for components other than events.
+func davQuery(resources []davResource, q davRequest) ([]davResource, error) {
+	if q.Filter == nil || len(q.Filter.Comp.Comps) == 0 {
+		return resources, nil
+	}
+	var event *davCompFilter
+	for i, c := range q.Filter.Comp.Comps {
+		if c.Name == "VEVENT" {
+			event = &q.Filter.Comp.Comps[i]
+		}
+	}
+	if event == nil {
+		return nil, nil
+	}
+	if event.TimeRange == nil {
+		return resources, nil
+	}
+	from := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, bound := range []struct {
+		value string
+		t     *time.Time
+	}{{event.TimeRange.Start, &from}, {event.TimeRange.End, &to}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse("20060102T150405Z", bound.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time-range: %w", err)
+		}
+		*bound.t = t
+	}
+	var out []davResource
+	for _, res := range resources {
+		for _, e := range res.events {
+			occurrences, err := expandEvent(e, from, to)
+			if err != nil {
+				occurrences, _ = expandEvent(withoutRecurrence(e), from, to)
+			}
+			if len(occurrences) > 0 {
+				out = append(out, res)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+func (h *calDAVHandler) resource(w http.ResponseWriter, r *http.Request, name string) {
+	id, err := url.PathUnescape(name)
+	if err != nil || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	res, exists, err := h.app.davResourceByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := h.app.davCalendarData(res)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("ETag", res.etag)
+		io.WriteString(w, data)
+	case http.MethodPut:
+		if !davPreconditionHolds(r, res.etag) {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		if status, err := h.put(r, id, exists); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		h.wrote()
+		if stored, ok, err := h.app.davResourceByID(id); err == nil && ok {
+			w.Header().Set("ETag", stored.etag)
+		}
+		if exists {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+	case http.MethodDelete:
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		if !davPreconditionHolds(r, res.etag) {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		if err := h.app.DeleteEvent(id); err != nil {
+			http.Error(w, err.Error(), writeErrorStatus(err))
+			return
+		}
+		h.wrote()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// put stores the resource id from the body of r, returning the status to answer with
+// when it fails.
+func (h *calDAVHandler) put(r *http.Request, id string, exists bool) (int, error) {
+	root, err := parseICS(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	var master *icsComponent
+	var overrides []*icsComponent
+	uid := ""
+	for _, cal := range root.components {
+		for _, c := range cal.components {
+			if cal.name != "VCALENDAR" || c.name != "VEVENT" {
+				continue
+			}
+			if uid == "" {
+				uid = c.value("UID")
+			}
+			if c.value("UID") != uid || uid == "" {
+				return http.StatusBadRequest, errors.New("a resource holds the events of one UID")
+			}
+			if _, ok := c.prop("RECURRENCE-ID"); ok {
+				overrides = append(overrides, c)
+			} else if master == nil {
+				master = c
+			} else {
+				return http.StatusBadRequest, errors.New("more than one event without RECURRENCE-ID")
+			}
+		}
+	}
+	if uid == "" {
+		return http.StatusBadRequest, errors.New("no events in resource")
+	}
+	owner, found, err := h.app.eventByICalUID(uid)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if found != exists || (found && owner.ID != id) {
+		return http.StatusConflict, errors.New("the UID belongs to another resource")
+	}
+	if master == nil {
+		return http.StatusConflict, errors.New("occurrences can only be stored with their series")
+	}
+	ev, err := parseVEvent(master)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	ev.ID = id
+	if _, _, err := h.app.importEvent(ev); err != nil {
+		return writeErrorStatus(err), err
+	}
+	kept := make(map[string]bool, len(overrides))
+	for _, c := range overrides {
+		res := h.app.importVEvent(c)
+		if res.Status == ImportFailed {
+			return http.StatusBadRequest, fmt.Errorf("occurrence %s: %s", res.RecurrenceID, res.Error)
+		}
+		kept[res.RecurrenceID] = true
+	}
+	if err := h.app.dropOverrides(id, kept); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// dropOverrides undoes the changes to occurrences of the series masterID that a client
+// left out of the series it wrote, all but those at the original starts in kept. Rows
+// only stored here are removed; those synced to a calendar are reset to the series,
+// as removing them there would cancel the occurrence.
+func (a *App) dropOverrides(masterID string, kept map[string]bool) error {
+	rows, err := a.db.Query(`SELECT id, COALESCE(original_start,''), COALESCE(remote_id,'') FROM events WHERE recurring_event_id = ? AND sync_status NOT IN ('deleted','cancelled')`, masterID)
+	if err != nil {
+		return err
+	}
+	type override struct{ id, original, remoteID string }
+	var dropped []override
+	for rows.Next() {
+		var o override
+		if err := rows.Scan(&o.id, &o.original, &o.remoteID); err != nil {
+			rows.Close()
+			return err
+		}
+		if !kept[o.original] {
+			dropped = append(dropped, o)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, o := range dropped {
+		if o.remoteID != "" {
+			occ, err := a.loadOccurrence(masterID, o.original)
+			if err == nil {
+				instance, err := seriesInstance(occ)
+				if err != nil {
+					return err
+				}
+				if _, err := a.overrideOccurrence(occ, instance); err != nil {
+					return err
+				}
+				continue
+			}
+			if !errors.Is(err, errNoOccurrence) {
+				return err
+			}
+			// The series no longer has the occurrence; the row goes as below.
+		}
+		tx, err := a.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := removeEvent(tx, o.id); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seriesInstance returns the occurrence occ as the series generates it.
+func seriesInstance(occ seriesOccurrence) (CalendarEvent, error) {
+	e := occ.master
+	start, end, err := parseEventTimes(e)
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	e.Start = occ.original.Format(time.RFC3339)
+	e.End = occ.original.Add(end.Sub(start)).Format(time.RFC3339)
+	return e, nil
+}
+
+// wrote tells the app that a client changed events.
+func (h *calDAVHandler) wrote() {
+	h.app.reminders.reschedule()
+	if h.changed != nil {
+		h.changed()
+	}
+}
+
+// writeErrorStatus returns the HTTP status answering a write that failed with err: the
+// event methods fail on what they were given unless the event is read-only.
+func writeErrorStatus(err error) int {
+	if errors.Is(err, errReadOnlyEvent) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// davPreconditionHolds reports whether the If-Match and If-None-Match headers of r hold
+// for a resource with etag, or for a missing one when etag is empty.
+func davPreconditionHolds(r *http.Request, etag string) bool {
+	if m := r.Header.Get("If-Match"); m != "" && (etag == "" || (m != "*" && m != etag)) {
+		return false
+	}
+	if n := r.Header.Get("If-None-Match"); n != "" && etag != "" && (n == "*" || n == etag) {
+		return false
+	}
+	return true
+}
+
+// davProperty is a property the server has for a resource, as XML.
+type davProperty struct {
+	name  xml.Name
+	inner string
+}
+
+func davValue(space, local, inner string) davProperty {
+	return davProperty{name: xml.Name{Space: space, Local: local}, inner: inner}
+}
+
+func davHrefProp(space, local, href string) davProperty {
+	return davValue(space, local, "<d:href>"+xmlText(href)+"</d:href>")
+}
+
+func davCollectionProps(resources []davResource) []davProperty {
+	return []davProperty{
+		davValue("DAV:", "resourcetype", "<d:collection/><c:calendar/>"),
+		davValue("DAV:", "displayname", "Calendar Widget"),
+		davValue(caldavNS, "supported-calendar-component-set", `<c:comp name="VEVENT"/>`),
+		davValue("DAV:", "current-user-privilege-set", "<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>"),
+		davValue("DAV:", "supported-report-set", "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>"+
+			"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>"),
+		davValue(calendarServerNS, "getctag", xmlText(davCTag(resources))),
+		davHrefProp("DAV:", "current-user-principal", davPrincipalPath),
+	}
+}
+
+// davResourceProps returns the properties of a resource; calendar-data is only there
+// when data is given.
+func davResourceProps(res davResource, data string) []davProperty {
+	props := []davProperty{
+		davValue("DAV:", "resourcetype", ""),
+		davValue("DAV:", "getetag", xmlText(res.etag)),
+		davValue("DAV:", "getcontenttype", "text/calendar; charset=utf-8"),
+	}
+	if data != "" {
+		props = append(props, davValue(caldavNS, "calendar-data", xmlText(data)))
+	}
+	return props
+}
+
+// davResponseXML renders the response for href with the properties asked for, or all
+// of them when names is nil. Properties the server does not have are answered as not
+// found.
+func davResponseXML(href string, names []xml.Name, props ...davProperty) string {
+	var found, missing strings.Builder
+	if names == nil {
+		for _, p := range props {
+			writeDAVProperty(&found, p)
+		}
+	}
+	for _, n := range names {
+		i := 0
+		for i < len(props) && props[i].name != n {
+			i++
+		}
+		if i < len(props) {
+			writeDAVProperty(&found, props[i])
+		} else {
+			fmt.Fprintf(&missing, `<%s xmlns="%s"/>`, n.Local, xmlText(n.Space))
+		}
+	}
+	var b strings.Builder
+	b.WriteString("<d:response><d:href>" + xmlText(href) + "</d:href>")
+	if found.Len() > 0 || missing.Len() == 0 {
+		b.WriteString("<d:propstat><d:prop>" + found.String() + "</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>")
+	}
+	if missing.Len() > 0 {
+		b.WriteString("<d:propstat><d:prop>" + missing.String() + "</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>")
+	}
+	b.WriteString("</d:response>")
+	return b.String()
+}
+
+func writeDAVProperty(b *strings.Builder, p davProperty) {
+	name := davPrefixes[p.name.Space] + ":" + p.name.Local
+	b.WriteString("<" + name + ">" + p.inner + "</" + name + ">")
+}
+
+func davStatusResponse(href string, status int) string {
+	return fmt.Sprintf("<d:response><d:href>%s</d:href><d:status>HTTP/1.1 %d %s</d:status></d:response>", xmlText(href), status, http.StatusText(status))
+}
+
+func writeDAVMultistatus(w http.ResponseWriter, responses []string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n")
+	io.WriteString(w, `<d:multistatus xmlns:d="DAV:" xmlns:c="`+caldavNS+`" xmlns:cs="`+calendarServerNS+`" xmlns:a="`+appleICalNS+`">`)
+	for _, r := range responses {
+		io.WriteString(w, r)
+	}
+	io.WriteString(w, "</d:multistatus>")
+}
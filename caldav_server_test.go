@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCalDAVServer(t *testing.T) {
+	a := NewApp()
+	if err := a.openDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+	lunch, err := a.CreateEvent(localEvent("lunch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	standup := newStandup(t, a)
+	late := standup
+	late.Title, late.Start, late.End = "late standup", "2024-05-03T11:00:00Z", "2024-05-03T11:30:00Z"
+	if _, err := a.UpdateOccurrence(standup.ID, "2024-05-03T09:00:00Z", OccurrenceThis, late); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := 0
+	srv := httptest.NewServer(&calDAVHandler{app: a, username: "me", password: "secret", changed: func() { changes++ }})
+	defer srv.Close()
+	ctx := context.Background()
+
+	wrong, err := NewCalDAVClient(srv.URL, "me", "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.ListCalendars(ctx); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("wrong password: %v", err)
+	}
+
+	client, err := NewCalDAVClient(srv.URL, "me", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calendars, err := client.ListCalendars(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calendars) != 1 || calendars[0].ID != srv.URL+davCollectionPath || calendars[0].AccessRole != "owner" {
+		t.Fatalf("calendars %+v", calendars)
+	}
+	calID := calendars[0].ID
+
+	// A series is served with the rows overriding its occurrences.
+	events, _, err := client.ListEvents(ctx, calID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byTitle := make(map[string]GoogleEvent)
+	for _, ev := range events {
+		byTitle[ev.Summary] = ev
+	}
+	if len(events) != 3 || byTitle["lunch"].ID != lunch.ID || byTitle["standup"].ID != standup.ID || byTitle["late standup"].RecurringEventID != standup.ID {
+		t.Fatalf("events %+v", events)
+	}
+	if ev, err := client.GetEvent(ctx, calID, lunch.ID); err != nil || ev.Etag != byTitle["lunch"].Etag {
+		t.Fatalf("get %+v, %v", ev, err)
+	}
+
+	// Events written by the client are created and updated through the app.
+	phone := GoogleEvent{Summary: "from phone", Start: GoogleEventTime{DateTime: "2024-05-06T08:00:00Z"}, End: GoogleEventTime{DateTime: "2024-05-06T09:00:00Z"}}
+	if _, err := client.CreateEventWithID(ctx, calID, "phone-1", phone); err != nil {
+		t.Fatal(err)
+	}
+	created, err := a.getEvent("phone-1")
+	if err != nil || created.Title != "from phone" || created.SyncStatus != "local" {
+		t.Fatalf("created %+v, %v", created, err)
+	}
+	moved := byTitle["late standup"]
+	moved.Summary = "later standup"
+	if _, err := client.UpdateEvent(ctx, calID, moved.ID, moved.Etag, moved); err != nil {
+		t.Fatal(err)
+	}
+	if got := occurrenceList(t, a); !strings.Contains(got, "later standup@05-03T11:00") || strings.Count(got, "standup@") != 5 {
+		t.Fatalf("occurrences after update: %s", got)
+	}
+
+	// Writes against an outdated copy are refused.
+	stale := byTitle["lunch"]
+	renamed := lunch
+	renamed.Title = "early lunch"
+	if _, err := a.UpdateEvent(renamed); err != nil {
+		t.Fatal(err)
+	}
+	stale.Summary = "late lunch"
+	if _, err := client.UpdateEvent(ctx, calID, lunch.ID, stale.Etag, stale); !errors.Is(err, errGoogleConflict) {
+		t.Fatalf("stale update: %v", err)
+	}
+	if row, _ := a.getEvent(lunch.ID); row.Title != "early lunch" {
+		t.Fatalf("lunch %+v", row)
+	}
+
+	// A series written without some of its overrides takes them back: one only stored
+	// here is removed, one synced to a calendar is reset to the series.
+	early := standup
+	early.Title, early.Start, early.End = "early standup", "2024-05-02T08:00:00Z", "2024-05-02T08:30:00Z"
+	earlyRow, err := a.UpdateOccurrence(standup.ID, "2024-05-02T09:00:00Z", OccurrenceThis, early)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, a, `UPDATE events SET remote_id = 'g-early' WHERE id = ?`, earlyRow.ID)
+	series, _, err := a.davResourceByID(standup.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served, err := a.davCalendarData(series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimSuffix(served, "END:VCALENDAR\r\n"), "BEGIN:VEVENT")
+	data := parts[0]
+	for _, part := range parts[1:] {
+		if !strings.Contains(part, "RECURRENCE-ID") {
+			data += "BEGIN:VEVENT" + part
+		}
+	}
+	req, err := http.NewRequest(http.MethodPut, srv.URL+series.href(), strings.NewReader(data+"END:VCALENDAR\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("me", "secret")
+	req.Header.Set("If-Match", series.etag)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("put series: %d", resp.StatusCode)
+	}
+	if got := occurrenceList(t, a); strings.Contains(got, "early standup") || strings.Contains(got, "later standup") || strings.Count(got, "standup@") != 5 {
+		t.Fatalf("occurrences after dropping overrides: %s", got)
+	}
+	if row, err := a.getEvent(earlyRow.ID); err != nil || row.Title != "standup" || row.Start != "2024-05-02T09:00:00Z" {
+		t.Fatalf("synced override %+v, %v", row, err)
+	}
+	// The answer carries the new ETag, so the client can write again without reading.
+	series, _, err = a.davResourceByID(standup.ID)
+	if err != nil || resp.Header.Get("ETag") != series.etag {
+		t.Fatalf("put answered ETag %q, resource has %q (%v)", resp.Header.Get("ETag"), series.etag, err)
+	}
+
+	// The ETag changes with any row of the resource, those cancelling occurrences included.
+	if err := a.DeleteOccurrence(standup.ID, "2024-05-05T09:00:00Z", OccurrenceThis); err != nil {
+		t.Fatal(err)
+	}
+	if after, _, _ := a.davResourceByID(standup.ID); after.etag == series.etag {
+		t.Fatal("ETag kept after an occurrence was cancelled")
+	}
+
+	if err := client.DeleteEvent(ctx, calID, "phone-1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events WHERE id = 'phone-1' AND sync_status <> 'deleted'`); n != 0 {
+		t.Fatal("deleted event is still there")
+	}
+	if changes != 4 {
+		t.Fatalf("%d changes reported", changes)
+	}
+}
+
+func TestCalDAVServerFollowsSettings(t *testing.T) {
+	s := &calDAVServer{app: NewApp()}
+	cfg := AppSettings{CalDAVServerEnabled: true, CalDAVServerAddr: "127.0.0.1:0", CalDAVServerUsername: "me"}
+	if err := validateCalDAVServerSettings(cfg); err == nil {
+		t.Fatal("accepted a server without a password")
+	}
+	cfg.CalDAVServerPassword = "secret"
+	if err := s.apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer s.stop()
+	resp, err := http.Get("http://" + s.addr + davPrincipalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("without credentials: %d", resp.StatusCode)
+	}
+	addr := s.addr
+	cfg.CalDAVServerEnabled = false
+	if err := s.apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get("http://" + addr + davPrincipalPath); err == nil {
+		t.Fatal("server still running after being disabled")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CalDAVAccount is an account on a CalDAV server, e.g. Nextcloud or Fastmail, whose
+// calendars are synced alongside the Google accounts.
+type CalDAVAccount struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	Username string `json:"username"`
+
+	password string
+}
+
+func ensureCalDAVAccountsTable(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS caldav_accounts (
+		id TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		username TEXT NOT NULL DEFAULT '',
+		password TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// AddCalDAVAccount connects a CalDAV account. serverURL may be the server's DAV root
+// (e.g. https://cloud.example.com/remote.php/dav), the user's principal or the calendar
+// home. The account is only stored once its calendars could be listed; its events
+// arrive with the next sync.
+func (a *App) AddCalDAVAccount(serverURL, username, password string) (CalDAVAccount, error) {
+	if a.db == nil {
+		return CalDAVAccount{}, errors.New("db not initialised")
+	}
+	acc := CalDAVAccount{
+		ID:       fmt.Sprintf("caldav-%d", time.Now().UnixNano()),
+		URL:      serverURL,
+		Username: username,
+		password: password,
+	}
+	client, err := acc.client()
+	if err != nil {
+		return CalDAVAccount{}, err
+	}
+	ctx := a.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	entries, err := client.ListCalendars(ctx)
+	if err != nil {
+		return CalDAVAccount{}, fmt.Errorf("list calendars: %w", err)
+	}
+	acc.URL = client.baseURL.String()
+	// Stored in plain text, as the Google tokens are; see Data Storage in the README.
+	if _, err := a.db.Exec(`INSERT INTO caldav_accounts (id, url, username, password) VALUES (?, ?, ?, ?)`, acc.ID, acc.URL, acc.Username, acc.password); err != nil {
+		return CalDAVAccount{}, err
+	}
+	if err := a.storeCalendars(acc.ID, entries); err != nil {
+		return CalDAVAccount{}, err
+	}
+	return acc, nil
+}
+
+// RemoveCalDAVAccount disconnects a CalDAV account and removes its cached data. Local
+// events never pushed to it are left alone.
+func (a *App) RemoveCalDAVAccount(id string) error {
+	if a.db == nil {
+		return errors.New("db not initialised")
+	}
+	res, err := a.db.Exec(`DELETE FROM caldav_accounts WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("account not found")
+	}
+	if err := a.clearAccountData(id); err != nil {
+		return err
+	}
+	a.reminders.reschedule()
+	return nil
+}
+
+// ListCalDAVAccounts returns the connected CalDAV accounts.
+func (a *App) ListCalDAVAccounts() ([]CalDAVAccount, error) {
+	return a.loadCalDAVAccounts()
+}
+
+func (a *App) loadCalDAVAccounts() ([]CalDAVAccount, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	rows, err := a.db.Query(`SELECT id, url, username, password FROM caldav_accounts ORDER BY created_at ASC, id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var accounts []CalDAVAccount
+	for rows.Next() {
+		var acc CalDAVAccount
+		if err := rows.Scan(&acc.ID, &acc.URL, &acc.Username, &acc.password); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, acc)
+	}
+	return accounts, rows.Err()
+}
+
+// client returns a CalDAV client signed in as the account.
+func (acc CalDAVAccount) client() (*CalDAVClient, error) {
+	return NewCalDAVClient(acc.URL, acc.Username, acc.password)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"myapp/internal/fakecaldav"
+)
+
+const caldavStandup = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Other Client//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:standup@example.com\r\n" +
+	"DTSTAMP:20240301T000000Z\r\n" +
+	"DTSTART;TZID=America/New_York:20240304T090000\r\n" +
+	"DTEND;TZID=America/New_York:20240304T091500\r\n" +
+	"RRULE:FREQ=WEEKLY;COUNT=4\r\n" +
+	"SUMMARY:Standup\r\n" +
+	"ATTENDEE;CN=Kim:mailto:kim@example.com\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT5M\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:standup@example.com\r\n" +
+	"DTSTAMP:20240301T000000Z\r\n" +
+	"RECURRENCE-ID;TZID=America/New_York:20240311T090000\r\n" +
+	"DTSTART;TZID=America/New_York:20240311T100000\r\n" +
+	"DTEND;TZID=America/New_York:20240311T101500\r\n" +
+	"SUMMARY:Standup (late)\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestCalDAVSync(t *testing.T) {
+	srv := fakecaldav.New()
+	defer srv.Close()
+	srv.AddCalendar(fakecaldav.Calendar{ID: "work", Name: "Work", Color: "#3366CCFF", TimeZone: "America/New_York"})
+	srv.AddCalendar(fakecaldav.Calendar{ID: "team", Name: "Team", ReadOnly: true})
+	workURL := srv.CalendarURL("work")
+	srv.PutEvent("work", "standup.ics", caldavStandup)
+
+	a := NewApp()
+	if err := a.openDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+
+	if _, err := a.AddCalDAVAccount(srv.URL(), fakecaldav.Username, "wrong"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("wrong password: %v", err)
+	}
+	acc, err := a.AddCalDAVAccount(srv.URL(), fakecaldav.Username, fakecaldav.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calendars, err := a.loadCalendars(acc.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calendars) != 2 {
+		t.Fatalf("calendars %+v", calendars)
+	}
+	for _, c := range calendars {
+		switch c.Summary {
+		case "Work":
+			if c.ID != workURL || c.Color != "#3366CC" || c.TimeZone != "America/New_York" || !c.Writable() {
+				t.Fatalf("work calendar %+v", c)
+			}
+		case "Team":
+			if c.Writable() {
+				t.Fatalf("read-only calendar %+v", c)
+			}
+		default:
+			t.Fatalf("calendar %+v", c)
+		}
+	}
+
+	// The first sync reads the calendars whole.
+	result := mustSync(t, a)
+	if result.Pulled != 2 || !result.FullSync {
+		t.Fatalf("first sync %+v", result)
+	}
+	rows := eventRows(t, a)
+	series, err := a.getEvent(rows["Standup"].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.SyncStatus != "synced" || series.Provider != ProviderCalDAV || series.GoogleEventID != "standup" || series.ICalUID != "standup@example.com" || series.Alert != "5m" {
+		t.Fatalf("series %+v", series)
+	}
+	late, err := a.getEvent(rows["Standup (late)"].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if late.RecurringEventID != series.ID || late.OriginalStart != "2024-03-11T13:00:00Z" {
+		t.Fatalf("exception %+v", late)
+	}
+
+	// Local changes are written with ETag preconditions; the attendee and the
+	// exception written by the other client stay.
+	series.Title = "Daily standup"
+	if _, err := a.UpdateEvent(series); err != nil {
+		t.Fatal(err)
+	}
+	lunch := localEvent("lunch")
+	lunch.AccountID, lunch.GoogleCalendarID = acc.ID, workURL
+	if lunch, err = a.CreateEvent(lunch); err != nil {
+		t.Fatal(err)
+	}
+	if result = mustSync(t, a); result.Pushed != 2 {
+		t.Fatalf("push %+v", result)
+	}
+	data, etag, _ := srv.Event("work", "standup.ics")
+	for _, want := range []string{"SUMMARY:Daily standup", "ATTENDEE;CN=Kim:mailto:kim@example.com", "SUMMARY:Standup (late)", "UID:standup@example.com", "TRIGGER:-PT5M", "BEGIN:VTIMEZONE"} {
+		if !strings.Contains(data, want) {
+			t.Fatalf("missing %q in\n%s", want, data)
+		}
+	}
+	if row := eventRows(t, a)["Daily standup"]; row.SyncStatus != "synced" || row.GoogleETag != etag {
+		t.Fatalf("row %+v, etag %s", row, etag)
+	}
+	lunchName := googleEventIDFor(lunch.ID) + ".ics"
+	if _, _, ok := srv.Event("work", lunchName); !ok {
+		t.Fatalf("lunch not created: %v", srv.Events("work"))
+	}
+
+	// Overriding one occurrence adds a RECURRENCE-ID component to the series.
+	series, err = a.getEvent(series.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := series
+	moved.Title, moved.Start, moved.End = "Standup (moved)", "2024-03-18T15:00:00Z", "2024-03-18T15:15:00Z"
+	if _, err := a.UpdateOccurrence(series.ID, "2024-03-18T13:00:00Z", OccurrenceThis, moved); err != nil {
+		t.Fatal(err)
+	}
+	mustSync(t, a)
+	data, _, _ = srv.Event("work", "standup.ics")
+	for _, want := range []string{"RECURRENCE-ID;TZID=America/New_York:20240318T090000", "SUMMARY:Standup (moved)", "SUMMARY:Standup (late)"} {
+		if !strings.Contains(data, want) {
+			t.Fatalf("missing %q in\n%s", want, data)
+		}
+	}
+
+	// Later syncs ask for what changed since the last sync token; the first one
+	// reads back the writes above.
+	mustSync(t, a)
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events`); n != 4 {
+		t.Fatalf("%d rows: %v", n, eventRows(t, a))
+	}
+	srv.PutEvent("work", lunchName, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:"+googleEventIDFor(lunch.ID)+"\r\nDTSTART:20240502T120000Z\r\nDTEND:20240502T130000Z\r\nSUMMARY:long lunch\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+	if result = mustSync(t, a); result.Pulled != 1 || result.FullSync {
+		t.Fatalf("incremental sync %+v", result)
+	}
+	if row := eventRows(t, a)["long lunch"]; row.ID != lunch.ID || row.SyncStatus != "synced" {
+		t.Fatalf("lunch row %+v", row)
+	}
+	if n := srv.CountRequests("REPORT", "/work/"); n < 3 {
+		t.Fatalf("%d reports", n)
+	}
+
+	// A series read again brings all its exceptions; one it no longer has is dropped.
+	srv.PutEvent("work", "standup.ics", caldavStandup)
+	if result = mustSync(t, a); result.FullSync || result.Deleted != 1 {
+		t.Fatalf("series sync %+v", result)
+	}
+	if rows := eventRows(t, a); len(rows) != 3 || rows["Standup"].ID != series.ID || rows["Standup (late)"].ID != late.ID {
+		t.Fatalf("rows after series change %v", rows)
+	}
+
+	// An edit made here against an outdated copy is parked as a conflict.
+	srv.PutEvent("work", lunchName, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:"+googleEventIDFor(lunch.ID)+"\r\nDTSTART:20240502T120000Z\r\nDTEND:20240502T140000Z\r\nSUMMARY:longer lunch\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+	edited, err := a.getEvent(lunch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited.Title = "short lunch"
+	if _, err := a.UpdateEvent(edited); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.GooglePush(); err != nil {
+		t.Fatal(err)
+	}
+	conflicts, err := a.ListConflicts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Remote.Title != "longer lunch" {
+		t.Fatalf("conflicts %+v", conflicts)
+	}
+	if _, err := a.ResolveConflict(lunch.ID, ConflictKeepRemote, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events deleted on either side are deleted on the other.
+	srv.RemoveEvent("work", "standup.ics")
+	if err := a.DeleteEvent(lunch.ID); err != nil {
+		t.Fatal(err)
+	}
+	mustSync(t, a)
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events`); n != 0 {
+		t.Fatalf("%d rows left: %v", n, eventRows(t, a))
+	}
+	if n := len(srv.Events("work")); n != 0 {
+		t.Fatalf("%d resources left", n)
+	}
+
+	// A full resync drops events removed while the sync token was not usable.
+	srv.PutEvent("work", "review.ics", "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:review\r\nDTSTART;VALUE=DATE:20240510\r\nSUMMARY:review\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+	mustSync(t, a)
+	srv.ExpireSyncTokens()
+	srv.RemoveEvent("work", "review.ics")
+	if result = mustSync(t, a); !result.FullSync || result.Deleted != 1 {
+		t.Fatalf("resync %+v", result)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events`); n != 0 {
+		t.Fatalf("%d rows left after resync", n)
+	}
+	for _, r := range srv.Requests() {
+		if (r.Method == http.MethodPut && r.IfMatch == "" && r.IfNoneMatch == "") || (r.Method == http.MethodDelete && r.IfMatch == "") {
+			t.Fatalf("unconditional write %+v", r)
+		}
+	}
+
+	if err := a.RemoveCalDAVAccount(acc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if calendars, err := a.loadCalendars(acc.ID, false); err != nil || len(calendars) != 0 {
+		t.Fatalf("calendars after removal %v, %v", calendars, err)
+	}
+}
+
+func TestCalDAVSeriesEditedTogether(t *testing.T) {
+	srv := fakecaldav.New()
+	defer srv.Close()
+	srv.AddCalendar(fakecaldav.Calendar{ID: "work", Name: "Work"})
+	srv.PutEvent("work", "standup.ics", caldavStandup)
+	a := NewApp()
+	if err := a.openDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+	if _, err := a.AddCalDAVAccount(srv.URL(), fakecaldav.Username, fakecaldav.Password); err != nil {
+		t.Fatal(err)
+	}
+	mustSync(t, a)
+
+	// The series and its exception are one resource; the second write is made against
+	// the ETag the first one left it with.
+	rows := eventRows(t, a)
+	for title, edited := range map[string]string{"Standup": "Daily standup", "Standup (late)": "Standup (later)"} {
+		e, err := a.getEvent(rows[title].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Title = edited
+		if _, err := a.UpdateEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result := mustSync(t, a); result.Pushed != 2 {
+		t.Fatalf("push %+v", result)
+	}
+	data, etag, _ := srv.Event("work", "standup.ics")
+	for _, want := range []string{"SUMMARY:Daily standup", "SUMMARY:Standup (later)"} {
+		if !strings.Contains(data, want) {
+			t.Fatalf("missing %q in\n%s", want, data)
+		}
+	}
+	for title, row := range eventRows(t, a) {
+		if row.SyncStatus != "synced" || row.GoogleETag != etag {
+			t.Fatalf("%s: %+v, etag %s", title, row, etag)
+		}
+	}
+}
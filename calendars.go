@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// primaryCalendarAlias is the calendar ID Google accepts for the signed-in user's main calendar.
+const primaryCalendarAlias = "primary"
+
+// CalendarInfo describes a Google calendar the user can sync.
+type CalendarInfo struct {
+	AccountID  string `json:"accountId"`
+	ID         string `json:"id"`
+	Summary    string `json:"summary"`
+	Color      string `json:"color"`
+	TimeZone   string `json:"timeZone"`
+	AccessRole string `json:"accessRole"`
+	Primary    bool   `json:"primary"`
+	Enabled    bool   `json:"enabled"`
+}
+
+// Writable reports whether local edits can be pushed to this calendar.
+func (c CalendarInfo) Writable() bool {
+	return c.AccessRole == "" || c.AccessRole == "owner" || c.AccessRole == "writer"
+}
+
+func ensureCalendarsTable(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS calendars (
+		account_id TEXT NOT NULL,
+		id TEXT NOT NULL,
+		summary TEXT NOT NULL DEFAULT '',
+		color TEXT,
+		time_zone TEXT,
+		access_role TEXT,
+		is_primary INTEGER NOT NULL DEFAULT 0,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (account_id, id)
+	);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// tableColumns returns the column names of a table (empty when the table does not exist).
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid int
+		var name, ctype string
+		var notnull int
+		var dflt sql.NullString
+		var pk int
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// ListCalendars returns the calendars of every account known locally, primary first.
+func (a *App) ListCalendars() ([]CalendarInfo, error) {
+	return a.loadCalendars("", false)
+}
+
+// GoogleRefreshCalendars fetches each account's calendar list from Google and stores it.
+func (a *App) GoogleRefreshCalendars() ([]CalendarInfo, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	if a.googleService() == nil {
+		return nil, errors.New("google sync not initialised")
+	}
+	ctx := a.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	accounts, err := a.loadAccounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, acc := range accounts {
+		svc, err := a.googleForAccount(acc.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.refreshCalendars(ctx, svc, acc.ID); err != nil {
+			return nil, fmt.Errorf("account %s: %w", firstNonEmpty(acc.Email, acc.ID), err)
+		}
+	}
+	return a.loadCalendars("", false)
+}
+
+// SetCalendarEnabled toggles whether a calendar takes part in sync.
+// Disabling a calendar drops its cached events; pending local edits are kept.
+func (a *App) SetCalendarEnabled(accountID, id string, enabled bool) error {
+	if a.db == nil {
+		return errors.New("db not initialised")
+	}
+	if accountID == "" || id == "" {
+		return errors.New("account and calendar id required")
+	}
+	res, err := a.db.Exec(`UPDATE calendars SET enabled=?, updated_at=CURRENT_TIMESTAMP WHERE account_id=? AND id=?`, boolToInt(enabled), accountID, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("calendar not found")
+	}
+	if enabled {
+		return nil
+	}
+	if _, err := a.db.Exec(`DELETE FROM events WHERE account_id = ? AND google_calendar_id = ? AND sync_status IN ('synced','cancelled','subscribed')`, accountID, id); err != nil {
+		return fmt.Errorf("clear calendar events: %w", err)
+	}
+	// Forget the sync token so re-enabling starts with a full sync.
+	return a.resetCalendarSyncToken(accountID, id)
+}
+
+// refreshCalendars replaces the stored calendar list with the provider's, keeping enabled
+// flags. When a Google list cannot be fetched (e.g. tokens granted before the
+// calendarList scope was requested) it falls back to the primary calendar so sync keeps
+// working.
+func (a *App) refreshCalendars(ctx context.Context, provider CalendarProvider, accountID string) error {
+	entries, err := provider.ListCalendars(ctx)
+	if err != nil && !provider.Capabilities().PrimaryAlias {
+		return err
+	}
+	if err != nil {
+		var count int
+		if qerr := a.db.QueryRow(`SELECT COUNT(*) FROM calendars WHERE account_id = ?`, accountID).Scan(&count); qerr != nil {
+			return qerr
+		}
+		if count > 0 {
+			return nil
+		}
+		fmt.Printf("calendar list unavailable, falling back to primary: %v\n", err)
+		entries = []GoogleCalendarListEntry{{ID: primaryCalendarAlias, Summary: primaryCalendarAlias, Primary: true, Selected: true, AccessRole: "owner"}}
+	}
+	return a.storeCalendars(accountID, entries)
+}
+
+func (a *App) storeCalendars(accountID string, entries []GoogleCalendarListEntry) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Deleted || entry.ID == "" {
+			continue
+		}
+		seen[entry.ID] = true
+		summary := firstNonEmpty(entry.SummaryOverride, entry.Summary, entry.ID)
+		// New calendars follow Google's "selected" flag; existing rows keep the user's choice.
+		enabled := entry.Primary || entry.Selected
+		defaultReminders := ""
+		if len(entry.DefaultReminders) > 0 {
+			data, err := json.Marshal(entry.DefaultReminders)
+			if err != nil {
+				return err
+			}
+			defaultReminders = string(data)
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO calendars (account_id, id, summary, color, time_zone, access_role, is_primary, enabled, default_reminders, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(account_id, id) DO UPDATE SET
+				summary=excluded.summary,
+				color=excluded.color,
+				time_zone=excluded.time_zone,
+				access_role=excluded.access_role,
+				is_primary=excluded.is_primary,
+				default_reminders=excluded.default_reminders,
+				updated_at=excluded.updated_at
+		`, accountID, entry.ID, summary, entry.BackgroundColor, entry.TimeZone, entry.AccessRole, boolToInt(entry.Primary), boolToInt(enabled), defaultReminders); err != nil {
+			return fmt.Errorf("store calendar %s: %w", entry.ID, err)
+		}
+		if entry.Primary && entry.ID != primaryCalendarAlias {
+			// Rows synced before calendar lists existed were tagged with the "primary" alias.
+			if _, err := tx.Exec(`UPDATE events SET google_calendar_id=? WHERE account_id=? AND google_calendar_id=?`, entry.ID, accountID, primaryCalendarAlias); err != nil {
+				return fmt.Errorf("migrate primary events: %w", err)
+			}
+			if _, err := tx.Exec(`UPDATE OR IGNORE calendar_sync_state SET calendar_id=? WHERE account_id=? AND calendar_id=?`, entry.ID, accountID, primaryCalendarAlias); err != nil {
+				return fmt.Errorf("migrate primary sync state: %w", err)
+			}
+		}
+	}
+
+	rows, err := tx.Query(`SELECT id FROM calendars WHERE account_id = ?`, accountID)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		if !seen[id] {
+			stale = append(stale, id)
+		}
+	}
+	rows.Close()
+	for _, id := range stale {
+		if _, err := tx.Exec(`DELETE FROM events WHERE account_id = ? AND google_calendar_id = ? AND sync_status IN ('synced','cancelled')`, accountID, id); err != nil {
+			return fmt.Errorf("clear calendar events: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM calendars WHERE account_id = ? AND id = ?`, accountID, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM calendar_sync_state WHERE account_id = ? AND calendar_id = ?`, accountID, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// loadCalendars returns stored calendars; an empty accountID means every account.
+func (a *App) loadCalendars(accountID string, enabledOnly bool) ([]CalendarInfo, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	query := `SELECT account_id, id, summary, COALESCE(color,''), COALESCE(time_zone,''), COALESCE(access_role,''), is_primary, enabled FROM calendars WHERE (? = '' OR account_id = ?)`
+	if enabledOnly {
+		query += ` AND enabled = 1`
+	}
+	query += ` ORDER BY account_id ASC, is_primary DESC, summary COLLATE NOCASE ASC`
+	rows, err := a.db.Query(query, accountID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var calendars []CalendarInfo
+	for rows.Next() {
+		var c CalendarInfo
+		var primary, enabled int
+		if err := rows.Scan(&c.AccountID, &c.ID, &c.Summary, &c.Color, &c.TimeZone, &c.AccessRole, &primary, &enabled); err != nil {
+			return nil, err
+		}
+		c.Primary = primary == 1
+		c.Enabled = enabled == 1
+		calendars = append(calendars, c)
+	}
+	return calendars, rows.Err()
+}
+
+// localGoogleEventID picks the local row ID for a Google event seen for the first time.
+// The same event can appear in several calendars or accounts (e.g. shared invites), so
+// fall back to a qualified ID when the plain one is already taken.
+func (a *App) localGoogleEventID(accountID, calendarID, googleEventID string) (string, error) {
+	id := fmt.Sprintf("google-%s", googleEventID)
+	var ownerAccount, ownerCalendar sql.NullString
+	err := a.db.QueryRow(`SELECT account_id, google_calendar_id FROM events WHERE id = ?`, id).Scan(&ownerAccount, &ownerCalendar)
+	if errors.Is(err, sql.ErrNoRows) {
+		return id, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if ownerAccount.String == accountID && ownerCalendar.String == calendarID {
+		return id, nil
+	}
+	return fmt.Sprintf("google-%s-%s-%s", accountID, strings.ReplaceAll(calendarID, "@", "_"), googleEventID), nil
+}
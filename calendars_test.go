@@ -0,0 +1,114 @@
+package main
+
+import "testing"
+
+// useTempConfigDir points the app config directory at a fresh temporary directory.
+func useTempConfigDir(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("APPDATA", dir)
+	t.Setenv("HOME", dir)
+}
+
+// newTestApp returns an App with a fresh database in a temporary config directory.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	useTempConfigDir(t)
+	a := NewApp()
+	if err := a.initDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.db.Close() })
+	return a
+}
+
+func mustExec(t *testing.T, a *App, query string, args ...any) {
+	t.Helper()
+	if _, err := a.db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countRows(t *testing.T, a *App, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := a.db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func calendarIDs(t *testing.T, a *App, accountID string) map[string]bool {
+	t.Helper()
+	calendars, err := a.loadCalendars(accountID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]bool)
+	for _, c := range calendars {
+		out[c.ID] = c.Enabled
+	}
+	return out
+}
+
+func TestStoreCalendars(t *testing.T) {
+	a := newTestApp(t)
+
+	// Rows and the sync token of the time before calendar lists are kept under "primary".
+	mustExec(t, a, `INSERT INTO events (id, title, start, end, sync_status, account_id, remote_id, google_calendar_id) VALUES ('old', 'old', '2024-05-01T09:00:00Z', '2024-05-01T10:00:00Z', 'synced', ?, 'g-old', 'primary')`, defaultAccountID)
+	if err := a.setCalendarSyncToken(defaultAccountID, primaryCalendarAlias, "token-1", true); err != nil {
+		t.Fatal(err)
+	}
+	entries := []GoogleCalendarListEntry{
+		{ID: "me@example.com", Summary: "Me", Primary: true, AccessRole: "owner"},
+		{ID: "team", Summary: "Team", Selected: true, AccessRole: "writer"},
+		{ID: "holidays", Summary: "Holidays", AccessRole: "reader"},
+	}
+	if err := a.storeCalendars(defaultAccountID, entries); err != nil {
+		t.Fatal(err)
+	}
+	if got := calendarIDs(t, a, defaultAccountID); len(got) != 3 || !got["me@example.com"] || !got["team"] || got["holidays"] {
+		t.Fatalf("calendars %v", got)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events WHERE id = 'old' AND google_calendar_id = 'me@example.com'`); n != 1 {
+		t.Fatal("primary row not moved to the primary calendar")
+	}
+	if token, _ := a.calendarSyncToken(defaultAccountID, "me@example.com"); token != "token-1" {
+		t.Fatalf("primary token %q", token)
+	}
+
+	// Storing the list again keeps the user's choices.
+	if err := a.SetCalendarEnabled(defaultAccountID, "holidays", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.storeCalendars(defaultAccountID, entries); err != nil {
+		t.Fatal(err)
+	}
+	if got := calendarIDs(t, a, defaultAccountID); !got["holidays"] {
+		t.Fatalf("enabled flag lost: %v", got)
+	}
+
+	// A calendar gone from the list takes its synced rows and sync state with it; local
+	// edits stay to be pushed.
+	mustExec(t, a, `INSERT INTO events (id, title, start, end, sync_status, account_id, remote_id, google_calendar_id) VALUES ('synced', 'synced', '2024-05-02T09:00:00Z', '2024-05-02T10:00:00Z', 'synced', ?, 'g1', 'team')`, defaultAccountID)
+	mustExec(t, a, `INSERT INTO events (id, title, start, end, sync_status, account_id, remote_id, google_calendar_id) VALUES ('edited', 'edited', '2024-05-03T09:00:00Z', '2024-05-03T10:00:00Z', 'dirty', ?, 'g2', 'team')`, defaultAccountID)
+	if err := a.setCalendarSyncToken(defaultAccountID, "team", "token-2", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.storeCalendars(defaultAccountID, entries[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if got := calendarIDs(t, a, defaultAccountID); len(got) != 1 {
+		t.Fatalf("calendars after removal %v", got)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events WHERE google_calendar_id = 'team'`); n != 1 {
+		t.Fatalf("%d rows left on the removed calendar", n)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events WHERE id = 'edited'`); n != 1 {
+		t.Fatal("local edit removed")
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM calendar_sync_state WHERE calendar_id = 'team'`); n != 0 {
+		t.Fatal("sync state of the removed calendar kept")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Automatic conflict policies selectable in AppSettings.ConflictPolicy.
+const (
+	ConflictPolicyManual     = "manual"
+	ConflictPolicyLocalWins  = "local-wins"
+	ConflictPolicyRemoteWins = "remote-wins"
+	ConflictPolicyNewestWins = "newest-wins"
+)
+
+// ConflictHistoryEntry records what an automatic resolution overwrote.
+type ConflictHistoryEntry struct {
+	ID          int64         `json:"id"`
+	EventID     string        `json:"eventId"`
+	Policy      string        `json:"policy"`
+	Winner      string        `json:"winner"` // "local" or "remote"
+	Overwritten CalendarEvent `json:"overwritten"`
+	// OverwrittenDeleted is set when the losing side was a deletion.
+	OverwrittenDeleted bool   `json:"overwrittenDeleted"`
+	ResolvedAt         string `json:"resolvedAt"`
+	UndoneAt           string `json:"undoneAt,omitempty"`
+}
+
+func ensureConflictHistoryTable(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS conflict_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id TEXT NOT NULL,
+		policy TEXT NOT NULL,
+		winner TEXT NOT NULL,
+		overwritten_json TEXT NOT NULL,
+		overwritten_deleted INTEGER NOT NULL DEFAULT 0,
+		resolved_at TIMESTAMP NOT NULL,
+		undone_at TIMESTAMP
+	);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+func validConflictPolicy(policy string) bool {
+	switch policy {
+	case "", ConflictPolicyManual, ConflictPolicyLocalWins, ConflictPolicyRemoteWins, ConflictPolicyNewestWins:
+		return true
+	}
+	return false
+}
+
+// handleConflict deals with a remote version that clashes with unsynced local edits,
+// either parking it for manual resolution or applying the configured policy.
+func (a *App) handleConflict(eventID string, remote GoogleEvent) error {
+	policy := a.currentSettings().ConflictPolicy
+	if policy == "" || policy == ConflictPolicyManual {
+		return a.recordConflict(eventID, remote)
+	}
+	local, err := a.getEvent(eventID)
+	if err != nil {
+		return err
+	}
+	localDeleted := local.SyncStatus == "deleted"
+	if local.SyncStatus == "conflict" {
+		// Parked before the policy was chosen, perhaps as a deletion.
+		if err := a.db.QueryRow(`SELECT local_deleted FROM event_conflicts WHERE event_id = ?`, eventID).Scan(&localDeleted); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	c := buildConflict(local, remote, localDeleted, time.Now())
+	if err := a.resolveDefaultAlert(&c.Remote); err != nil {
+		return err
+	}
+
+	strategy := ConflictKeepRemote
+	if conflictLocalWins(policy, c, remote) {
+		strategy = ConflictKeepLocal
+	}
+	winner, overwritten, overwrittenDeleted := "remote", c.Local, c.LocalDeleted
+	if strategy == ConflictKeepLocal {
+		winner, overwritten, overwrittenDeleted = "local", c.Remote, c.RemoteDeleted
+	}
+	payload, err := json.Marshal(overwritten)
+	if err != nil {
+		return err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := applyConflictResolution(tx, c, strategy, nil); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO conflict_history (event_id, policy, winner, overwritten_json, overwritten_deleted, resolved_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		eventID, policy, winner, string(payload), boolToInt(overwrittenDeleted), time.Now(),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// handleDeletedConflict deals with a remote version of an event deleted locally that
+// changed since the deletion was based on it. A version that differs from the deleted
+// one in none of the compared fields only moved its ETag, as a CalDAV series does when
+// another of its rows is written; the deletion goes ahead against the new ETag. Any
+// other goes to handleConflict.
+func (a *App) handleDeletedConflict(eventID string, remote GoogleEvent) error {
+	local, err := a.getEvent(eventID)
+	if err != nil {
+		return err
+	}
+	if len(diffEvents(local, googleToCalendar(local.AccountID, local.GoogleCalendarID, remote))) == 0 {
+		_, err := a.db.Exec(`UPDATE events SET remote_etag = ? WHERE id = ?`, remote.Etag, eventID)
+		return err
+	}
+	return a.handleConflict(eventID, remote)
+}
+
+// conflictLocalWins applies a policy; newest-wins compares the local updated_at, which
+// a deletion sets too, with Google's updated timestamp and lets the remote side win ties.
+func conflictLocalWins(policy string, c EventConflict, remote GoogleEvent) bool {
+	switch policy {
+	case ConflictPolicyLocalWins:
+		return true
+	case ConflictPolicyNewestWins:
+		localUpdated, err := time.Parse(time.RFC3339, c.Local.UpdatedAt)
+		if err != nil {
+			return false
+		}
+		remoteUpdated, err := time.Parse(time.RFC3339, remote.Updated)
+		if err != nil {
+			return true
+		}
+		return localUpdated.After(remoteUpdated)
+	}
+	return false
+}
+
+// ListConflictHistory returns the most recent automatic resolutions, newest first.
+func (a *App) ListConflictHistory(limit int) ([]ConflictHistoryEntry, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := a.db.Query(`SELECT id, event_id, policy, winner, overwritten_json, overwritten_deleted, resolved_at, undone_at FROM conflict_history ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []ConflictHistoryEntry
+	for rows.Next() {
+		entry, err := scanConflictHistory(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// UndoConflictResolution restores the version an automatic resolution overwrote. The
+// restored version is queued for push so Google ends up matching it again.
+func (a *App) UndoConflictResolution(historyID int64) (CalendarEvent, error) {
+	if a.db == nil {
+		return CalendarEvent{}, errors.New("db not initialised")
+	}
+	entry, err := scanConflictHistory(a.db.QueryRow(`SELECT id, event_id, policy, winner, overwritten_json, overwritten_deleted, resolved_at, undone_at FROM conflict_history WHERE id = ?`, historyID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return CalendarEvent{}, errors.New("history entry not found")
+	}
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	if entry.UndoneAt != "" {
+		return CalendarEvent{}, errors.New("resolution already undone")
+	}
+
+	current, err := a.getEvent(entry.EventID)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return CalendarEvent{}, err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	defer tx.Rollback()
+
+	restored := entry.Overwritten
+	restored.ID = entry.EventID
+	switch {
+	case entry.OverwrittenDeleted:
+		// The losing side deleted the event; delete it again.
+		if exists {
+			if _, err := tx.Exec(`UPDATE events SET sync_status='deleted' WHERE id = ?`, entry.EventID); err != nil {
+				return CalendarEvent{}, err
+			}
+		}
+		restored.SyncStatus = "deleted"
+	case exists:
+		restored.SyncStatus = "dirty"
+		restored.GoogleEventID = current.GoogleEventID
+		restored.GoogleETag = current.GoogleETag
+		if restored.GoogleEventID == "" {
+			restored.SyncStatus = "new"
+		}
+		if err := writeResolvedEvent(tx, entry.EventID, restored); err != nil {
+			return CalendarEvent{}, err
+		}
+	default:
+		// The winning side removed the row; bring the overwritten version back as new.
+		restored.SyncStatus = "new"
+		restored.GoogleEventID = ""
+		restored.GoogleETag = ""
+		startTime, endTime, err := parseEventTimes(restored)
+		if err != nil {
+			return CalendarEvent{}, err
+		}
+		now := time.Now()
+		if _, err := tx.Exec(
+			`INSERT INTO events (id, title, all_day, start, end, recurrence, recurrence_custom, location, alert, alert_offset, color, description, sync_status, account_id, google_calendar_id, time_zone, updated_at, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			restored.ID, restored.Title, boolToInt(restored.AllDay), startTime, endTime, restored.Recurrence, restored.RecurrenceEx, restored.Location, firstNonEmpty(restored.Alert, "none"), restored.AlertOffset, restored.Color, restored.Description, restored.SyncStatus, restored.AccountID, restored.GoogleCalendarID, firstNonEmpty(restored.TimeZone, "UTC"), now, now,
+		); err != nil {
+			return CalendarEvent{}, err
+		}
+	}
+	if op := outboxOpFor(restored); op != "" && (exists || op != OutboxDelete) {
+		if err := appendOutbox(tx, entry.EventID, op); err != nil {
+			return CalendarEvent{}, err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE conflict_history SET undone_at = ? WHERE id = ?`, time.Now(), historyID); err != nil {
+		return CalendarEvent{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return CalendarEvent{}, err
+	}
+	return restored, nil
+}
+
+func scanConflictHistory(row rowScanner) (ConflictHistoryEntry, error) {
+	var entry ConflictHistoryEntry
+	var payload string
+	var deleted int
+	var resolvedAt time.Time
+	var undoneAt sql.NullTime
+	if err := row.Scan(&entry.ID, &entry.EventID, &entry.Policy, &entry.Winner, &payload, &deleted, &resolvedAt, &undoneAt); err != nil {
+		return ConflictHistoryEntry{}, err
+	}
+	if err := json.Unmarshal([]byte(payload), &entry.Overwritten); err != nil {
+		return ConflictHistoryEntry{}, fmt.Errorf("decode overwritten version: %w", err)
+	}
+	entry.OverwrittenDeleted = deleted == 1
+	entry.ResolvedAt = resolvedAt.Format(time.RFC3339)
+	if undoneAt.Valid {
+		entry.UndoneAt = undoneAt.Time.Format(time.RFC3339)
+	}
+	return entry, nil
+}
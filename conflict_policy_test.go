@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUndoConflictResolution(t *testing.T) {
+	a := newTestApp(t)
+	setPolicy := func(policy string) {
+		cfg := a.currentSettings()
+		cfg.ConflictPolicy = policy
+		a.setSettings(cfg)
+	}
+
+	// remote-wins overwrote the local edit; undoing it queues the edit again.
+	setPolicy(ConflictPolicyRemoteWins)
+	mine := syncedEvent(t, a, "mine", "g-shared")
+	theirs := GoogleEvent{ID: "g-shared", Etag: `"2"`, Updated: "2024-05-02T08:00:00Z", Summary: "theirs", Start: GoogleEventTime{DateTime: "2024-05-02T09:00:00Z"}, End: GoogleEventTime{DateTime: "2024-05-02T10:00:00Z"}}
+	if err := a.handleConflict(mine.ID, theirs); err != nil {
+		t.Fatal(err)
+	}
+	if row, _ := a.getEvent(mine.ID); row.Title != "theirs" || row.SyncStatus != "synced" {
+		t.Fatalf("after remote-wins %+v", row)
+	}
+	history, err := a.ListConflictHistory(0)
+	if err != nil || len(history) != 1 || history[0].Winner != "remote" || history[0].Overwritten.Title != "mine" {
+		t.Fatalf("history %+v, %v", history, err)
+	}
+	restored, err := a.UndoConflictResolution(history[0].ID)
+	if err != nil || restored.Title != "mine" {
+		t.Fatalf("undo %+v, %v", restored, err)
+	}
+	row, _ := a.getEvent(mine.ID)
+	if row.Title != "mine" || row.SyncStatus != "dirty" || row.GoogleETag != `"2"` {
+		t.Fatalf("after undo %+v", row)
+	}
+	if ops := pendingOps(t, a); len(ops) != 1 || ops[0] != OutboxUpdate+" mine" {
+		t.Fatalf("pending %v", ops)
+	}
+	if _, err := a.UndoConflictResolution(history[0].ID); err == nil || !strings.Contains(err.Error(), "already undone") {
+		t.Fatalf("second undo: %v", err)
+	}
+	mustExec(t, a, `DELETE FROM outbox`)
+
+	// local-wins kept an edit of an event deleted remotely; undoing it deletes it again.
+	setPolicy(ConflictPolicyLocalWins)
+	kept := syncedEvent(t, a, "kept", "g-gone")
+	if err := a.handleConflict(kept.ID, GoogleEvent{ID: "g-gone", Status: "cancelled"}); err != nil {
+		t.Fatal(err)
+	}
+	if row, _ := a.getEvent(kept.ID); row.SyncStatus != "new" || row.GoogleEventID != "" {
+		t.Fatalf("after local-wins %+v", row)
+	}
+	history, err = a.ListConflictHistory(0)
+	if err != nil || len(history) != 2 || !history[0].OverwrittenDeleted {
+		t.Fatalf("history %+v, %v", history, err)
+	}
+	if _, err := a.UndoConflictResolution(history[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if row, _ := a.getEvent(kept.ID); row.SyncStatus != "deleted" {
+		t.Fatalf("after undo %+v", row)
+	}
+	if ops := pendingOps(t, a); len(ops) != 1 || ops[0] != OutboxDelete+" kept" {
+		t.Fatalf("pending %v", ops)
+	}
+
+	if _, err := a.UndoConflictResolution(999); err == nil {
+		t.Fatal("undid a missing entry")
+	}
+}
+
+func TestNewestWinsConflictPolicy(t *testing.T) {
+	a := newTestApp(t)
+	cfg := a.currentSettings()
+	cfg.ConflictPolicy = ConflictPolicyNewestWins
+	a.setSettings(cfg)
+
+	for _, tt := range []struct {
+		name          string
+		remoteUpdated time.Time
+		wantTitle     string
+		wantWinner    string
+	}{
+		{"older remote edit", time.Now().Add(-time.Hour), "mine", "local"},
+		{"newer remote edit", time.Now().Add(time.Hour), "theirs", "remote"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mine := syncedEvent(t, a, "mine", "g-"+strings.ReplaceAll(tt.name, " ", "-"))
+			theirs := GoogleEvent{ID: mine.GoogleEventID, Etag: `"2"`, Summary: "theirs", Updated: tt.remoteUpdated.UTC().Format(time.RFC3339), Start: GoogleEventTime{DateTime: "2024-05-02T09:00:00Z"}, End: GoogleEventTime{DateTime: "2024-05-02T10:00:00Z"}}
+			if err := a.handleConflict(mine.ID, theirs); err != nil {
+				t.Fatal(err)
+			}
+			if row, _ := a.getEvent(mine.ID); row.Title != tt.wantTitle {
+				t.Fatalf("row %+v", row)
+			}
+			history, err := a.ListConflictHistory(1)
+			if err != nil || len(history) != 1 || history[0].EventID != mine.ID || history[0].Winner != tt.wantWinner {
+				t.Fatalf("history %+v, %v", history, err)
+			}
+		})
+	}
+}
+
+func TestLocalDeletionConflictPolicy(t *testing.T) {
+	a := newTestApp(t)
+	// deleted stores an event deleted here that a pull finds edited remotely.
+	deleted := func(policy, remoteID string) CalendarEvent {
+		t.Helper()
+		cfg := a.currentSettings()
+		cfg.ConflictPolicy = policy
+		a.setSettings(cfg)
+		e := syncedEvent(t, a, "gone", remoteID)
+		mustExec(t, a, `UPDATE events SET account_id = ?, google_calendar_id = 'primary' WHERE id = ?`, defaultAccountID, e.ID)
+		if err := a.DeleteEvent(e.ID); err != nil {
+			t.Fatal(err)
+		}
+		edited := GoogleEvent{ID: remoteID, Etag: `"2"`, Summary: "edited", Updated: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), Start: GoogleEventTime{DateTime: "2024-05-02T09:00:00Z"}, End: GoogleEventTime{DateTime: "2024-05-02T10:00:00Z"}}
+		if err := a.applyGoogleEvent(ProviderGoogle, defaultAccountID, "primary", edited); err != nil {
+			t.Fatal(err)
+		}
+		row, err := a.getEvent(e.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return row
+	}
+
+	// local-wins and newest-wins, the deletion being newer, keep deleting it against the
+	// edited version.
+	for _, policy := range []string{ConflictPolicyLocalWins, ConflictPolicyNewestWins} {
+		if row := deleted(policy, "g-"+policy); row.SyncStatus != "deleted" || row.GoogleETag != `"2"` {
+			t.Fatalf("%s: %+v", policy, row)
+		}
+		if ops := pendingOps(t, a); len(ops) != 1 || ops[0] != OutboxDelete+" gone" {
+			t.Fatalf("%s: pending %v", policy, ops)
+		}
+	}
+
+	// remote-wins brings the edited version back; undoing that deletes it again.
+	row := deleted(ConflictPolicyRemoteWins, "g-remote")
+	if row.SyncStatus != "synced" || row.Title != "edited" {
+		t.Fatalf("remote-wins: %+v", row)
+	}
+	if ops := pendingOps(t, a); len(ops) != 0 {
+		t.Fatalf("pending %v", ops)
+	}
+	history, err := a.ListConflictHistory(1)
+	if err != nil || len(history) != 1 || !history[0].OverwrittenDeleted {
+		t.Fatalf("history %+v, %v", history, err)
+	}
+	if _, err := a.UndoConflictResolution(history[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if row, _ := a.getEvent(row.ID); row.SyncStatus != "deleted" {
+		t.Fatalf("after undo %+v", row)
+	}
+
+	// Without a policy the conflict is parked for the user.
+	if row := deleted(ConflictPolicyManual, "g-manual"); row.SyncStatus != "conflict" {
+		t.Fatalf("manual: %+v", row)
+	}
+	conflicts, err := a.ListConflicts()
+	if err != nil || len(conflicts) != 1 || !conflicts[0].LocalDeleted || len(conflicts[0].Fields) != 0 {
+		t.Fatalf("conflicts %+v, %v", conflicts, err)
+	}
+	if _, err := a.ResolveConflict(conflicts[0].EventID, ConflictMerge, []string{"title"}); err == nil {
+		t.Fatal("merged a deletion")
+	}
+}
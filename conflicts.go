@@ -0,0 +1,363 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conflict resolution strategies accepted by ResolveConflict.
+const (
+	ConflictKeepLocal  = "keep-local"
+	ConflictKeepRemote = "keep-remote"
+	ConflictMerge      = "merge"
+)
+
+// conflictFields lists the fields compared between the local and remote versions,
+// named after their CalendarEvent JSON keys.
+var conflictFields = []string{"title", "allDay", "start", "end", "recurrence", "recurrenceCustom", "location", "color", "description", "timeZone", "reminders"}
+
+// ConflictField is one field that differs between the local and remote versions.
+type ConflictField struct {
+	Field  string `json:"field"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+}
+
+// EventConflict pairs a local event with the remote version it conflicts with.
+// LocalDeleted is set when the local change was deleting the event, which Local shows
+// as last seen.
+type EventConflict struct {
+	EventID       string          `json:"eventId"`
+	Local         CalendarEvent   `json:"local"`
+	Remote        CalendarEvent   `json:"remote"`
+	LocalDeleted  bool            `json:"localDeleted"`
+	RemoteDeleted bool            `json:"remoteDeleted"`
+	Fields        []ConflictField `json:"fields"`
+	DetectedAt    string          `json:"detectedAt"`
+}
+
+func ensureConflictsTable(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS event_conflicts (
+		event_id TEXT PRIMARY KEY,
+		remote_json TEXT NOT NULL,
+		local_deleted INTEGER NOT NULL DEFAULT 0,
+		detected_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// recordConflict stores the remote version next to the local row and parks the row
+// as 'conflict' so neither push nor pull touches it until it is resolved. A row parked
+// while deleted is remembered as a local deletion.
+func (a *App) recordConflict(eventID string, remote GoogleEvent) error {
+	payload, err := json.Marshal(remote)
+	if err != nil {
+		return err
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		INSERT INTO event_conflicts (event_id, remote_json, local_deleted, detected_at)
+		VALUES (?, ?, (SELECT sync_status = 'deleted' FROM events WHERE id = ?), ?)
+		ON CONFLICT(event_id) DO UPDATE SET remote_json=excluded.remote_json, detected_at=excluded.detected_at
+	`, eventID, string(payload), eventID, time.Now()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE events SET sync_status='conflict' WHERE id=?`, eventID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListConflicts returns every unresolved conflict with both versions and the fields that differ.
+func (a *App) ListConflicts() ([]EventConflict, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	rows, err := a.db.Query(`SELECT event_id FROM event_conflicts ORDER BY detected_at ASC`)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	conflicts := make([]EventConflict, 0, len(ids))
+	for _, id := range ids {
+		c, err := a.loadConflict(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, nil
+}
+
+// ResolveConflict settles a conflict. keep-local pushes the local version over the remote
+// one on the next sync, keep-remote adopts the remote version, and merge takes the fields
+// named in remoteFields from the remote version and everything else from the local one.
+func (a *App) ResolveConflict(id, strategy string, remoteFields []string) (CalendarEvent, error) {
+	if a.db == nil {
+		return CalendarEvent{}, errors.New("db not initialised")
+	}
+	c, err := a.loadConflict(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CalendarEvent{}, errors.New("conflict not found")
+	}
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	defer tx.Rollback()
+	resolved, err := applyConflictResolution(tx, c, strategy, remoteFields)
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return CalendarEvent{}, err
+	}
+	return resolved, nil
+}
+
+// applyConflictResolution writes the chosen version of a conflicting event and clears
+// the parked conflict, if any.
+func applyConflictResolution(tx *sql.Tx, c EventConflict, strategy string, remoteFields []string) (CalendarEvent, error) {
+	id := c.EventID
+	var resolved CalendarEvent
+	switch strategy {
+	case ConflictKeepRemote:
+		resolved = c.Remote
+	case ConflictKeepLocal:
+		resolved = c.Local
+	case ConflictMerge:
+		resolved = c.Local
+		for _, field := range remoteFields {
+			if err := copyConflictField(&resolved, c.Remote, field); err != nil {
+				return CalendarEvent{}, err
+			}
+		}
+	default:
+		return CalendarEvent{}, fmt.Errorf("unknown conflict strategy: %s", strategy)
+	}
+
+	if c.LocalDeleted && strategy == ConflictMerge {
+		return CalendarEvent{}, errors.New("a deleted event cannot be merged")
+	}
+
+	if strategy == ConflictKeepRemote {
+		if c.RemoteDeleted {
+			// Like finishDelete: the row goes with its exceptions and pending changes.
+			if err := deleteExceptions(tx, id); err != nil {
+				return CalendarEvent{}, err
+			}
+			if _, err := tx.Exec(`DELETE FROM events WHERE id = ?`, id); err != nil {
+				return CalendarEvent{}, err
+			}
+			if _, err := tx.Exec(`DELETE FROM outbox WHERE event_id = ?`, id); err != nil {
+				return CalendarEvent{}, err
+			}
+			resolved.SyncStatus = "deleted"
+		} else if err := storeRemoteEvent(tx, id, resolved); err != nil {
+			return CalendarEvent{}, err
+		}
+	} else if c.LocalDeleted {
+		// The deletion stands, made against the remote version just looked at; the delete
+		// queued with it is pushed on the next sync.
+		if _, err := tx.Exec(`UPDATE events SET sync_status='deleted', remote_etag=?, updated_at=? WHERE id=?`, c.Remote.GoogleETag, time.Now(), id); err != nil {
+			return CalendarEvent{}, err
+		}
+		resolved.SyncStatus = "deleted"
+	} else {
+		if c.RemoteDeleted {
+			// The remote copy is gone; push the local version as a new event.
+			resolved.SyncStatus = "new"
+			resolved.GoogleEventID = ""
+			resolved.GoogleETag = ""
+		} else {
+			// Patch against the remote version we just looked at.
+			resolved.SyncStatus = "dirty"
+			resolved.GoogleETag = c.Remote.GoogleETag
+		}
+		if err := writeResolvedEvent(tx, id, resolved); err != nil {
+			return CalendarEvent{}, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM event_conflicts WHERE event_id = ?`, id); err != nil {
+		return CalendarEvent{}, err
+	}
+	resolved.ID = id
+	return resolved, nil
+}
+
+func (a *App) loadConflict(eventID string) (EventConflict, error) {
+	var remoteJSON string
+	var localDeleted bool
+	var detectedAt time.Time
+	if err := a.db.QueryRow(`SELECT remote_json, local_deleted, detected_at FROM event_conflicts WHERE event_id = ?`, eventID).Scan(&remoteJSON, &localDeleted, &detectedAt); err != nil {
+		return EventConflict{}, err
+	}
+	local, err := a.getEvent(eventID)
+	if err != nil {
+		return EventConflict{}, err
+	}
+	var ge GoogleEvent
+	if err := json.Unmarshal([]byte(remoteJSON), &ge); err != nil {
+		return EventConflict{}, fmt.Errorf("decode remote version: %w", err)
+	}
+	c := buildConflict(local, ge, localDeleted, detectedAt)
+	return c, a.resolveDefaultAlert(&c.Remote)
+}
+
+func buildConflict(local CalendarEvent, ge GoogleEvent, localDeleted bool, detectedAt time.Time) EventConflict {
+	remote := googleToCalendar(local.AccountID, local.GoogleCalendarID, ge)
+	remote.ID = local.ID
+	c := EventConflict{
+		EventID:       local.ID,
+		Local:         local,
+		Remote:        remote,
+		LocalDeleted:  localDeleted,
+		RemoteDeleted: ge.Status == "cancelled",
+		DetectedAt:    detectedAt.Format(time.RFC3339),
+	}
+	if !c.LocalDeleted && !c.RemoteDeleted {
+		c.Fields = diffEvents(local, remote)
+	}
+	return c
+}
+
+// writeResolvedEvent stores the outcome of a keep-local or merge resolution, reminders
+// included when e says what they are.
+func writeResolvedEvent(tx *sql.Tx, id string, e CalendarEvent) error {
+	startTime, endTime, err := parseEventTimes(e)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`UPDATE events SET title=?, all_day=?, start=?, end=?, recurrence=?, recurrence_custom=?, location=?, alert=?, alert_offset=?, color=?, description=?, time_zone=?, sync_status=?, remote_id=?, remote_etag=?, updated_at=? WHERE id=?`,
+		e.Title, boolToInt(e.AllDay), startTime, endTime, e.Recurrence, e.RecurrenceEx, e.Location, firstNonEmpty(e.Alert, "none"), e.AlertOffset, e.Color, e.Description, e.TimeZone, e.SyncStatus, e.GoogleEventID, e.GoogleETag, time.Now(), id,
+	)
+	if err != nil || (e.Reminders == nil && !e.UseDefaultReminders) {
+		return err
+	}
+	return writeReminders(tx, id, e)
+}
+
+// diffEvents returns the compared fields whose values differ.
+func diffEvents(local, remote CalendarEvent) []ConflictField {
+	var fields []ConflictField
+	for _, field := range conflictFields {
+		l := conflictFieldValue(local, field)
+		r := conflictFieldValue(remote, field)
+		if l != r {
+			fields = append(fields, ConflictField{Field: field, Local: l, Remote: r})
+		}
+	}
+	return fields
+}
+
+func conflictFieldValue(e CalendarEvent, field string) string {
+	switch field {
+	case "title":
+		return e.Title
+	case "allDay":
+		return strconv.FormatBool(e.AllDay)
+	case "start":
+		return normalizeEventTime(e.Start, e.AllDay)
+	case "end":
+		return normalizeEventTime(e.End, e.AllDay)
+	case "recurrence":
+		if e.Recurrence == "" {
+			return "none"
+		}
+		return e.Recurrence
+	case "recurrenceCustom":
+		return e.RecurrenceEx
+	case "location":
+		return e.Location
+	case "color":
+		return e.Color
+	case "description":
+		return e.Description
+	case "timeZone":
+		// Events saved without a zone are stored as UTC.
+		return firstNonEmpty(e.TimeZone, "UTC")
+	case "reminders":
+		if e.UseDefaultReminders {
+			return "default"
+		}
+		var parts []string
+		for _, r := range sortReminders(e.Reminders) {
+			parts = append(parts, fmt.Sprintf("%s %dm", r.Method, r.Minutes))
+		}
+		return strings.Join(parts, ", ")
+	}
+	return ""
+}
+
+func copyConflictField(dst *CalendarEvent, src CalendarEvent, field string) error {
+	switch field {
+	case "title":
+		dst.Title = src.Title
+	case "allDay":
+		dst.AllDay = src.AllDay
+	case "start":
+		dst.Start = src.Start
+	case "end":
+		dst.End = src.End
+	case "recurrence":
+		dst.Recurrence = src.Recurrence
+	case "recurrenceCustom":
+		dst.RecurrenceEx = src.RecurrenceEx
+	case "location":
+		dst.Location = src.Location
+	case "color":
+		dst.Color = src.Color
+	case "description":
+		dst.Description = src.Description
+	case "timeZone":
+		dst.TimeZone = src.TimeZone
+	case "reminders":
+		dst.Reminders, dst.UseDefaultReminders = src.Reminders, src.UseDefaultReminders
+		dst.Alert, dst.AlertOffset = src.Alert, src.AlertOffset
+	default:
+		return fmt.Errorf("unknown conflict field: %s", field)
+	}
+	return nil
+}
+
+// normalizeEventTime makes stored and Google time strings comparable.
+func normalizeEventTime(v string, allDay bool) string {
+	if allDay {
+		return strings.Split(v, "T")[0]
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return v
+	}
+	return t.UTC().Format(time.RFC3339)
+}
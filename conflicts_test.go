@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func localEvent(title string) CalendarEvent {
+	return CalendarEvent{Title: title, Start: "2024-05-02T09:00:00Z", End: "2024-05-02T10:00:00Z", Recurrence: "none", Alert: "none"}
+}
+
+// syncedEvent stores an event as pulled from Google and then edited locally.
+func syncedEvent(t *testing.T, a *App, title, remoteID string) CalendarEvent {
+	t.Helper()
+	e, err := a.CreateEvent(localEvent(title))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, a, `UPDATE events SET sync_status = 'dirty', remote_id = ?, remote_etag = '"1"' WHERE id = ?`, remoteID, e.ID)
+	mustExec(t, a, `DELETE FROM outbox`)
+	return e
+}
+
+func TestKeepRemoteRemovesDeletedSeries(t *testing.T) {
+	a := newTestApp(t)
+	master := newStandup(t, a)
+	mustExec(t, a, `UPDATE events SET sync_status = 'synced', remote_id = 'g-standup', remote_etag = '"1"' WHERE id = ?`, master.ID)
+	mustExec(t, a, `DELETE FROM outbox`)
+
+	// Local edits to the series and one of its occurrences meet a remote deletion.
+	late := master
+	late.Title, late.Start, late.End = "late standup", "2024-05-03T11:00:00Z", "2024-05-03T11:30:00Z"
+	if _, err := a.UpdateOccurrence(master.ID, "2024-05-03T09:00:00Z", OccurrenceThis, late); err != nil {
+		t.Fatal(err)
+	}
+	renamed := master
+	renamed.Title = "daily"
+	if _, err := a.UpdateEvent(renamed); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.recordConflict(master.ID, GoogleEvent{ID: "g-standup", Status: "cancelled"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := a.ResolveConflict(master.ID, ConflictKeepRemote, nil)
+	if err != nil || resolved.SyncStatus != "deleted" {
+		t.Fatalf("resolved %+v, %v", resolved, err)
+	}
+	for _, table := range []string{"events", "outbox", "event_conflicts"} {
+		if n := countRows(t, a, `SELECT COUNT(*) FROM `+table); n != 0 {
+			t.Fatalf("%d rows left in %s", n, table)
+		}
+	}
+	if ops := pendingOps(t, a); len(ops) != 0 {
+		t.Fatalf("pending %v", ops)
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	a := newTestApp(t)
+	theirs := GoogleEvent{Etag: `"2"`, Updated: "2024-05-02T08:00:00Z", Summary: "theirs", Location: "room 2", Start: GoogleEventTime{DateTime: "2024-05-02T09:00:00Z"}, End: GoogleEventTime{DateTime: "2024-05-02T10:00:00Z"}, Reminders: &GoogleReminders{Overrides: []Reminder{{Method: ReminderPopup, Minutes: 30}}}}
+	// parked stores a local edit of an event and the remote version it met.
+	parked := func(remoteID string, remote GoogleEvent) CalendarEvent {
+		t.Helper()
+		e := syncedEvent(t, a, "mine", remoteID)
+		remote.ID = remoteID
+		if err := a.recordConflict(e.ID, remote); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	resolve := func(id, strategy string, remoteFields ...string) CalendarEvent {
+		t.Helper()
+		if _, err := a.ResolveConflict(id, strategy, remoteFields); err != nil {
+			t.Fatal(err)
+		}
+		row, err := a.getEvent(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return row
+	}
+
+	mine := parked("g-listed", theirs)
+	conflicts, err := a.ListConflicts()
+	if err != nil || len(conflicts) != 1 || conflicts[0].EventID != mine.ID || conflicts[0].RemoteDeleted {
+		t.Fatalf("conflicts %+v, %v", conflicts, err)
+	}
+	want := []ConflictField{{"title", "mine", "theirs"}, {"location", "", "room 2"}, {"reminders", "", "popup 30m"}}
+	if !reflect.DeepEqual(conflicts[0].Fields, want) {
+		t.Fatalf("fields %+v", conflicts[0].Fields)
+	}
+
+	// Unknown strategies and fields are refused and leave the conflict parked.
+	if _, err := a.ResolveConflict(mine.ID, "both", nil); err == nil || !strings.Contains(err.Error(), "unknown conflict strategy") {
+		t.Fatalf("unknown strategy: %v", err)
+	}
+	if _, err := a.ResolveConflict(mine.ID, ConflictMerge, []string{"attendees"}); err == nil || !strings.Contains(err.Error(), "unknown conflict field") {
+		t.Fatalf("unknown field: %v", err)
+	}
+	if _, err := a.ResolveConflict("missing", ConflictKeepLocal, nil); err == nil {
+		t.Fatal("resolved a missing conflict")
+	}
+	if conflicts, _ := a.ListConflicts(); len(conflicts) != 1 {
+		t.Fatalf("conflicts after refusals %+v", conflicts)
+	}
+
+	// keep-local pushes the local version over the remote one it was shown.
+	if row := resolve(mine.ID, ConflictKeepLocal); row.Title != "mine" || row.SyncStatus != "dirty" || row.GoogleETag != `"2"` {
+		t.Fatalf("keep-local %+v", row)
+	}
+	if conflicts, _ := a.ListConflicts(); len(conflicts) != 0 {
+		t.Fatalf("conflicts after keep-local %+v", conflicts)
+	}
+
+	// merge takes the fields named from the remote version, reminders included.
+	merged := parked("g-merged", theirs)
+	row := resolve(merged.ID, ConflictMerge, "location", "reminders")
+	if row.Title != "mine" || row.Location != "room 2" || row.Alert != "30m" || !reflect.DeepEqual(row.Reminders, []Reminder{{ReminderPopup, 30}}) || row.SyncStatus != "dirty" || row.GoogleETag != `"2"` {
+		t.Fatalf("merge %+v", row)
+	}
+
+	// keep-remote adopts the remote version with its reminders.
+	adopted := parked("g-adopted", theirs)
+	if row := resolve(adopted.ID, ConflictKeepRemote); row.Title != "theirs" || row.Location != "room 2" || row.Alert != "30m" || len(row.Reminders) != 1 || row.SyncStatus != "synced" {
+		t.Fatalf("keep-remote %+v", row)
+	}
+
+	// keep-local on a remote deletion creates the event again.
+	kept := parked("g-deleted", GoogleEvent{Status: "cancelled"})
+	if row := resolve(kept.ID, ConflictKeepLocal); row.Title != "mine" || row.SyncStatus != "new" || row.GoogleEventID != "" || row.GoogleETag != "" {
+		t.Fatalf("keep-local after remote deletion %+v", row)
+	}
+
+	// keep-remote on a remote deletion removes the row and the changes queued for it.
+	gone := parked("g-gone", GoogleEvent{Status: "cancelled"})
+	mustExec(t, a, `INSERT INTO outbox (event_id, op, created_at) VALUES (?, 'update', CURRENT_TIMESTAMP)`, gone.ID)
+	if resolved, err := a.ResolveConflict(gone.ID, ConflictKeepRemote, nil); err != nil || resolved.SyncStatus != "deleted" {
+		t.Fatalf("keep-remote after remote deletion %+v, %v", resolved, err)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events WHERE id = ?`, gone.ID); n != 0 {
+		t.Fatal("deleted event kept")
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM outbox WHERE event_id = ?`, gone.ID); n != 0 {
+		t.Fatalf("%d outbox entries left", n)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// Reminder methods, as Google names them.
+const (
+	ReminderPopup = "popup"
+	ReminderEmail = "email"
+)
+
+// Reminder is one reminder of an event, given in minutes before it starts.
+type Reminder struct {
+	Method  string `json:"method"`
+	Minutes int    `json:"minutes"`
+}
+
+// ensureEventRemindersTable creates the table holding each event's reminders, and the
+// calendars' default reminders, which events with reminders_use_default follow instead.
+func ensureEventRemindersTable(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS event_reminders (
+		event_id TEXT NOT NULL,
+		method TEXT NOT NULL,
+		minutes INTEGER NOT NULL,
+		PRIMARY KEY (event_id, method, minutes)
+	);
+	`
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	// Rows are deleted from many places; the trigger keeps their reminders from lingering.
+	if _, err := db.Exec(`
+		CREATE TRIGGER IF NOT EXISTS events_delete_reminders AFTER DELETE ON events
+		BEGIN
+			DELETE FROM event_reminders WHERE event_id = OLD.id;
+		END
+	`); err != nil {
+		return err
+	}
+	columns, err := tableColumns(db, "calendars")
+	if err != nil {
+		return err
+	}
+	if !columns["default_reminders"] {
+		if _, err := db.Exec(`ALTER TABLE calendars ADD COLUMN default_reminders TEXT`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alertPresets are the alert choices offered in the event form, by minutes.
+var alertPresets = map[int]string{5: "5m", 10: "10m", 15: "15m", 30: "30m", 60: "1h", 24 * 60: "1d"}
+
+// alertForReminders returns the alert showing reminders in the event form: the popup
+// that goes off last before the start.
+func alertForReminders(reminders []Reminder) (string, int) {
+	minutes := -1
+	for _, r := range reminders {
+		if r.Method == ReminderPopup && (minutes < 0 || r.Minutes < minutes) {
+			minutes = r.Minutes
+		}
+	}
+	if minutes < 0 {
+		return "none", 0
+	}
+	if alert, ok := alertPresets[minutes]; ok {
+		return alert, 0
+	}
+	return "custom", minutes
+}
+
+// reconcileReminders settles the reminders of e before it is written, keeping its alert
+// in step with them. The event form edits the nearest popup through the alert, so an
+// edited alert replaces the popups; without reminders, e gets the alert's.
+func reconcileReminders(e CalendarEvent, alertEdited bool) CalendarEvent {
+	if alertEdited || (e.Reminders == nil && !e.UseDefaultReminders) {
+		reminders := []Reminder{}
+		if !e.UseDefaultReminders {
+			for _, r := range e.Reminders {
+				if r.Method != ReminderPopup {
+					reminders = append(reminders, r)
+				}
+			}
+		}
+		if minutes, ok := alertMinutes(e); ok {
+			reminders = append(reminders, Reminder{Method: ReminderPopup, Minutes: minutes})
+		}
+		e.Reminders = sortReminders(reminders)
+		e.UseDefaultReminders = false
+		return e
+	}
+	if e.UseDefaultReminders {
+		e.Reminders = []Reminder{}
+		return e
+	}
+	e.Reminders = sortReminders(e.Reminders)
+	e.Alert, e.AlertOffset = alertForReminders(e.Reminders)
+	return e
+}
+
+// sortReminders orders reminders nearest to the start first, dropping duplicates.
+func sortReminders(reminders []Reminder) []Reminder {
+	out := make([]Reminder, 0, len(reminders))
+	for _, r := range reminders {
+		if r.Method == "" {
+			r.Method = ReminderPopup
+		}
+		if !slices.Contains(out, r) {
+			out = append(out, r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Minutes != out[j].Minutes {
+			return out[i].Minutes < out[j].Minutes
+		}
+		return out[i].Method > out[j].Method
+	})
+	return out
+}
+
+// writeReminders replaces the stored reminders of the row eventID with those of e.
+func writeReminders(db execer, eventID string, e CalendarEvent) error {
+	if _, err := db.Exec(`DELETE FROM event_reminders WHERE event_id = ?`, eventID); err != nil {
+		return err
+	}
+	if !e.UseDefaultReminders {
+		for _, r := range e.Reminders {
+			if _, err := db.Exec(`INSERT OR IGNORE INTO event_reminders (event_id, method, minutes) VALUES (?, ?, ?)`, eventID, r.Method, r.Minutes); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := db.Exec(`UPDATE events SET reminders_use_default = ? WHERE id = ?`, boolToInt(e.UseDefaultReminders), eventID)
+	return err
+}
+
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// loadReminders returns the reminders stored for the row eventID, nearest to the start first.
+func loadReminders(db querier, eventID string) ([]Reminder, error) {
+	rows, err := db.Query(`SELECT method, minutes FROM event_reminders WHERE event_id = ?`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Reminder{}
+	for rows.Next() {
+		var r Reminder
+		if err := rows.Scan(&r.Method, &r.Minutes); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return sortReminders(out), rows.Err()
+}
+
+// attachReminders fills in the reminders of events.
+func (a *App) attachReminders(events []CalendarEvent) error {
+	rows, err := a.db.Query(`SELECT event_id, method, minutes FROM event_reminders`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byEvent := make(map[string][]Reminder)
+	for rows.Next() {
+		var id string
+		var r Reminder
+		if err := rows.Scan(&id, &r.Method, &r.Minutes); err != nil {
+			return err
+		}
+		byEvent[id] = append(byEvent[id], r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range events {
+		events[i].Reminders = sortReminders(byEvent[events[i].ID])
+	}
+	return nil
+}
+
+// googleReminders returns the reminder settings to send to Google for the row e.
+func (a *App) googleReminders(e CalendarEvent) (*GoogleReminders, error) {
+	var useDefault bool
+	// The row may be gone already, deleted while it was being pushed.
+	err := a.db.QueryRow(`SELECT reminders_use_default FROM events WHERE id = ?`, e.ID).Scan(&useDefault)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if useDefault {
+		return &GoogleReminders{UseDefault: true}, nil
+	}
+	overrides, err := loadReminders(a.db, e.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &GoogleReminders{Overrides: overrides}, nil
+}
+
+// calendarDefaultReminders returns the default reminders of a Google calendar.
+func (a *App) calendarDefaultReminders(accountID, calendarID string) ([]Reminder, error) {
+	var raw sql.NullString
+	err := a.db.QueryRow(`SELECT default_reminders FROM calendars WHERE account_id = ? AND id = ?`, accountID, calendarID).Scan(&raw)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return decodeReminders(raw.String)
+}
+
+func decodeReminders(raw string) ([]Reminder, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var out []Reminder
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// resolveDefaultAlert sets the alert of a version read from Google that follows its
+// calendar's default reminders from those defaults.
+func (a *App) resolveDefaultAlert(e *CalendarEvent) error {
+	if !e.UseDefaultReminders {
+		return nil
+	}
+	defaults, err := a.calendarDefaultReminders(e.AccountID, e.GoogleCalendarID)
+	if err != nil {
+		return err
+	}
+	e.Alert, e.AlertOffset = alertForReminders(defaults)
+	return nil
+}
+
+// popupReminders maps row IDs to the minutes before the start their popup reminders go
+// off, resolving calendar defaults.
+func (a *App) popupReminders() (map[string][]int, error) {
+	out := make(map[string][]int)
+	rows, err := a.db.Query(`
+		SELECT r.event_id, r.minutes FROM event_reminders r JOIN events e ON e.id = r.event_id
+		WHERE r.method = ? AND e.reminders_use_default = 0`, ReminderPopup)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id string
+		var minutes int
+		if err := rows.Scan(&id, &minutes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		out[id] = append(out[id], minutes)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = a.db.Query(`
+		SELECT e.id, COALESCE(c.default_reminders,'') FROM events e
+		LEFT JOIN calendars c ON c.account_id = e.account_id AND c.id = e.google_calendar_id
+		WHERE e.reminders_use_default = 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, raw string
+		if err := rows.Scan(&id, &raw); err != nil {
+			return nil, err
+		}
+		defaults, err := decodeReminders(raw)
+		if err != nil {
+			return nil, err
+		}
+		out[id] = []int{}
+		for _, r := range defaults {
+			if r.Method == ReminderPopup {
+				out[id] = append(out[id], r.Minutes)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, minutes := range out {
+		sort.Ints(minutes)
+	}
+	return out, nil
+}
+
+// alertRemindersKey marks in sync_state that migrateAlertReminders has run.
+const alertRemindersKey = "alert_reminders_migrated"
+
+// migrateAlertReminders gives rows written before reminders were stored the popup
+// reminder their alert stands for.
+func migrateAlertReminders(db *sql.DB) error {
+	var done int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sync_state WHERE key = ?`, alertRemindersKey).Scan(&done); err != nil {
+		return err
+	}
+	if done > 0 {
+		return nil
+	}
+	rows, err := db.Query(`SELECT id, alert, alert_offset FROM events
+		WHERE reminders_use_default = 0 AND alert != 'none' AND id NOT IN (SELECT event_id FROM event_reminders)`)
+	if err != nil {
+		return err
+	}
+	var pending []CalendarEvent
+	for rows.Next() {
+		var e CalendarEvent
+		if err := rows.Scan(&e.ID, &e.Alert, &e.AlertOffset); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, e := range pending {
+		if err := writeReminders(tx, e.ID, reconcileReminders(e, true)); err != nil {
+			return fmt.Errorf("migrate alert of %s: %w", e.ID, err)
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO sync_state (key, value) VALUES (?, '1')`, alertRemindersKey); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"myapp/internal/fakegcal"
+)
+
+func TestRemindersRoundTrip(t *testing.T) {
+	srv := fakegcal.New()
+	defer srv.Close()
+	srv.AddCalendar(fakegcal.Calendar{ID: fakegcal.PrimaryCalendarID, Summary: fakegcal.PrimaryCalendarID, Primary: true, Selected: true,
+		DefaultReminders: []fakegcal.Reminder{{Method: "popup", Minutes: 30}, {Method: "email", Minutes: 60}}})
+	a := newE2EApp(t, srv)
+
+	custom := remoteEvent("custom")
+	custom["reminders"] = map[string]any{"useDefault": false, "overrides": []any{
+		map[string]any{"method": "email", "minutes": 1440},
+		map[string]any{"method": "popup", "minutes": 10},
+	}}
+	srv.PutEvent(fakegcal.PrimaryCalendarID, custom)
+	srv.PutEvent(fakegcal.PrimaryCalendarID, remoteEvent("defaults"))
+	mustSync(t, a)
+
+	rows := eventRows(t, a)
+	customRow, err := a.getEvent(rows["custom"].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultsRow, err := a.getEvent(rows["defaults"].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customRow.Alert != "10m" || defaultsRow.Alert != "30m" {
+		t.Fatalf("alerts %q, %q", customRow.Alert, defaultsRow.Alert)
+	}
+	if want := []Reminder{{"popup", 10}, {"email", 1440}}; !reflect.DeepEqual(customRow.Reminders, want) {
+		t.Fatalf("stored reminders %v, want %v", customRow.Reminders, want)
+	}
+	if !defaultsRow.UseDefaultReminders || len(defaultsRow.Reminders) != 0 {
+		t.Fatalf("default reminders %+v", defaultsRow)
+	}
+	popups, err := a.popupReminders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(popups[customRow.ID], []int{10}) || !reflect.DeepEqual(popups[defaultsRow.ID], []int{30}) {
+		t.Fatalf("popup reminders %v", popups)
+	}
+
+	// Changing the alert replaces the popup only; other edits keep the calendar defaults.
+	customRow.Alert = "1h"
+	if _, err := a.UpdateEvent(customRow); err != nil {
+		t.Fatal(err)
+	}
+	defaultsRow.Title = "defaults (renamed)"
+	if _, err := a.UpdateEvent(defaultsRow); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.CreateEvent(CalendarEvent{Title: "quiet", Start: "2024-05-01T09:00:00Z", End: "2024-05-01T10:00:00Z", Alert: "none"}); err != nil {
+		t.Fatal(err)
+	}
+	mustSync(t, a)
+	remote := remoteSummaries(srv)
+	for title, want := range map[string]map[string]any{
+		"custom": {"useDefault": false, "overrides": []any{
+			map[string]any{"method": "popup", "minutes": float64(60)},
+			map[string]any{"method": "email", "minutes": float64(1440)},
+		}},
+		"defaults (renamed)": {"useDefault": true, "overrides": nil},
+		"quiet":              {"useDefault": false, "overrides": []any{}},
+	} {
+		if got := remote[title]["reminders"]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: remote reminders %#v, want %#v", title, got, want)
+		}
+	}
+}
+
+func TestEventReminders(t *testing.T) {
+	a := NewApp()
+	if err := a.openDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+
+	e, err := a.CreateEvent(CalendarEvent{Title: "team meeting", Start: "2024-05-02T09:00:00Z", End: "2024-05-02T10:00:00Z", Alert: "none",
+		Reminders: []Reminder{{"popup", 1440}, {"popup", 10}, {"email", 60}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Reminder{{"popup", 10}, {"email", 60}, {"popup", 1440}}; e.Alert != "10m" || !reflect.DeepEqual(e.Reminders, want) {
+		t.Fatalf("created %q %v, want 10m %v", e.Alert, e.Reminders, want)
+	}
+	listed, err := a.ListEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || !reflect.DeepEqual(listed[0].Reminders, e.Reminders) {
+		t.Fatalf("listed %+v", listed)
+	}
+	got, _ := remindersAt(t, a, "2024-05-01T09:00:00Z")
+	if got != "team meeting@05-02T09:00" {
+		t.Fatalf("day before: %q", got)
+	}
+
+	// Editing the alert alone replaces the popups and keeps the email.
+	e.Alert = "5m"
+	if e, err = a.UpdateEvent(e); err != nil {
+		t.Fatal(err)
+	}
+	if want := []Reminder{{"popup", 5}, {"email", 60}}; !reflect.DeepEqual(e.Reminders, want) {
+		t.Fatalf("after editing the alert %v, want %v", e.Reminders, want)
+	}
+	// Callers that leave the reminders out keep them.
+	e.Reminders, e.Title = nil, "team sync"
+	if _, err := a.UpdateEvent(e); err != nil {
+		t.Fatal(err)
+	}
+	if e, err = a.getEvent(e.ID); err != nil || len(e.Reminders) != 2 {
+		t.Fatalf("after a title edit %+v, %v", e.Reminders, err)
+	}
+	e.Reminders = []Reminder{{"popup", 120}}
+	if e, err = a.UpdateEvent(e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Alert != "custom" || e.AlertOffset != 120 {
+		t.Fatalf("alert %q/%d after setting reminders", e.Alert, e.AlertOffset)
+	}
+
+	// Alerts stored before reminders become popup reminders on upgrade.
+	mustExec(t, a, `INSERT INTO events (id, title, start, end, alert, alert_offset) VALUES ('old1', 'old', '2024-05-03T09:00:00Z', '2024-05-03T10:00:00Z', '15m', 0), ('old2', 'old', '2024-05-03T09:00:00Z', '2024-05-03T10:00:00Z', 'custom', 45)`)
+	mustExec(t, a, `DELETE FROM sync_state WHERE key = ?`, alertRemindersKey)
+	if err := migrateAlertReminders(a.db); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string][]Reminder{"old1": {{"popup", 15}}, "old2": {{"popup", 45}}, e.ID: {{"popup", 120}}} {
+		if got, err := a.getEvent(id); err != nil || !reflect.DeepEqual(got.Reminders, want) {
+			t.Errorf("%s: reminders %v, want %v (%v)", id, got.Reminders, want, err)
+		}
+	}
+}
@@ -18,7 +18,7 @@ import { useLanguage } from './language-provider'
 import { useHolidaySettings } from './hooks/use-holiday-settings'
 import StatusBanner from './status-banner'
 import { GoogleTokenInfo } from '../../wailsjs/go/main/App'
-import { BrowserOpenURL } from '../../wailsjs/runtime/runtime'
+import { BrowserOpenURL, EventsOn } from '../../wailsjs/runtime/runtime'
 
 const HOMEPAGE_URL = 'https://jkh-ml.github.io/windows-calendar-widget/'
 
@@ -142,6 +142,37 @@ export default function CalendarDemo() {
     return () => window.removeEventListener('google-connected', onConnected)
   }, [countryCode, resolvedLanguage])
 
+  // 백그라운드 동기화가 끝나거나 CalDAV·API 서버, 구독 피드가 일정을 바꾸면 화면의 일정을 다시 읽어옴
+  useEffect(() => {
+    const reload = async (synced: boolean) => {
+      try {
+        const data = await ListEvents()
+        let holidays: any[] = []
+        try {
+          holidays = await GoogleListHolidays(countryCode || resolvedLanguage)
+        } catch (error) {
+          holidays = []
+        }
+        setEvents(
+          [...data, ...holidays].map((e: any) => ({
+            ...e,
+            start: new Date(e.start),
+            end: new Date(e.end),
+          }))
+        )
+        if (synced) setSyncError(null)
+      } catch (error) {
+        console.error('Failed to load events', error)
+      }
+    }
+    const offs = [
+      EventsOn('sync:finished', () => reload(true)),
+      EventsOn('calendar:changed', () => reload(false)),
+      EventsOn('subscriptions:refreshed', () => reload(false)),
+    ]
+    return () => offs.forEach((off) => off())
+  }, [countryCode, resolvedLanguage])
+
   const api = {
     async create(event: CalendarEvent) {
       const created = await CreateEvent({
@@ -42,6 +42,8 @@ export default function CalendarSettingsDialog({
     weekStartsOn === 1 ? 'monday' : 'sunday'
   )
   const [autoStart, setAutoStart] = useState(true)
+  // UpdateSettings replaces every setting, so saving sends back what was loaded.
+  const [settings, setSettings] = useState<main.AppSettings | null>(null)
   const [loadingSettings, setLoadingSettings] = useState(false)
   const [error, setError] = useState<string | null>(null)
 
@@ -57,6 +59,7 @@ export default function CalendarSettingsDialog({
       setError(null)
       try {
         const s: main.AppSettings = await GetSettings()
+        setSettings(s)
         setAutoStart(s.autoStart ?? true)
       } catch (err: any) {
         setError(err?.message ?? String(err))
@@ -156,7 +159,7 @@ export default function CalendarSettingsDialog({
               onClick={() => {
                 onChange(selected)
                 onChangeWeekStartsOn(selectedWeekStart === 'monday' ? 1 : 0)
-                void UpdateSettings({ autoStart })
+                if (settings) void UpdateSettings({ ...settings, autoStart })
                 onOpenChange(false)
               }}
             >
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// errGoogleRateLimited matches Google API errors caused by quota or rate limits.
+var errGoogleRateLimited = errors.New("google rate limit exceeded")
+
+// retryPolicy bounds how GoogleSyncService retries transient failures.
+type retryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// googleAPIError is an error response from a Google API.
+type googleAPIError struct {
+	Op     string
+	Status string
+	Code   int
+	Reason string
+	Body   string
+}
+
+func (e *googleAPIError) Error() string {
+	return fmt.Sprintf("%s failed: %s %s", e.Op, e.Status, e.Body)
+}
+
+func (e *googleAPIError) Is(target error) bool {
+	return target == errGoogleRateLimited && e.rateLimited()
+}
+
+func (e *googleAPIError) rateLimited() bool {
+	return e.Code == http.StatusTooManyRequests || (e.Code == http.StatusForbidden && isRateLimitReason(e.Reason))
+}
+
+// Temporary reports whether the request may succeed if tried again later.
+func (e *googleAPIError) Temporary() bool {
+	return e.rateLimited() || e.Code >= 500
+}
+
+// newGoogleAPIError reads an error response; the caller still closes the body.
+func newGoogleAPIError(op string, resp *http.Response) *googleAPIError {
+	body, _ := io.ReadAll(resp.Body)
+	return &googleAPIError{Op: op, Status: resp.Status, Code: resp.StatusCode, Reason: googleErrorReason(body), Body: string(body)}
+}
+
+// isPermanentGoogleError reports whether err is an API error retrying cannot fix.
+func isPermanentGoogleError(err error) bool {
+	var apiErr *googleAPIError
+	return errors.As(err, &apiErr) && !apiErr.Temporary()
+}
+
+func isRateLimitReason(reason string) bool {
+	return reason == "rateLimitExceeded" || reason == "userRateLimitExceeded"
+}
+
+// googleErrorReason extracts error.errors[0].reason from a Google error body.
+func googleErrorReason(body []byte) string {
+	var payload struct {
+		Error struct {
+			Errors []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || len(payload.Error.Errors) == 0 {
+		return ""
+	}
+	return payload.Error.Errors[0].Reason
+}
+
+// send performs req, retrying rate limits, 5xx responses and network errors with
+// exponential backoff and jitter. Retry-After is honoured when it fits within the
+// policy's maximum delay; a longer wait gives up and returns the response. The last
+// response is returned unchanged so callers report errors as before.
+func (g *GoogleSyncService) send(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	policy := g.retry
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, errors.New("request body cannot be replayed")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := g.httpClient.Do(req)
+		last := attempt >= policy.MaxAttempts
+		if err != nil {
+			if ctx.Err() != nil || last {
+				return nil, err
+			}
+			if werr := g.wait(ctx, backoffDelay(policy, attempt)); werr != nil {
+				return nil, err
+			}
+			continue
+		}
+		retryable, err := retryableResponse(resp)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if !retryable || last {
+			return resp, nil
+		}
+		delay := backoffDelay(policy, attempt)
+		if after, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if after > policy.MaxDelay {
+				return resp, nil
+			}
+			delay = after
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err := g.wait(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryableResponse reports whether resp is worth retrying. A 403 is only retried for
+// rate-limit reasons; its body is read to find out and then restored for the caller.
+func retryableResponse(resp *http.Response) (bool, error) {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, nil
+	case resp.StatusCode == http.StatusForbidden:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return isRateLimitReason(googleErrorReason(body)), nil
+	}
+	return false, nil
+}
+
+// backoffDelay returns the wait after the given failed attempt: the base delay doubled
+// per attempt, capped at the maximum, with jitter over its upper half.
+func backoffDelay(policy retryPolicy, attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func (g *GoogleSyncService) wait(ctx context.Context, d time.Duration) error {
+	if g.sleep != nil {
+		return g.sleep(ctx, d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// calendarStub is an httptest stand-in for the Calendar and OAuth endpoints. Each
+// request is answered by the next queued handler; the last one repeats.
+type calendarStub struct {
+	mu       sync.Mutex
+	handlers []http.HandlerFunc
+	requests []*http.Request
+	bodies   []string
+}
+
+func (s *calendarStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, string(body))
+	h := s.handlers[0]
+	if len(s.handlers) > 1 {
+		s.handlers = s.handlers[1:]
+	}
+	s.mu.Unlock()
+	h(w, r)
+}
+
+func (s *calendarStub) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+// newStubService returns a service with valid tokens whose requests hit the stub, and
+// the list of delays it would have slept for.
+func newStubService(t *testing.T, handlers ...http.HandlerFunc) (*GoogleSyncService, *calendarStub, *[]time.Duration) {
+	t.Helper()
+	stub := &calendarStub{handlers: handlers}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	store := &FileTokenStore{path: t.TempDir() + "/tokens.json"}
+	if err := store.Save(OAuthTokens{AccessToken: "token", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour).Format(time.RFC3339)}); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewGoogleSyncService(GoogleOAuthConfig{
+		ClientID:        "id",
+		ClientSecret:    "secret",
+		RedirectURI:     "http://localhost/cb",
+		TokenURL:        srv.URL + "/token",
+		UserInfoURL:     srv.URL + "/userinfo",
+		CalendarBaseURL: srv.URL + "/calendar/v3",
+	}, store)
+	var mu sync.Mutex
+	var delays []time.Duration
+	svc.sleep = func(ctx context.Context, d time.Duration) error {
+		mu.Lock()
+		delays = append(delays, d)
+		mu.Unlock()
+		return ctx.Err()
+	}
+	return svc, stub, &delays
+}
+
+func status(code int, headers ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"error":{"code":%d,"message":%q}}`, code, http.StatusText(code))
+	}
+}
+
+func googleError(code int, reason string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error": map[string]any{"code": code, "errors": []map[string]any{{"reason": reason}}},
+		})
+	}
+}
+
+func okJSON(body any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+var emptyEventList = okJSON(map[string]any{"items": []any{}, "nextSyncToken": "next"})
+
+func TestSendRetriesServerErrors(t *testing.T) {
+	svc, stub, delays := newStubService(t, status(503), status(502), emptyEventList)
+	_, token, err := svc.ListEvents(context.Background(), "primary", "")
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if token != "next" {
+		t.Fatalf("sync token = %q", token)
+	}
+	if stub.count() != 3 {
+		t.Fatalf("requests = %d, want 3", stub.count())
+	}
+	if len(*delays) != 2 {
+		t.Fatalf("delays = %v, want 2", *delays)
+	}
+	for i, d := range *delays {
+		max := defaultRetryPolicy.BaseDelay << i
+		if d < max/2 || d > max {
+			t.Fatalf("delay %d = %v, want within [%v, %v]", i, d, max/2, max)
+		}
+	}
+}
+
+func TestSendHonoursRetryAfter(t *testing.T) {
+	svc, _, delays := newStubService(t, status(http.StatusTooManyRequests, "Retry-After", "7"), emptyEventList)
+	if _, _, err := svc.ListEvents(context.Background(), "primary", ""); err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if len(*delays) != 1 || (*delays)[0] != 7*time.Second {
+		t.Fatalf("delays = %v, want [7s]", *delays)
+	}
+}
+
+func TestSendGivesUpWhenRetryAfterTooLong(t *testing.T) {
+	svc, stub, _ := newStubService(t, status(http.StatusTooManyRequests, "Retry-After", "3600"), emptyEventList)
+	_, _, err := svc.ListEvents(context.Background(), "primary", "")
+	if !errors.Is(err, errGoogleRateLimited) {
+		t.Fatalf("err = %v, want rate limited", err)
+	}
+	if stub.count() != 1 {
+		t.Fatalf("requests = %d, want 1", stub.count())
+	}
+}
+
+func TestSendDistinguishesRateLimitFrom403(t *testing.T) {
+	svc, stub, _ := newStubService(t, googleError(http.StatusForbidden, "rateLimitExceeded"), googleError(http.StatusForbidden, "userRateLimitExceeded"), okJSON(map[string]any{"id": "e1"}))
+	if _, err := svc.GetEvent(context.Background(), "primary", "e1"); err != nil {
+		t.Fatalf("GetEvent: %v", err)
+	}
+	if stub.count() != 3 {
+		t.Fatalf("requests = %d, want 3", stub.count())
+	}
+
+	svc, stub, _ = newStubService(t, googleError(http.StatusForbidden, "forbidden"))
+	_, err := svc.GetEvent(context.Background(), "primary", "e1")
+	if err == nil || !isPermanentGoogleError(err) || errors.Is(err, errGoogleRateLimited) {
+		t.Fatalf("err = %v, want a permanent non-rate-limit error", err)
+	}
+	if !strings.Contains(err.Error(), "forbidden") {
+		t.Fatalf("error lost the response body: %v", err)
+	}
+	if stub.count() != 1 {
+		t.Fatalf("requests = %d, want 1", stub.count())
+	}
+}
+
+func TestSendStopsAfterMaxAttempts(t *testing.T) {
+	svc, stub, _ := newStubService(t, status(500))
+	err := svc.DeleteEvent(context.Background(), "primary", "e1", "")
+	var apiErr *googleAPIError
+	if !errors.As(err, &apiErr) || !apiErr.Temporary() {
+		t.Fatalf("err = %v, want a temporary API error", err)
+	}
+	if stub.count() != defaultRetryPolicy.MaxAttempts {
+		t.Fatalf("requests = %d, want %d", stub.count(), defaultRetryPolicy.MaxAttempts)
+	}
+}
+
+func TestSendDoesNotRetryPermanentErrors(t *testing.T) {
+	svc, stub, _ := newStubService(t, googleError(http.StatusBadRequest, "invalid"))
+	_, err := svc.CreateEvent(context.Background(), "primary", GoogleEvent{Summary: "x"})
+	if !isPermanentGoogleError(err) {
+		t.Fatalf("err = %v, want permanent", err)
+	}
+	if stub.count() != 1 {
+		t.Fatalf("requests = %d, want 1", stub.count())
+	}
+}
+
+func TestSendReplaysRequestBody(t *testing.T) {
+	svc, stub, _ := newStubService(t, status(503), okJSON(map[string]any{"id": "created"}))
+	got, err := svc.CreateEvent(context.Background(), "primary", GoogleEvent{Summary: "retry me"})
+	if err != nil {
+		t.Fatalf("CreateEvent: %v", err)
+	}
+	if got.ID != "created" {
+		t.Fatalf("created id = %q", got.ID)
+	}
+	for i, body := range stub.bodies {
+		if !strings.Contains(body, `"summary":"retry me"`) {
+			t.Fatalf("attempt %d sent body %q", i+1, body)
+		}
+	}
+}
+
+func TestSendRetriesNetworkErrors(t *testing.T) {
+	dropConnection := func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}
+	svc, stub, _ := newStubService(t, dropConnection, emptyEventList)
+	if _, _, err := svc.ListEvents(context.Background(), "primary", ""); err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if stub.count() != 2 {
+		t.Fatalf("requests = %d, want 2", stub.count())
+	}
+}
+
+func TestSendRetriesTokenRequests(t *testing.T) {
+	svc, stub, _ := newStubService(t, status(500), okJSON(map[string]any{"access_token": "fresh", "expires_in": 3600}))
+	tokens, err := svc.Refresh(context.Background(), "refresh")
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if tokens.AccessToken != "fresh" || tokens.RefreshToken != "refresh" {
+		t.Fatalf("tokens = %+v", tokens)
+	}
+	if stub.count() != 2 || !strings.Contains(stub.bodies[1], "grant_type=refresh_token") {
+		t.Fatalf("token request not replayed: %q", stub.bodies)
+	}
+}
+
+func TestSendStopsWhenContextCancelled(t *testing.T) {
+	svc, stub, _ := newStubService(t, status(503))
+	ctx, cancel := context.WithCancel(context.Background())
+	svc.sleep = func(context.Context, time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+	if _, _, err := svc.ListEvents(ctx, "primary", ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if stub.count() != 1 {
+		t.Fatalf("requests = %d, want 1", stub.count())
+	}
+}
+
+func TestRetryAfterParsing(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"12", 12 * time.Second, true},
+		{"-1", 0, false},
+		{"Wed, 01 May 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 01 May 2024 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestBackoffDelayIsBounded(t *testing.T) {
+	policy := retryPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt := 1; attempt <= 10; attempt++ {
+		d := backoffDelay(policy, attempt)
+		if d < 500*time.Millisecond || d > policy.MaxDelay {
+			t.Fatalf("attempt %d: delay %v out of bounds", attempt, d)
+		}
+	}
+}
+
+// TestPushSkipsRejectedEvents checks a permanent error on one event does not stop the
+// remaining events from being pushed.
+func TestPushSkipsRejectedEvents(t *testing.T) {
+	a := newSyncTestApp(t)
+	svc, _, _ := newStubService(t, func(w http.ResponseWriter, r *http.Request) {
+		var ev GoogleEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		if ev.Summary == "bad" {
+			googleError(http.StatusBadRequest, "invalid")(w, r)
+			return
+		}
+		okJSON(map[string]any{"id": "g-" + ev.Summary, "etag": "\"1\"", "updated": "2024-05-01T09:00:00Z"})(w, r)
+	})
+	for _, title := range []string{"bad", "good"} {
+		if _, err := a.CreateEvent(CalendarEvent{Title: title, Start: "2024-05-01T10:00:00Z", End: "2024-05-01T11:00:00Z", SyncStatus: "new", AccountID: defaultAccountID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pushed, err := a.pushLocalChanges(context.Background(), svc, CalendarInfo{AccountID: defaultAccountID, ID: primaryCalendarAlias, Primary: true, Enabled: true})
+	if pushed != 1 || !isPermanentGoogleError(err) {
+		t.Fatalf("pushed = %d, err = %v; want 1 and the rejection", pushed, err)
+	}
+	events, err := a.ListEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		want := map[string]string{"bad": "new", "good": "synced"}[e.Title]
+		if e.SyncStatus != want {
+			t.Errorf("%s: status %s, want %s", e.Title, e.SyncStatus, want)
+		}
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -18,14 +17,41 @@ var (
 	errGoogleNotFound = errors.New("google event not found")
 	errGoogleGone     = errors.New("google event deleted")
 	errGoogleConflict = errors.New("google event conflict")
+	// errGoogleDuplicate means an insert named an event ID that is already taken (409).
+	errGoogleDuplicate = errors.New("google event already exists")
+	// errSyncTokenExpired means Google rejected a sync token (410) and a full sync is required.
+	errSyncTokenExpired = errors.New("sync token expired")
 )
 
-// GoogleOAuthConfig holds OAuth client details.
+// Default Google endpoints, used when GoogleOAuthConfig leaves them empty.
+const (
+	defaultGoogleAuthURL         = "https://accounts.google.com/o/oauth2/v2/auth"
+	defaultGoogleTokenURL        = "https://oauth2.googleapis.com/token"
+	defaultGoogleUserInfoURL     = "https://www.googleapis.com/oauth2/v2/userinfo"
+	defaultGoogleCalendarBaseURL = "https://www.googleapis.com/calendar/v3"
+)
+
+// GoogleOAuthConfig holds OAuth client details and the endpoints to talk to.
+// The URL fields can point at a fake server for offline testing.
 type GoogleOAuthConfig struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURI  string
 	Scopes       []string
+
+	AuthURL         string
+	TokenURL        string
+	UserInfoURL     string
+	CalendarBaseURL string // e.g. https://www.googleapis.com/calendar/v3
+}
+
+// withDefaults fills in the production endpoints for any URL left empty.
+func (c GoogleOAuthConfig) withDefaults() GoogleOAuthConfig {
+	c.AuthURL = firstNonEmpty(c.AuthURL, defaultGoogleAuthURL)
+	c.TokenURL = firstNonEmpty(c.TokenURL, defaultGoogleTokenURL)
+	c.UserInfoURL = firstNonEmpty(c.UserInfoURL, defaultGoogleUserInfoURL)
+	c.CalendarBaseURL = strings.TrimRight(firstNonEmpty(c.CalendarBaseURL, defaultGoogleCalendarBaseURL), "/")
+	return c
 }
 
 // OAuthTokens represents OAuth token data.
@@ -67,23 +93,51 @@ type GoogleEvent struct {
 	Start       GoogleEventTime `json:"start,omitempty"`
 	End         GoogleEventTime `json:"end,omitempty"`
 	Recurrence  []string        `json:"recurrence,omitempty"`
-	Reminders   struct {
-		UseDefault bool `json:"useDefault,omitempty"`
-	} `json:"reminders,omitempty"`
+	// RecurringEventID and OriginalStartTime identify an exception instance of a
+	// recurring event: its series and the start it was generated with.
+	RecurringEventID  string           `json:"recurringEventId,omitempty"`
+	OriginalStartTime *GoogleEventTime `json:"originalStartTime,omitempty"`
+	Reminders         *GoogleReminders `json:"reminders,omitempty"`
+	// ICalUID is the event's UID in iCalendar, shared by a series and its exceptions.
+	ICalUID string `json:"iCalUID,omitempty"`
 	Updated string `json:"updated,omitempty"`
 	Etag    string `json:"etag,omitempty"`
 }
 
+// GoogleReminders is an event's reminder settings. Overrides apply when UseDefault is
+// false; sent as an empty list, they clear the event's reminders.
+type GoogleReminders struct {
+	UseDefault bool       `json:"useDefault"`
+	Overrides  []Reminder `json:"overrides"`
+}
+
+// GoogleCalendarListEntry represents a subset of calendarList entry fields.
+type GoogleCalendarListEntry struct {
+	ID              string `json:"id"`
+	Summary         string `json:"summary,omitempty"`
+	SummaryOverride string `json:"summaryOverride,omitempty"`
+	BackgroundColor string `json:"backgroundColor,omitempty"`
+	TimeZone        string `json:"timeZone,omitempty"`
+	AccessRole      string `json:"accessRole,omitempty"`
+	Primary         bool   `json:"primary,omitempty"`
+	Selected        bool   `json:"selected,omitempty"`
+	Deleted         bool   `json:"deleted,omitempty"`
+	// DefaultReminders apply to the calendar's events that use the default reminders.
+	DefaultReminders []Reminder `json:"defaultReminders,omitempty"`
+}
+
 // GoogleSyncResult summarizes a sync session.
 type GoogleSyncResult struct {
-	Pulled       int    `json:"pulled"`
-	Pushed       int    `json:"pushed"`
-	Deleted      int    `json:"deleted"`
-	SyncToken    string `json:"syncToken"`
-	CalendarID   string `json:"calendarId"`
-	FullSync     bool   `json:"fullSync"`
-	Errors       int    `json:"errors"`
-	ErrorMessage string `json:"errorMessage,omitempty"`
+	AccountID    string             `json:"accountId,omitempty"`
+	Pulled       int                `json:"pulled"`
+	Pushed       int                `json:"pushed"`
+	Deleted      int                `json:"deleted"`
+	SyncToken    string             `json:"syncToken"`
+	CalendarID   string             `json:"calendarId"`
+	FullSync     bool               `json:"fullSync"`
+	Errors       int                `json:"errors"`
+	ErrorMessage string             `json:"errorMessage,omitempty"`
+	Calendars    []GoogleSyncResult `json:"calendars,omitempty"` // per-calendar breakdown of a multi-calendar sync
 }
 
 // TokenStore persists tokens locally.
@@ -133,17 +187,29 @@ type GoogleSyncService struct {
 	cfg        GoogleOAuthConfig
 	httpClient *http.Client
 	store      TokenStore
+	retry      retryPolicy
+	// sleep waits between retries; tests replace it to avoid real delays.
+	sleep func(ctx context.Context, d time.Duration) error
 }
 
 func NewGoogleSyncService(cfg GoogleOAuthConfig, store TokenStore) *GoogleSyncService {
 	client := &http.Client{Timeout: 10 * time.Second}
 	return &GoogleSyncService{
-		cfg:        cfg,
+		cfg:        cfg.withDefaults(),
 		httpClient: client,
 		store:      store,
+		retry:      defaultRetryPolicy,
 	}
 }
 
+// WithTokenStore returns a service sharing this client config and HTTP client but
+// reading and writing tokens through store (one store per connected account).
+func (g *GoogleSyncService) WithTokenStore(store TokenStore) *GoogleSyncService {
+	clone := *g
+	clone.store = store
+	return &clone
+}
+
 func (g *GoogleSyncService) HasClientConfig() bool {
 	return g.cfg.ClientID != "" && g.cfg.ClientSecret != "" && g.cfg.RedirectURI != ""
 }
@@ -167,7 +233,7 @@ func (g *GoogleSyncService) BuildAuthURL(state string) (string, error) {
 	if state != "" {
 		v.Set("state", state)
 	}
-	return "https://accounts.google.com/o/oauth2/v2/auth?" + v.Encode(), nil
+	return g.cfg.AuthURL + "?" + v.Encode(), nil
 }
 
 // ExchangeCode exchanges an auth code for tokens.
@@ -201,19 +267,19 @@ func (g *GoogleSyncService) tokenRequest(ctx context.Context, payload url.Values
 	if !g.HasClientConfig() {
 		return OAuthTokens{}, errors.New("google client config not set")
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://oauth2.googleapis.com/token", strings.NewReader(payload.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.cfg.TokenURL, strings.NewReader(payload.Encode()))
 	if err != nil {
 		return OAuthTokens{}, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := g.httpClient.Do(req)
+	resp, err := g.send(req)
 	if err != nil {
 		return OAuthTokens{}, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		return OAuthTokens{}, fmt.Errorf("token request failed: %s", resp.Status)
+		return OAuthTokens{}, newGoogleAPIError("token request", resp)
 	}
 
 	var raw struct {
@@ -235,7 +301,7 @@ func (g *GoogleSyncService) tokenRequest(ctx context.Context, payload url.Values
 		IDToken:      raw.IDToken,
 		Expiry:       time.Now().Add(time.Duration(raw.ExpiresIn) * time.Second).Format(time.RFC3339),
 	}
-	if tokens.RefreshToken == "" {
+	if tokens.RefreshToken == "" && g.store != nil {
 		// Keep existing refresh token if Google omits it on refresh.
 		if stored, err := g.store.Load(); err == nil && stored.RefreshToken != "" {
 			tokens.RefreshToken = stored.RefreshToken
@@ -278,18 +344,18 @@ func (g *GoogleSyncService) EnsureAccessToken(ctx context.Context) (OAuthTokens,
 
 // FetchUserInfo fetches profile info using the access token.
 func (g *GoogleSyncService) FetchUserInfo(ctx context.Context, accessToken string) (GoogleUserInfo, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://www.googleapis.com/oauth2/v2/userinfo", nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.cfg.UserInfoURL, nil)
 	if err != nil {
 		return GoogleUserInfo{}, err
 	}
 	req.Header.Set("Authorization", "Bearer "+accessToken)
-	resp, err := g.httpClient.Do(req)
+	resp, err := g.send(req)
 	if err != nil {
 		return GoogleUserInfo{}, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		return GoogleUserInfo{}, fmt.Errorf("userinfo failed: %s", resp.Status)
+		return GoogleUserInfo{}, newGoogleAPIError("userinfo", resp)
 	}
 	var u GoogleUserInfo
 	if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
@@ -306,6 +372,60 @@ func (g *GoogleSyncService) ClearTokens() error {
 	return g.store.Delete()
 }
 
+// Name implements CalendarProvider.
+func (g *GoogleSyncService) Name() string { return ProviderGoogle }
+
+// Capabilities implements CalendarProvider.
+func (g *GoogleSyncService) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{PrimaryAlias: true, DefaultReminders: true}
+}
+
+// ListCalendars fetches the user's calendar list.
+func (g *GoogleSyncService) ListCalendars(ctx context.Context) ([]GoogleCalendarListEntry, error) {
+	tokens, err := g.EnsureAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var entries []GoogleCalendarListEntry
+	nextPage := ""
+	for {
+		params := url.Values{}
+		params.Set("maxResults", "250")
+		if nextPage != "" {
+			params.Set("pageToken", nextPage)
+		}
+		reqURL := g.cfg.CalendarBaseURL + "/users/me/calendarList?" + params.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		resp, err := g.send(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 400 {
+			err := newGoogleAPIError("list calendars", resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var payload struct {
+			Items    []GoogleCalendarListEntry `json:"items"`
+			NextPage string                    `json:"nextPageToken"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		resp.Body.Close()
+		entries = append(entries, payload.Items...)
+		if payload.NextPage == "" {
+			return entries, nil
+		}
+		nextPage = payload.NextPage
+	}
+}
+
 // ListEvents fetches events with optional sync token.
 func (g *GoogleSyncService) ListEvents(ctx context.Context, calendarID string, syncToken string) ([]GoogleEvent, string, error) {
 	tokens, err := g.EnsureAccessToken(ctx)
@@ -320,35 +440,35 @@ func (g *GoogleSyncService) ListEvents(ctx context.Context, calendarID string, s
 		if syncToken != "" {
 			params.Set("syncToken", syncToken)
 		} else {
-			params.Set("singleEvents", "true")
+			// Series come as their masters plus exceptions, as incremental syncs return them.
 			params.Set("showDeleted", "true")
 			params.Set("maxResults", "2500")
 		}
 		if nextPage != "" {
 			params.Set("pageToken", nextPage)
 		}
-		reqURL := fmt.Sprintf("https://www.googleapis.com/calendar/v3/calendars/%s/events?%s", url.PathEscape(calendarID), params.Encode())
+		reqURL := g.eventsURL(calendarID) + "?" + params.Encode()
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 		if err != nil {
 			return nil, "", err
 		}
 		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
-		resp, err := g.httpClient.Do(req)
+		resp, err := g.send(req)
 		if err != nil {
 			return nil, "", err
 		}
 		if resp.StatusCode == http.StatusGone {
-			// syncToken expired; require full sync
+			// syncToken expired; the caller must discard it and run a full sync.
 			resp.Body.Close()
 			if fullSync {
-				return nil, "", fmt.Errorf("sync token expired")
+				return nil, "", fmt.Errorf("list events failed: %s", resp.Status)
 			}
-			return g.ListEvents(ctx, calendarID, "")
+			return nil, "", errSyncTokenExpired
 		}
 		if resp.StatusCode >= 400 {
-			body, _ := io.ReadAll(resp.Body)
+			err := newGoogleAPIError("list events", resp)
 			resp.Body.Close()
-			return nil, "", fmt.Errorf("list events failed: %s %s", resp.Status, string(body))
+			return nil, "", err
 		}
 		var payload struct {
 			Items     []GoogleEvent `json:"items"`
@@ -389,20 +509,19 @@ func (g *GoogleSyncService) ListEventsRange(ctx context.Context, calendarID stri
 		params.Set("timeMax", timeMax)
 	}
 
-	reqURL := fmt.Sprintf("https://www.googleapis.com/calendar/v3/calendars/%s/events?%s", url.PathEscape(calendarID), params.Encode())
+	reqURL := g.eventsURL(calendarID) + "?" + params.Encode()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
-	resp, err := g.httpClient.Do(req)
+	resp, err := g.send(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("list events failed: %s %s", resp.Status, string(body))
+		return nil, newGoogleAPIError("list events", resp)
 	}
 	var payload struct {
 		Items []GoogleEvent `json:"items"`
@@ -413,29 +532,83 @@ func (g *GoogleSyncService) ListEventsRange(ctx context.Context, calendarID stri
 	return payload.Items, nil
 }
 
+// GetEvent fetches a single event, e.g. to capture the remote side of a conflict.
+func (g *GoogleSyncService) GetEvent(ctx context.Context, calendarID, eventID string) (GoogleEvent, error) {
+	tokens, err := g.EnsureAccessToken(ctx)
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	reqURL := g.eventsURL(calendarID) + "/" + url.PathEscape(eventID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	resp, err := g.send(req)
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return GoogleEvent{}, errGoogleNotFound
+	}
+	if resp.StatusCode >= 400 {
+		return GoogleEvent{}, newGoogleAPIError("get event", resp)
+	}
+	var out GoogleEvent
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return GoogleEvent{}, err
+	}
+	return out, nil
+}
+
 // CreateEvent creates a Google Calendar event.
 func (g *GoogleSyncService) CreateEvent(ctx context.Context, calendarID string, ev GoogleEvent) (GoogleEvent, error) {
 	return g.writeEvent(ctx, http.MethodPost, calendarID, "", "", ev)
 }
 
+// CreateEventWithID creates an event under a client-chosen ID, so repeating the insert
+// after a lost response cannot create a second copy. If the ID is already taken by a live
+// event, that event is an earlier copy and is patched to ev instead; if it was deleted
+// since, Google picks a new ID, as it does when eventID is empty.
+func (g *GoogleSyncService) CreateEventWithID(ctx context.Context, calendarID, eventID string, ev GoogleEvent) (GoogleEvent, error) {
+	ev.ID = eventID
+	created, err := g.CreateEvent(ctx, calendarID, ev)
+	if eventID == "" || !errors.Is(err, errGoogleDuplicate) {
+		return created, err
+	}
+	existing, err := g.GetEvent(ctx, calendarID, eventID)
+	if errors.Is(err, errGoogleNotFound) || (err == nil && existing.Status == "cancelled") {
+		ev.ID = ""
+		return g.CreateEvent(ctx, calendarID, ev)
+	}
+	if err != nil {
+		return GoogleEvent{}, err
+	}
+	return g.UpdateEvent(ctx, calendarID, eventID, existing.Etag, ev)
+}
+
 // UpdateEvent updates an existing Google Calendar event with optional ETag match.
 func (g *GoogleSyncService) UpdateEvent(ctx context.Context, calendarID, eventID, etag string, ev GoogleEvent) (GoogleEvent, error) {
 	return g.writeEvent(ctx, http.MethodPatch, calendarID, eventID, etag, ev)
 }
 
-// DeleteEvent deletes an event.
-func (g *GoogleSyncService) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+// DeleteEvent deletes an event with optional ETag match.
+func (g *GoogleSyncService) DeleteEvent(ctx context.Context, calendarID, eventID, etag string) error {
 	tokens, err := g.EnsureAccessToken(ctx)
 	if err != nil {
 		return err
 	}
-	reqURL := fmt.Sprintf("https://www.googleapis.com/calendar/v3/calendars/%s/events/%s", url.PathEscape(calendarID), url.PathEscape(eventID))
+	reqURL := g.eventsURL(calendarID) + "/" + url.PathEscape(eventID)
 	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, reqURL, nil)
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
-	resp, err := g.httpClient.Do(req)
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := g.send(req)
 	if err != nil {
 		return err
 	}
@@ -443,9 +616,11 @@ func (g *GoogleSyncService) DeleteEvent(ctx context.Context, calendarID, eventID
 	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
 		return nil
 	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return errGoogleConflict
+	}
 	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("delete event failed: %s %s", resp.Status, string(body))
+		return newGoogleAPIError("delete event", resp)
 	}
 	return nil
 }
@@ -459,7 +634,7 @@ func (g *GoogleSyncService) writeEvent(ctx context.Context, method, calendarID,
 	if err != nil {
 		return GoogleEvent{}, err
 	}
-	target := fmt.Sprintf("https://www.googleapis.com/calendar/v3/calendars/%s/events", url.PathEscape(calendarID))
+	target := g.eventsURL(calendarID)
 	if eventID != "" {
 		target = target + "/" + url.PathEscape(eventID)
 	}
@@ -472,7 +647,7 @@ func (g *GoogleSyncService) writeEvent(ctx context.Context, method, calendarID,
 	if ifMatch != "" {
 		req.Header.Set("If-Match", ifMatch)
 	}
-	resp, err := g.httpClient.Do(req)
+	resp, err := g.send(req)
 	if err != nil {
 		return GoogleEvent{}, err
 	}
@@ -483,9 +658,11 @@ func (g *GoogleSyncService) writeEvent(ctx context.Context, method, calendarID,
 	if resp.StatusCode == http.StatusPreconditionFailed {
 		return GoogleEvent{}, errGoogleConflict
 	}
+	if resp.StatusCode == http.StatusConflict {
+		return GoogleEvent{}, errGoogleDuplicate
+	}
 	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return GoogleEvent{}, fmt.Errorf("write event failed: %s %s", resp.Status, string(body))
+		return GoogleEvent{}, newGoogleAPIError("write event", resp)
 	}
 	var out GoogleEvent
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
@@ -494,6 +671,11 @@ func (g *GoogleSyncService) writeEvent(ctx context.Context, method, calendarID,
 	return out, nil
 }
 
+// eventsURL returns the events collection URL of a calendar.
+func (g *GoogleSyncService) eventsURL(calendarID string) string {
+	return fmt.Sprintf("%s/calendars/%s/events", g.cfg.CalendarBaseURL, url.PathEscape(calendarID))
+}
+
 func isExpired(expiry string) (bool, error) {
 	if expiry == "" {
 		return false, nil
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"myapp/internal/fakegcal"
+)
+
+func newFakeGoogleService(t *testing.T, srv *fakegcal.Server) *GoogleSyncService {
+	t.Helper()
+	store := &FileTokenStore{path: t.TempDir() + "/tokens.json"}
+	return NewGoogleSyncService(GoogleOAuthConfig{
+		ClientID:        "id",
+		ClientSecret:    "secret",
+		RedirectURI:     "http://localhost/cb",
+		Scopes:          []string{"openid"},
+		AuthURL:         srv.AuthURL(),
+		TokenURL:        srv.TokenURL(),
+		UserInfoURL:     srv.UserInfoURL(),
+		CalendarBaseURL: srv.CalendarBaseURL(),
+	}, store)
+}
+
+func TestGoogleSyncServiceUsesConfiguredEndpoints(t *testing.T) {
+	srv := fakegcal.New()
+	defer srv.Close()
+	svc := newFakeGoogleService(t, srv)
+	ctx := context.Background()
+
+	authURL, err := svc.BuildAuthURL("state")
+	if err != nil || !strings.HasPrefix(authURL, srv.AuthURL()+"?") {
+		t.Fatalf("auth url = %q, %v", authURL, err)
+	}
+	tokens, err := svc.ExchangeCode(ctx, "code")
+	if err != nil || tokens.AccessToken != fakegcal.AccessToken || tokens.RefreshToken == "" {
+		t.Fatalf("exchange: %+v, %v", tokens, err)
+	}
+	user, err := svc.FetchUserInfo(ctx, tokens.AccessToken)
+	if err != nil || user.Email != srv.User.Email {
+		t.Fatalf("userinfo: %+v, %v", user, err)
+	}
+	calendars, err := svc.ListCalendars(ctx)
+	if err != nil || len(calendars) != 1 || calendars[0].ID != fakegcal.PrimaryCalendarID || !calendars[0].Primary {
+		t.Fatalf("calendars: %+v, %v", calendars, err)
+	}
+}
+
+func TestGoogleSyncServiceAgainstFakeServer(t *testing.T) {
+	srv := fakegcal.New()
+	defer srv.Close()
+	svc := newFakeGoogleService(t, srv)
+	ctx := context.Background()
+	if _, err := svc.ExchangeCode(ctx, "code"); err != nil {
+		t.Fatal(err)
+	}
+	cal := fakegcal.PrimaryCalendarID
+
+	created, err := svc.CreateEvent(ctx, cal, GoogleEvent{Summary: "lunch", Start: GoogleEventTime{DateTime: "2024-05-01T12:00:00Z"}, End: GoogleEventTime{DateTime: "2024-05-01T13:00:00Z"}})
+	if err != nil || created.ID == "" || created.Etag == "" {
+		t.Fatalf("create: %+v, %v", created, err)
+	}
+	events, token, err := svc.ListEvents(ctx, cal, "")
+	if err != nil || len(events) != 1 || token == "" {
+		t.Fatalf("full list: %d events, token %q, %v", len(events), token, err)
+	}
+
+	// Someone edits the event in Google; our etag is now stale.
+	srv.PutEvent(cal, fakegcal.Event{"id": created.ID, "summary": "lunch (moved)"})
+	if _, err := svc.UpdateEvent(ctx, cal, created.ID, created.Etag, GoogleEvent{Summary: "mine"}); !errors.Is(err, errGoogleConflict) {
+		t.Fatalf("stale update: %v, want conflict", err)
+	}
+	events, token, err = svc.ListEvents(ctx, cal, token)
+	if err != nil || len(events) != 1 || events[0].Summary != "lunch (moved)" {
+		t.Fatalf("incremental: %+v, %v", events, err)
+	}
+
+	if err := svc.DeleteEvent(ctx, cal, created.ID, ""); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	events, _, err = svc.ListEvents(ctx, cal, token)
+	if err != nil || len(events) != 1 || events[0].Status != "cancelled" {
+		t.Fatalf("incremental after delete: %+v, %v", events, err)
+	}
+	if _, err := svc.UpdateEvent(ctx, cal, created.ID, "", GoogleEvent{Summary: "again"}); !errors.Is(err, errGoogleNotFound) {
+		t.Fatalf("update deleted: %v, want not found", err)
+	}
+
+	srv.ExpireSyncTokens()
+	if _, _, err := svc.ListEvents(ctx, cal, token); !errors.Is(err, errSyncTokenExpired) {
+		t.Fatalf("expired token: %v, want errSyncTokenExpired", err)
+	}
+}
+
+func TestCreateEventWithIDIsIdempotent(t *testing.T) {
+	srv := fakegcal.New()
+	defer srv.Close()
+	svc := newFakeGoogleService(t, srv)
+	ctx := context.Background()
+	if _, err := svc.ExchangeCode(ctx, "code"); err != nil {
+		t.Fatal(err)
+	}
+	cal := fakegcal.PrimaryCalendarID
+	id := googleEventIDFor("evt-1")
+
+	first, err := svc.CreateEventWithID(ctx, cal, id, GoogleEvent{Summary: "v1"})
+	if err != nil || first.ID != id {
+		t.Fatalf("create: %+v, %v", first, err)
+	}
+	again, err := svc.CreateEventWithID(ctx, cal, id, GoogleEvent{Summary: "v2"})
+	if err != nil || again.ID != id || again.Summary != "v2" {
+		t.Fatalf("repeated create: %+v, %v", again, err)
+	}
+	if events := srv.Events(cal); len(events) != 1 {
+		t.Fatalf("%d remote events, want 1", len(events))
+	}
+
+	srv.RemoveEvent(cal, id)
+	fresh, err := svc.CreateEventWithID(ctx, cal, id, GoogleEvent{Summary: "v3"})
+	if err != nil || fresh.ID == "" || fresh.ID == id {
+		t.Fatalf("create over deleted id: %+v, %v", fresh, err)
+	}
+}
+
+func TestGoogleEventIDFor(t *testing.T) {
+	for _, local := range []string{"evt-1715000000000000000", "google-abc@x", "한글"} {
+		id := googleEventIDFor(local)
+		if len(id) < 5 || strings.Trim(id, "0123456789abcdefghijklmnopqrstuv") != "" {
+			t.Fatalf("%q -> %q is not a valid Google event ID", local, id)
+		}
+		if back, ok := localIDForGoogleEvent(id); !ok || back != local {
+			t.Fatalf("%q -> %q -> %q, %v", local, id, back, ok)
+		}
+	}
+	if id := googleEventIDFor("a"); id != "" {
+		t.Fatalf("short local ID -> %q, want empty", id)
+	}
+	if _, ok := localIDForGoogleEvent("not base32hex!"); ok {
+		t.Fatal("decoded an invalid ID")
+	}
+}
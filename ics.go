@@ -0,0 +1,456 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// localCalendarID selects, in ExportICS, the events that are not on a Google calendar.
+const localCalendarID = "local"
+
+// icsProductID identifies this app in exported calendars.
+const icsProductID = "-//calendar widget//Calendar Widget//EN"
+
+// ExportICS returns the events overlapping [start, end) as an iCalendar (RFC 5545)
+// document. start and end are RFC3339 or YYYY-MM-DD; leaving both empty exports every
+// event. calendarIDs limits the export to those Google calendars, with "local" for
+// events kept only here; none means all calendars.
+func (a *App) ExportICS(start, end string, calendarIDs []string) (string, error) {
+	if a.db == nil {
+		return "", errors.New("db not initialised")
+	}
+	from := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	if strings.TrimSpace(start) != "" {
+		t, err := parseWindowTime(start)
+		if err != nil {
+			return "", fmt.Errorf("invalid start: %w", err)
+		}
+		from = t
+	}
+	if strings.TrimSpace(end) != "" {
+		t, err := parseWindowTime(end)
+		if err != nil {
+			return "", fmt.Errorf("invalid end: %w", err)
+		}
+		to = t
+	}
+	if !to.After(from) {
+		return "", errors.New("end must be after start")
+	}
+	var b strings.Builder
+	if err := a.writeICS(&b, from, to, calendarIDs, time.Now()); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// writeICS writes the events overlapping [from, to) in calendarIDs to w as a VCALENDAR,
+// stamped with now.
+func (a *App) writeICS(w io.Writer, from, to time.Time, calendarIDs []string, now time.Time) error {
+	events, err := a.ListEvents()
+	if err != nil {
+		return err
+	}
+
+	// Series go out whole when any of their occurrences, or rows overriding one, falls
+	// in the window; rows overriding an occurrence go out with their series.
+	byID := make(map[string]CalendarEvent, len(events))
+	for _, e := range events {
+		byID[e.ID] = e
+	}
+	included := make(map[string]bool)
+	for _, e := range events {
+		series := e
+		if master, ok := byID[e.RecurringEventID]; ok {
+			series = master
+		}
+		if len(calendarIDs) > 0 && !slices.Contains(calendarIDs, icsCalendarID(series)) {
+			continue
+		}
+		occurrences, err := expandEvent(e, from, to)
+		if err != nil {
+			occurrences, _ = expandEvent(withoutRecurrence(e), from, to)
+		}
+		if len(occurrences) > 0 {
+			included[series.ID] = true
+		}
+	}
+
+	var out []CalendarEvent
+	for _, e := range events {
+		series := e.ID
+		if _, ok := byID[e.RecurringEventID]; ok {
+			series = e.RecurringEventID
+		}
+		if included[series] {
+			out = append(out, e)
+		}
+	}
+	return a.writeVCalendar(w, out, byID, now)
+}
+
+// writeVCalendar writes events as a VCALENDAR with the time zones they use, stamped with
+// now. byID holds the series of the rows overriding an occurrence.
+func (a *App) writeVCalendar(w io.Writer, events []CalendarEvent, byID map[string]CalendarEvent, now time.Time) error {
+	cancelled, err := a.cancelledOccurrences()
+	if err != nil {
+		return err
+	}
+	emails, err := a.accountEmails()
+	if err != nil {
+		return err
+	}
+	zones := make(map[string][2]time.Time)
+	for _, e := range events {
+		startTime, endTime, err := parseEventTimes(e)
+		if err != nil {
+			return fmt.Errorf("event %s: %w", e.ID, err)
+		}
+		if zone := icsTimeZone(e); zone != "" {
+			span, ok := zones[zone]
+			if !ok || startTime.Before(span[0]) {
+				span[0] = startTime
+			}
+			if !ok || endTime.After(span[1]) {
+				span[1] = endTime
+			}
+			zones[zone] = span
+		}
+	}
+
+	iw := &icsWriter{w: w}
+	iw.line("BEGIN:VCALENDAR")
+	iw.line("VERSION:2.0")
+	iw.line("PRODID:" + icsProductID)
+	iw.line("CALSCALE:GREGORIAN")
+	names := make([]string, 0, len(zones))
+	for zone := range zones {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+	for _, zone := range names {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			continue
+		}
+		span := zones[zone]
+		// Cover every year the events touch and the next one, through the current year.
+		last := max(span[1].Year(), now.Year()) + 1
+		writeVTimezone(iw, loc, time.Date(span[0].Year(), 1, 1, 0, 0, 0, 0, loc), time.Date(last+1, 1, 1, 0, 0, 0, 0, loc))
+	}
+	defaults := make(map[string][]Reminder)
+	for _, e := range events {
+		var master *CalendarEvent
+		if m, ok := byID[e.RecurringEventID]; ok {
+			master = &m
+		}
+		reminders := e.Reminders
+		if e.UseDefaultReminders {
+			key := e.AccountID + "\x00" + e.GoogleCalendarID
+			if _, ok := defaults[key]; !ok {
+				if defaults[key], err = a.calendarDefaultReminders(e.AccountID, e.GoogleCalendarID); err != nil {
+					return err
+				}
+			}
+			reminders = defaults[key]
+		}
+		if err := writeVEvent(iw, e, master, cancelled[e.ID], reminders, emails[e.AccountID], now); err != nil {
+			return err
+		}
+	}
+	iw.line("END:VCALENDAR")
+	return iw.err
+}
+
+// icsCalendarID returns the calendar e is exported under.
+func icsCalendarID(e CalendarEvent) string {
+	return firstNonEmpty(e.GoogleCalendarID, localCalendarID)
+}
+
+// icsTimeZone returns the zone e's times are written in, or "" for UTC and all-day events.
+func icsTimeZone(e CalendarEvent) string {
+	if e.AllDay || e.Recurrence == "allday" || e.TimeZone == "" || e.TimeZone == "UTC" {
+		return ""
+	}
+	if _, err := time.LoadLocation(e.TimeZone); err != nil {
+		return ""
+	}
+	return e.TimeZone
+}
+
+// icalUID returns the UID an event is exported under. Imported events keep the UID
+// they came with and Google's events the one Google gives them.
+func icalUID(e CalendarEvent) string {
+	if e.ICalUID != "" {
+		return e.ICalUID
+	}
+	if e.GoogleEventID != "" {
+		return e.GoogleEventID + "@google.com"
+	}
+	return e.ID + "@calendar-widget"
+}
+
+// cancelledOccurrences maps series row IDs to the original starts of their occurrences
+// cancelled in Google, which are exported as EXDATEs.
+func (a *App) cancelledOccurrences() (map[string][]time.Time, error) {
+	rows, err := a.db.Query(`SELECT recurring_event_id, original_start FROM events WHERE sync_status = 'cancelled' AND COALESCE(recurring_event_id,'') != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string][]time.Time)
+	for rows.Next() {
+		var master, original string
+		if err := rows.Scan(&master, &original); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(time.RFC3339, original)
+		if err != nil {
+			continue
+		}
+		out[master] = append(out[master], t)
+	}
+	return out, rows.Err()
+}
+
+// accountEmails maps account IDs to their addresses, which email alarms are sent to.
+func (a *App) accountEmails() (map[string]string, error) {
+	accounts, err := a.loadAccounts()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(accounts))
+	for _, acc := range accounts {
+		out[acc.ID] = acc.Email
+	}
+	return out, nil
+}
+
+// writeVEvent writes e as a VEVENT. An event overriding an occurrence of master is
+// written with master's UID and the occurrence as its RECURRENCE-ID.
+func writeVEvent(w *icsWriter, e CalendarEvent, master *CalendarEvent, cancelled []time.Time, reminders []Reminder, email string, now time.Time) error {
+	startTime, endTime, err := parseEventTimes(e)
+	if err != nil {
+		return fmt.Errorf("event %s: %w", e.ID, err)
+	}
+	allDay := e.AllDay || e.Recurrence == "allday"
+	zone := icsTimeZone(e)
+	w.line("BEGIN:VEVENT")
+	uid := icalUID(e)
+	if master != nil {
+		uid = icalUID(*master)
+	}
+	w.line("UID:" + uid)
+	w.line("DTSTAMP:" + now.UTC().Format("20060102T150405Z"))
+	w.line("DTSTART" + icsTimeValue(startTime, allDay, zone))
+	w.line("DTEND" + icsTimeValue(endTime, allDay, zone))
+	if master != nil {
+		if original, err := time.Parse(time.RFC3339, e.OriginalStart); err == nil {
+			masterAllDay := master.AllDay || master.Recurrence == "allday"
+			w.line("RECURRENCE-ID" + icsTimeValue(original, masterAllDay, icsTimeZone(*master)))
+		}
+	}
+	w.line("SUMMARY:" + icsText(e.Title))
+	if e.Description != "" {
+		w.line("DESCRIPTION:" + icsText(e.Description))
+	}
+	if e.Location != "" {
+		w.line("LOCATION:" + icsText(e.Location))
+	}
+	if master == nil {
+		for _, line := range recurrenceLines(e) {
+			w.line(line)
+		}
+		for _, t := range cancelled {
+			w.line("EXDATE" + icsTimeValue(t, allDay, zone))
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, e.CreatedAt); err == nil {
+		w.line("CREATED:" + t.UTC().Format("20060102T150405Z"))
+	}
+	if t, err := time.Parse(time.RFC3339, e.UpdatedAt); err == nil {
+		w.line("LAST-MODIFIED:" + t.UTC().Format("20060102T150405Z"))
+	}
+	for _, r := range reminders {
+		switch {
+		case r.Method == ReminderEmail:
+			w.line("BEGIN:VALARM")
+			w.line("ACTION:EMAIL")
+			w.line("SUMMARY:" + icsText(e.Title))
+			w.line("DESCRIPTION:" + icsText(e.Title))
+			// Events kept only here have no account to send to; the alarm is still
+			// written so a backup keeps it.
+			if email != "" {
+				w.line("ATTENDEE:mailto:" + email)
+			}
+		case r.Method == ReminderPopup:
+			w.line("BEGIN:VALARM")
+			w.line("ACTION:DISPLAY")
+			w.line("DESCRIPTION:" + icsText(e.Title))
+		default:
+			continue
+		}
+		w.line("TRIGGER:" + icsTrigger(r.Minutes))
+		w.line("END:VALARM")
+	}
+	w.line("END:VEVENT")
+	return nil
+}
+
+// icsTimeValue formats t as the parameters and value of a DATE or DATE-TIME property:
+// a date for all-day events, local time with TZID in zone, or UTC.
+func icsTimeValue(t time.Time, allDay bool, zone string) string {
+	if allDay {
+		return ";VALUE=DATE:" + t.Format("20060102")
+	}
+	if zone != "" {
+		if loc, err := time.LoadLocation(zone); err == nil {
+			return ";TZID=" + zone + ":" + t.In(loc).Format("20060102T150405")
+		}
+	}
+	return ":" + t.UTC().Format("20060102T150405Z")
+}
+
+// icsTrigger formats a reminder's lead as a negative DURATION.
+func icsTrigger(minutes int) string {
+	if minutes == 0 {
+		return "PT0M"
+	}
+	var b strings.Builder
+	b.WriteString("-P")
+	if days := minutes / (24 * 60); days > 0 && minutes%(24*60) == 0 {
+		fmt.Fprintf(&b, "%dD", days)
+		return b.String()
+	}
+	b.WriteString("T")
+	if h := minutes / 60; h > 0 {
+		fmt.Fprintf(&b, "%dH", h)
+	}
+	if m := minutes % 60; m > 0 {
+		fmt.Fprintf(&b, "%dM", m)
+	}
+	return b.String()
+}
+
+var icsTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// icsText escapes a TEXT property value.
+func icsText(v string) string {
+	return icsTextEscaper.Replace(v)
+}
+
+// writeVTimezone writes a VTIMEZONE for loc with one observance per offset change
+// between from and to, starting with the offset in effect at from.
+func writeVTimezone(w *icsWriter, loc *time.Location, from, to time.Time) {
+	w.line("BEGIN:VTIMEZONE")
+	w.line("TZID:" + loc.String())
+	name, offset := from.Zone()
+	writeObservance(w, from, from.IsDST(), name, offset, offset)
+	for t := from; ; {
+		next, ok := nextZoneChange(t, to)
+		if !ok {
+			break
+		}
+		_, prev := t.Zone()
+		name, offset := next.Zone()
+		writeObservance(w, next, next.IsDST(), name, prev, offset)
+		t = next
+	}
+	w.line("END:VTIMEZONE")
+}
+
+// writeObservance writes a STANDARD or DAYLIGHT observance starting at onset, whose
+// DTSTART is the local time in the offset it changes from.
+func writeObservance(w *icsWriter, onset time.Time, dst bool, name string, from, to int) {
+	kind := "STANDARD"
+	if dst {
+		kind = "DAYLIGHT"
+	}
+	w.line("BEGIN:" + kind)
+	w.line("DTSTART:" + onset.UTC().Add(time.Duration(from)*time.Second).Format("20060102T150405"))
+	w.line("TZOFFSETFROM:" + icsOffset(from))
+	w.line("TZOFFSETTO:" + icsOffset(to))
+	if name != "" {
+		w.line("TZNAME:" + name)
+	}
+	w.line("END:" + kind)
+}
+
+// nextZoneChange returns the first instant after t, before limit, at which the UTC
+// offset of t's location changes.
+func nextZoneChange(t, limit time.Time) (time.Time, bool) {
+	_, offset := t.Zone()
+	for day := t; day.Before(limit); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		if _, o := next.Zone(); o == offset {
+			continue
+		}
+		lo, hi := day, next
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2).Truncate(time.Second)
+			if _, o := mid.Zone(); o == offset {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		return hi, hi.Before(limit)
+	}
+	return time.Time{}, false
+}
+
+// icsOffset formats a UTC offset in seconds as a UTC-OFFSET value, e.g. -0500.
+func icsOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	s := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+	if seconds%60 != 0 {
+		s += fmt.Sprintf("%02d", seconds%60)
+	}
+	return s
+}
+
+// icsWriter writes content lines, folded at 75 octets and ended with CRLF. It keeps
+// the first write error.
+type icsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *icsWriter) line(s string) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = io.WriteString(w.w, foldICSLine(s))
+}
+
+// foldICSLine folds a content line into lines of at most 75 octets, never inside a
+// UTF-8 sequence, and ends it with CRLF.
+func foldICSLine(s string) string {
+	var b strings.Builder
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		// The leading space counts towards the next line.
+		limit = 74
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+	return b.String()
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outcomes of importing one event, as reported by ImportICS.
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+	ImportDeleted   = "deleted"
+	ImportSkipped   = "skipped"
+	ImportFailed    = "failed"
+)
+
+// ICSImportResult reports what importing one VEVENT did.
+type ICSImportResult struct {
+	UID   string `json:"uid"`
+	Title string `json:"title"`
+	// RecurrenceID is set for an event changing one occurrence of a recurring event.
+	RecurrenceID string `json:"recurrenceId,omitempty"`
+	Status       string `json:"status"`
+	EventID      string `json:"eventId,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// ImportICS imports the events of the iCalendar file at path. Events are matched by UID,
+// so importing a file again updates the events it created before; events exported from
+// here or from Google update the events they came from. New events are stored as local
+// and pushed to Google with the next sync.
+func (a *App) ImportICS(path string) ([]ICSImportResult, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	results, err := a.importICS(f)
+	if err != nil {
+		return nil, err
+	}
+	a.reminders.reschedule()
+	return results, nil
+}
+
+// importICS imports the events read from r, returning one result per VEVENT in the
+// order they appear.
+func (a *App) importICS(r io.Reader) ([]ICSImportResult, error) {
+	root, err := parseICS(r)
+	if err != nil {
+		return nil, err
+	}
+	var vevents []*icsComponent
+	for _, cal := range root.components {
+		if cal.name != "VCALENDAR" {
+			continue
+		}
+		for _, c := range cal.components {
+			if c.name == "VEVENT" {
+				vevents = append(vevents, c)
+			}
+		}
+	}
+	if len(vevents) == 0 {
+		return nil, errors.New("no events in iCalendar file")
+	}
+	// Series are imported before the events changing their occurrences.
+	order := make([]int, len(vevents))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		_, oi := vevents[order[i]].prop("RECURRENCE-ID")
+		_, oj := vevents[order[j]].prop("RECURRENCE-ID")
+		return !oi && oj
+	})
+	results := make([]ICSImportResult, len(vevents))
+	for _, i := range order {
+		results[i] = a.importVEvent(vevents[i])
+	}
+	return results, nil
+}
+
+// importVEvent imports one VEVENT.
+func (a *App) importVEvent(c *icsComponent) ICSImportResult {
+	res := ICSImportResult{UID: c.value("UID"), Title: c.text("SUMMARY")}
+	ev, err := parseVEvent(c)
+	if err == nil && !ev.recurrenceID.IsZero() {
+		res.RecurrenceID = originalStartKey(ev.recurrenceID)
+		res.Status, res.EventID, err = a.importOccurrence(ev)
+	} else if err == nil {
+		res.Status, res.EventID, err = a.importEvent(ev)
+	}
+	if err != nil {
+		res.Status, res.Error = ImportFailed, err.Error()
+	}
+	return res
+}
+
+// importedEvent is a VEVENT read as an event.
+type importedEvent struct {
+	CalendarEvent
+	uid string
+	// recurrenceID is the original start of the occurrence the event changes.
+	recurrenceID time.Time
+	cancelled    bool
+	// hasAlarms tells Reminders, possibly empty, from a VEVENT saying nothing about them.
+	hasAlarms bool
+}
+
+// importEvent creates or updates the event ev stands for.
+func (a *App) importEvent(ev importedEvent) (string, string, error) {
+	existing, found, err := a.eventByICalUID(ev.uid)
+	if err != nil {
+		return "", "", err
+	}
+	if ev.cancelled {
+		if !found {
+			return ImportSkipped, "", nil
+		}
+		return ImportDeleted, existing.ID, a.DeleteEvent(existing.ID)
+	}
+	if !found {
+		e := ev.CalendarEvent
+		e.ICalUID = ev.uid
+		e.SyncStatus = "local"
+		created, err := a.CreateEvent(e)
+		return ImportCreated, created.ID, err
+	}
+	merged := mergeImported(existing, ev)
+	if sameImportedContent(existing, merged) {
+		return ImportUnchanged, existing.ID, nil
+	}
+	updated, err := a.UpdateEvent(merged)
+	return ImportUpdated, updated.ID, err
+}
+
+// importOccurrence applies ev to the occurrence of its series that it changes.
+func (a *App) importOccurrence(ev importedEvent) (string, string, error) {
+	master, found, err := a.eventByICalUID(ev.uid)
+	if err != nil {
+		return "", "", err
+	}
+	if !found {
+		return "", "", errors.New("the recurring event it changes is not in the calendar")
+	}
+	original := originalStartKey(ev.recurrenceID)
+	var existingID string
+	if err := a.db.QueryRow(`SELECT id FROM events WHERE recurring_event_id = ? AND original_start = ? AND sync_status NOT IN ('deleted','cancelled')`, master.ID, original).Scan(&existingID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", "", err
+	}
+	if ev.cancelled {
+		err := a.DeleteOccurrence(master.ID, original, OccurrenceThis)
+		if errors.Is(err, errNoOccurrence) {
+			// Cancelled already.
+			return ImportUnchanged, master.ID, nil
+		}
+		return ImportDeleted, master.ID, err
+	}
+	base, status := master, ImportCreated
+	if existingID != "" {
+		existing, err := a.getEvent(existingID)
+		if err != nil {
+			return "", "", err
+		}
+		base, status = existing, ImportUpdated
+	}
+	merged := mergeImported(base, ev)
+	merged.Recurrence, merged.RecurrenceEx, merged.ICalUID = "none", "", ""
+	if existingID != "" && sameImportedContent(base, merged) {
+		return ImportUnchanged, existingID, nil
+	}
+	e, err := a.UpdateOccurrence(master.ID, original, OccurrenceThis, merged)
+	return status, e.ID, err
+}
+
+// eventByICalUID looks up the series or single event with the given UID, whether it was
+// imported with it or exported under it.
+func (a *App) eventByICalUID(uid string) (CalendarEvent, bool, error) {
+	if uid == "" {
+		return CalendarEvent{}, false, nil
+	}
+	localID, _ := strings.CutSuffix(uid, "@calendar-widget")
+	googleID, _ := strings.CutSuffix(uid, "@google.com")
+	var id string
+	err := a.db.QueryRow(`
+		SELECT id FROM events
+		WHERE COALESCE(recurring_event_id,'') = '' AND sync_status NOT IN ('deleted','cancelled','subscribed')
+			AND (ical_uid = ? OR id = ? OR remote_id = ?)
+		ORDER BY ical_uid = ? DESC LIMIT 1`, uid, localID, googleID, uid).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CalendarEvent{}, false, nil
+	}
+	if err != nil {
+		return CalendarEvent{}, false, err
+	}
+	e, err := a.getEvent(id)
+	return e, err == nil, err
+}
+
+// mergeImported returns base with the fields an iCalendar event carries replaced by
+// those of ev. Reminders are kept when ev has no alarms.
+func mergeImported(base CalendarEvent, ev importedEvent) CalendarEvent {
+	e := base
+	e.Title, e.Description, e.Location = ev.Title, ev.Description, ev.Location
+	e.AllDay, e.Start, e.End, e.TimeZone = ev.AllDay, ev.Start, ev.End, ev.TimeZone
+	e.Recurrence, e.RecurrenceEx = ev.Recurrence, ev.RecurrenceEx
+	if ev.hasAlarms {
+		e.Reminders, e.UseDefaultReminders = ev.Reminders, false
+		e.Alert, e.AlertOffset = alertForReminders(e.Reminders)
+	}
+	return e
+}
+
+// sameImportedContent reports whether a and b agree on what an iCalendar event carries.
+func sameImportedContent(a, b CalendarEvent) bool {
+	as, ae, aerr := parseEventTimes(a)
+	bs, be, berr := parseEventTimes(b)
+	return aerr == nil && berr == nil && as.Equal(bs) && ae.Equal(be) &&
+		a.Title == b.Title && a.Description == b.Description && a.Location == b.Location &&
+		a.AllDay == b.AllDay && a.TimeZone == b.TimeZone &&
+		a.Recurrence == b.Recurrence && a.RecurrenceEx == b.RecurrenceEx &&
+		a.UseDefaultReminders == b.UseDefaultReminders && slices.Equal(a.Reminders, b.Reminders)
+}
+
+// parseVEvent reads a VEVENT as an event.
+func parseVEvent(c *icsComponent) (importedEvent, error) {
+	ev := importedEvent{uid: c.value("UID")}
+	ev.Title = c.text("SUMMARY")
+	ev.Description = c.text("DESCRIPTION")
+	ev.Location = c.text("LOCATION")
+	ev.Alert = "none"
+	ev.Recurrence = "none"
+	ev.cancelled = strings.EqualFold(c.value("STATUS"), "CANCELLED")
+
+	dtstart, ok := c.prop("DTSTART")
+	if !ok {
+		return importedEvent{}, errors.New("missing DTSTART")
+	}
+	start, allDay, zone, err := icsPropTime(dtstart)
+	if err != nil {
+		return importedEvent{}, fmt.Errorf("invalid DTSTART: %w", err)
+	}
+	var end time.Time
+	if dtend, ok := c.prop("DTEND"); ok {
+		if end, _, _, err = icsPropTime(dtend); err != nil {
+			return importedEvent{}, fmt.Errorf("invalid DTEND: %w", err)
+		}
+	} else if dur, ok := c.prop("DURATION"); ok {
+		d, err := parseICSDuration(dur.value)
+		if err != nil {
+			return importedEvent{}, fmt.Errorf("invalid DURATION: %w", err)
+		}
+		end = start.Add(d)
+	} else if allDay {
+		end = start.AddDate(0, 0, 1)
+	} else {
+		end = start
+	}
+	if end.Before(start) {
+		return importedEvent{}, errors.New("DTEND is before DTSTART")
+	}
+	ev.AllDay = allDay
+	ev.TimeZone = zone
+	if allDay {
+		ev.Start, ev.End = start.Format("2006-01-02"), end.Format("2006-01-02")
+	} else {
+		ev.Start, ev.End = start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339)
+	}
+
+	if p, ok := c.prop("RECURRENCE-ID"); ok {
+		if ev.recurrenceID, _, _, err = icsPropTime(p); err != nil {
+			return importedEvent{}, fmt.Errorf("invalid RECURRENCE-ID: %w", err)
+		}
+	}
+
+	var lines []string
+	for _, p := range c.props {
+		if p.name == "RRULE" || p.name == "RDATE" || p.name == "EXDATE" {
+			lines = append(lines, p.raw)
+		}
+	}
+	if hasRule(lines) {
+		loc := start.Location()
+		if allDay {
+			loc = time.UTC
+		}
+		if _, err := parseRecurrence(lines, loc); err != nil {
+			return importedEvent{}, err
+		}
+		ev.Recurrence, ev.RecurrenceEx = "rrule", strings.Join(lines, "\n")
+	}
+
+	ev.Reminders = []Reminder{}
+	for _, alarm := range c.components {
+		if alarm.name != "VALARM" {
+			continue
+		}
+		ev.hasAlarms = true
+		if r, ok := parseVAlarm(alarm, start, end); ok {
+			ev.Reminders = append(ev.Reminders, r)
+		}
+	}
+	ev.Reminders = sortReminders(ev.Reminders)
+	ev.Alert, ev.AlertOffset = alertForReminders(ev.Reminders)
+	return ev, nil
+}
+
+// parseVAlarm reads a VALARM of an event running from start to end as a reminder. Alarms
+// going off after the start and those of unknown actions are left out.
+func parseVAlarm(c *icsComponent, start, end time.Time) (Reminder, bool) {
+	var r Reminder
+	switch strings.ToUpper(c.value("ACTION")) {
+	case "DISPLAY", "AUDIO":
+		r.Method = ReminderPopup
+	case "EMAIL":
+		r.Method = ReminderEmail
+	default:
+		return Reminder{}, false
+	}
+	trigger, ok := c.prop("TRIGGER")
+	if !ok {
+		return Reminder{}, false
+	}
+	var at time.Time
+	if strings.EqualFold(trigger.params["VALUE"], "DATE-TIME") {
+		t, _, err := parseICalTime(trigger.value, time.UTC)
+		if err != nil {
+			return Reminder{}, false
+		}
+		at = t
+	} else {
+		d, err := parseICSDuration(trigger.value)
+		if err != nil {
+			return Reminder{}, false
+		}
+		at = start.Add(d)
+		if strings.EqualFold(trigger.params["RELATED"], "END") {
+			at = end.Add(d)
+		}
+	}
+	lead := start.Sub(at)
+	if lead < 0 {
+		return Reminder{}, false
+	}
+	r.Minutes = int(lead.Round(time.Minute) / time.Minute)
+	return r, true
+}
+
+// icsPropTime reads a DATE or DATE-TIME property. zone is its TZID, or UTC for UTC and
+// floating times and dates; floating times are read in local time.
+func icsPropTime(p icsProperty) (t time.Time, dateOnly bool, zone string, err error) {
+	loc, zone := time.Local, "UTC"
+	if tzid := p.params["TZID"]; tzid != "" {
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, false, "", fmt.Errorf("unknown time zone %s", tzid)
+		}
+		zone = tzid
+	}
+	if strings.EqualFold(p.params["VALUE"], "DATE") || len(strings.TrimSpace(p.value)) == len("20060102") {
+		t, _, err = parseICalTime(p.value, time.UTC)
+		return t, true, "UTC", err
+	}
+	t, _, err = parseICalTime(p.value, loc)
+	return t, false, zone, err
+}
+
+// parseICSDuration parses a DURATION value such as -PT15M, P1D or P1W.
+func parseICSDuration(v string) (time.Duration, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	sign := time.Duration(1)
+	if rest, ok := strings.CutPrefix(v, "-"); ok {
+		sign, v = -1, rest
+	} else {
+		v = strings.TrimPrefix(v, "+")
+	}
+	rest, ok := strings.CutPrefix(v, "P")
+	if !ok || rest == "" {
+		return 0, fmt.Errorf("invalid duration %q", v)
+	}
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+	var d time.Duration
+	inTime := false
+	for rest != "" {
+		if rest[0] == 'T' {
+			inTime, rest = true, rest[1:]
+			continue
+		}
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		if i == 0 || i == len(rest) {
+			return 0, fmt.Errorf("invalid duration %q", v)
+		}
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", v)
+		}
+		unit := rest[i]
+		// M means minutes only after T; weeks and days only come before it.
+		if unit == 'M' && !inTime || (unit == 'W' || unit == 'D') && inTime || units[unit] == 0 {
+			return 0, fmt.Errorf("invalid duration %q", v)
+		}
+		d += time.Duration(n) * units[unit]
+		rest = rest[i+1:]
+	}
+	return sign * d, nil
+}
+
+// icsProperty is one content line of an iCalendar file.
+type icsProperty struct {
+	// name and the parameter names are upper-cased; parameter values are unquoted.
+	name   string
+	params map[string]string
+	value  string
+	// raw is the line as written, after unfolding, e.g. "EXDATE;TZID=Asia/Seoul:20240501T090000".
+	raw string
+}
+
+// icsComponent is a BEGIN/END block of an iCalendar file.
+type icsComponent struct {
+	name       string
+	props      []icsProperty
+	components []*icsComponent
+}
+
+// prop returns the first property called name.
+func (c *icsComponent) prop(name string) (icsProperty, bool) {
+	for _, p := range c.props {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return icsProperty{}, false
+}
+
+// value returns the raw value of the property called name, or "".
+func (c *icsComponent) value(name string) string {
+	p, _ := c.prop(name)
+	return strings.TrimSpace(p.value)
+}
+
+// text returns the unescaped TEXT value of the property called name, or "".
+func (c *icsComponent) text(name string) string {
+	p, _ := c.prop(name)
+	return icsUnescape(p.value)
+}
+
+// parseICS reads an iCalendar stream into a root component holding its VCALENDARs.
+func parseICS(r io.Reader) (*icsComponent, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	// Unfold: a line starting with a space or tab continues the previous one.
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	root := &icsComponent{}
+	stack := []*icsComponent{root}
+	for n, line := range lines {
+		p, err := parseICSLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		top := stack[len(stack)-1]
+		switch p.name {
+		case "BEGIN":
+			c := &icsComponent{name: strings.ToUpper(strings.TrimSpace(p.value))}
+			top.components = append(top.components, c)
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 1 || top.name != strings.ToUpper(strings.TrimSpace(p.value)) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", n+1, p.value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			top.props = append(top.props, p)
+		}
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("unterminated %s", stack[len(stack)-1].name)
+	}
+	if len(root.components) == 0 {
+		return nil, errors.New("not an iCalendar file")
+	}
+	return root, nil
+}
+
+// parseICSLine splits a content line into its name, parameters and value. Parameter
+// values may be quoted, and then contain ':' and ';'.
+func parseICSLine(line string) (icsProperty, error) {
+	p := icsProperty{params: make(map[string]string), raw: line}
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return icsProperty{}, fmt.Errorf("invalid content line %q", line)
+	}
+	p.name = strings.ToUpper(line[:i])
+	for line[i] == ';' {
+		rest := line[i+1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return icsProperty{}, fmt.Errorf("invalid parameter in %q", line)
+		}
+		key := strings.ToUpper(rest[:eq])
+		j := eq + 1
+		var val string
+		if j < len(rest) && rest[j] == '"' {
+			end := strings.IndexByte(rest[j+1:], '"')
+			if end < 0 {
+				return icsProperty{}, fmt.Errorf("unterminated quote in %q", line)
+			}
+			val = rest[j+1 : j+1+end]
+			j += end + 2
+		} else {
+			end := strings.IndexAny(rest[j:], ";:")
+			if end < 0 {
+				return icsProperty{}, fmt.Errorf("missing value in %q", line)
+			}
+			val = rest[j : j+end]
+			j += end
+		}
+		if j >= len(rest) {
+			return icsProperty{}, fmt.Errorf("missing value in %q", line)
+		}
+		p.params[key] = val
+		i += 1 + j
+	}
+	p.value = line[i+1:]
+	return p, nil
+}
+
+// icsUnescape undoes the escaping of a TEXT value.
+func icsUnescape(v string) string {
+	if !strings.Contains(v, `\`) {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' || i+1 == len(v) {
+			b.WriteByte(v[i])
+			continue
+		}
+		i++
+		switch v[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+	return b.String()
+}
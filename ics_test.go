@@ -0,0 +1,241 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFoldICSLine(t *testing.T) {
+	line := "DESCRIPTION:" + strings.Repeat("회의", 30)
+	folded := foldICSLine(line)
+	if !strings.HasSuffix(folded, "\r\n") {
+		t.Fatalf("not ended with CRLF: %q", folded)
+	}
+	var joined strings.Builder
+	for i, part := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+		if len(part) > 75 {
+			t.Fatalf("line %d is %d octets", i, len(part))
+		}
+		if i > 0 {
+			if !strings.HasPrefix(part, " ") {
+				t.Fatalf("continuation %d without leading space: %q", i, part)
+			}
+			part = part[1:]
+		}
+		joined.WriteString(part)
+	}
+	if joined.String() != line {
+		t.Fatalf("unfolded %q", joined.String())
+	}
+	if got := icsText("a,b;c\\d\ne"); got != `a\,b\;c\\d\ne` {
+		t.Fatalf("escaped %q", got)
+	}
+}
+
+func TestExportICS(t *testing.T) {
+	a := NewApp()
+	if err := a.openDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+
+	master, err := a.CreateEvent(CalendarEvent{Title: "standup, daily", Start: "2024-03-08T14:00:00Z", End: "2024-03-08T14:30:00Z",
+		TimeZone: "America/New_York", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=DAILY;COUNT=5", Alert: "10m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := master
+	edited.Title, edited.Start, edited.End = "late standup", "2024-03-11T15:00:00Z", "2024-03-11T15:30:00Z"
+	if _, err := a.UpdateOccurrence(master.ID, "2024-03-11T13:00:00Z", OccurrenceThis, edited); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.CreateEvent(CalendarEvent{Title: "holiday", AllDay: true, Start: "2024-03-09", End: "2024-03-10", Alert: "none",
+		Reminders: []Reminder{{ReminderPopup, 24 * 60}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.CreateEvent(CalendarEvent{Title: "next month", Start: "2024-04-20T09:00:00Z", End: "2024-04-20T10:00:00Z", Alert: "none"}); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := a.ExportICS("2024-03-01T00:00:00Z", "2024-04-01T00:00:00Z", []string{localCalendarID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(strings.ReplaceAll(out, "\r\n", ""), "\n") {
+		t.Fatal("bare LF in output")
+	}
+	lines := strings.Split(strings.ReplaceAll(out, "\r\n ", ""), "\r\n")
+	for _, want := range []string{
+		"BEGIN:VCALENDAR",
+		"TZID:America/New_York",
+		// US daylight saving time started on 2024-03-10 at 02:00 EST.
+		"DTSTART:20240310T020000",
+		"TZOFFSETFROM:-0500",
+		"TZOFFSETTO:-0400",
+		"UID:" + master.ID + "@calendar-widget",
+		"DTSTART;TZID=America/New_York:20240308T090000",
+		`SUMMARY:standup\, daily`,
+		"RRULE:FREQ=DAILY;COUNT=5",
+		"RECURRENCE-ID;TZID=America/New_York:20240311T090000",
+		"SUMMARY:late standup",
+		"ACTION:DISPLAY",
+		"TRIGGER:-PT10M",
+		"DTSTART;VALUE=DATE:20240309",
+		"TRIGGER:-P1D",
+		"END:VCALENDAR",
+	} {
+		found := false
+		for _, line := range lines {
+			if line == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "UID:"+master.ID+"@calendar-widget"); n != 2 {
+		t.Errorf("series UID appears %d times, want 2", n)
+	}
+	if strings.Contains(out, "next month") {
+		t.Error("exported an event outside the window")
+	}
+	if out, err := a.ExportICS("", "", []string{"someone@example.com"}); err != nil || strings.Contains(out, "BEGIN:VEVENT") {
+		t.Fatalf("other calendar: %v\n%s", err, out)
+	}
+}
+
+const sampleICS = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Conference//EN\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:America/New_York\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:weekly-sync@example.com\r\n" +
+	"DTSTART;TZID=America/New_York:20240304T090000\r\n" +
+	"DTEND;TZID=America/New_York:20240304T093000\r\n" +
+	"RRULE:FREQ=WEEKLY;COUNT=4\r\n" +
+	"EXDATE;TZID=America/New_York:20240311T090000\r\n" +
+	"SUMMARY:Weekly sync\\, team A\r\n" +
+	"DESCRIPTION:Agenda:\\nstatus\\; blockers and a description long enough to be f\r\n" +
+	" olded\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"END:VALARM\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:EMAIL\r\n" +
+	"TRIGGER;RELATED=START:-P1D\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:weekly-sync@example.com\r\n" +
+	"RECURRENCE-ID;TZID=America/New_York:20240318T090000\r\n" +
+	"DTSTART;TZID=America/New_York:20240318T110000\r\n" +
+	"DURATION:PT1H\r\n" +
+	"SUMMARY:Weekly sync (moved)\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:conference@example.com\r\n" +
+	"DTSTART;VALUE=DATE:20240320\r\n" +
+	"DTEND;VALUE=DATE:20240322\r\n" +
+	"SUMMARY:Conference\r\n" +
+	"LOCATION:Hall 1\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:broken@example.com\r\n" +
+	"DTSTART;TZID=Nowhere/Special:20240320T090000\r\n" +
+	"SUMMARY:Broken\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+// importStatuses returns the statuses of an import report as "title=status" entries.
+func importStatuses(results []ICSImportResult) string {
+	var out []string
+	for _, r := range results {
+		out = append(out, r.Title+"="+r.Status)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestImportICS(t *testing.T) {
+	a := NewApp()
+	if err := a.openDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+
+	results, err := a.importICS(strings.NewReader(sampleICS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := importStatuses(results); got != "Weekly sync, team A=created Weekly sync (moved)=created Conference=created Broken=failed" {
+		t.Fatalf("first import: %s", got)
+	}
+	if !strings.Contains(results[3].Error, "Nowhere/Special") {
+		t.Fatalf("error %q", results[3].Error)
+	}
+	series, err := a.getEvent(results[0].EventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.ICalUID != "weekly-sync@example.com" || series.SyncStatus != "local" || series.TimeZone != "America/New_York" {
+		t.Fatalf("series %+v", series)
+	}
+	if series.Description != "Agenda:\nstatus; blockers and a description long enough to be folded" {
+		t.Fatalf("description %q", series.Description)
+	}
+	if want := []Reminder{{ReminderPopup, 15}, {ReminderEmail, 24 * 60}}; !reflect.DeepEqual(series.Reminders, want) || series.Alert != "15m" {
+		t.Fatalf("reminders %v, alert %q", series.Reminders, series.Alert)
+	}
+	occurrences, err := a.ListOccurrences("2024-03-01T00:00:00Z", "2024-04-01T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, o := range occurrences {
+		got = append(got, o.Title+"@"+o.start.UTC().Format("01-02T15:04"))
+	}
+	want := "Weekly sync, team A@03-04T14:00 Weekly sync (moved)@03-18T15:00 Conference@03-20T00:00 Weekly sync, team A@03-25T13:00"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("occurrences\n%s\nwant\n%s", strings.Join(got, " "), want)
+	}
+	if ops := pendingOps(t, a); len(ops) != 3 {
+		t.Fatalf("pending ops %v", ops)
+	}
+
+	// Importing again changes nothing; changes made to the file update the events.
+	results, err = a.importICS(strings.NewReader(sampleICS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := importStatuses(results); got != "Weekly sync, team A=unchanged Weekly sync (moved)=unchanged Conference=unchanged Broken=failed" {
+		t.Fatalf("second import: %s", got)
+	}
+	changed := strings.Replace(sampleICS, "LOCATION:Hall 1", "LOCATION:Hall 2", 1)
+	changed = strings.Replace(changed, "SUMMARY:Weekly sync (moved)\r\n", "SUMMARY:Weekly sync (moved)\r\nSTATUS:CANCELLED\r\n", 1)
+	if results, err = a.importICS(strings.NewReader(changed)); err != nil {
+		t.Fatal(err)
+	}
+	if got := importStatuses(results); got != "Weekly sync, team A=unchanged Weekly sync (moved)=deleted Conference=updated Broken=failed" {
+		t.Fatalf("changed import: %s", got)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events WHERE sync_status NOT IN ('deleted','cancelled')`); n != 2 {
+		t.Fatalf("%d events after re-import, want 2", n)
+	}
+
+	// Exported events come back onto themselves.
+	out, err := a.ExportICS("", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results, err = a.importICS(strings.NewReader(out)); err != nil {
+		t.Fatal(err)
+	}
+	if got := importStatuses(results); got != "Weekly sync, team A=unchanged Conference=unchanged" {
+		t.Fatalf("import of export: %s\n%s", got, out)
+	}
+}
@@ -0,0 +1,500 @@
+// Package fakecaldav is an in-memory stand-in for a CalDAV server (RFC 4791), such as
+// Nextcloud or Fastmail, for use from go test.
+//
+// It implements the subset the widget uses: principal and calendar-home discovery
+// through PROPFIND, calendar-query and calendar-multiget REPORTs, sync-collection
+// (RFC 6578) with sync tokens, and GET, PUT and DELETE on event resources with If-Match
+// and If-None-Match preconditions. Requests must carry basic auth with Username and
+// Password. Resources are stored as the iCalendar text they were written with; the
+// server does not interpret them, and PROPFIND answers with every property it knows
+// whatever was asked for. Sync tokens can be expired to force a full resync.
+package fakecaldav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Credentials the server accepts.
+const (
+	Username = "user"
+	Password = "secret"
+)
+
+const (
+	principalPath = "/principals/" + Username + "/"
+	homePath      = "/calendars/" + Username + "/"
+	syncTokenBase = "http://fakecaldav.test/sync/"
+)
+
+// Calendar describes a calendar collection in the user's calendar home.
+type Calendar struct {
+	// ID is the collection's path segment under the calendar home.
+	ID       string
+	Name     string
+	Color    string
+	TimeZone string
+	// ReadOnly calendars refuse PUT and DELETE with 403 Forbidden.
+	ReadOnly bool
+}
+
+// Request records one request received by the server.
+type Request struct {
+	Method      string
+	Path        string
+	Depth       string
+	IfMatch     string
+	IfNoneMatch string
+	// Report is the root element of a REPORT body, e.g. "sync-collection".
+	Report string
+}
+
+type resource struct {
+	data    string
+	etag    string
+	seq     int64
+	deleted bool
+}
+
+type calendarData struct {
+	info Calendar
+	// resources holds the events by resource name, deleted ones as tombstones.
+	resources map[string]*resource
+}
+
+// Server is a fake CalDAV server.
+type Server struct {
+	mu        sync.Mutex
+	srv       *httptest.Server
+	calendars map[string]*calendarData
+	order     []string
+	seq       int64
+	// minSeq is the oldest change sequence a sync token may name.
+	minSeq   int64
+	requests []Request
+}
+
+// New starts a server whose calendar home is empty. Close it when done.
+func New() *Server {
+	s := &Server{calendars: make(map[string]*calendarData)}
+	s.srv = httptest.NewServer(s)
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() { s.srv.Close() }
+
+// URL returns the server's base URL, where discovery starts.
+func (s *Server) URL() string { return s.srv.URL + "/" }
+
+// CalendarURL returns the URL of a calendar collection.
+func (s *Server) CalendarURL(id string) string { return s.srv.URL + homePath + id + "/" }
+
+// AddCalendar adds a calendar collection, replacing the properties of one with the same ID.
+func (s *Server) AddCalendar(c Calendar) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.calendars[c.ID]; ok {
+		existing.info = c
+		return
+	}
+	s.calendars[c.ID] = &calendarData{info: c, resources: make(map[string]*resource)}
+	s.order = append(s.order, c.ID)
+}
+
+// PutEvent creates or replaces the resource name (e.g. "standup.ics") as if another
+// client wrote it, returning its new ETag.
+func (s *Server) PutEvent(calendarID, name, data string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cal, ok := s.calendars[calendarID]
+	if !ok {
+		panic(fmt.Sprintf("fakecaldav: unknown calendar %s", calendarID))
+	}
+	return s.store(cal, name, data).etag
+}
+
+// RemoveEvent deletes a resource as if another client deleted it.
+func (s *Server) RemoveEvent(calendarID, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cal, ok := s.calendars[calendarID]
+	if !ok {
+		return false
+	}
+	r, ok := cal.resources[name]
+	if !ok || r.deleted {
+		return false
+	}
+	s.remove(r)
+	return true
+}
+
+// Event returns the data and ETag of a resource.
+func (s *Server) Event(calendarID, name string) (data, etag string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cal, found := s.calendars[calendarID]
+	if !found {
+		return "", "", false
+	}
+	r, found := cal.resources[name]
+	if !found || r.deleted {
+		return "", "", false
+	}
+	return r.data, r.etag, true
+}
+
+// Events returns the data of a calendar's resources by name.
+func (s *Server) Events(calendarID string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]string)
+	if cal, ok := s.calendars[calendarID]; ok {
+		for name, r := range cal.resources {
+			if !r.deleted {
+				out[name] = r.data
+			}
+		}
+	}
+	return out
+}
+
+// ExpireSyncTokens invalidates every sync token issued so far; the next sync-collection
+// report naming one fails with the valid-sync-token precondition.
+func (s *Server) ExpireSyncTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	s.minSeq = s.seq
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// CountRequests returns how many requests used method on a path ending in suffix.
+func (s *Server) CountRequests(method, suffix string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if r.Method == method && strings.HasSuffix(r.Path, suffix) {
+			n++
+		}
+	}
+	return n
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report := ""
+	if r.Method == "REPORT" {
+		var root struct{ XMLName xml.Name }
+		if err := xml.Unmarshal(body, &root); err != nil {
+			http.Error(w, "invalid REPORT body", http.StatusBadRequest)
+			return
+		}
+		report = root.XMLName.Local
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{
+		Method: r.Method, Path: r.URL.Path, Depth: r.Header.Get("Depth"),
+		IfMatch: r.Header.Get("If-Match"), IfNoneMatch: r.Header.Get("If-None-Match"), Report: report,
+	})
+	if user, pass, ok := r.BasicAuth(); !ok || user != Username || pass != Password {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fakecaldav"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/" || path == principalPath:
+		if r.Method != "PROPFIND" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeMultistatus(w, []string{principalResponse(path)}, "")
+	case path == homePath:
+		if r.Method != "PROPFIND" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		responses := []string{response(homePath, prop("resourcetype", "<d:collection/>"))}
+		if r.Header.Get("Depth") == "1" {
+			for _, id := range s.order {
+				responses = append(responses, s.calendarResponse(s.calendars[id]))
+			}
+		}
+		writeMultistatus(w, responses, "")
+	case strings.HasPrefix(path, homePath):
+		rest := strings.TrimPrefix(path, homePath)
+		id, name, _ := strings.Cut(rest, "/")
+		cal, ok := s.calendars[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if name == "" {
+			s.handleCalendar(w, r, cal, report, body)
+		} else {
+			s.handleResource(w, r, cal, name, body)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleCalendar(w http.ResponseWriter, r *http.Request, cal *calendarData, report string, body []byte) {
+	switch r.Method {
+	case "PROPFIND":
+		responses := []string{s.calendarResponse(cal)}
+		if r.Header.Get("Depth") == "1" {
+			for _, name := range s.names(cal, 0) {
+				responses = append(responses, s.resourceResponse(cal, name, false))
+			}
+		}
+		writeMultistatus(w, responses, "")
+	case "REPORT":
+		s.handleReport(w, cal, report, body)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleReport(w http.ResponseWriter, cal *calendarData, report string, body []byte) {
+	var req struct {
+		SyncToken string   `xml:"DAV: sync-token"`
+		Hrefs     []string `xml:"DAV: href"`
+		Prop      struct {
+			CalendarData *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+		} `xml:"DAV: prop"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid REPORT body", http.StatusBadRequest)
+		return
+	}
+	withData := req.Prop.CalendarData != nil
+	var responses []string
+	switch report {
+	case "calendar-query":
+		for _, name := range s.names(cal, 0) {
+			responses = append(responses, s.resourceResponse(cal, name, withData))
+		}
+		writeMultistatus(w, responses, "")
+	case "calendar-multiget":
+		prefix := homePath + cal.info.ID + "/"
+		for _, href := range req.Hrefs {
+			name := strings.TrimPrefix(href, prefix)
+			if r, ok := cal.resources[name]; !ok || r.deleted || !strings.HasPrefix(href, prefix) {
+				responses = append(responses, statusResponse(href, http.StatusNotFound))
+				continue
+			}
+			responses = append(responses, s.resourceResponse(cal, name, withData))
+		}
+		writeMultistatus(w, responses, "")
+	case "sync-collection":
+		since := int64(0)
+		if req.SyncToken != "" {
+			seq, err := strconv.ParseInt(strings.TrimPrefix(req.SyncToken, syncTokenBase), 10, 64)
+			if err != nil || !strings.HasPrefix(req.SyncToken, syncTokenBase) || seq < s.minSeq || seq > s.seq {
+				w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+				w.WriteHeader(http.StatusForbidden)
+				io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+`<d:error xmlns:d="DAV:"><d:valid-sync-token/></d:error>`)
+				return
+			}
+			since = seq
+		}
+		for _, name := range s.changed(cal, since) {
+			if cal.resources[name].deleted {
+				if since > 0 {
+					responses = append(responses, statusResponse(s.href(cal, name), http.StatusNotFound))
+				}
+				continue
+			}
+			responses = append(responses, s.resourceResponse(cal, name, withData))
+		}
+		writeMultistatus(w, responses, s.syncToken())
+	default:
+		http.Error(w, "unsupported report "+report, http.StatusForbidden)
+	}
+}
+
+func (s *Server) handleResource(w http.ResponseWriter, r *http.Request, cal *calendarData, name string, body []byte) {
+	current, exists := cal.resources[name]
+	if exists && current.deleted {
+		exists = false
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("ETag", current.etag)
+		io.WriteString(w, current.data)
+	case http.MethodPut, http.MethodDelete:
+		if cal.info.ReadOnly {
+			http.Error(w, "calendar is read-only", http.StatusForbidden)
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && (!exists || (m != "*" && m != current.etag)) {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			if !exists {
+				http.NotFound(w, r)
+				return
+			}
+			s.remove(current)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		stored := s.store(cal, name, string(body))
+		w.Header().Set("ETag", stored.etag)
+		if exists {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) store(cal *calendarData, name, data string) *resource {
+	s.seq++
+	r := &resource{data: data, etag: fmt.Sprintf(`"%d"`, s.seq), seq: s.seq}
+	cal.resources[name] = r
+	return r
+}
+
+func (s *Server) remove(r *resource) {
+	s.seq++
+	r.deleted, r.data, r.seq = true, "", s.seq
+}
+
+func (s *Server) syncToken() string {
+	return syncTokenBase + strconv.FormatInt(s.seq, 10)
+}
+
+func (s *Server) href(cal *calendarData, name string) string {
+	return homePath + cal.info.ID + "/" + name
+}
+
+// names returns the live resources changed after seq, by name.
+func (s *Server) names(cal *calendarData, seq int64) []string {
+	var out []string
+	for _, name := range s.changed(cal, seq) {
+		if !cal.resources[name].deleted {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// changed returns the resources, tombstones included, changed after seq in change order.
+func (s *Server) changed(cal *calendarData, seq int64) []string {
+	var out []string
+	for name, r := range cal.resources {
+		if r.seq > seq {
+			out = append(out, name)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return cal.resources[out[i]].seq < cal.resources[out[j]].seq })
+	return out
+}
+
+func (s *Server) calendarResponse(cal *calendarData) string {
+	c := cal.info
+	privileges := "<d:privilege><d:read/></d:privilege>"
+	if !c.ReadOnly {
+		privileges += "<d:privilege><d:write/></d:privilege>"
+	}
+	props := prop("resourcetype", "<d:collection/><c:calendar/>") +
+		prop("displayname", escape(c.Name)) +
+		prop("c:supported-calendar-component-set", `<c:comp name="VEVENT"/>`) +
+		prop("current-user-privilege-set", privileges) +
+		prop("sync-token", escape(s.syncToken()))
+	if c.Color != "" {
+		props += prop("a:calendar-color", escape(c.Color))
+	}
+	if c.TimeZone != "" {
+		vtimezone := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VTIMEZONE\r\nTZID:" + c.TimeZone + "\r\nEND:VTIMEZONE\r\nEND:VCALENDAR\r\n"
+		props += prop("c:calendar-timezone", escape(vtimezone))
+	}
+	return response(homePath+c.ID+"/", props)
+}
+
+func (s *Server) resourceResponse(cal *calendarData, name string, withData bool) string {
+	r := cal.resources[name]
+	props := prop("getetag", escape(r.etag))
+	if withData {
+		props += prop("c:calendar-data", escape(r.data))
+	}
+	return response(s.href(cal, name), props)
+}
+
+func principalResponse(path string) string {
+	return response(path,
+		prop("current-user-principal", "<d:href>"+principalPath+"</d:href>")+
+			prop("c:calendar-home-set", "<d:href>"+homePath+"</d:href>"))
+}
+
+// prop renders a property; names without a prefix are in the DAV: namespace.
+func prop(name, inner string) string {
+	if !strings.Contains(name, ":") {
+		name = "d:" + name
+	}
+	return "<" + name + ">" + inner + "</" + name + ">"
+}
+
+func response(href, props string) string {
+	return "<d:response><d:href>" + escape(href) + "</d:href><d:propstat><d:prop>" + props +
+		"</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>"
+}
+
+func statusResponse(href string, status int) string {
+	return fmt.Sprintf("<d:response><d:href>%s</d:href><d:status>HTTP/1.1 %d %s</d:status></d:response>", escape(href), status, http.StatusText(status))
+}
+
+func writeMultistatus(w http.ResponseWriter, responses []string, syncToken string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n")
+	io.WriteString(w, `<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:a="http://apple.com/ns/ical/">`)
+	for _, r := range responses {
+		io.WriteString(w, r)
+	}
+	if syncToken != "" {
+		io.WriteString(w, "<d:sync-token>"+escape(syncToken)+"</d:sync-token>")
+	}
+	io.WriteString(w, "</d:multistatus>")
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,118 @@
+package fakecaldav
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type client struct {
+	t   *testing.T
+	srv *Server
+}
+
+func (c client) do(method, url, body string, header ...string) (int, http.Header, string) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.SetBasicAuth(Username, Password)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header, string(data)
+}
+
+func syncCollection(token string) string {
+	return `<d:sync-collection xmlns:d="DAV:"><d:sync-token>` + token + `</d:sync-token><d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop></d:sync-collection>`
+}
+
+// syncTokenOf extracts the sync token of a multistatus body.
+func syncTokenOf(body string) string {
+	_, rest, _ := strings.Cut(body, "<d:sync-token>")
+	token, _, _ := strings.Cut(rest, "</d:sync-token>")
+	return token
+}
+
+func TestSyncCollectionReportsChangesAndDeletes(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	srv.AddCalendar(Calendar{ID: "work", Name: "Work"})
+	c := client{t, srv}
+	calURL := srv.CalendarURL("work")
+
+	srv.PutEvent("work", "a.ics", "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")
+	status, _, body := c.do("REPORT", calURL, syncCollection(""), "Depth", "1")
+	if status != http.StatusMultiStatus || !strings.Contains(body, "/calendars/user/work/a.ics") {
+		t.Fatalf("initial sync: %d %s", status, body)
+	}
+	token := syncTokenOf(body)
+
+	srv.PutEvent("work", "b.ics", "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")
+	srv.RemoveEvent("work", "a.ics")
+	status, _, body = c.do("REPORT", calURL, syncCollection(token), "Depth", "1")
+	if status != http.StatusMultiStatus || !strings.Contains(body, "b.ics") || !strings.Contains(body, "HTTP/1.1 404 Not Found") {
+		t.Fatalf("incremental sync: %d %s", status, body)
+	}
+	if syncTokenOf(body) == token {
+		t.Fatal("sync token did not advance")
+	}
+
+	srv.ExpireSyncTokens()
+	if status, _, body = c.do("REPORT", calURL, syncCollection(token), "Depth", "1"); status != http.StatusForbidden || !strings.Contains(body, "valid-sync-token") {
+		t.Fatalf("expired token: %d %s", status, body)
+	}
+}
+
+func TestWritePreconditions(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	srv.AddCalendar(Calendar{ID: "work", Name: "Work"})
+	srv.AddCalendar(Calendar{ID: "shared", Name: "Shared", ReadOnly: true})
+	c := client{t, srv}
+	eventURL := srv.CalendarURL("work") + "e.ics"
+
+	status, header, _ := c.do(http.MethodPut, eventURL, "v1", "If-None-Match", "*")
+	if status != http.StatusCreated || header.Get("ETag") == "" {
+		t.Fatalf("create: %d", status)
+	}
+	etag := header.Get("ETag")
+	if status, _, _ := c.do(http.MethodPut, eventURL, "again", "If-None-Match", "*"); status != http.StatusPreconditionFailed {
+		t.Fatalf("second create: %d", status)
+	}
+	srv.PutEvent("work", "e.ics", "changed elsewhere")
+	if status, _, _ := c.do(http.MethodPut, eventURL, "v2", "If-Match", etag); status != http.StatusPreconditionFailed {
+		t.Fatalf("stale update: %d", status)
+	}
+	if status, _, _ := c.do(http.MethodDelete, eventURL, "", "If-Match", etag); status != http.StatusPreconditionFailed {
+		t.Fatalf("stale delete: %d", status)
+	}
+	_, current, _ := srv.Event("work", "e.ics")
+	if status, _, _ := c.do(http.MethodDelete, eventURL, "", "If-Match", current); status != http.StatusNoContent {
+		t.Fatalf("delete: %d", status)
+	}
+	if status, _, _ := c.do(http.MethodGet, eventURL, ""); status != http.StatusNotFound {
+		t.Fatalf("get deleted: %d", status)
+	}
+	if status, _, _ := c.do(http.MethodPut, srv.CalendarURL("shared")+"e.ics", "v1"); status != http.StatusForbidden {
+		t.Fatalf("read-only calendar: %d", status)
+	}
+
+	req, _ := http.NewRequest("PROPFIND", srv.URL(), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("without credentials: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,692 @@
+// Package fakegcal is an in-memory stand-in for the Google Calendar v3 API and the
+// OAuth token/userinfo endpoints, for use from go test.
+//
+// It implements the subset the widget uses: calendarList, events list with syncToken
+// and pageToken, get, insert, patch with If-Match, and delete. Deleted events stay
+// visible to incremental syncs as cancelled, and sync tokens can be expired to force
+// the 410 Gone full-resync path. Instances of recurring events can be read, patched and
+// deleted by their "<series ID>_<original start>" ID, which stores them as exceptions.
+package fakegcal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrimaryCalendarID is the ID of the calendar a new Server starts with; the "primary"
+// alias resolves to it.
+const PrimaryCalendarID = "me@fakegcal.test"
+
+// AccessToken is the bearer token issued by the fake token endpoint.
+const AccessToken = "fake-access-token"
+
+// Event is a Calendar API event resource as JSON fields. Fields the server does not
+// interpret are stored and returned unchanged.
+type Event map[string]any
+
+// ID returns the event ID.
+func (e Event) ID() string { return e.str("id") }
+
+// Summary returns the event title.
+func (e Event) Summary() string { return e.str("summary") }
+
+// Status returns confirmed, tentative or cancelled.
+func (e Event) Status() string { return e.str("status") }
+
+// ETag returns the current entity tag.
+func (e Event) ETag() string { return e.str("etag") }
+
+func (e Event) str(key string) string {
+	v, _ := e[key].(string)
+	return v
+}
+
+func (e Event) clone() Event {
+	data, _ := json.Marshal(e)
+	var out Event
+	json.Unmarshal(data, &out)
+	return out
+}
+
+// Calendar describes a calendar in the user's calendar list.
+type Calendar struct {
+	ID         string `json:"id"`
+	Summary    string `json:"summary"`
+	TimeZone   string `json:"timeZone,omitempty"`
+	AccessRole string `json:"accessRole"`
+	Primary    bool   `json:"primary,omitempty"`
+	Selected   bool   `json:"selected,omitempty"`
+	// DefaultReminders apply to events whose reminders use the default.
+	DefaultReminders []Reminder `json:"defaultReminders,omitempty"`
+}
+
+// Reminder is a calendar's default reminder.
+type Reminder struct {
+	Method  string `json:"method"`
+	Minutes int    `json:"minutes"`
+}
+
+// UserInfo is returned by the userinfo endpoint.
+type UserInfo struct {
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+}
+
+// Request records one request received by the server.
+type Request struct {
+	Method  string
+	Path    string
+	Query   url.Values
+	IfMatch string
+}
+
+type storedEvent struct {
+	Event
+	seq int64
+}
+
+type calendarData struct {
+	info   Calendar
+	events map[string]*storedEvent
+}
+
+type failure struct {
+	status int
+	reason string
+}
+
+// Server is a fake Google Calendar API server.
+type Server struct {
+	// PageSize caps the events returned per list page; zero means no cap beyond maxResults.
+	PageSize int
+	// User is returned by the userinfo endpoint.
+	User UserInfo
+
+	mu        sync.Mutex
+	srv       *httptest.Server
+	calendars map[string]*calendarData
+	order     []string
+	seq       int64
+	nextID    int64
+	// tokens maps issued sync tokens to the change sequence they were issued at.
+	tokens   map[string]int64
+	failures []failure
+	requests []Request
+	now      func() time.Time
+}
+
+// New starts a server holding an empty primary calendar. Close it when done.
+func New() *Server {
+	s := &Server{
+		User:      UserInfo{Email: PrimaryCalendarID, Name: "Fake User"},
+		calendars: make(map[string]*calendarData),
+		tokens:    make(map[string]int64),
+		now:       time.Now,
+	}
+	s.AddCalendar(Calendar{ID: PrimaryCalendarID, Summary: PrimaryCalendarID, AccessRole: "owner", Primary: true, Selected: true})
+	s.srv = httptest.NewServer(s)
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() { s.srv.Close() }
+
+// URL returns the server's base URL.
+func (s *Server) URL() string { return s.srv.URL }
+
+// AuthURL returns the OAuth consent endpoint.
+func (s *Server) AuthURL() string { return s.srv.URL + "/o/oauth2/v2/auth" }
+
+// TokenURL returns the OAuth token endpoint.
+func (s *Server) TokenURL() string { return s.srv.URL + "/token" }
+
+// UserInfoURL returns the userinfo endpoint.
+func (s *Server) UserInfoURL() string { return s.srv.URL + "/oauth2/v2/userinfo" }
+
+// CalendarBaseURL returns the Calendar API base, the equivalent of
+// https://www.googleapis.com/calendar/v3.
+func (s *Server) CalendarBaseURL() string { return s.srv.URL + "/calendar/v3" }
+
+// AddCalendar adds a calendar to the calendar list, replacing one with the same ID.
+func (s *Server) AddCalendar(c Calendar) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.AccessRole == "" {
+		c.AccessRole = "owner"
+	}
+	if existing, ok := s.calendars[c.ID]; ok {
+		existing.info = c
+		return
+	}
+	s.calendars[c.ID] = &calendarData{info: c, events: make(map[string]*storedEvent)}
+	s.order = append(s.order, c.ID)
+}
+
+// PutEvent creates or replaces an event as if it were changed in Google Calendar,
+// returning the stored copy with its new etag.
+func (s *Server) PutEvent(calendarID string, ev Event) Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cal := s.calendar(calendarID)
+	if cal == nil {
+		panic(fmt.Sprintf("fakegcal: unknown calendar %s", calendarID))
+	}
+	ev = ev.clone()
+	if ev.ID() == "" {
+		ev["id"] = s.newID()
+	}
+	if prev, ok := cal.events[ev.ID()]; ok {
+		if _, ok := ev["created"]; !ok {
+			ev["created"] = prev.Event["created"]
+		}
+	}
+	return s.store(cal, ev).clone()
+}
+
+// RemoveEvent deletes an event, or one instance of a recurring event, as if it were
+// deleted in Google Calendar.
+func (s *Server) RemoveEvent(calendarID, eventID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cal := s.calendar(calendarID)
+	if cal == nil {
+		return false
+	}
+	stored, ok := s.lookup(cal, eventID)
+	if !ok || stored.Status() == "cancelled" {
+		return false
+	}
+	s.cancel(cal, stored)
+	return true
+}
+
+// PurgeEvent removes an event without leaving a cancelled copy, so later requests for
+// it answer 404 Not Found.
+func (s *Server) PurgeEvent(calendarID, eventID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cal := s.calendar(calendarID)
+	if cal == nil {
+		return false
+	}
+	if _, ok := cal.events[eventID]; !ok {
+		return false
+	}
+	delete(cal.events, eventID)
+	return true
+}
+
+// Event returns a copy of an event, including cancelled ones.
+func (s *Server) Event(calendarID, eventID string) (Event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cal := s.calendar(calendarID)
+	if cal == nil {
+		return nil, false
+	}
+	stored, ok := cal.events[eventID]
+	if !ok {
+		return nil, false
+	}
+	return stored.clone(), true
+}
+
+// Events returns copies of a calendar's events that are not cancelled, in change order.
+func (s *Server) Events(calendarID string) []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cal := s.calendar(calendarID)
+	if cal == nil {
+		return nil
+	}
+	var out []Event
+	for _, stored := range s.sorted(cal, 0) {
+		if stored.Status() != "cancelled" {
+			out = append(out, stored.clone())
+		}
+	}
+	return out
+}
+
+// ExpireSyncTokens invalidates every sync token issued so far; the next incremental
+// list answers 410 Gone.
+func (s *Server) ExpireSyncTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]int64)
+}
+
+// FailNext makes the next n Calendar API requests fail with status. A non-empty reason
+// is reported in error.errors[0].reason, e.g. "rateLimitExceeded".
+func (s *Server) FailNext(n, status int, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status: status, reason: reason})
+	}
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// CountRequests returns how many requests used method on a path ending in suffix.
+func (s *Server) CountRequests(method, suffix string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if r.Method == method && strings.HasSuffix(r.Path, suffix) {
+			n++
+		}
+	}
+	return n
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), IfMatch: r.Header.Get("If-Match")})
+	s.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/token" && r.Method == http.MethodPost:
+		s.handleToken(w, r)
+	case r.URL.Path == "/oauth2/v2/userinfo" && r.Method == http.MethodGet:
+		if !authorized(w, r) {
+			return
+		}
+		s.mu.Lock()
+		user := s.User
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, user)
+	case strings.HasPrefix(r.URL.Path, "/calendar/v3/"):
+		if !authorized(w, r) {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.failures) > 0 {
+			f := s.failures[0]
+			s.failures = s.failures[1:]
+			writeError(w, f.status, f.reason)
+			return
+		}
+		s.handleCalendar(w, r, strings.TrimPrefix(r.URL.Path, "/calendar/v3"))
+	default:
+		writeError(w, http.StatusNotFound, "notFound")
+	}
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	resp := map[string]any{
+		"access_token": AccessToken,
+		"expires_in":   3600,
+		"token_type":   "Bearer",
+		"scope":        "https://www.googleapis.com/auth/calendar.events",
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		resp["refresh_token"] = "fake-refresh-token"
+	case "refresh_token":
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleCalendar serves Calendar API paths; the caller holds s.mu.
+func (s *Server) handleCalendar(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "/users/me/calendarList" && r.Method == http.MethodGet {
+		items := make([]Calendar, 0, len(s.order))
+		for _, id := range s.order {
+			items = append(items, s.calendars[id].info)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"kind": "calendar#calendarList", "items": items})
+		return
+	}
+
+	// /calendars/{calendarId}/events[/{eventId}]
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/calendar/v3/calendars/"), "/")
+	if !strings.HasPrefix(path, "/calendars/") || len(parts) < 2 || parts[1] != "events" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, "notFound")
+		return
+	}
+	calendarID, _ := url.PathUnescape(parts[0])
+	cal := s.calendar(calendarID)
+	if cal == nil {
+		writeError(w, http.StatusNotFound, "notFound")
+		return
+	}
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			s.list(w, r, cal)
+		case http.MethodPost:
+			s.insert(w, r, cal)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "methodNotAllowed")
+		}
+		return
+	}
+	eventID, _ := url.PathUnescape(parts[2])
+	switch r.Method {
+	case http.MethodGet:
+		stored, ok := s.lookup(cal, eventID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound")
+			return
+		}
+		writeJSON(w, http.StatusOK, stored.Event)
+	case http.MethodPatch, http.MethodPut:
+		s.patch(w, r, cal, eventID)
+	case http.MethodDelete:
+		stored, ok := s.lookup(cal, eventID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound")
+			return
+		}
+		if stored.Status() == "cancelled" {
+			writeError(w, http.StatusGone, "deleted")
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && m != stored.ETag() {
+			writeError(w, http.StatusPreconditionFailed, "conditionNotMet")
+			return
+		}
+		s.cancel(cal, stored)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "methodNotAllowed")
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, cal *calendarData) {
+	q := r.URL.Query()
+	var since int64
+	incremental := q.Get("syncToken") != ""
+	if incremental {
+		seq, ok := s.tokens[q.Get("syncToken")]
+		if !ok {
+			writeError(w, http.StatusGone, "fullSyncRequired")
+			return
+		}
+		since = seq
+	}
+	showDeleted := incremental || q.Get("showDeleted") == "true"
+	timeMin := parseTime(q.Get("timeMin"))
+	timeMax := parseTime(q.Get("timeMax"))
+
+	var matched []Event
+	for _, stored := range s.sorted(cal, since) {
+		if !showDeleted && stored.Status() == "cancelled" {
+			continue
+		}
+		if !incremental && !overlaps(stored.Event, timeMin, timeMax) {
+			continue
+		}
+		matched = append(matched, stored.Event)
+	}
+
+	offset := 0
+	if token := q.Get("pageToken"); token != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(token, "page-"))
+		if err != nil || n < 0 || n > len(matched) {
+			writeError(w, http.StatusBadRequest, "invalidPageToken")
+			return
+		}
+		offset = n
+	}
+	size := len(matched) - offset
+	if n, err := strconv.Atoi(q.Get("maxResults")); err == nil && n > 0 && n < size {
+		size = n
+	}
+	if s.PageSize > 0 && s.PageSize < size {
+		size = s.PageSize
+	}
+	page := matched[offset : offset+size]
+	resp := map[string]any{"kind": "calendar#events", "items": page}
+	if offset+size < len(matched) {
+		resp["nextPageToken"] = fmt.Sprintf("page-%d", offset+size)
+	} else {
+		token := fmt.Sprintf("sync-%d-%d", s.seq, len(s.tokens))
+		s.tokens[token] = s.seq
+		resp["nextSyncToken"] = token
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) insert(w http.ResponseWriter, r *http.Request, cal *calendarData) {
+	var ev Event
+	if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+		writeError(w, http.StatusBadRequest, "parseError")
+		return
+	}
+	if id := ev.ID(); id != "" {
+		if !validEventID(id) {
+			writeError(w, http.StatusBadRequest, "invalid")
+			return
+		}
+		if _, exists := cal.events[id]; exists {
+			writeError(w, http.StatusConflict, "duplicate")
+			return
+		}
+	} else {
+		ev["id"] = s.newID()
+	}
+	writeJSON(w, http.StatusOK, s.store(cal, ev))
+}
+
+func (s *Server) patch(w http.ResponseWriter, r *http.Request, cal *calendarData, eventID string) {
+	stored, ok := s.lookup(cal, eventID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound")
+		return
+	}
+	if stored.Status() == "cancelled" {
+		writeError(w, http.StatusGone, "deleted")
+		return
+	}
+	if m := r.Header.Get("If-Match"); m != "" && m != stored.ETag() {
+		writeError(w, http.StatusPreconditionFailed, "conditionNotMet")
+		return
+	}
+	var changes Event
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		writeError(w, http.StatusBadRequest, "parseError")
+		return
+	}
+	merged := stored.Event.clone()
+	if r.Method == http.MethodPut {
+		merged = Event{"created": stored.Event["created"], "iCalUID": stored.Event["iCalUID"]}
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	merged["id"] = eventID
+	writeJSON(w, http.StatusOK, s.store(cal, merged))
+}
+
+// store saves ev as a new revision; the caller holds s.mu.
+func (s *Server) store(cal *calendarData, ev Event) Event {
+	s.seq++
+	now := s.now().UTC().Format("2006-01-02T15:04:05.000Z")
+	if ev.Status() == "" {
+		ev["status"] = "confirmed"
+	}
+	if _, ok := ev["reminders"]; !ok {
+		ev["reminders"] = map[string]any{"useDefault": true}
+	}
+	if _, ok := ev["created"]; !ok {
+		ev["created"] = now
+	}
+	if _, ok := ev["iCalUID"]; !ok {
+		ev["iCalUID"] = ev.ID() + "@google.com"
+	}
+	ev["kind"] = "calendar#event"
+	ev["etag"] = fmt.Sprintf("\"%d\"", s.seq)
+	ev["updated"] = now
+	cal.events[ev.ID()] = &storedEvent{Event: ev, seq: s.seq}
+	return ev
+}
+
+// cancel marks an event deleted; the caller holds s.mu.
+func (s *Server) cancel(cal *calendarData, stored *storedEvent) {
+	ev := stored.Event.clone()
+	ev["status"] = "cancelled"
+	s.store(cal, ev)
+}
+
+// lookup finds a stored event, or an unchanged instance of a recurring event, which is
+// made up from its series and only stored once it is written. Whether the original start
+// is one the series' recurrence produces is not checked. The caller holds s.mu.
+func (s *Server) lookup(cal *calendarData, eventID string) (*storedEvent, bool) {
+	if stored, ok := cal.events[eventID]; ok {
+		return stored, true
+	}
+	seriesID, suffix, ok := strings.Cut(eventID, "_")
+	if !ok {
+		return nil, false
+	}
+	series, ok := cal.events[seriesID]
+	if !ok || series.Status() == "cancelled" || series.Event["recurrence"] == nil {
+		return nil, false
+	}
+	start, startOK := series.Event["start"].(map[string]any)
+	dur := eventTime(series.Event["end"]).Sub(eventTime(series.Event["start"]))
+	var original, end map[string]any
+	if t, err := time.Parse("20060102T150405Z", suffix); err == nil && startOK && start["dateTime"] != nil {
+		original = map[string]any{"dateTime": t.Format(time.RFC3339), "timeZone": start["timeZone"]}
+		end = map[string]any{"dateTime": t.Add(dur).Format(time.RFC3339), "timeZone": start["timeZone"]}
+	} else if t, err := time.Parse("20060102", suffix); err == nil && startOK && start["date"] != nil {
+		original = map[string]any{"date": t.Format("2006-01-02")}
+		end = map[string]any{"date": t.Add(dur).Format("2006-01-02")}
+	} else {
+		return nil, false
+	}
+	ev := series.Event.clone()
+	delete(ev, "recurrence")
+	ev["id"] = eventID
+	ev["recurringEventId"] = seriesID
+	ev["originalStartTime"] = original
+	ev["start"] = original
+	ev["end"] = end
+	return &storedEvent{Event: ev.clone()}, true
+}
+
+// calendar resolves a calendar ID or the "primary" alias; the caller holds s.mu.
+func (s *Server) calendar(id string) *calendarData {
+	if id == "primary" {
+		for _, cid := range s.order {
+			if s.calendars[cid].info.Primary {
+				return s.calendars[cid]
+			}
+		}
+	}
+	return s.calendars[id]
+}
+
+// sorted returns events changed after seq in change order; the caller holds s.mu.
+func (s *Server) sorted(cal *calendarData, since int64) []*storedEvent {
+	var out []*storedEvent
+	for _, stored := range cal.events {
+		if stored.seq > since {
+			out = append(out, stored)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].seq < out[j].seq })
+	return out
+}
+
+// newID returns a fresh event ID in Google's base32hex alphabet; the caller holds s.mu.
+func (s *Server) newID() string {
+	s.nextID++
+	return "fake" + strconv.FormatInt(s.nextID, 32)
+}
+
+// validEventID reports whether id is acceptable as a client-chosen event ID: 5 to 1024
+// characters of base32hex (0-9 and a-v).
+func validEventID(id string) bool {
+	if len(id) < 5 || len(id) > 1024 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'v') {
+			return false
+		}
+	}
+	return true
+}
+
+func authorized(w http.ResponseWriter, r *http.Request) bool {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "authError")
+		return false
+	}
+	return true
+}
+
+func parseTime(v string) time.Time {
+	t, _ := time.Parse(time.RFC3339, v)
+	return t
+}
+
+// overlaps reports whether an event intersects [min, max); zero bounds are open.
+func overlaps(ev Event, min, max time.Time) bool {
+	if min.IsZero() && max.IsZero() {
+		return true
+	}
+	start, end := eventTime(ev["start"]), eventTime(ev["end"])
+	if !max.IsZero() && !start.IsZero() && !start.Before(max) {
+		return false
+	}
+	if !min.IsZero() && !end.IsZero() && !end.After(min) {
+		return false
+	}
+	return true
+}
+
+func eventTime(v any) time.Time {
+	m, _ := v.(map[string]any)
+	if dt, _ := m["dateTime"].(string); dt != "" {
+		return parseTime(dt)
+	}
+	if d, _ := m["date"].(string); d != "" {
+		t, _ := time.Parse("2006-01-02", d)
+		return t
+	}
+	return time.Time{}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, reason string) {
+	body := map[string]any{"code": status, "message": http.StatusText(status)}
+	if reason != "" {
+		body["errors"] = []map[string]any{{"reason": reason, "message": http.StatusText(status)}}
+	}
+	writeJSON(w, status, map[string]any{"error": body})
+}
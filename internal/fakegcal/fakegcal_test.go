@@ -0,0 +1,235 @@
+package fakegcal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+type client struct {
+	t   *testing.T
+	srv *Server
+}
+
+func (c client) do(method, path string, body any, header ...string) (int, map[string]any) {
+	c.t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, c.srv.CalendarBaseURL()+path, reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+AccessToken)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+func eventsPath(query url.Values) string {
+	p := "/calendars/primary/events"
+	if len(query) > 0 {
+		p += "?" + query.Encode()
+	}
+	return p
+}
+
+func items(body map[string]any) []any {
+	list, _ := body["items"].([]any)
+	return list
+}
+
+func TestIncrementalSyncSeesChangesAndDeletes(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	c := client{t, srv}
+
+	code, created := c.do(http.MethodPost, eventsPath(nil), map[string]any{"summary": "one"})
+	if code != http.StatusOK || created["etag"] == "" || created["status"] != "confirmed" {
+		t.Fatalf("insert: %d %v", code, created)
+	}
+	id := created["id"].(string)
+
+	code, full := c.do(http.MethodGet, eventsPath(nil), nil)
+	if code != http.StatusOK || len(items(full)) != 1 {
+		t.Fatalf("full list: %d %v", code, full)
+	}
+	token := full["nextSyncToken"].(string)
+
+	code, body := c.do(http.MethodGet, eventsPath(url.Values{"syncToken": {token}}), nil)
+	if code != http.StatusOK || len(items(body)) != 0 {
+		t.Fatalf("no-change incremental: %d %v", code, body)
+	}
+
+	if code, _ := c.do(http.MethodDelete, eventsPath(nil)+"/"+id, nil); code != http.StatusNoContent {
+		t.Fatalf("delete: %d", code)
+	}
+	if code, _ := c.do(http.MethodDelete, eventsPath(nil)+"/"+id, nil); code != http.StatusGone {
+		t.Fatalf("second delete: %d, want 410", code)
+	}
+	code, body = c.do(http.MethodGet, eventsPath(url.Values{"syncToken": {token}}), nil)
+	got := items(body)
+	if code != http.StatusOK || len(got) != 1 || got[0].(map[string]any)["status"] != "cancelled" {
+		t.Fatalf("incremental after delete: %d %v", code, body)
+	}
+	if len(srv.Events(PrimaryCalendarID)) != 0 {
+		t.Fatal("deleted event still listed")
+	}
+}
+
+func TestPatchHonoursIfMatch(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	c := client{t, srv}
+	ev := srv.PutEvent("primary", Event{"summary": "before", "location": "here"})
+
+	code, _ := c.do(http.MethodPatch, eventsPath(nil)+"/"+ev.ID(), map[string]any{"summary": "stale"}, "If-Match", `"stale"`)
+	if code != http.StatusPreconditionFailed {
+		t.Fatalf("stale patch: %d, want 412", code)
+	}
+	code, patched := c.do(http.MethodPatch, eventsPath(nil)+"/"+ev.ID(), map[string]any{"summary": "after"}, "If-Match", ev.ETag())
+	if code != http.StatusOK || patched["summary"] != "after" || patched["location"] != "here" || patched["etag"] == ev.ETag() {
+		t.Fatalf("patch: %d %v", code, patched)
+	}
+	srv.PurgeEvent("primary", ev.ID())
+	if code, _ := c.do(http.MethodPatch, eventsPath(nil)+"/"+ev.ID(), map[string]any{"summary": "gone"}); code != http.StatusNotFound {
+		t.Fatalf("patch purged: %d, want 404", code)
+	}
+}
+
+func TestInsertWithExistingIDConflicts(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	c := client{t, srv}
+	if code, _ := c.do(http.MethodPost, eventsPath(nil), map[string]any{"id": "abc123", "summary": "x"}); code != http.StatusOK {
+		t.Fatalf("insert: %d", code)
+	}
+	if code, _ := c.do(http.MethodPost, eventsPath(nil), map[string]any{"id": "abc123", "summary": "x"}); code != http.StatusConflict {
+		t.Fatalf("duplicate insert: %d, want 409", code)
+	}
+	if code, _ := c.do(http.MethodPost, eventsPath(nil), map[string]any{"id": "not-base32hex", "summary": "x"}); code != http.StatusBadRequest {
+		t.Fatalf("invalid id: %d, want 400", code)
+	}
+}
+
+func TestRecurringInstancesBecomeExceptions(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	c := client{t, srv}
+	series := srv.PutEvent("primary", Event{
+		"summary":    "standup",
+		"start":      map[string]any{"dateTime": "2024-05-01T09:00:00Z"},
+		"end":        map[string]any{"dateTime": "2024-05-01T09:30:00Z"},
+		"recurrence": []any{"RRULE:FREQ=DAILY"},
+	})
+	instance := eventsPath(nil) + "/" + series.ID() + "_20240503T090000Z"
+
+	code, got := c.do(http.MethodGet, instance, nil)
+	if code != http.StatusOK || got["recurringEventId"] != series.ID() || got["recurrence"] != nil {
+		t.Fatalf("get instance: %d %v", code, got)
+	}
+	if len(srv.Events("primary")) != 1 {
+		t.Fatal("reading an instance stored it")
+	}
+	code, got = c.do(http.MethodPatch, instance, map[string]any{"summary": "moved"})
+	end, _ := got["end"].(map[string]any)
+	if code != http.StatusOK || got["summary"] != "moved" || end["dateTime"] != "2024-05-03T09:30:00Z" {
+		t.Fatalf("patch instance: %d %v", code, got)
+	}
+	if !srv.RemoveEvent("primary", series.ID()+"_20240504T090000Z") {
+		t.Fatal("remove instance failed")
+	}
+	if ev, ok := srv.Event("primary", series.ID()+"_20240504T090000Z"); !ok || ev.Status() != "cancelled" {
+		t.Fatalf("removed instance: %v", ev)
+	}
+	if code, _ := c.do(http.MethodGet, eventsPath(nil)+"/"+series.ID()+"_tomorrow", nil); code != http.StatusNotFound {
+		t.Fatalf("bad instance id: %d, want 404", code)
+	}
+}
+
+func TestExpiredSyncTokenIsGone(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	c := client{t, srv}
+	_, full := c.do(http.MethodGet, eventsPath(nil), nil)
+	srv.ExpireSyncTokens()
+	code, body := c.do(http.MethodGet, eventsPath(url.Values{"syncToken": {full["nextSyncToken"].(string)}}), nil)
+	if code != http.StatusGone {
+		t.Fatalf("expired token: %d %v, want 410", code, body)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	srv.PageSize = 2
+	for i := 0; i < 5; i++ {
+		srv.PutEvent("primary", Event{"summary": "e"})
+	}
+	c := client{t, srv}
+	var seen, pages int
+	query := url.Values{}
+	for {
+		code, body := c.do(http.MethodGet, eventsPath(query), nil)
+		if code != http.StatusOK {
+			t.Fatalf("page %d: %d", pages, code)
+		}
+		pages++
+		seen += len(items(body))
+		next, _ := body["nextPageToken"].(string)
+		if next == "" {
+			if body["nextSyncToken"] == nil {
+				t.Fatal("last page has no sync token")
+			}
+			break
+		}
+		if body["nextSyncToken"] != nil {
+			t.Fatal("sync token before the last page")
+		}
+		query.Set("pageToken", next)
+	}
+	if seen != 5 || pages != 3 {
+		t.Fatalf("saw %d events over %d pages", seen, pages)
+	}
+}
+
+func TestFailNextAndAuth(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	c := client{t, srv}
+	srv.FailNext(1, http.StatusForbidden, "rateLimitExceeded")
+	code, body := c.do(http.MethodGet, eventsPath(nil), nil)
+	if code != http.StatusForbidden {
+		t.Fatalf("injected failure: %d", code)
+	}
+	reason := body["error"].(map[string]any)["errors"].([]any)[0].(map[string]any)["reason"]
+	if reason != "rateLimitExceeded" {
+		t.Fatalf("reason = %v", reason)
+	}
+	if code, _ := c.do(http.MethodGet, eventsPath(nil), nil); code != http.StatusOK {
+		t.Fatalf("after failure: %d", code)
+	}
+
+	resp, err := http.Get(srv.CalendarBaseURL() + "/users/me/calendarList")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated: %d, want 401", resp.StatusCode)
+	}
+}
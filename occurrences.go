@@ -0,0 +1,510 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scopes of a change made from one occurrence of a recurring event.
+const (
+	// OccurrenceThis changes only that occurrence.
+	OccurrenceThis = "this"
+	// OccurrenceFollowing changes it and every later occurrence, splitting the series.
+	OccurrenceFollowing = "following"
+	// OccurrenceAll changes the whole series.
+	OccurrenceAll = "all"
+)
+
+// errNoOccurrence means an occurrence named by its series and original start does not
+// exist: the series is gone, does not repeat, or has no occurrence at that start.
+var errNoOccurrence = errors.New("no such occurrence")
+
+// seriesOccurrence is a recurring event with one of its occurrences picked out.
+type seriesOccurrence struct {
+	master CalendarEvent
+	lines  []string
+	// dtstart is the first occurrence and original the picked one's generated start,
+	// both in the time zone the recurrence is read in.
+	dtstart, original time.Time
+	allDay            bool
+}
+
+// UpdateOccurrence saves e, an edited occurrence of the recurring event masterID that
+// was generated to start at originalStart. With "this" the occurrence is overridden by
+// a row of its own; "following" ends the series before it and starts a new series from
+// e; "all" applies e to the series, moved by as much as the occurrence was. It returns
+// the row written.
+func (a *App) UpdateOccurrence(masterID, originalStart, scope string, e CalendarEvent) (CalendarEvent, error) {
+	occ, err := a.loadOccurrence(masterID, originalStart)
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	switch scope {
+	case OccurrenceThis:
+		return a.overrideOccurrence(occ, e)
+	case OccurrenceFollowing:
+		if occ.original.After(occ.dtstart) {
+			return a.splitOccurrences(occ, e)
+		}
+		return a.updateSeries(occ, e)
+	case OccurrenceAll:
+		return a.updateSeries(occ, e)
+	}
+	return CalendarEvent{}, fmt.Errorf("unknown occurrence scope: %s", scope)
+}
+
+// DeleteOccurrence deletes the occurrence of masterID generated to start at
+// originalStart: with "this" by an EXDATE on the series, with "following" by ending
+// the series before it, with "all" by deleting the series.
+func (a *App) DeleteOccurrence(masterID, originalStart, scope string) error {
+	occ, err := a.loadOccurrence(masterID, originalStart)
+	if err != nil {
+		return err
+	}
+	if scope == OccurrenceFollowing && !occ.original.After(occ.dtstart) {
+		scope = OccurrenceAll
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	switch scope {
+	case OccurrenceThis:
+		if err := rewriteSeries(tx, occ.master, append(occ.lines, exdateLine(occ.original, occ.allDay))); err != nil {
+			return err
+		}
+		err = removeExceptions(tx, occ.master.ID, occ.original.Equal)
+	case OccurrenceFollowing:
+		head, _, serr := splitSeries(occ.lines, occ.dtstart, occ.original, occ.allDay)
+		if serr != nil {
+			return serr
+		}
+		if err := rewriteSeries(tx, occ.master, head); err != nil {
+			return err
+		}
+		err = removeExceptions(tx, occ.master.ID, func(t time.Time) bool { return !t.Before(occ.original) })
+	case OccurrenceAll:
+		err = removeEvent(tx, occ.master.ID)
+	default:
+		return fmt.Errorf("unknown occurrence scope: %s", scope)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadOccurrence looks up the series masterID and its occurrence generated to start at
+// originalStart. masterID may also name a row overriding an occurrence of the series.
+func (a *App) loadOccurrence(masterID, originalStart string) (seriesOccurrence, error) {
+	master, err := a.getEvent(masterID)
+	if err == nil && master.RecurringEventID != "" {
+		master, err = a.getEvent(master.RecurringEventID)
+	}
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && master.SyncStatus == "deleted") {
+		return seriesOccurrence{}, fmt.Errorf("%w: event not found", errNoOccurrence)
+	}
+	if err != nil {
+		return seriesOccurrence{}, err
+	}
+	if master.SyncStatus == "subscribed" {
+		return seriesOccurrence{}, errReadOnlyEvent
+	}
+	original, err := time.Parse(time.RFC3339, originalStart)
+	if err != nil {
+		return seriesOccurrence{}, fmt.Errorf("invalid original start: %w", err)
+	}
+	occ := seriesOccurrence{master: master, lines: recurrenceLines(master), allDay: master.AllDay || master.Recurrence == "allday"}
+	if len(occ.lines) == 0 {
+		return seriesOccurrence{}, fmt.Errorf("%w: event does not repeat", errNoOccurrence)
+	}
+	start, _, err := parseEventTimes(master)
+	if err != nil {
+		return seriesOccurrence{}, err
+	}
+	loc := eventLocation(master)
+	if occ.allDay {
+		loc = time.UTC
+	}
+	occ.dtstart = start.In(loc)
+	occurrences, err := expandEvent(master, original, original.Add(time.Second))
+	if err != nil {
+		return seriesOccurrence{}, err
+	}
+	for _, o := range occurrences {
+		if o.start.Equal(original) {
+			occ.original = o.start
+			return occ, nil
+		}
+	}
+	return seriesOccurrence{}, fmt.Errorf("%w at %s", errNoOccurrence, originalStart)
+}
+
+// overrideOccurrence stores e as the row overriding occ, creating it on first use.
+func (a *App) overrideOccurrence(occ seriesOccurrence, e CalendarEvent) (CalendarEvent, error) {
+	key := originalStartKey(occ.original)
+	var existingID, existingStatus string
+	if err := a.db.QueryRow(`SELECT id, sync_status FROM events WHERE recurring_event_id = ? AND original_start = ?`, occ.master.ID, key).Scan(&existingID, &existingStatus); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return CalendarEvent{}, err
+	}
+	ex := withoutRecurrence(e)
+	ex.RecurringEventID = occ.master.ID
+	ex.OriginalStart = key
+	ex.AccountID = occ.master.AccountID
+	ex.GoogleCalendarID = occ.master.GoogleCalendarID
+	// e may carry the series' Google identity; the row gets its own when it is pushed.
+	ex.GoogleEventID, ex.GoogleETag, ex.GoogleUpdatedAt, ex.SyncStatus = "", "", "", ""
+	if existingID != "" {
+		ex.ID = existingID
+		if existingStatus != "cancelled" {
+			ex.SyncStatus = existingStatus
+		}
+		return a.UpdateEvent(ex)
+	}
+	ex.ID = occurrenceID(occ.master.ID, occ.original, occ.allDay)
+	return a.CreateEvent(ex)
+}
+
+// updateSeries applies e, edited from occurrence occ, to the whole series.
+func (a *App) updateSeries(occ seriesOccurrence, e CalendarEvent) (CalendarEvent, error) {
+	start, end, err := parseEventTimes(e)
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	masterStart, _, err := parseEventTimes(occ.master)
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	m := e
+	m.ID = occ.master.ID
+	if e.RecurringEventID != "" {
+		// Edited from a row overriding the occurrence, which does not repeat itself.
+		m.Recurrence, m.RecurrenceEx = occ.master.Recurrence, occ.master.RecurrenceEx
+	}
+	m.RecurringEventID, m.OriginalStart = "", ""
+	first := masterStart.Add(start.Sub(occ.original))
+	m.Start = first.Format(time.RFC3339)
+	m.End = first.Add(end.Sub(start)).Format(time.RFC3339)
+	m.AccountID, m.GoogleCalendarID = occ.master.AccountID, occ.master.GoogleCalendarID
+	m.GoogleEventID, m.GoogleETag, m.GoogleUpdatedAt = occ.master.GoogleEventID, occ.master.GoogleETag, occ.master.GoogleUpdatedAt
+	m.SyncStatus = occ.master.SyncStatus
+	return a.UpdateEvent(m)
+}
+
+// splitOccurrences ends the series before occ and starts a new series from e. Unless e
+// picks a different repeat, the new series carries on the old one's recurrence.
+func (a *App) splitOccurrences(occ seriesOccurrence, e CalendarEvent) (CalendarEvent, error) {
+	head, tail, err := splitSeries(occ.lines, occ.dtstart, occ.original, occ.allDay)
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	next := e
+	if e.RecurringEventID != "" || sameRules(recurrenceLines(e), occ.lines) {
+		next.Recurrence, next.RecurrenceEx = "rrule", strings.Join(tail, "\n")
+		if len(tail) == 0 {
+			next = withoutRecurrence(next)
+		}
+	}
+	next.ID = ""
+	next.RecurringEventID, next.OriginalStart = "", ""
+	next.AccountID, next.GoogleCalendarID = occ.master.AccountID, occ.master.GoogleCalendarID
+	next.GoogleEventID, next.GoogleETag, next.GoogleUpdatedAt, next.SyncStatus = "", "", "", ""
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	defer tx.Rollback()
+	if err := rewriteSeries(tx, occ.master, head); err != nil {
+		return CalendarEvent{}, err
+	}
+	if err := removeExceptions(tx, occ.master.ID, func(t time.Time) bool { return !t.Before(occ.original) }); err != nil {
+		return CalendarEvent{}, err
+	}
+	next, err = insertEvent(tx, next)
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	return next, tx.Commit()
+}
+
+// sameRules reports whether two recurrences repeat by the same RRULEs, whatever dates
+// they add or exclude.
+func sameRules(a, b []string) bool {
+	rules := func(lines []string) []string {
+		var out []string
+		for _, line := range lines {
+			if strings.HasPrefix(strings.ToUpper(line), "RRULE:") {
+				out = append(out, line)
+			}
+		}
+		return out
+	}
+	return slices.Equal(rules(a), rules(b))
+}
+
+// rewriteSeries replaces the recurrence of master with lines.
+func rewriteSeries(tx *sql.Tx, master CalendarEvent, lines []string) error {
+	master.Recurrence = "rrule"
+	master.RecurrenceEx = strings.Join(lines, "\n")
+	_, err := updateEventRow(tx, master)
+	return err
+}
+
+// removeExceptions deletes the rows overriding occurrences of masterID whose original
+// start satisfies match.
+func removeExceptions(tx *sql.Tx, masterID string, match func(time.Time) bool) error {
+	rows, err := tx.Query(`SELECT id, COALESCE(original_start,'') FROM events WHERE recurring_event_id = ?`, masterID)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id, original string
+		if err := rows.Scan(&id, &original); err != nil {
+			rows.Close()
+			return err
+		}
+		if t, err := time.Parse(time.RFC3339, original); err != nil || match(t) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := removeEvent(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteExceptions drops the rows overriding occurrences of masterID, with their
+// journaled changes, when the series itself goes.
+func deleteExceptions(tx *sql.Tx, masterID string) error {
+	if _, err := tx.Exec(`DELETE FROM outbox WHERE event_id IN (SELECT id FROM events WHERE recurring_event_id = ?)`, masterID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM events WHERE recurring_event_id = ?`, masterID)
+	return err
+}
+
+// overriddenOccurrences maps series row IDs to the original starts, in Unix seconds, of
+// their occurrences that other rows override.
+func (a *App) overriddenOccurrences() (map[string]map[int64]bool, error) {
+	rows, err := a.db.Query(`SELECT recurring_event_id, COALESCE(original_start,'') FROM events WHERE COALESCE(recurring_event_id,'') != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]map[int64]bool)
+	for rows.Next() {
+		var masterID, original string
+		if err := rows.Scan(&masterID, &original); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(time.RFC3339, original)
+		if err != nil {
+			continue
+		}
+		if out[masterID] == nil {
+			out[masterID] = make(map[int64]bool)
+		}
+		out[masterID][t.Unix()] = true
+	}
+	return out, rows.Err()
+}
+
+// originalStartKey formats an original start as stored in original_start: RFC 3339 in
+// UTC, which all-day occurrences already are.
+func originalStartKey(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// googleInstanceID returns the Google ID of the instance a row overriding an occurrence
+// is written onto, or false while the series has no Google ID yet.
+func (a *App) googleInstanceID(e CalendarEvent) (string, bool, error) {
+	master, err := a.getEvent(e.RecurringEventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil || master.GoogleEventID == "" {
+		return "", false, err
+	}
+	original, err := time.Parse(time.RFC3339, e.OriginalStart)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid original start of %s: %w", e.ID, err)
+	}
+	return occurrenceID(master.GoogleEventID, original, master.AllDay || master.Recurrence == "allday"), true, nil
+}
+
+// applyGoogleOccurrence stores an exception instance of a Google series as the row
+// overriding that occurrence of the series' row. A cancelled instance is kept as a
+// 'cancelled' row, which only hides the occurrence. Exceptions of series that are not
+// stored here are skipped.
+func (a *App) applyGoogleOccurrence(provider, accountID, calendarID string, ge GoogleEvent, existingID string) error {
+	var masterID, masterStatus string
+	err := a.db.QueryRow(`SELECT id, sync_status FROM events WHERE remote_id = ? AND google_calendar_id = ? AND account_id = ? LIMIT 1`, ge.RecurringEventID, calendarID, accountID).Scan(&masterID, &masterStatus)
+	if errors.Is(err, sql.ErrNoRows) || masterStatus == "deleted" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	original, err := googleOriginalStart(ge)
+	if err != nil {
+		return fmt.Errorf("google event %s: %w", ge.ID, err)
+	}
+	eventID := existingID
+	if eventID == "" {
+		if eventID, err = a.localGoogleEventID(accountID, calendarID, ge.ID); err != nil {
+			return err
+		}
+	}
+	e := googleToCalendar(accountID, calendarID, ge)
+	e.Provider = provider
+	e.RecurringEventID = masterID
+	e.OriginalStart = original
+	if ge.Status == "cancelled" {
+		return storeCancelledOccurrence(a.db, eventID, e)
+	}
+	if e.Start == "" || e.End == "" {
+		return fmt.Errorf("google event missing time: %s", ge.ID)
+	}
+	if err := a.resolveDefaultAlert(&e); err != nil {
+		return err
+	}
+	return storeRemoteEvent(a.db, eventID, e)
+}
+
+// storeCancelledOccurrence marks the row eventID as a cancelled occurrence of its series.
+func storeCancelledOccurrence(db execer, eventID string, e CalendarEvent) error {
+	original, err := time.Parse(time.RFC3339, e.OriginalStart)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM outbox WHERE event_id = ?`, eventID); err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO events (id, title, all_day, start, end, sync_status, provider, account_id, remote_id, google_calendar_id, remote_etag, google_updated_at, recurring_event_id, original_start, updated_at)
+		VALUES (?, ?, 0, ?, ?, 'cancelled', ?, ?, ?, ?, ?, NULLIF(?,''), ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(id) DO UPDATE SET
+			sync_status='cancelled',
+			provider=COALESCE(NULLIF(excluded.provider,''), events.provider),
+			remote_etag=excluded.remote_etag,
+			google_updated_at=excluded.google_updated_at,
+			recurring_event_id=excluded.recurring_event_id,
+			original_start=excluded.original_start,
+			updated_at=excluded.updated_at
+	`, eventID, e.Title, original, original, e.Provider, e.AccountID, e.GoogleEventID, e.GoogleCalendarID, e.GoogleETag, e.GoogleUpdatedAt, e.RecurringEventID, e.OriginalStart)
+	return err
+}
+
+// googleOriginalStart returns the original start of an exception instance as stored in
+// original_start.
+func googleOriginalStart(ge GoogleEvent) (string, error) {
+	if ge.OriginalStartTime == nil {
+		return "", errors.New("exception without originalStartTime")
+	}
+	if d := ge.OriginalStartTime.Date; d != "" {
+		t, err := time.Parse("2006-01-02", d)
+		if err != nil {
+			return "", fmt.Errorf("invalid originalStartTime: %w", err)
+		}
+		return originalStartKey(t), nil
+	}
+	t, err := time.Parse(time.RFC3339, ge.OriginalStartTime.DateTime)
+	if err != nil {
+		return "", fmt.Errorf("invalid originalStartTime: %w", err)
+	}
+	return originalStartKey(t), nil
+}
+
+// flattenedInstancesKey marks in sync_state that migrateFlattenedInstances has run.
+const flattenedInstancesKey = "flattened_instances_migrated"
+
+// migrateFlattenedInstances drops the rows full syncs used to store for each instance
+// of a Google series, which the series' row now expands into. Google only puts "_" in
+// the IDs of instances. Rows with unpushed changes stay and are linked to their series
+// when it is pulled. Every sync token is reset so the next sync fetches the series.
+func migrateFlattenedInstances(db *sql.DB) error {
+	var done int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sync_state WHERE key = ?`, flattenedInstancesKey).Scan(&done); err != nil {
+		return err
+	}
+	if done > 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	flattened := `sync_status = 'synced' AND COALESCE(recurring_event_id,'') = '' AND remote_id LIKE '%\_%' ESCAPE '\'`
+	if _, err := tx.Exec(`DELETE FROM outbox WHERE event_id IN (SELECT id FROM events WHERE ` + flattened + `)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM events WHERE ` + flattened); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE calendar_sync_state SET sync_token = ''`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO sync_state (key, value) VALUES (?, '1')`, flattenedInstancesKey); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// linkFlattenedInstances makes the rows left from flattened instances of the Google
+// series seriesGoogleID override their occurrences of the series' row masterID.
+func (a *App) linkFlattenedInstances(accountID, calendarID, masterID, seriesGoogleID string) error {
+	rows, err := a.db.Query(`SELECT id, remote_id FROM events
+		WHERE account_id = ? AND google_calendar_id = ? AND remote_id LIKE ? ESCAPE '\' AND COALESCE(recurring_event_id,'') = ''`,
+		accountID, calendarID, seriesGoogleID+`\_%`)
+	if err != nil {
+		return err
+	}
+	instances := make(map[string]string)
+	for rows.Next() {
+		var id, googleID string
+		if err := rows.Scan(&id, &googleID); err != nil {
+			rows.Close()
+			return err
+		}
+		instances[id] = googleID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, googleID := range instances {
+		original, ok := instanceOriginalStart(strings.TrimPrefix(googleID, seriesGoogleID+"_"))
+		if !ok {
+			continue
+		}
+		if _, err := a.db.Exec(`UPDATE events SET recurring_event_id = ?, original_start = ? WHERE id = ?`, masterID, originalStartKey(original), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// instanceOriginalStart parses the suffix of a Google instance ID, the original start
+// occurrenceID puts there.
+func instanceOriginalStart(suffix string) (time.Time, bool) {
+	for _, layout := range []string{"20060102T150405Z", "20060102"} {
+		if t, err := time.Parse(layout, suffix); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
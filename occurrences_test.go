@@ -0,0 +1,306 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"myapp/internal/fakegcal"
+)
+
+func TestSplitSeries(t *testing.T) {
+	tests := []struct {
+		name       string
+		lines      []string
+		dtstart    string
+		split      string
+		allDay     bool
+		head, tail []string
+	}{
+		{
+			name:    "open-ended",
+			lines:   []string{"RRULE:FREQ=WEEKLY;BYDAY=MO,WE"},
+			dtstart: "2024-05-01T09:00:00Z",
+			split:   "2024-05-13T09:00:00Z",
+			head:    []string{"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20240513T085959Z"},
+			tail:    []string{"RRULE:FREQ=WEEKLY;BYDAY=MO,WE"},
+		},
+		{
+			name:    "count carries over",
+			lines:   []string{"RRULE:FREQ=DAILY;COUNT=10", "EXDATE:20240502T090000Z,20240507T090000Z", "RDATE:20240520T090000Z"},
+			dtstart: "2024-05-01T09:00:00Z",
+			split:   "2024-05-04T09:00:00Z",
+			head:    []string{"RRULE:FREQ=DAILY;UNTIL=20240504T085959Z", "EXDATE:20240502T090000Z"},
+			tail:    []string{"RRULE:FREQ=DAILY;COUNT=7", "EXDATE:20240507T090000Z", "RDATE:20240520T090000Z"},
+		},
+		{
+			name:    "rule already over",
+			lines:   []string{"RRULE:FREQ=DAILY;UNTIL=20240503T090000Z", "RDATE:20240510T090000Z"},
+			dtstart: "2024-05-01T09:00:00Z",
+			split:   "2024-05-10T09:00:00Z",
+			head:    []string{"RRULE:FREQ=DAILY;UNTIL=20240503T090000Z"},
+			tail:    []string{"RDATE:20240510T090000Z"},
+		},
+		{
+			name:    "all-day",
+			lines:   []string{"RRULE:FREQ=YEARLY"},
+			dtstart: "2020-03-01T00:00:00Z",
+			split:   "2024-03-01T00:00:00Z",
+			allDay:  true,
+			head:    []string{"RRULE:FREQ=YEARLY;UNTIL=20240229"},
+			tail:    []string{"RRULE:FREQ=YEARLY"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			head, tail, err := splitSeries(tt.lines, mustTime(t, tt.dtstart), mustTime(t, tt.split), tt.allDay)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(head, tt.head) || !reflect.DeepEqual(tail, tt.tail) {
+				t.Fatalf("head %q, tail %q; want %q, %q", head, tail, tt.head, tt.tail)
+			}
+		})
+	}
+}
+
+// occurrenceList renders the occurrences in May 2024 as "title@start" entries.
+func occurrenceList(t *testing.T, a *App) string {
+	t.Helper()
+	got, err := a.ListOccurrences("2024-05-01T00:00:00Z", "2024-06-01T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, o := range got {
+		out = append(out, o.Title+"@"+o.Start[5:16])
+	}
+	return strings.Join(out, " ")
+}
+
+func newStandup(t *testing.T, a *App) CalendarEvent {
+	t.Helper()
+	e, err := a.CreateEvent(CalendarEvent{Title: "standup", Start: "2024-05-01T09:00:00Z", End: "2024-05-01T09:30:00Z", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=DAILY;COUNT=5", Alert: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEditSingleOccurrences(t *testing.T) {
+	a := NewApp()
+	if err := a.openDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+	master := newStandup(t, a)
+
+	edited := master
+	edited.Title, edited.Start, edited.End = "late standup", "2024-05-03T11:00:00Z", "2024-05-03T11:30:00Z"
+	ex, err := a.UpdateOccurrence(master.ID, "2024-05-03T09:00:00Z", OccurrenceThis, edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.RecurringEventID != master.ID || ex.OriginalStart != "2024-05-03T09:00:00Z" || ex.Recurrence != "none" {
+		t.Fatalf("exception %+v", ex)
+	}
+	if err := a.DeleteOccurrence(master.ID, "2024-05-02T09:00:00Z", OccurrenceThis); err != nil {
+		t.Fatal(err)
+	}
+	want := "standup@05-01T09:00 late standup@05-03T11:00 standup@05-04T09:00 standup@05-05T09:00"
+	if got := occurrenceList(t, a); got != want {
+		t.Fatalf("after editing one occurrence:\n got %s\nwant %s", got, want)
+	}
+	occurrences, _ := a.ListOccurrences("2024-05-03", "2024-05-04")
+	if len(occurrences) != 1 || occurrences[0].MasterID != master.ID || occurrences[0].OriginalStart != "2024-05-03T09:00:00Z" {
+		t.Fatalf("exception occurrence %+v", occurrences)
+	}
+
+	// Editing the exception again updates the same row.
+	ex.Title = "later standup"
+	if _, err := a.UpdateOccurrence(ex.ID, ex.OriginalStart, OccurrenceThis, ex); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events WHERE recurring_event_id = ?`, master.ID); n != 1 {
+		t.Fatalf("%d exception rows", n)
+	}
+
+	renamed := master
+	renamed.Title, renamed.Start, renamed.End = "sync", "2024-05-04T10:00:00Z", "2024-05-04T10:30:00Z"
+	next, err := a.UpdateOccurrence(master.ID, "2024-05-04T09:00:00Z", OccurrenceFollowing, renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID == master.ID || next.RecurrenceEx != "RRULE:FREQ=DAILY;COUNT=2" {
+		t.Fatalf("new series %+v", next)
+	}
+	want = "standup@05-01T09:00 later standup@05-03T11:00 sync@05-04T10:00 sync@05-05T10:00"
+	if got := occurrenceList(t, a); got != want {
+		t.Fatalf("after splitting:\n got %s\nwant %s", got, want)
+	}
+
+	// Moving the whole series by an hour from one occurrence moves every occurrence.
+	moved := next
+	moved.Start, moved.End = "2024-05-05T11:00:00Z", "2024-05-05T11:30:00Z"
+	if _, err := a.UpdateOccurrence(next.ID, "2024-05-05T10:00:00Z", OccurrenceAll, moved); err != nil {
+		t.Fatal(err)
+	}
+	want = "standup@05-01T09:00 later standup@05-03T11:00 sync@05-04T11:00 sync@05-05T11:00"
+	if got := occurrenceList(t, a); got != want {
+		t.Fatalf("after moving the series:\n got %s\nwant %s", got, want)
+	}
+
+	// Deleting the exception row deletes its occurrence from the series.
+	if err := a.DeleteEvent(ex.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.DeleteOccurrence(next.ID, "2024-05-05T11:00:00Z", OccurrenceFollowing); err != nil {
+		t.Fatal(err)
+	}
+	want = "standup@05-01T09:00 sync@05-04T11:00"
+	if got := occurrenceList(t, a); got != want {
+		t.Fatalf("after deleting:\n got %s\nwant %s", got, want)
+	}
+	if err := a.DeleteOccurrence(master.ID, "2024-05-02T09:00:00Z", OccurrenceThis); err == nil {
+		t.Fatal("deleted an occurrence that was already gone")
+	}
+	if err := a.DeleteOccurrence(master.ID, "2024-05-01T09:00:00Z", OccurrenceFollowing); err != nil {
+		t.Fatal(err)
+	}
+	if got := occurrenceList(t, a); got != "sync@05-04T11:00" {
+		t.Fatalf("after deleting the series: %s", got)
+	}
+}
+
+func TestOccurrenceExceptionsSync(t *testing.T) {
+	srv := fakegcal.New()
+	defer srv.Close()
+	a := newE2EApp(t, srv)
+	master := newStandup(t, a)
+
+	// Local exceptions go to the instances of the Google series, once it is there.
+	edited := master
+	edited.Title, edited.Start, edited.End = "standup (room 2)", "2024-05-03T09:00:00Z", "2024-05-03T09:30:00Z"
+	if _, err := a.UpdateOccurrence(master.ID, "2024-05-03T09:00:00Z", OccurrenceThis, edited); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.DeleteOccurrence(master.ID, "2024-05-02T09:00:00Z", OccurrenceThis); err != nil {
+		t.Fatal(err)
+	}
+	mustSync(t, a)
+	master, err := a.getEvent(master.ID)
+	if err != nil || master.GoogleEventID == "" {
+		t.Fatalf("series not pushed: %+v, %v", master, err)
+	}
+	instance, ok := srv.Event(fakegcal.PrimaryCalendarID, master.GoogleEventID+"_20240503T090000Z")
+	if !ok || instance.Summary() != "standup (room 2)" || instance["recurringEventId"] != master.GoogleEventID {
+		t.Fatalf("remote instance %v", instance)
+	}
+	series, _ := srv.Event(fakegcal.PrimaryCalendarID, master.GoogleEventID)
+	if !reflect.DeepEqual(series["recurrence"], []any{"RRULE:FREQ=DAILY;COUNT=5", "EXDATE:20240502T090000Z"}) {
+		t.Fatalf("remote recurrence %v", series["recurrence"])
+	}
+	if row := eventRows(t, a)["standup (room 2)"]; row.SyncStatus != "synced" || row.GoogleEventID != instance.ID() {
+		t.Fatalf("exception row %+v", row)
+	}
+
+	// Exceptions made in Google come back as rows overriding the occurrence.
+	srv.RemoveEvent(fakegcal.PrimaryCalendarID, master.GoogleEventID+"_20240505T090000Z")
+	srv.PutEvent(fakegcal.PrimaryCalendarID, fakegcal.Event{
+		"id":                master.GoogleEventID + "_20240504T090000Z",
+		"summary":           "standup (moved)",
+		"recurringEventId":  master.GoogleEventID,
+		"originalStartTime": map[string]any{"dateTime": "2024-05-04T09:00:00Z"},
+		"start":             map[string]any{"dateTime": "2024-05-04T13:00:00Z"},
+		"end":               map[string]any{"dateTime": "2024-05-04T13:30:00Z"},
+	})
+	mustSync(t, a)
+	want := "standup@05-01T09:00 standup (room 2)@05-03T09:00 standup (moved)@05-04T13:00"
+	if got := occurrenceList(t, a); got != want {
+		t.Fatalf("after pulling exceptions:\n got %s\nwant %s", got, want)
+	}
+	if ops := pendingOps(t, a); len(ops) != 0 {
+		t.Fatalf("pending after pull: %v", ops)
+	}
+
+	// Deleting the series takes its exceptions along.
+	if err := a.DeleteOccurrence(master.ID, "2024-05-01T09:00:00Z", OccurrenceAll); err != nil {
+		t.Fatal(err)
+	}
+	mustSync(t, a)
+	if got := occurrenceList(t, a); got != "" {
+		t.Fatalf("occurrences left: %s", got)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events`); n != 0 {
+		t.Fatalf("%d rows left", n)
+	}
+}
+
+func TestSyncSeriesAsMasters(t *testing.T) {
+	srv := fakegcal.New()
+	defer srv.Close()
+	a := newE2EApp(t, srv)
+	series := srv.PutEvent(fakegcal.PrimaryCalendarID, fakegcal.Event{
+		"summary":    "standup",
+		"start":      map[string]any{"dateTime": "2024-05-01T09:00:00Z"},
+		"end":        map[string]any{"dateTime": "2024-05-01T09:30:00Z"},
+		"recurrence": []any{"RRULE:FREQ=DAILY;COUNT=5"},
+	})
+	mustSync(t, a)
+	for _, r := range srv.Requests() {
+		if r.Query.Has("singleEvents") {
+			t.Fatalf("sync asked for single events: %+v", r)
+		}
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events`); n != 1 {
+		t.Fatalf("%d rows for one series", n)
+	}
+	want := "standup@05-01T09:00 standup@05-02T09:00 standup@05-03T09:00 standup@05-04T09:00 standup@05-05T09:00"
+	if got := occurrenceList(t, a); got != want {
+		t.Fatalf("occurrences:\n got %s\nwant %s", got, want)
+	}
+
+	// Rows an older full sync stored per instance give way to the series on upgrade;
+	// an instance edited locally since stays and becomes an exception.
+	var calendarID string
+	if err := a.db.QueryRow(`SELECT google_calendar_id FROM events`).Scan(&calendarID); err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, a, `DELETE FROM events`)
+	mustExec(t, a, `DELETE FROM sync_state WHERE key = ?`, flattenedInstancesKey)
+	for _, day := range []string{"01", "02", "03"} {
+		title, status := "standup", "synced"
+		if day == "03" {
+			title, status = "standup (local)", "dirty"
+		}
+		start := "2024-05-" + day + "T09:00:00Z"
+		mustExec(t, a, `INSERT INTO events (id, title, all_day, start, end, sync_status, account_id, remote_id, google_calendar_id) VALUES (?, ?, 0, ?, ?, ?, ?, ?, ?)`,
+			"flat"+day, title, start, start[:11]+"09:30:00Z", status, defaultAccountID, series.ID()+"_202405"+day+"T090000Z", calendarID)
+		if status == "dirty" {
+			mustExec(t, a, `INSERT INTO outbox (event_id, op, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)`, "flat"+day, OutboxUpdate)
+		}
+	}
+	if err := migrateFlattenedInstances(a.db); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events`); n != 1 {
+		t.Fatalf("%d rows left after the migration", n)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM calendar_sync_state WHERE sync_token != ''`); n != 0 {
+		t.Fatalf("%d sync tokens kept", n)
+	}
+	result := mustSync(t, a)
+	if !result.FullSync {
+		t.Fatalf("sync after the migration was incremental: %+v", result)
+	}
+	want = "standup@05-01T09:00 standup@05-02T09:00 standup (local)@05-03T09:00 standup@05-04T09:00 standup@05-05T09:00"
+	if got := occurrenceList(t, a); got != want {
+		t.Fatalf("after migrating:\n got %s\nwant %s", got, want)
+	}
+	if instance, ok := srv.Event(fakegcal.PrimaryCalendarID, series.ID()+"_20240503T090000Z"); !ok || instance.Summary() != "standup (local)" {
+		t.Fatalf("remote instance %v", instance)
+	}
+	if ops := pendingOps(t, a); len(ops) != 0 {
+		t.Fatalf("pending after sync: %v", ops)
+	}
+}
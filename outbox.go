@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Operations recorded in the outbox.
+const (
+	OutboxCreate = "create"
+	OutboxUpdate = "update"
+	OutboxDelete = "delete"
+)
+
+// PendingChange is a local change that has not reached Google yet.
+type PendingChange struct {
+	ID            int64  `json:"id"`
+	EventID       string `json:"eventId"`
+	Title         string `json:"title"`
+	Op            string `json:"op"`
+	CreatedAt     string `json:"createdAt"`
+	Attempts      int    `json:"attempts"`
+	LastError     string `json:"lastError,omitempty"`
+	LastAttemptAt string `json:"lastAttemptAt,omitempty"`
+}
+
+// ensureOutboxTable creates the outbox, journaling rows that were already waiting to be
+// pushed when it is first created.
+func ensureOutboxTable(db *sql.DB) error {
+	columns, err := tableColumns(db, "outbox")
+	if err != nil {
+		return err
+	}
+	if len(columns) > 0 {
+		return nil
+	}
+	schema := `
+	CREATE TABLE outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id TEXT NOT NULL,
+		op TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT,
+		last_attempt_at TIMESTAMP
+	);
+	CREATE INDEX outbox_event ON outbox (event_id, id);
+	`
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(schema); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO outbox (event_id, op, created_at)
+		SELECT id, CASE
+			WHEN sync_status = 'deleted' THEN 'delete'
+			WHEN COALESCE(remote_id,'') = '' THEN 'create'
+			ELSE 'update' END, ?
+		FROM events WHERE sync_status IN ('new','dirty','deleted','local')
+		ORDER BY updated_at
+	`, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appendOutbox journals a change to eventID; call it in the transaction that makes it.
+func appendOutbox(tx *sql.Tx, eventID, op string) error {
+	_, err := tx.Exec(`INSERT INTO outbox (event_id, op, created_at) VALUES (?, ?, ?)`, eventID, op, time.Now())
+	return err
+}
+
+// outboxOpFor returns the operation a write leaving e in its sync status represents,
+// or "" when the status is not one the push picks up.
+func outboxOpFor(e CalendarEvent) string {
+	switch e.SyncStatus {
+	case "deleted":
+		return OutboxDelete
+	case "new", "dirty", "local":
+		if e.GoogleEventID == "" {
+			return OutboxCreate
+		}
+		return OutboxUpdate
+	}
+	return ""
+}
+
+// ListPendingChanges returns the journaled changes not yet pushed, oldest first.
+func (a *App) ListPendingChanges() ([]PendingChange, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	rows, err := a.db.Query(`
+		SELECT o.id, o.event_id, COALESCE(e.title,''), o.op, o.created_at, o.attempts, COALESCE(o.last_error,''), o.last_attempt_at
+		FROM outbox o LEFT JOIN events e ON e.id = o.event_id
+		ORDER BY o.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []PendingChange
+	for rows.Next() {
+		var c PendingChange
+		var createdAt time.Time
+		var lastAttempt sql.NullTime
+		if err := rows.Scan(&c.ID, &c.EventID, &c.Title, &c.Op, &createdAt, &c.Attempts, &c.LastError, &lastAttempt); err != nil {
+			return nil, err
+		}
+		c.CreatedAt = createdAt.Format(time.RFC3339)
+		if lastAttempt.Valid {
+			c.LastAttemptAt = lastAttempt.Time.Format(time.RFC3339)
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// recordPushAttempt notes a failed push on the entries of eventID up to upto.
+func (a *App) recordPushAttempt(eventID string, upto int64, pushErr error) error {
+	_, err := a.db.Exec(`UPDATE outbox SET attempts = attempts + 1, last_error = ?, last_attempt_at = ? WHERE event_id = ? AND id <= ?`, pushErr.Error(), time.Now(), eventID, upto)
+	return err
+}
+
+// finishPush stores the Google identity of a row that was just written to Google and
+// drops the outbox entries up to upto, which that write covered. The row is marked synced
+// unless it changed again in the meantime. It reports false if the row is gone, i.e. it
+// was deleted while the write was in flight.
+func (a *App) finishPush(id string, upto int64, provider, accountID, calendarID string, r GoogleEvent) (bool, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`UPDATE events SET provider=?, account_id=?, remote_id=?, google_calendar_id=?, remote_etag=?, google_updated_at=NULLIF(?,''),
+		sync_status = CASE WHEN EXISTS (SELECT 1 FROM outbox WHERE event_id = events.id AND id > ?) THEN sync_status ELSE 'synced' END
+		WHERE id=?`, provider, accountID, r.ID, calendarID, r.Etag, r.Updated, upto, id)
+	if err != nil {
+		return false, fmt.Errorf("record pushed event %s: %w", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM outbox WHERE event_id = ? AND id <= ?`, id, upto); err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+// refreshSeriesETag gives etag, which a write of e left its series' resource with, to the
+// other rows of the series that held the ETag the write replaced: e's own, or for an
+// occurrence first written now, the series'. Rows holding an older one missed a remote
+// change, and keep it so that their writes still conflict.
+func (a *App) refreshSeriesETag(e CalendarEvent, etag string) error {
+	seriesID, replaced := firstNonEmpty(e.RecurringEventID, e.ID), e.GoogleETag
+	if replaced == "" {
+		if err := a.db.QueryRow(`SELECT COALESCE(remote_etag,'') FROM events WHERE id = ?`, seriesID).Scan(&replaced); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	if replaced == "" {
+		return nil
+	}
+	_, err := a.db.Exec(`UPDATE events SET remote_etag = ?
+		WHERE (id = ? OR recurring_event_id = ?) AND id <> ? AND COALESCE(remote_id,'') <> '' AND remote_etag = ?`,
+		etag, seriesID, seriesID, e.ID, replaced)
+	return err
+}
+
+// finishDelete removes a row whose deletion reached Google, with its outbox entries and
+// the rows overriding its occurrences.
+func (a *App) finishDelete(id string) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := deleteExceptions(tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM events WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM outbox WHERE event_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
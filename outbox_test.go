@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"myapp/internal/fakegcal"
+)
+
+func pendingOps(t *testing.T, a *App) []string {
+	t.Helper()
+	changes, err := a.ListPendingChanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, c := range changes {
+		ops = append(ops, c.Op+" "+c.Title)
+	}
+	return ops
+}
+
+func TestOutboxJournalsLocalEdits(t *testing.T) {
+	srv := fakegcal.New()
+	defer srv.Close()
+	a := newE2EApp(t, srv)
+
+	e, err := a.CreateEvent(localEvent("draft"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Title = "final"
+	if _, err := a.UpdateEvent(e); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(pendingOps(t, a), ", "); got != "create final, create final" {
+		t.Fatalf("pending = %q", got)
+	}
+
+	mustSync(t, a)
+	if ops := pendingOps(t, a); len(ops) != 0 {
+		t.Fatalf("pending after sync: %v", ops)
+	}
+	if err := a.DeleteEvent(e.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(pendingOps(t, a), ", "); got != "delete final" {
+		t.Fatalf("pending = %q", got)
+	}
+	mustSync(t, a)
+	if ops := pendingOps(t, a); len(ops) != 0 {
+		t.Fatalf("pending after delete: %v", ops)
+	}
+
+	// A local-only event deleted before it was pushed leaves nothing behind.
+	unsent, err := a.CreateEvent(localEvent("unsent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.DeleteEvent(unsent.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ops := pendingOps(t, a); len(ops) != 0 {
+		t.Fatalf("pending after local delete: %v", ops)
+	}
+}
+
+func TestOutboxRecordsFailedAttempts(t *testing.T) {
+	srv := fakegcal.New()
+	defer srv.Close()
+	a := newE2EApp(t, srv)
+	mustSync(t, a)
+
+	if _, err := a.CreateEvent(localEvent("flaky")); err != nil {
+		t.Fatal(err)
+	}
+	srv.FailNext(defaultRetryPolicy.MaxAttempts, http.StatusServiceUnavailable, "")
+	if _, err := a.GooglePush(); err == nil {
+		t.Fatal("push succeeded despite the outage")
+	}
+	changes, err := a.ListPendingChanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Attempts != 1 || !strings.Contains(changes[0].LastError, "503") || changes[0].LastAttemptAt == "" {
+		t.Fatalf("pending = %+v", changes)
+	}
+
+	if _, err := a.GooglePush(); err != nil {
+		t.Fatal(err)
+	}
+	if ops := pendingOps(t, a); len(ops) != 0 {
+		t.Fatalf("pending after retry: %v", ops)
+	}
+}
+
+// loadPending reads the pending push of one row the way pushLocalChanges does.
+func loadPending(t *testing.T, a *App, id string) pendingPush {
+	t.Helper()
+	e, err := a.getEvent(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pendingPush{CalendarEvent: e}
+	if err := a.db.QueryRow(`SELECT start, end, (SELECT COALESCE(MAX(id), 0) FROM outbox WHERE event_id = events.id) FROM events WHERE id = ?`, id).Scan(&p.start, &p.end, &p.upto); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func primaryCalendar(t *testing.T, a *App) CalendarInfo {
+	t.Helper()
+	calendars, err := a.loadCalendars(defaultAccountID, true)
+	if err != nil || len(calendars) != 1 {
+		t.Fatalf("calendars: %+v, %v", calendars, err)
+	}
+	return calendars[0]
+}
+
+func TestPushKeepsEditsMadeWhileInFlight(t *testing.T) {
+	srv := fakegcal.New()
+	defer srv.Close()
+	a := newE2EApp(t, srv)
+	mustSync(t, a)
+	svc, err := a.googleForAccount(defaultAccountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := a.CreateEvent(localEvent("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := loadPending(t, a, e.ID)
+	// The user edits the event after the push read it.
+	e.Title = "v2"
+	if _, err := a.UpdateEvent(e); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := a.pushEvent(context.Background(), svc, primaryCalendar(t, a), p); !ok || err != nil {
+		t.Fatalf("push: %v, %v", ok, err)
+	}
+	row := eventRows(t, a)["v2"]
+	if row.SyncStatus == "synced" || row.GoogleEventID == "" {
+		t.Fatalf("row %+v, want still pending with its Google ID", row)
+	}
+	if ops := pendingOps(t, a); len(ops) != 1 {
+		t.Fatalf("pending = %v, want the later edit only", ops)
+	}
+
+	mustSync(t, a)
+	remote := remoteSummaries(srv)
+	if len(remote) != 1 || remote["v2"] == nil || eventRows(t, a)["v2"].SyncStatus != "synced" {
+		t.Fatalf("remote %v, rows %+v", remote, eventRows(t, a))
+	}
+}
+
+func TestPushRemovesEventDeletedWhileBeingCreated(t *testing.T) {
+	srv := fakegcal.New()
+	defer srv.Close()
+	a := newE2EApp(t, srv)
+	mustSync(t, a)
+	svc, err := a.googleForAccount(defaultAccountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := a.CreateEvent(localEvent("short-lived"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := loadPending(t, a, e.ID)
+	if err := a.DeleteEvent(e.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.pushEvent(context.Background(), svc, primaryCalendar(t, a), p); err != nil {
+		t.Fatal(err)
+	}
+	if remote := remoteSummaries(srv); len(remote) != 0 {
+		t.Fatalf("remote copy left behind: %v", remote)
+	}
+	if rows := eventRows(t, a); len(rows) != 0 {
+		t.Fatalf("rows: %+v", rows)
+	}
+}
+
+func TestOutboxJournalsExistingPendingRows(t *testing.T) {
+	srv := fakegcal.New()
+	defer srv.Close()
+	a := newE2EApp(t, srv)
+	mustExec(t, a, `DROP TABLE outbox`)
+	mustExec(t, a, `INSERT INTO events (id, title, all_day, start, end, alert, alert_offset, sync_status, remote_id) VALUES
+		('a', 'edited', 0, '2024-05-01 09:00:00', '2024-05-01 10:00:00', 'none', 0, 'dirty', 'g1'),
+		('b', 'removed', 0, '2024-05-01 09:00:00', '2024-05-01 10:00:00', 'none', 0, 'deleted', 'g2'),
+		('c', 'added', 0, '2024-05-01 09:00:00', '2024-05-01 10:00:00', 'none', 0, 'new', NULL),
+		('d', 'settled', 0, '2024-05-01 09:00:00', '2024-05-01 10:00:00', 'none', 0, 'synced', 'g4')`)
+	if err := ensureOutboxTable(a.db); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(pendingOps(t, a), ", ")
+	for _, want := range []string{"update edited", "delete removed", "create added"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("pending = %q, missing %q", got, want)
+		}
+	}
+	if strings.Contains(got, "settled") {
+		t.Fatalf("pending = %q includes a synced row", got)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Provider names stored in events.provider.
+const (
+	ProviderGoogle = "google"
+	ProviderCalDAV = "caldav"
+)
+
+// CalendarProvider is a calendar service whose calendars are synced with the local
+// events. Events travel in Google's event shape whichever the service; their IDs and
+// ETags are the provider's own and are kept on the row as remote_id and remote_etag.
+type CalendarProvider interface {
+	// Name is stored as the provider of the rows synced through it.
+	Name() string
+	Capabilities() ProviderCapabilities
+	ListCalendars(ctx context.Context) ([]GoogleCalendarListEntry, error)
+	// ListEvents lists a calendar's events, or those changed since syncToken, and
+	// returns the token of the next call. A token the provider no longer accepts fails
+	// with errSyncTokenExpired.
+	ListEvents(ctx context.Context, calendarID, syncToken string) ([]GoogleEvent, string, error)
+	GetEvent(ctx context.Context, calendarID, eventID string) (GoogleEvent, error)
+	CreateEventWithID(ctx context.Context, calendarID, eventID string, ev GoogleEvent) (GoogleEvent, error)
+	// UpdateEvent overwrites an event. With an etag the write fails with
+	// errGoogleConflict when the event has changed since that version.
+	UpdateEvent(ctx context.Context, calendarID, eventID, etag string, ev GoogleEvent) (GoogleEvent, error)
+	// DeleteEvent deletes an event; one already gone is not an error. With an etag the
+	// delete fails with errGoogleConflict when the event has changed since that version.
+	DeleteEvent(ctx context.Context, calendarID, eventID, etag string) error
+}
+
+// ProviderCapabilities lists what sync has to do differently per provider.
+type ProviderCapabilities struct {
+	// PrimaryAlias means the account's main calendar can be addressed as "primary",
+	// which sync falls back to while the calendar list is unavailable.
+	PrimaryAlias bool
+	// DefaultReminders means calendars have default reminders events can follow.
+	// Elsewhere an event following them is written with the defaults spelled out.
+	DefaultReminders bool
+	// SnapshotListing means a full listing leaves deleted events out instead of
+	// returning them cancelled, so synced rows it misses were deleted remotely. A
+	// series listed as changed comes with all its exceptions, so the same goes for
+	// exceptions an incremental listing misses.
+	SnapshotListing bool
+	// SeriesResource means a series and its exceptions are stored as one resource with
+	// one ETag, so writing any of them changes the ETag the others are written against.
+	SeriesResource bool
+}
+
+// providerAccount is a connected account with the provider its calendars sync through.
+type providerAccount struct {
+	ID string
+	// Label names the account in errors.
+	Label    string
+	Provider CalendarProvider
+}
+
+// providerAccounts returns the connected accounts of every provider, Google's first.
+// Google accounts are left out while Google sync is not configured.
+func (a *App) providerAccounts() ([]providerAccount, error) {
+	calDAVAccounts, err := a.loadCalDAVAccounts()
+	if err != nil {
+		return nil, err
+	}
+	google := a.googleService()
+	if google == nil && len(calDAVAccounts) == 0 {
+		return nil, errors.New("google sync not initialised")
+	}
+	var accounts []providerAccount
+	if google != nil {
+		googleAccounts, err := a.loadAccounts()
+		if err != nil {
+			return nil, err
+		}
+		for _, acc := range googleAccounts {
+			svc, err := a.googleForAccount(acc.ID)
+			if err != nil {
+				return nil, err
+			}
+			accounts = append(accounts, providerAccount{ID: acc.ID, Label: "account " + firstNonEmpty(acc.Email, acc.ID), Provider: svc})
+		}
+	}
+	for _, acc := range calDAVAccounts {
+		client, err := acc.client()
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, providerAccount{ID: acc.ID, Label: "caldav account " + acc.Username, Provider: client})
+	}
+	return accounts, nil
+}
+
+// migrateEventProviders records the provider of rows synced before rows carried one.
+func migrateEventProviders(db *sql.DB) error {
+	_, err := db.Exec(`UPDATE events SET provider = CASE WHEN account_id IN (SELECT id FROM caldav_accounts) THEN ? ELSE ? END
+		WHERE provider = '' AND COALESCE(remote_id,'') <> ''`, ProviderCalDAV, ProviderGoogle)
+	return err
+}
+
+// dropUnlistedEvents removes the synced rows of a calendar that a snapshot listing of it
+// left out, which were deleted remotely, with the rows overriding their occurrences.
+// After a full listing that is any such row; otherwise only the exceptions of the series
+// listed. It returns how many rows were removed.
+func (a *App) dropUnlistedEvents(accountID, calendarID string, listed []GoogleEvent, full bool) (int, error) {
+	seen := make(map[string]bool, len(listed))
+	series := make(map[string]bool)
+	for _, ge := range listed {
+		seen[ge.ID] = true
+		if ge.RecurringEventID == "" && ge.Status != "cancelled" {
+			series[ge.ID] = true
+		}
+	}
+	rows, err := a.db.Query(`
+		SELECT e.id, COALESCE(e.remote_id,''), COALESCE(m.remote_id,'')
+		FROM events e LEFT JOIN events m ON m.id = e.recurring_event_id
+		WHERE e.account_id = ? AND e.google_calendar_id = ? AND e.sync_status IN ('synced','cancelled')
+	`, accountID, calendarID)
+	if err != nil {
+		return 0, err
+	}
+	var stale []string
+	for rows.Next() {
+		var id, remoteID, seriesID string
+		if err := rows.Scan(&id, &remoteID, &seriesID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if remoteID != "" && !seen[remoteID] && (full || series[seriesID]) {
+			stale = append(stale, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for i, id := range stale {
+		if err := a.finishDelete(id); err != nil {
+			return i, err
+		}
+	}
+	return len(stale), nil
+}
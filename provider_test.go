@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoteMappingMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The events table as it was when only Google was synced.
+	for _, stmt := range []string{
+		`CREATE TABLE events (id TEXT PRIMARY KEY, title TEXT NOT NULL, all_day INTEGER NOT NULL DEFAULT 0, start TIMESTAMP NOT NULL, end TIMESTAMP NOT NULL,
+			recurrence TEXT NOT NULL DEFAULT 'none', recurrence_custom TEXT, location TEXT, alert TEXT NOT NULL DEFAULT 'none', alert_offset INTEGER NOT NULL DEFAULT 0,
+			color TEXT NOT NULL DEFAULT 'sky', description TEXT, sync_status TEXT NOT NULL DEFAULT 'local', account_id TEXT, google_event_id TEXT, google_calendar_id TEXT,
+			time_zone TEXT, google_etag TEXT, google_updated_at TIMESTAMP, updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE caldav_accounts (id TEXT PRIMARY KEY, url TEXT NOT NULL, username TEXT NOT NULL DEFAULT '', password TEXT NOT NULL DEFAULT '', created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+		`INSERT INTO caldav_accounts (id, url) VALUES ('caldav-1', 'https://dav.example.com/')`,
+		`INSERT INTO events (id, title, start, end, sync_status, account_id, google_event_id, google_calendar_id, google_etag)
+			VALUES ('a', 'from google', '2024-05-01T09:00:00Z', '2024-05-01T10:00:00Z', 'synced', 'acc-1', 'g1', 'primary', '"1"')`,
+		`INSERT INTO events (id, title, start, end, sync_status, account_id, google_event_id, google_calendar_id, google_etag)
+			VALUES ('b', 'from caldav', '2024-05-02T09:00:00Z', '2024-05-02T10:00:00Z', 'synced', 'caldav-1', 'c1', 'https://dav.example.com/work/', '"7"')`,
+		`INSERT INTO events (id, title, start, end) VALUES ('c', 'local', '2024-05-03T09:00:00Z', '2024-05-03T10:00:00Z')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	a := NewApp()
+	if err := a.openDB(path); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+	want := map[string][3]string{
+		"a": {ProviderGoogle, "g1", `"1"`},
+		"b": {ProviderCalDAV, "c1", `"7"`},
+		"c": {"", "", ""},
+	}
+	for id, w := range want {
+		e, err := a.getEvent(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := [3]string{e.Provider, e.GoogleEventID, e.GoogleETag}; got != w {
+			t.Fatalf("%s: got %q, want %q", id, got, w)
+		}
+	}
+	columns, err := tableColumns(a.db, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns["google_event_id"] || columns["google_etag"] {
+		t.Fatalf("old columns kept: %v", columns)
+	}
+}
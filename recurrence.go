@@ -0,0 +1,765 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Windows has no zoneinfo database for time.LoadLocation.
+)
+
+// rrule is a parsed RFC 5545 RRULE. Supported are FREQ DAILY to YEARLY with INTERVAL,
+// COUNT, UNTIL, BYDAY, BYMONTHDAY, BYMONTH, BYSETPOS and WKST, which covers what
+// calendar clients produce for events.
+type rrule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time // zero when unbounded
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    []int
+	bySetPos   []int
+	wkst       time.Weekday
+}
+
+// weekdayNum is a BYDAY entry such as MO, 2TU or -1FR; n is 0 for every such weekday.
+type weekdayNum struct {
+	n   int
+	day time.Weekday
+}
+
+var icalWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseRRule parses an RRULE value such as "FREQ=WEEKLY;BYDAY=MO,WE". Floating UNTIL
+// values are read in loc.
+func parseRRule(value string, loc *time.Location) (rrule, error) {
+	r := rrule{interval: 1, wkst: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return rrule{}, fmt.Errorf("invalid rrule part %q", part)
+		}
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.freq = strings.ToUpper(val)
+			switch r.freq {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+			default:
+				return rrule{}, fmt.Errorf("unsupported rrule frequency %q", val)
+			}
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(val)
+			if err == nil && r.interval < 1 {
+				err = errors.New("must be positive")
+			}
+		case "COUNT":
+			r.count, err = strconv.Atoi(val)
+			if err == nil && r.count < 1 {
+				err = errors.New("must be positive")
+			}
+		case "UNTIL":
+			var dateOnly bool
+			r.until, dateOnly, err = parseICalTime(val, loc)
+			if err == nil && dateOnly {
+				// A date UNTIL includes occurrences on that day.
+				r.until = r.until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+		case "BYDAY":
+			for _, d := range strings.Split(val, ",") {
+				var wd weekdayNum
+				if wd, err = parseWeekdayNum(d); err != nil {
+					break
+				}
+				r.byDay = append(r.byDay, wd)
+			}
+		case "BYMONTHDAY":
+			r.byMonthDay, err = parseIntList(val, 1, 31)
+		case "BYMONTH":
+			r.byMonth, err = parseIntList(val, 1, 12)
+			for _, month := range r.byMonth {
+				if month < 0 {
+					err = errors.New("out of range")
+				}
+			}
+		case "BYSETPOS":
+			r.bySetPos, err = parseIntList(val, 1, 366)
+		case "WKST":
+			day, ok := icalWeekdays[strings.ToUpper(val)]
+			if !ok {
+				err = errors.New("unknown weekday")
+			}
+			r.wkst = day
+		default:
+			return rrule{}, fmt.Errorf("unsupported rrule part %s", key)
+		}
+		if err != nil {
+			return rrule{}, fmt.Errorf("invalid rrule %s=%s: %w", key, val, err)
+		}
+	}
+	if r.freq == "" {
+		return rrule{}, errors.New("rrule has no FREQ")
+	}
+	return r, nil
+}
+
+func parseWeekdayNum(v string) (weekdayNum, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	if len(v) < 2 {
+		return weekdayNum{}, fmt.Errorf("invalid weekday %q", v)
+	}
+	day, ok := icalWeekdays[v[len(v)-2:]]
+	if !ok {
+		return weekdayNum{}, fmt.Errorf("invalid weekday %q", v)
+	}
+	wd := weekdayNum{day: day}
+	if prefix := v[:len(v)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return weekdayNum{}, fmt.Errorf("invalid weekday %q", v)
+		}
+		wd.n = n
+	}
+	return wd, nil
+}
+
+// parseIntList parses a comma-separated list of non-zero integers from lo to hi, or
+// from -hi to -1.
+func parseIntList(v string, lo, hi int) ([]int, error) {
+	var out []int
+	for _, s := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 || n > hi || n < -hi || (n > 0 && n < lo) {
+			return nil, fmt.Errorf("%d out of range", n)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// parseICalTime parses an iCalendar DATE or DATE-TIME value. UTC values end in Z;
+// floating values and dates are read in loc.
+func parseICalTime(v string, loc *time.Location) (time.Time, bool, error) {
+	v = strings.TrimSpace(v)
+	switch {
+	case len(v) == 8:
+		t, err := time.ParseInLocation("20060102", v, loc)
+		return t, true, err
+	case strings.HasSuffix(v, "Z"):
+		t, err := time.Parse("20060102T150405Z", v)
+		return t, false, err
+	default:
+		t, err := time.ParseInLocation("20060102T150405", v, loc)
+		return t, false, err
+	}
+}
+
+// each calls fn with the start of every occurrence of r, in order, beginning with dtstart
+// itself, which always counts as the first. It stops when fn returns false, the rule ends,
+// or the rule's periods pass limit.
+func (r rrule) each(dtstart, limit time.Time, fn func(time.Time) bool) {
+	if !fn(dtstart) {
+		return
+	}
+	count := 1
+	loc := dtstart.Location()
+	y, m, d := dtstart.Date()
+	hour, minute, sec := dtstart.Clock()
+	weekStart := time.Date(y, m, d-int((dtstart.Weekday()-r.wkst+7)%7), 0, 0, 0, 0, loc)
+	for period := 0; ; period++ {
+		var from time.Time
+		var days []time.Time
+		switch r.freq {
+		case "DAILY":
+			from = time.Date(y, m, d+period*r.interval, 0, 0, 0, 0, loc)
+			if r.matchesDay(from) {
+				days = []time.Time{from}
+			}
+		case "WEEKLY":
+			from = weekStart.AddDate(0, 0, 7*period*r.interval)
+			for i := 0; i < 7; i++ {
+				day := from.AddDate(0, 0, i)
+				if r.weeklyDay(day, dtstart) {
+					days = append(days, day)
+				}
+			}
+		case "MONTHLY":
+			from = time.Date(y, m+time.Month(period*r.interval), 1, 0, 0, 0, 0, loc)
+			if len(r.byMonth) == 0 || containsInt(r.byMonth, int(from.Month())) {
+				days = r.monthDays(from, dtstart)
+			}
+		case "YEARLY":
+			from = time.Date(y+period*r.interval, time.January, 1, 0, 0, 0, 0, loc)
+			days = r.yearDays(from, dtstart)
+		}
+		if from.After(limit) {
+			return
+		}
+		for _, day := range applySetPos(days, r.bySetPos) {
+			dy, dm, dd := day.Date()
+			t := time.Date(dy, dm, dd, hour, minute, sec, 0, loc)
+			if !t.After(dtstart) {
+				continue
+			}
+			if !r.until.IsZero() && t.After(r.until) {
+				return
+			}
+			count++
+			if r.count > 0 && count > r.count {
+				return
+			}
+			if !fn(t) {
+				return
+			}
+		}
+	}
+}
+
+// matchesDay applies BYMONTH, BYMONTHDAY and BYDAY as filters, as DAILY rules use them.
+func (r rrule) matchesDay(day time.Time) bool {
+	if len(r.byMonth) > 0 && !containsInt(r.byMonth, int(day.Month())) {
+		return false
+	}
+	if len(r.byMonthDay) > 0 && !matchesMonthDay(r.byMonthDay, day) {
+		return false
+	}
+	if len(r.byDay) > 0 {
+		for _, wd := range r.byDay {
+			if wd.day == day.Weekday() {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (r rrule) weeklyDay(day, dtstart time.Time) bool {
+	if len(r.byMonth) > 0 && !containsInt(r.byMonth, int(day.Month())) {
+		return false
+	}
+	if len(r.byDay) == 0 {
+		return day.Weekday() == dtstart.Weekday()
+	}
+	for _, wd := range r.byDay {
+		if wd.day == day.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// monthDays returns the days of the month starting at first that the rule selects.
+func (r rrule) monthDays(first, dtstart time.Time) []time.Time {
+	last := first.AddDate(0, 1, -1).Day()
+	var days []time.Time
+	for day := 1; day <= last; day++ {
+		t := first.AddDate(0, 0, day-1)
+		switch {
+		case len(r.byMonthDay) == 0 && len(r.byDay) == 0:
+			if day != dtstart.Day() {
+				continue
+			}
+		case len(r.byMonthDay) > 0 && !matchesMonthDay(r.byMonthDay, t):
+			continue
+		case len(r.byDay) > 0 && !matchesWeekdayIn(r.byDay, t, first, last):
+			continue
+		}
+		days = append(days, t)
+	}
+	return days
+}
+
+// yearDays returns the days of the year starting at jan1 that the rule selects.
+func (r rrule) yearDays(jan1, dtstart time.Time) []time.Time {
+	if len(r.byMonth) > 0 || (len(r.byDay) == 0 && len(r.byMonthDay) > 0) {
+		var days []time.Time
+		for month := 1; month <= 12; month++ {
+			if len(r.byMonth) > 0 && !containsInt(r.byMonth, month) {
+				continue
+			}
+			first := time.Date(jan1.Year(), time.Month(month), 1, 0, 0, 0, 0, jan1.Location())
+			if len(r.byDay) == 0 && len(r.byMonthDay) == 0 {
+				if t := first.AddDate(0, 0, dtstart.Day()-1); t.Month() == first.Month() {
+					days = append(days, t)
+				}
+				continue
+			}
+			days = append(days, r.monthDays(first, dtstart)...)
+		}
+		return days
+	}
+	if len(r.byDay) == 0 {
+		t := time.Date(jan1.Year(), dtstart.Month(), dtstart.Day(), 0, 0, 0, 0, jan1.Location())
+		if t.Day() != dtstart.Day() {
+			return nil // 29 February in a common year
+		}
+		return []time.Time{t}
+	}
+	// BYDAY without BYMONTH counts weekdays through the whole year.
+	daysInYear := time.Date(jan1.Year(), time.December, 31, 0, 0, 0, 0, jan1.Location()).YearDay()
+	var days []time.Time
+	for i := 0; i < daysInYear; i++ {
+		t := jan1.AddDate(0, 0, i)
+		if len(r.byMonthDay) > 0 && !matchesMonthDay(r.byMonthDay, t) {
+			continue
+		}
+		if matchesWeekdayIn(r.byDay, t, jan1, daysInYear) {
+			days = append(days, t)
+		}
+	}
+	return days
+}
+
+func matchesMonthDay(list []int, day time.Time) bool {
+	last := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location()).Day()
+	for _, n := range list {
+		if n == day.Day() || (n < 0 && last+n+1 == day.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWeekdayIn reports whether day matches a BYDAY entry, counting ordinals within the
+// span of length days that starts at first (a month or a year).
+func matchesWeekdayIn(list []weekdayNum, day, first time.Time, length int) bool {
+	index := day.YearDay() - first.YearDay() + 1
+	for _, wd := range list {
+		if wd.day != day.Weekday() {
+			continue
+		}
+		switch {
+		case wd.n == 0:
+			return true
+		case wd.n > 0 && (index-1)/7+1 == wd.n:
+			return true
+		case wd.n < 0 && (length-index)/7+1 == -wd.n:
+			return true
+		}
+	}
+	return false
+}
+
+// applySetPos keeps the BYSETPOS positions of a period's sorted candidate days.
+func applySetPos(days []time.Time, setPos []int) []time.Time {
+	if len(setPos) == 0 || len(days) == 0 {
+		return days
+	}
+	var out []time.Time
+	for _, pos := range setPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(days) + pos
+		}
+		if i >= 0 && i < len(days) {
+			out = append(out, days[i])
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return out
+}
+
+func containsInt(list []int, v int) bool {
+	for _, n := range list {
+		if n == v {
+			return true
+		}
+	}
+	return false
+}
+
+// recurrenceSet is the RRULE, RDATE and EXDATE lines of a recurring event.
+type recurrenceSet struct {
+	rules   []rrule
+	rdates  []time.Time
+	exdates []time.Time
+	// exDays holds EXDATE;VALUE=DATE entries as YYYYMMDD.
+	exDays map[string]bool
+}
+
+// parseRecurrence parses recurrence lines as stored for Google events, e.g.
+// "RRULE:FREQ=DAILY" or "EXDATE;TZID=Asia/Seoul:20240501T090000". Floating times are read
+// in loc.
+func parseRecurrence(lines []string, loc *time.Location) (recurrenceSet, error) {
+	set := recurrenceSet{exDays: make(map[string]bool)}
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, _, value, valueLoc, err := splitRecurrenceLine(line, loc)
+		if err != nil {
+			return recurrenceSet{}, err
+		}
+		switch name {
+		case "RRULE":
+			r, err := parseRRule(value, loc)
+			if err != nil {
+				return recurrenceSet{}, err
+			}
+			set.rules = append(set.rules, r)
+		case "RDATE", "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				if strings.Contains(v, "/") {
+					return recurrenceSet{}, fmt.Errorf("unsupported %s period %q", name, v)
+				}
+				t, dateOnly, err := parseICalTime(v, valueLoc)
+				if err != nil {
+					return recurrenceSet{}, fmt.Errorf("invalid %s %q: %w", name, v, err)
+				}
+				switch {
+				case name == "RDATE":
+					set.rdates = append(set.rdates, t)
+				case dateOnly:
+					set.exDays[t.Format("20060102")] = true
+				default:
+					set.exdates = append(set.exdates, t)
+				}
+			}
+		default:
+			return recurrenceSet{}, fmt.Errorf("unsupported recurrence line %s", name)
+		}
+	}
+	return set, nil
+}
+
+// splitRecurrenceLine splits a recurrence line into its upper-cased name, the name with
+// its parameters as written, and the value. valueLoc is the line's TZID, or loc.
+func splitRecurrenceLine(line string, loc *time.Location) (name, head, value string, valueLoc *time.Location, err error) {
+	head, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok {
+		return "", "", "", nil, fmt.Errorf("invalid recurrence line %q", line)
+	}
+	params := strings.Split(head, ";")
+	valueLoc = loc
+	for _, p := range params[1:] {
+		key, val, _ := strings.Cut(p, "=")
+		if strings.EqualFold(key, "TZID") {
+			l, err := time.LoadLocation(strings.Trim(val, `"`))
+			if err != nil {
+				return "", "", "", nil, fmt.Errorf("unknown TZID %s: %w", val, err)
+			}
+			valueLoc = l
+		}
+	}
+	return strings.ToUpper(params[0]), head, value, valueLoc, nil
+}
+
+// splitSeries cuts recurrence lines at the occurrence starting at split. head ends the
+// series just before it; tail carries the rest on for a series starting at split, with
+// COUNT reduced by the occurrences left behind. dtstart is the first occurrence, in the
+// time zone the lines are read in. tail is empty if nothing repeats after split.
+func splitSeries(lines []string, dtstart, split time.Time, allDay bool) (head, tail []string, err error) {
+	loc := dtstart.Location()
+	until := split.Add(-time.Second).UTC().Format("20060102T150405Z")
+	if allDay {
+		until = split.AddDate(0, 0, -1).Format("20060102")
+	}
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, prefix, value, valueLoc, err := splitRecurrenceLine(line, loc)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch name {
+		case "RRULE":
+			r, err := parseRRule(value, loc)
+			if err != nil {
+				return nil, nil, err
+			}
+			before := 0
+			r.each(dtstart, split, func(t time.Time) bool {
+				if !t.Before(split) {
+					return false
+				}
+				before++
+				return true
+			})
+			if (r.count > 0 && before >= r.count) || (!r.until.IsZero() && r.until.Before(split)) {
+				// The rule ends before split anyway.
+				head = append(head, line)
+				continue
+			}
+			var parts []string
+			for _, part := range strings.Split(value, ";") {
+				key, _, _ := strings.Cut(part, "=")
+				if part != "" && !strings.EqualFold(key, "COUNT") && !strings.EqualFold(key, "UNTIL") {
+					parts = append(parts, part)
+				}
+			}
+			head = append(head, prefix+":"+strings.Join(parts, ";")+";UNTIL="+until)
+			rest := value
+			if r.count > 0 {
+				rest = strings.Join(append(parts, fmt.Sprintf("COUNT=%d", r.count-before)), ";")
+			}
+			tail = append(tail, prefix+":"+rest)
+		case "RDATE", "EXDATE":
+			var early, late []string
+			for _, v := range strings.Split(value, ",") {
+				t, _, err := parseICalTime(v, valueLoc)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid %s %q: %w", name, v, err)
+				}
+				if t.Before(split) {
+					early = append(early, v)
+				} else {
+					late = append(late, v)
+				}
+			}
+			if len(early) > 0 {
+				head = append(head, prefix+":"+strings.Join(early, ","))
+			}
+			if len(late) > 0 {
+				tail = append(tail, prefix+":"+strings.Join(late, ","))
+			}
+		default:
+			return nil, nil, fmt.Errorf("unsupported recurrence line %s", name)
+		}
+	}
+	if !hasRule(tail) {
+		// A tail of exclusions alone does not repeat.
+		tail = nil
+	}
+	return head, tail, nil
+}
+
+func hasRule(lines []string) bool {
+	for _, line := range lines {
+		if name, _, _ := strings.Cut(strings.ToUpper(line), ":"); strings.HasPrefix(name, "RRULE") || strings.HasPrefix(name, "RDATE") {
+			return true
+		}
+	}
+	return false
+}
+
+// exdateLine returns an EXDATE line excluding the occurrence starting at t.
+func exdateLine(t time.Time, allDay bool) string {
+	if allDay {
+		return "EXDATE;VALUE=DATE:" + t.Format("20060102")
+	}
+	return "EXDATE:" + t.UTC().Format("20060102T150405Z")
+}
+
+// between returns the starts of occurrences overlapping [from, to), given the first
+// occurrence dtstart (in the event's time zone) and the length of each occurrence.
+func (s recurrenceSet) between(dtstart time.Time, dur time.Duration, from, to time.Time) []time.Time {
+	seen := make(map[int64]bool)
+	var out []time.Time
+	add := func(t time.Time) {
+		if !t.Before(to) {
+			return
+		}
+		// Zero-length occurrences count when they start inside the window.
+		if end := t.Add(dur); !end.After(from) && !(dur == 0 && !t.Before(from)) {
+			return
+		}
+		if seen[t.UnixNano()] || s.excluded(t) {
+			return
+		}
+		seen[t.UnixNano()] = true
+		out = append(out, t)
+	}
+	if len(s.rules) == 0 {
+		add(dtstart)
+	}
+	for _, r := range s.rules {
+		r.each(dtstart, to, func(t time.Time) bool {
+			if !t.Before(to) {
+				return false
+			}
+			add(t)
+			return true
+		})
+	}
+	for _, t := range s.rdates {
+		add(t.In(dtstart.Location()))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return out
+}
+
+func (s recurrenceSet) excluded(t time.Time) bool {
+	if s.exDays[t.Format("20060102")] {
+		return true
+	}
+	for _, ex := range s.exdates {
+		if ex.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// recurrencePresets maps the repeat choices offered in the event dialog to RRULEs.
+var recurrencePresets = map[string]string{
+	"daily":   "RRULE:FREQ=DAILY",
+	"weekly":  "RRULE:FREQ=WEEKLY",
+	"monthly": "RRULE:FREQ=MONTHLY",
+	"yearly":  "RRULE:FREQ=YEARLY",
+}
+
+// recurrenceLines returns an event's recurrence as RRULE/RDATE/EXDATE lines, or nil if it
+// does not repeat. Presets become RRULEs; "rrule" and "custom" keep the stored lines.
+func recurrenceLines(e CalendarEvent) []string {
+	if rule, ok := recurrencePresets[e.Recurrence]; ok {
+		return []string{rule}
+	}
+	if e.Recurrence != "rrule" && e.Recurrence != "custom" {
+		return nil
+	}
+	var lines []string
+	for _, line := range strings.Split(e.RecurrenceEx, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(strings.ToUpper(line), "FREQ=") {
+			line = "RRULE:" + line
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// eventLocation returns the event's time zone, or UTC if it is unset or unknown.
+func eventLocation(e CalendarEvent) *time.Location {
+	if e.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(e.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// EventOccurrence is one instance of an event within a window. An event that does not
+// repeat has a single occurrence carrying its own ID. OriginalStart is the start the
+// occurrence was generated with, which UpdateOccurrence and DeleteOccurrence take; for
+// an overridden occurrence MasterID and OriginalStart name the one it replaces.
+type EventOccurrence struct {
+	CalendarEvent
+	MasterID string `json:"masterId"`
+	start    time.Time
+}
+
+// ListOccurrences returns the occurrences of all events that overlap [start, end),
+// expanding recurring events. start and end are RFC3339 or YYYY-MM-DD (local midnight).
+func (a *App) ListOccurrences(start, end string) ([]EventOccurrence, error) {
+	from, err := parseWindowTime(start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start: %w", err)
+	}
+	to, err := parseWindowTime(end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end: %w", err)
+	}
+	if !to.After(from) {
+		return nil, errors.New("end must be after start")
+	}
+	events, err := a.ListEvents()
+	if err != nil {
+		return nil, err
+	}
+	overridden, err := a.overriddenOccurrences()
+	if err != nil {
+		return nil, err
+	}
+	var out []EventOccurrence
+	for _, e := range events {
+		occurrences, err := expandEvent(e, from, to)
+		if err != nil {
+			// Show the event once rather than dropping it.
+			fmt.Printf("expand recurrence of %s: %v\n", e.ID, err)
+			occurrences, _ = expandEvent(withoutRecurrence(e), from, to)
+		}
+		for _, o := range occurrences {
+			// Rows overriding an occurrence stand in for it, or hide it if cancelled.
+			if overridden[e.ID][o.start.Unix()] {
+				continue
+			}
+			out = append(out, o)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].start.Before(out[j].start) })
+	return out, nil
+}
+
+// expandEvent returns the occurrences of e overlapping [from, to).
+func expandEvent(e CalendarEvent, from, to time.Time) ([]EventOccurrence, error) {
+	startTime, endTime, err := parseEventTimes(e)
+	if err != nil {
+		return nil, err
+	}
+	allDay := e.AllDay || e.Recurrence == "allday"
+	loc := eventLocation(e)
+	if allDay {
+		loc = time.UTC
+	}
+	lines := recurrenceLines(e)
+	set, err := parseRecurrence(lines, loc)
+	if err != nil {
+		return nil, err
+	}
+	dur := endTime.Sub(startTime)
+	var out []EventOccurrence
+	for _, t := range set.between(startTime.In(loc), dur, from, to) {
+		o := EventOccurrence{CalendarEvent: e, MasterID: e.ID, start: t}
+		o.OriginalStart = t.Format(time.RFC3339)
+		if e.RecurringEventID != "" {
+			o.MasterID = e.RecurringEventID
+			o.OriginalStart = e.OriginalStart
+		}
+		o.Start = t.Format(time.RFC3339)
+		o.End = t.Add(dur).Format(time.RFC3339)
+		if allDay {
+			// Whole days, so a DST change cannot shift the end off midnight.
+			days := int(dur.Hours()/24 + 0.5)
+			o.End = t.AddDate(0, 0, days).Format(time.RFC3339)
+		}
+		if len(lines) > 0 {
+			o.ID = occurrenceID(e.ID, t, allDay)
+		}
+		out = append(out, o)
+	}
+	return out, nil
+}
+
+// occurrenceID names one instance of a recurring event the way Google does: the master
+// ID, an underscore and the original start in UTC (or the date for all-day events).
+func occurrenceID(masterID string, start time.Time, allDay bool) string {
+	if allDay {
+		return masterID + "_" + start.Format("20060102")
+	}
+	return masterID + "_" + start.UTC().Format("20060102T150405Z")
+}
+
+func withoutRecurrence(e CalendarEvent) CalendarEvent {
+	if e.Recurrence != "allday" {
+		e.Recurrence = "none"
+	}
+	e.RecurrenceEx = ""
+	return e
+}
+
+func parseWindowTime(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if len(v) == len("2006-01-02") {
+		return time.ParseInLocation("2006-01-02", v, time.Local)
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustTime(t *testing.T, v string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestExpandEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    CalendarEvent
+		from, to string
+		want     []string // occurrence starts in RFC3339
+	}{
+		{
+			name:  "daily count",
+			event: CalendarEvent{Start: "2024-05-01T09:00:00Z", End: "2024-05-01T10:00:00Z", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=DAILY;COUNT=3"},
+			from:  "2024-01-01T00:00:00Z", to: "2025-01-01T00:00:00Z",
+			want: []string{"2024-05-01T09:00:00Z", "2024-05-02T09:00:00Z", "2024-05-03T09:00:00Z"},
+		},
+		{
+			name:  "weekly on several days",
+			event: CalendarEvent{Start: "2024-05-06T09:00:00Z", End: "2024-05-06T10:00:00Z", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4"},
+			from:  "2024-01-01T00:00:00Z", to: "2025-01-01T00:00:00Z",
+			want: []string{"2024-05-06T09:00:00Z", "2024-05-08T09:00:00Z", "2024-05-13T09:00:00Z", "2024-05-15T09:00:00Z"},
+		},
+		{
+			name:  "every other week",
+			event: CalendarEvent{Start: "2024-05-01T09:00:00Z", End: "2024-05-01T10:00:00Z", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=WEEKLY;INTERVAL=2;COUNT=3"},
+			from:  "2024-01-01T00:00:00Z", to: "2025-01-01T00:00:00Z",
+			want: []string{"2024-05-01T09:00:00Z", "2024-05-15T09:00:00Z", "2024-05-29T09:00:00Z"},
+		},
+		{
+			name:  "last friday of the month",
+			event: CalendarEvent{Start: "2024-01-26T09:00:00Z", End: "2024-01-26T10:00:00Z", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=MONTHLY;BYDAY=-1FR;COUNT=3"},
+			from:  "2024-01-01T00:00:00Z", to: "2025-01-01T00:00:00Z",
+			want: []string{"2024-01-26T09:00:00Z", "2024-02-23T09:00:00Z", "2024-03-29T09:00:00Z"},
+		},
+		{
+			name:  "monthly on the 31st skips short months",
+			event: CalendarEvent{Start: "2024-01-31T09:00:00Z", End: "2024-01-31T10:00:00Z", Recurrence: "monthly"},
+			from:  "2024-01-01T00:00:00Z", to: "2024-06-01T00:00:00Z",
+			want: []string{"2024-01-31T09:00:00Z", "2024-03-31T09:00:00Z", "2024-05-31T09:00:00Z"},
+		},
+		{
+			name:  "last weekday of the month",
+			event: CalendarEvent{Start: "2024-05-31T09:00:00Z", End: "2024-05-31T10:00:00Z", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1;COUNT=3"},
+			from:  "2024-01-01T00:00:00Z", to: "2025-01-01T00:00:00Z",
+			want: []string{"2024-05-31T09:00:00Z", "2024-06-28T09:00:00Z", "2024-07-31T09:00:00Z"},
+		},
+		{
+			name:  "yearly on 29 February",
+			event: CalendarEvent{Start: "2024-02-29T09:00:00Z", End: "2024-02-29T10:00:00Z", Recurrence: "yearly"},
+			from:  "2024-01-01T00:00:00Z", to: "2030-01-01T00:00:00Z",
+			want: []string{"2024-02-29T09:00:00Z", "2028-02-29T09:00:00Z"},
+		},
+		{
+			name:  "fourth thursday of november",
+			event: CalendarEvent{Start: "2024-11-28T18:00:00Z", End: "2024-11-28T21:00:00Z", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=4TH"},
+			from:  "2024-01-01T00:00:00Z", to: "2027-01-01T00:00:00Z",
+			want: []string{"2024-11-28T18:00:00Z", "2025-11-27T18:00:00Z", "2026-11-26T18:00:00Z"},
+		},
+		{
+			name:  "until is inclusive",
+			event: CalendarEvent{Start: "2024-05-01T09:00:00Z", End: "2024-05-01T10:00:00Z", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=DAILY;UNTIL=20240503T090000Z"},
+			from:  "2024-01-01T00:00:00Z", to: "2025-01-01T00:00:00Z",
+			want: []string{"2024-05-01T09:00:00Z", "2024-05-02T09:00:00Z", "2024-05-03T09:00:00Z"},
+		},
+		{
+			name:  "keeps local time across a DST change",
+			event: CalendarEvent{Start: "2024-03-09T09:00:00-05:00", End: "2024-03-09T10:00:00-05:00", TimeZone: "America/New_York", Recurrence: "daily"},
+			from:  "2024-03-09T00:00:00Z", to: "2024-03-12T00:00:00Z",
+			want: []string{"2024-03-09T09:00:00-05:00", "2024-03-10T09:00:00-04:00", "2024-03-11T09:00:00-04:00"},
+		},
+		{
+			name:  "exdate in the event time zone",
+			event: CalendarEvent{Start: "2024-05-01T09:00:00+09:00", End: "2024-05-01T10:00:00+09:00", TimeZone: "Asia/Seoul", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=DAILY;COUNT=3\nEXDATE;TZID=Asia/Seoul:20240502T090000"},
+			from:  "2024-01-01T00:00:00Z", to: "2025-01-01T00:00:00Z",
+			want: []string{"2024-05-01T09:00:00+09:00", "2024-05-03T09:00:00+09:00"},
+		},
+		{
+			name:  "rdate adds an occurrence",
+			event: CalendarEvent{Start: "2024-05-01T09:00:00Z", End: "2024-05-01T10:00:00Z", Recurrence: "rrule", RecurrenceEx: "RDATE:20240510T120000Z"},
+			from:  "2024-01-01T00:00:00Z", to: "2025-01-01T00:00:00Z",
+			want: []string{"2024-05-01T09:00:00Z", "2024-05-10T12:00:00Z"},
+		},
+		{
+			name:  "only the window of an endless rule",
+			event: CalendarEvent{Start: "2020-01-01T09:00:00Z", End: "2020-01-01T10:00:00Z", Recurrence: "custom", RecurrenceEx: "FREQ=DAILY"},
+			from:  "2024-05-01T09:30:00Z", to: "2024-05-03T09:00:00Z",
+			want: []string{"2024-05-01T09:00:00Z", "2024-05-02T09:00:00Z"},
+		},
+		{
+			name:  "all-day yearly",
+			event: CalendarEvent{Start: "2024-05-01", End: "2024-05-02", AllDay: true, Recurrence: "yearly"},
+			from:  "2025-01-01T00:00:00Z", to: "2026-01-01T00:00:00Z",
+			want: []string{"2025-05-01T00:00:00Z"},
+		},
+		{
+			name:  "no recurrence",
+			event: CalendarEvent{Start: "2024-05-01T09:00:00Z", End: "2024-05-01T10:00:00Z", Recurrence: "none"},
+			from:  "2024-05-01T00:00:00Z", to: "2024-05-02T00:00:00Z",
+			want: []string{"2024-05-01T09:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.event.ID = "master"
+			got, err := expandEvent(tt.event, mustTime(t, tt.from), mustTime(t, tt.to))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var starts []string
+			for _, o := range got {
+				starts = append(starts, o.Start)
+			}
+			if !reflect.DeepEqual(starts, tt.want) {
+				t.Fatalf("starts = %v, want %v", starts, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandEventInstances(t *testing.T) {
+	e := CalendarEvent{ID: "evt-1", Title: "standup", Start: "2024-05-01T09:00:00+09:00", End: "2024-05-01T09:15:00+09:00", TimeZone: "Asia/Seoul", Recurrence: "daily"}
+	got, err := expandEvent(e, mustTime(t, "2024-05-02T00:00:00+09:00"), mustTime(t, "2024-05-03T00:00:00+09:00"))
+	if err != nil || len(got) != 1 {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+	o := got[0]
+	if o.ID != "evt-1_20240502T000000Z" || o.MasterID != "evt-1" || o.Title != "standup" {
+		t.Fatalf("instance %+v", o)
+	}
+	if o.Start != "2024-05-02T09:00:00+09:00" || o.End != "2024-05-02T09:15:00+09:00" || o.OriginalStart != o.Start {
+		t.Fatalf("instance times %s - %s (%s)", o.Start, o.End, o.OriginalStart)
+	}
+
+	allDay := CalendarEvent{ID: "bday", Start: "2024-05-01", End: "2024-05-02", AllDay: true, Recurrence: "yearly"}
+	got, err = expandEvent(allDay, mustTime(t, "2025-01-01T00:00:00Z"), mustTime(t, "2026-01-01T00:00:00Z"))
+	if err != nil || len(got) != 1 || got[0].ID != "bday_20250501" || got[0].End != "2025-05-02T00:00:00Z" {
+		t.Fatalf("all-day instance %+v, %v", got, err)
+	}
+}
+
+func TestParseRecurrenceRejectsUnsupportedRules(t *testing.T) {
+	for _, line := range []string{
+		"RRULE:FREQ=HOURLY",
+		"RRULE:FREQ=YEARLY;BYWEEKNO=20",
+		"RRULE:INTERVAL=2",
+		"RRULE:FREQ=DAILY;COUNT=0",
+		"EXRULE:FREQ=DAILY",
+	} {
+		if _, err := parseRecurrence([]string{line}, time.UTC); err == nil {
+			t.Errorf("%s: no error", line)
+		}
+	}
+}
+
+func TestListOccurrences(t *testing.T) {
+	a := NewApp()
+	if err := a.openDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+	for _, e := range []CalendarEvent{
+		{ID: "weekly", Title: "gym", Start: "2024-04-29T18:00:00Z", End: "2024-04-29T19:00:00Z", Recurrence: "weekly", Alert: "none"},
+		{ID: "once", Title: "dentist", Start: "2024-05-02T08:00:00Z", End: "2024-05-02T09:00:00Z", Recurrence: "none", Alert: "none"},
+		{ID: "later", Title: "trip", Start: "2024-06-02T08:00:00Z", End: "2024-06-02T09:00:00Z", Recurrence: "none", Alert: "none"},
+		{ID: "broken", Title: "odd", Start: "2024-05-03T08:00:00Z", End: "2024-05-03T09:00:00Z", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=SECONDLY", Alert: "none"},
+	} {
+		if _, err := a.CreateEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := a.ListOccurrences("2024-05-01T00:00:00Z", "2024-05-15T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, o := range got {
+		ids = append(ids, o.ID)
+	}
+	want := "once broken weekly_20240506T180000Z weekly_20240513T180000Z"
+	if strings.Join(ids, " ") != want {
+		t.Fatalf("ids = %v, want %s", ids, want)
+	}
+	if _, err := a.ListOccurrences("2024-05-15", "2024-05-01"); err == nil {
+		t.Fatal("accepted an empty window")
+	}
+}
+
+func TestCalendarToGoogleSendsRRules(t *testing.T) {
+	start := mustTime(t, "2024-05-01T09:00:00Z")
+	tests := map[string][]string{
+		"none":   nil,
+		"daily":  {"RRULE:FREQ=DAILY"},
+		"weekly": {"RRULE:FREQ=WEEKLY"},
+		"allday": nil,
+		"rrule":  {"RRULE:FREQ=MONTHLY;BYDAY=1MO", "EXDATE:20240603T090000Z"},
+	}
+	for recurrence, want := range tests {
+		e := CalendarEvent{Recurrence: recurrence, RecurrenceEx: "RRULE:FREQ=MONTHLY;BYDAY=1MO\nEXDATE:20240603T090000Z\n"}
+		if got := calendarToGoogle(e, start, start.Add(time.Hour)).Recurrence; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: recurrence = %q, want %q", recurrence, got, want)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EventReminderDue is emitted when a reminder goes off. It carries the DueReminder.
+const EventReminderDue = "reminder:due"
+
+const (
+	// reminderPollInterval bounds how long the scheduler sleeps, so reminders missed
+	// while the machine was suspended are caught up soon after it wakes.
+	reminderPollInterval = time.Minute
+	defaultSnoozeMinutes = 5
+	maxSnoozeMinutes     = 24 * 60
+	// reminderRetention is how long fired reminders are remembered after their occurrence.
+	reminderRetention = 30 * 24 * time.Hour
+)
+
+// DueReminder is a reminder that went off for one occurrence of an event.
+type DueReminder struct {
+	// ID identifies the reminder to SnoozeReminder and DismissReminder.
+	ID            string          `json:"id"`
+	Event         EventOccurrence `json:"event"`
+	MinutesBefore int             `json:"minutesBefore"`
+	TriggerAt     string          `json:"triggerAt"`
+	// Missed is set when the reminder is delivered after the occurrence started, e.g.
+	// because the app was closed or the machine asleep when it was due.
+	Missed bool `json:"missed"`
+}
+
+// ensureRemindersFiredTable creates the record of reminders already delivered, so they
+// are not repeated after a restart.
+func ensureRemindersFiredTable(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS reminders_fired (
+		id TEXT PRIMARY KEY,
+		event_id TEXT NOT NULL,
+		occurrence_start TEXT NOT NULL,
+		minutes_before INTEGER NOT NULL,
+		fired_at TEXT,
+		snoozed_until TEXT,
+		dismissed_at TEXT
+	);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// alertMinutes returns how many minutes before its start e asks to be reminded, or
+// false when it has no alert. Custom alerts keep the minutes in AlertOffset.
+func alertMinutes(e CalendarEvent) (int, bool) {
+	switch e.Alert {
+	case "5m":
+		return 5, true
+	case "10m":
+		return 10, true
+	case "15m":
+		return 15, true
+	case "30m":
+		return 30, true
+	case "1h":
+		return 60, true
+	case "1d":
+		return 24 * 60, true
+	case "custom":
+		return e.AlertOffset, e.AlertOffset >= 0
+	}
+	return 0, false
+}
+
+// reminderStart returns when occurrence o starts for reminding purposes; all-day
+// occurrences start at local midnight.
+func reminderStart(o EventOccurrence) time.Time {
+	if o.AllDay || o.Recurrence == "allday" {
+		y, m, d := o.start.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	}
+	return o.start
+}
+
+// firedReminder is the delivery state of a reminder in reminders_fired.
+type firedReminder struct {
+	snoozedUntil string
+	dismissed    bool
+}
+
+// dueReminders marks and returns the reminders due at now: those whose trigger time
+// has passed while their occurrence has not ended, and snoozed ones whose snooze ran
+// out. next is the earliest later trigger or snooze end, zero if none is known.
+func (a *App) dueReminders(now time.Time) (due []DueReminder, next time.Time, err error) {
+	if a.db == nil {
+		return nil, time.Time{}, errors.New("db not initialised")
+	}
+	now = now.UTC().Truncate(time.Second)
+	lookahead := 24 * time.Hour
+	var maxLead sql.NullInt64
+	if err := a.db.QueryRow(`SELECT MAX(m) FROM (SELECT alert_offset AS m FROM events WHERE alert = 'custom' UNION ALL SELECT minutes FROM event_reminders)`).Scan(&maxLead); err != nil {
+		return nil, time.Time{}, err
+	}
+	if d := time.Duration(maxLead.Int64) * time.Minute; d > lookahead {
+		lookahead = d
+	}
+	// Occurrences starting up to a lookahead after the longest lead have their triggers
+	// within the lookahead, which is how far next looks.
+	occurrences, err := a.ListOccurrences(now.Format(time.RFC3339), now.Add(2*lookahead).Format(time.RFC3339))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	fired, err := a.firedReminders(now)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	popups, err := a.popupReminders()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	later := func(t time.Time) {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	for _, o := range occurrences {
+		start := reminderStart(o)
+		for _, minutes := range popups[occurrenceRowID(o)] {
+			trigger := start.Add(-time.Duration(minutes) * time.Minute)
+			if trigger.After(now) {
+				later(trigger)
+				continue
+			}
+			r := DueReminder{
+				ID:            fmt.Sprintf("%s/%s/%d", o.ID, originalStartKey(start), minutes),
+				Event:         o,
+				MinutesBefore: minutes,
+				TriggerAt:     trigger.UTC().Format(time.RFC3339),
+				Missed:        !now.Before(start),
+			}
+			if state, ok := fired[r.ID]; ok {
+				if state.dismissed || state.snoozedUntil == "" {
+					continue
+				}
+				until, err := time.Parse(time.RFC3339, state.snoozedUntil)
+				if err != nil {
+					return nil, time.Time{}, err
+				}
+				if until.After(now) {
+					later(until)
+					continue
+				}
+			}
+			if _, err := a.db.Exec(`
+				INSERT INTO reminders_fired (id, event_id, occurrence_start, minutes_before, fired_at) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT(id) DO UPDATE SET fired_at=excluded.fired_at, snoozed_until=NULL
+			`, r.ID, o.ID, originalStartKey(start), minutes, now.Format(time.RFC3339)); err != nil {
+				return nil, time.Time{}, err
+			}
+			due = append(due, r)
+		}
+	}
+	return due, next, nil
+}
+
+// occurrenceRowID returns the ID of the row o comes from: the series' row, or the row
+// overriding the occurrence.
+func occurrenceRowID(o EventOccurrence) string {
+	if o.RecurringEventID != "" {
+		return o.ID
+	}
+	return o.MasterID
+}
+
+// firedReminders loads the delivery state of recent reminders by ID, forgetting those
+// of occurrences long past.
+func (a *App) firedReminders(now time.Time) (map[string]firedReminder, error) {
+	if _, err := a.db.Exec(`DELETE FROM reminders_fired WHERE occurrence_start < ?`, now.Add(-reminderRetention).Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+	rows, err := a.db.Query(`SELECT id, COALESCE(snoozed_until,''), dismissed_at IS NOT NULL FROM reminders_fired`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]firedReminder)
+	for rows.Next() {
+		var id string
+		var r firedReminder
+		if err := rows.Scan(&id, &r.snoozedUntil, &r.dismissed); err != nil {
+			return nil, err
+		}
+		out[id] = r
+	}
+	return out, rows.Err()
+}
+
+// SnoozeReminder brings a delivered reminder back after minutes (5 when zero).
+func (a *App) SnoozeReminder(id string, minutes int) error {
+	if err := a.snoozeReminder(id, minutes, time.Now()); err != nil {
+		return err
+	}
+	a.reminders.reschedule()
+	return nil
+}
+
+func (a *App) snoozeReminder(id string, minutes int, now time.Time) error {
+	if minutes == 0 {
+		minutes = defaultSnoozeMinutes
+	}
+	if minutes < 0 || minutes > maxSnoozeMinutes {
+		return fmt.Errorf("snooze must be between 1 and %d minutes", maxSnoozeMinutes)
+	}
+	until := now.UTC().Truncate(time.Second).Add(time.Duration(minutes) * time.Minute)
+	return a.updateFiredReminder(`UPDATE reminders_fired SET snoozed_until = ?, dismissed_at = NULL WHERE id = ?`, until.Format(time.RFC3339), id)
+}
+
+// DismissReminder stops a delivered reminder, cancelling any snooze.
+func (a *App) DismissReminder(id string) error {
+	return a.updateFiredReminder(`UPDATE reminders_fired SET dismissed_at = ?, snoozed_until = NULL WHERE id = ?`, time.Now().UTC().Format(time.RFC3339), id)
+}
+
+func (a *App) updateFiredReminder(query string, args ...any) error {
+	if a.db == nil {
+		return errors.New("db not initialised")
+	}
+	res, err := a.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New("reminder not found")
+	}
+	return nil
+}
+
+// reminderScheduler delivers reminders while the app runs.
+type reminderScheduler struct {
+	app  *App
+	emit func(name string, data ...interface{})
+	now  func() time.Time
+	wake chan struct{}
+}
+
+// startReminderScheduler starts the reminder loop; it stops when ctx is done or on shutdown.
+func (a *App) startReminderScheduler(ctx context.Context, emit func(name string, data ...interface{})) {
+	ctx, cancel := context.WithCancel(ctx)
+	a.stopReminders = cancel
+	a.reminders = &reminderScheduler{
+		app:  a,
+		emit: emit,
+		now:  time.Now,
+		wake: make(chan struct{}, 1),
+	}
+	go a.reminders.run(ctx)
+}
+
+// reschedule makes the loop look for due reminders again, e.g. after a snooze. It is a
+// no-op before the scheduler has started.
+func (s *reminderScheduler) reschedule() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *reminderScheduler) run(ctx context.Context) {
+	for {
+		// Checking right away also catches up on reminders due while the app was closed.
+		delay := s.tick()
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// tick delivers the reminders due now and returns how long to wait for the next check.
+func (s *reminderScheduler) tick() time.Duration {
+	if s.app.db == nil {
+		return reminderPollInterval
+	}
+	now := s.now()
+	due, next, err := s.app.dueReminders(now)
+	if err != nil {
+		fmt.Printf("reminders: %v\n", err)
+		return reminderPollInterval
+	}
+	for _, r := range due {
+		s.emit(EventReminderDue, r)
+	}
+	if !next.IsZero() {
+		if d := next.Sub(now); d < reminderPollInterval {
+			return max(d, time.Second)
+		}
+	}
+	return reminderPollInterval
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// remindersAt returns the reminders due at now as "title@start" entries, with a "!"
+// suffix on missed ones, and the next trigger time.
+func remindersAt(t *testing.T, a *App, now string) (string, time.Time) {
+	t.Helper()
+	due, next, err := a.dueReminders(mustTime(t, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, r := range due {
+		entry := r.Event.Title + "@" + r.Event.Start[5:16]
+		if r.Missed {
+			entry += "!"
+		}
+		out = append(out, entry)
+	}
+	return strings.Join(out, " "), next
+}
+
+func TestReminderDelivery(t *testing.T) {
+	a := NewApp()
+	if err := a.openDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+	for _, e := range []CalendarEvent{
+		{Title: "review", Start: "2024-05-01T10:00:00Z", End: "2024-05-01T10:30:00Z", Recurrence: "rrule", RecurrenceEx: "RRULE:FREQ=DAILY;COUNT=3", Alert: "15m"},
+		{Title: "lunch", Start: "2024-05-01T12:00:00Z", End: "2024-05-01T13:00:00Z", Alert: "none"},
+		{Title: "call", Start: "2024-05-01T11:00:00Z", End: "2024-05-01T11:30:00Z", Alert: "custom", AlertOffset: 90},
+	} {
+		if _, err := a.CreateEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, next := remindersAt(t, a, "2024-05-01T09:00:00Z")
+	if got != "" || !next.Equal(mustTime(t, "2024-05-01T09:30:00Z")) {
+		t.Fatalf("at 09:00: %q, next %v", got, next)
+	}
+	got, next = remindersAt(t, a, "2024-05-01T09:46:00Z")
+	if got != "review@05-01T10:00 call@05-01T11:00" {
+		t.Fatalf("at 09:46: %q", got)
+	}
+	if !next.Equal(mustTime(t, "2024-05-02T09:45:00Z")) {
+		t.Fatalf("next after 09:46: %v", next)
+	}
+	if got, _ := remindersAt(t, a, "2024-05-01T09:47:00Z"); got != "" {
+		t.Fatalf("delivered twice: %q", got)
+	}
+
+	// Fired reminders are remembered across restarts.
+	if got, _ := remindersAt(t, &App{db: a.db}, "2024-05-01T09:48:00Z"); got != "" {
+		t.Fatalf("after restart: %q", got)
+	}
+
+	var reviewID string
+	if err := a.db.QueryRow(`SELECT id FROM reminders_fired WHERE minutes_before = 15`).Scan(&reviewID); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.snoozeReminder(reviewID, 5, mustTime(t, "2024-05-01T09:48:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	if got, next := remindersAt(t, a, "2024-05-01T09:50:00Z"); got != "" || !next.Equal(mustTime(t, "2024-05-01T09:53:00Z")) {
+		t.Fatalf("during snooze: %q, next %v", got, next)
+	}
+	if got, _ := remindersAt(t, a, "2024-05-01T09:53:00Z"); got != "review@05-01T10:00" {
+		t.Fatalf("after snooze: %q", got)
+	}
+	if err := a.snoozeReminder(reviewID, 5, mustTime(t, "2024-05-01T09:54:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.DismissReminder(reviewID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := remindersAt(t, a, "2024-05-01T09:59:00Z"); got != "" {
+		t.Fatalf("after dismissing: %q", got)
+	}
+	if err := a.DismissReminder("missing"); err == nil {
+		t.Fatal("dismissed an unknown reminder")
+	}
+
+	// Waking up after sleeping through a reminder delivers it late, unless the
+	// occurrence is already over.
+	if got, _ := remindersAt(t, a, "2024-05-02T10:10:00Z"); got != "review@05-02T10:00!" {
+		t.Fatalf("after sleeping: %q", got)
+	}
+	if got, _ := remindersAt(t, a, "2024-05-03T10:45:00Z"); got != "" {
+		t.Fatalf("for an occurrence that is over: %q", got)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Runtime events emitted around background syncs. Each carries the GoogleSyncResult.
+const (
+	EventSyncStarted  = "sync:started"
+	EventSyncFinished = "sync:finished"
+	EventSyncError    = "sync:error"
+)
+
+const (
+	// defaultSyncInterval applies when AppSettings.SyncIntervalMinutes is zero.
+	defaultSyncInterval    = 15 * time.Minute
+	maxSyncIntervalMinutes = 24 * 60
+	// startSyncDelay is the wait before the first run, which lets the window come up
+	// before a sync competes with it.
+	startSyncDelay = 10 * time.Second
+	// maxSyncBackoff caps how far repeated failures stretch the interval.
+	maxSyncBackoff     = 2 * time.Hour
+	onlineCheckTimeout = 3 * time.Second
+)
+
+// syncScheduler runs GoogleSync in the background so an idle widget stays current.
+type syncScheduler struct {
+	app      *App
+	emit     func(name string, data ...interface{})
+	online   func(ctx context.Context) bool
+	wake     chan struct{}
+	failures int
+	// startDelay is the wait before the first run; ticked is set once it happened.
+	startDelay time.Duration
+	ticked     bool
+}
+
+// startSyncScheduler starts the background sync loop; it stops when ctx is done or on
+// shutdown. The first sync runs shortly after start rather than a full interval later.
+func (a *App) startSyncScheduler(ctx context.Context, emit func(name string, data ...interface{})) {
+	ctx, cancel := context.WithCancel(ctx)
+	a.stopScheduler = cancel
+	a.scheduler = &syncScheduler{
+		app:        a,
+		emit:       emit,
+		online:     a.googleReachable,
+		wake:       make(chan struct{}, 1),
+		startDelay: startSyncDelay,
+	}
+	go a.scheduler.run(ctx)
+}
+
+// reschedule makes the loop pick up a changed interval.
+func (s *syncScheduler) reschedule() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *syncScheduler) run(ctx context.Context) {
+	for {
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if delay, ok := s.nextDelay(); ok {
+			timer = time.NewTimer(delay)
+			fire = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-s.wake:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-fire:
+			s.tick(ctx)
+		}
+	}
+}
+
+// nextDelay returns the wait before the next run, doubling the interval per consecutive
+// failure. ok is false when background sync is turned off.
+func (s *syncScheduler) nextDelay() (time.Duration, bool) {
+	interval, ok := syncInterval(s.app.currentSettings())
+	if !ok {
+		return 0, false
+	}
+	if !s.ticked {
+		return min(s.startDelay, interval), true
+	}
+	delay := interval
+	for i := 0; i < s.failures && delay < maxSyncBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxSyncBackoff && interval < maxSyncBackoff {
+		delay = maxSyncBackoff
+	}
+	return delay, true
+}
+
+// tick refreshes subscribed feeds and runs one background sync of the Google and CalDAV
+// accounts unless there is nothing to sync, the network is down, or another sync is
+// already in flight.
+func (s *syncScheduler) tick(ctx context.Context) {
+	a := s.app
+	s.ticked = true
+	if a.db != nil {
+		// Feeds need no Google account, so they are refreshed first.
+		changed, err := a.refreshSubscriptions(ctx)
+		if err != nil {
+			fmt.Printf("subscriptions: %v\n", err)
+		} else if changed {
+			a.reminders.reschedule()
+			s.emit(EventSubscriptionsRefreshed)
+		}
+	}
+	if a.db == nil {
+		return
+	}
+	hasGoogle := false
+	if google := a.googleService(); google != nil && google.HasClientConfig() {
+		accounts, err := a.loadAccounts()
+		hasGoogle = err == nil && len(accounts) > 0
+	}
+	calDAVAccounts, err := a.loadCalDAVAccounts()
+	hasCalDAV := err == nil && len(calDAVAccounts) > 0
+	if !hasGoogle && !hasCalDAV {
+		return
+	}
+	if hasGoogle && !s.online(ctx) {
+		// Being offline is not a sync failure; try again at the normal interval.
+		return
+	}
+	if a.syncs.busy(syncKeyFull) {
+		return
+	}
+
+	s.emit(EventSyncStarted)
+	result, err := a.syncs.do(syncKeyFull, func() (GoogleSyncResult, error) {
+		return a.runSync(ctx)
+	})
+	if err != nil {
+		s.failures++
+		if result.ErrorMessage == "" {
+			result.ErrorMessage = err.Error()
+		}
+		s.emit(EventSyncError, result)
+		return
+	}
+	s.failures = 0
+	s.emit(EventSyncFinished, result)
+}
+
+// syncInterval returns the configured background sync interval; ok is false when the
+// setting is negative, which turns background sync off.
+func syncInterval(cfg AppSettings) (time.Duration, bool) {
+	switch {
+	case cfg.SyncIntervalMinutes < 0:
+		return 0, false
+	case cfg.SyncIntervalMinutes == 0:
+		return defaultSyncInterval, true
+	}
+	return time.Duration(cfg.SyncIntervalMinutes) * time.Minute, true
+}
+
+// googleReachable reports whether the configured Calendar API host accepts connections.
+func (a *App) googleReachable(ctx context.Context) bool {
+	baseURL := defaultGoogleCalendarBaseURL
+	if google := a.googleService(); google != nil {
+		baseURL = google.cfg.CalendarBaseURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	dialer := net.Dialer{Timeout: onlineCheckTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
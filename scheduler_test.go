@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// emitted records the runtime events a scheduler emits.
+type emitted struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (e *emitted) emit(name string, data ...interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.names = append(e.names, name)
+}
+
+// take returns the events emitted since the last call.
+func (e *emitted) take() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := strings.Join(e.names, " ")
+	e.names = nil
+	return out
+}
+
+// refusingTransport answers every Calendar API call with 403.
+type refusingTransport struct{}
+
+func (refusingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return jsonResponse(http.StatusForbidden, map[string]any{"error": map[string]any{"code": 403, "message": "forbidden"}}), nil
+}
+
+func TestSyncSchedulerBackoff(t *testing.T) {
+	a := NewApp()
+	s := &syncScheduler{app: a, startDelay: startSyncDelay}
+	delay := func(minutes, failures int) time.Duration {
+		t.Helper()
+		a.setSettings(AppSettings{SyncIntervalMinutes: minutes})
+		s.failures = failures
+		d, ok := s.nextDelay()
+		if !ok {
+			t.Fatalf("sync off for %d minutes", minutes)
+		}
+		return d
+	}
+
+	if d := delay(0, 0); d != startSyncDelay {
+		t.Fatalf("first run after %v", d)
+	}
+	s.ticked = true
+	for failures, want := range []time.Duration{10 * time.Minute, 20 * time.Minute, 40 * time.Minute, 80 * time.Minute, maxSyncBackoff, maxSyncBackoff} {
+		if d := delay(10, failures); d != want {
+			t.Fatalf("after %d failures: %v, want %v", failures, d, want)
+		}
+	}
+	if d := delay(0, 0); d != defaultSyncInterval {
+		t.Fatalf("default interval %v", d)
+	}
+	// An interval above the cap is never shortened by it.
+	if d := delay(180, 3); d != 180*time.Minute {
+		t.Fatalf("long interval %v", d)
+	}
+	a.setSettings(AppSettings{SyncIntervalMinutes: -1})
+	if _, ok := s.nextDelay(); ok {
+		t.Fatal("sync not turned off")
+	}
+}
+
+func TestSyncSchedulerTick(t *testing.T) {
+	a := newSyncTestApp(t)
+	a.google.sleep = func(context.Context, time.Duration) error { return nil }
+	events := &emitted{}
+	online := false
+	s := &syncScheduler{app: a, emit: events.emit, online: func(context.Context) bool { return online }, wake: make(chan struct{}, 1)}
+	ctx := context.Background()
+
+	// Being offline skips the run without counting it as a failure.
+	api := &fakeCalendarTransport{}
+	a.google.httpClient = &http.Client{Transport: api}
+	s.tick(ctx)
+	if got := events.take(); got != "" || s.failures != 0 {
+		t.Fatalf("offline: %q, %d failures", got, s.failures)
+	}
+
+	online = true
+	a.google.httpClient = &http.Client{Transport: refusingTransport{}}
+	s.tick(ctx)
+	s.tick(ctx)
+	if got, want := events.take(), strings.Join([]string{EventSyncStarted, EventSyncError, EventSyncStarted, EventSyncError}, " "); got != want || s.failures != 2 {
+		t.Fatalf("failing: %q, %d failures", got, s.failures)
+	}
+
+	a.google.httpClient = &http.Client{Transport: api}
+	s.tick(ctx)
+	if got := events.take(); got != EventSyncStarted+" "+EventSyncFinished || s.failures != 0 {
+		t.Fatalf("recovered: %q, %d failures", got, s.failures)
+	}
+}
+
+func TestSyncSchedulerRunsAtStart(t *testing.T) {
+	a := newSyncTestApp(t)
+	a.google.httpClient = &http.Client{Transport: &fakeCalendarTransport{}}
+	events := &emitted{}
+	s := &syncScheduler{app: a, emit: events.emit, online: func(context.Context) bool { return true }, wake: make(chan struct{}, 1), startDelay: time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The default interval is 15 minutes; the first sync does not wait for it.
+	var got []string
+	waitFor(t, func() bool {
+		if names := events.take(); names != "" {
+			got = append(got, names)
+		}
+		return strings.Contains(strings.Join(got, " "), EventSyncFinished)
+	})
+}
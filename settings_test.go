@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestUpdateSettingsKeepsOtherSettings(t *testing.T) {
+	a := newSyncTestApp(t)
+	if err := a.SetActiveAccount(defaultAccountID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.modifySettings(func(cfg *AppSettings) {
+		cfg.AutoStart = true
+		cfg.GoogleClientID, cfg.GoogleClientSecret = "client", "client-secret"
+		cfg.ConflictPolicy = ConflictPolicyNewestWins
+		cfg.SyncIntervalMinutes = 30
+		cfg.GoogleCalendarBaseURL = "http://127.0.0.1:9999"
+		cfg.CalDAVServerEnabled, cfg.CalDAVServerUsername, cfg.CalDAVServerPassword = true, "me", "dav-secret"
+		cfg.APIServerToken = "api-token"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := a.currentSettings()
+
+	// The settings dialog sends back what it loaded with autostart changed.
+	loaded, err := a.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.GoogleClientSecret != "" || loaded.CalDAVServerPassword != "" {
+		t.Fatalf("secrets returned: %+v", loaded)
+	}
+	loaded.AutoStart = false
+	loaded.ActiveAccountID = "someone-else"
+	saved, err := a.UpdateSettings(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.AutoStart = false
+	if saved != want || a.currentSettings() != want {
+		t.Fatalf("saved %+v, want %+v", saved, want)
+	}
+
+	if runtime.GOOS != "windows" {
+		path, err := a.settingsPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Fatalf("settings file mode %v", info.Mode().Perm())
+		}
+	}
+
+	// What was saved is what the next start loads.
+	b := NewApp()
+	if err := b.loadSettings(); err != nil {
+		t.Fatal(err)
+	}
+	if b.currentSettings() != want {
+		t.Fatalf("loaded %+v, want %+v", b.currentSettings(), want)
+	}
+}
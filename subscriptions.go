@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// subscriptionAccountID is the account_id of the calendars and events read from ICS feeds.
+const subscriptionAccountID = "ics"
+
+// EventSubscriptionsRefreshed is emitted when a background refresh changed the events
+// of a subscribed feed.
+const EventSubscriptionsRefreshed = "subscriptions:refreshed"
+
+const (
+	subscriptionFetchTimeout = 30 * time.Second
+	// maxSubscriptionBytes bounds how much of a feed is read.
+	maxSubscriptionBytes = 10 << 20
+)
+
+// errReadOnlyEvent is returned for changes to events of a subscribed feed.
+var errReadOnlyEvent = errors.New("event belongs to a read-only subscription")
+
+var subscriptionClient = &http.Client{Timeout: subscriptionFetchTimeout}
+
+// Subscription is an ICS feed shown as a read-only calendar. Its ID is the ID of that
+// calendar, under the "ics" account.
+type Subscription struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Name          string `json:"name"`
+	Enabled       bool   `json:"enabled"`
+	LastFetchedAt string `json:"lastFetchedAt,omitempty"`
+	LastSuccessAt string `json:"lastSuccessAt,omitempty"`
+	LastError     string `json:"lastError,omitempty"`
+}
+
+// ensureSubscriptionsTable creates the table of subscribed feeds, with the validators of
+// the copy last fetched for conditional requests.
+func ensureSubscriptionsTable(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS ics_subscriptions (
+		id TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		etag TEXT,
+		last_modified TEXT,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// AddSubscription subscribes to the ICS feed at feedURL (http, https or webcal) and
+// fetches it. The subscription is kept even if the first fetch fails; the error is
+// reported in LastError.
+func (a *App) AddSubscription(feedURL, name string) (Subscription, error) {
+	if a.db == nil {
+		return Subscription{}, errors.New("db not initialised")
+	}
+	u, err := subscriptionURL(feedURL)
+	if err != nil {
+		return Subscription{}, err
+	}
+	id := fmt.Sprintf("ics-%d", time.Now().UnixNano())
+	tx, err := a.db.Begin()
+	if err != nil {
+		return Subscription{}, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO ics_subscriptions (id, url) VALUES (?, ?)`, id, u.String()); err != nil {
+		return Subscription{}, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO calendars (account_id, id, summary, access_role, is_primary, enabled, updated_at)
+		VALUES (?, ?, ?, 'reader', 0, 1, CURRENT_TIMESTAMP)
+	`, subscriptionAccountID, id, firstNonEmpty(strings.TrimSpace(name), u.Host)); err != nil {
+		return Subscription{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Subscription{}, err
+	}
+	ctx := a.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, err := a.refreshSubscription(ctx, id); err != nil {
+		fmt.Printf("subscription %s: %v\n", u.Redacted(), err)
+	}
+	a.reminders.reschedule()
+	return a.subscription(id)
+}
+
+// subscriptionURL checks a feed URL, reading webcal:// as https://.
+func subscriptionURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed url: %w", err)
+	}
+	if strings.EqualFold(u.Scheme, "webcal") || strings.EqualFold(u.Scheme, "webcals") {
+		u.Scheme = "https"
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("feed url must be an http, https or webcal url")
+	}
+	return u, nil
+}
+
+// RemoveSubscription unsubscribes from a feed and drops its calendar and events.
+func (a *App) RemoveSubscription(id string) error {
+	if a.db == nil {
+		return errors.New("db not initialised")
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM ics_subscriptions WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("subscription not found")
+	}
+	for _, query := range []string{
+		`DELETE FROM events WHERE account_id = ? AND google_calendar_id = ?`,
+		`DELETE FROM calendars WHERE account_id = ? AND id = ?`,
+		`DELETE FROM calendar_sync_state WHERE account_id = ? AND calendar_id = ?`,
+	} {
+		if _, err := tx.Exec(query, subscriptionAccountID, id); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	a.reminders.reschedule()
+	return nil
+}
+
+// ListSubscriptions returns the subscribed feeds with the outcome of their last fetch.
+func (a *App) ListSubscriptions() ([]Subscription, error) {
+	return a.loadSubscriptions("")
+}
+
+// RefreshSubscriptions fetches every enabled feed now.
+func (a *App) RefreshSubscriptions() ([]Subscription, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	ctx := a.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, err := a.refreshSubscriptions(ctx); err != nil {
+		return nil, err
+	}
+	a.reminders.reschedule()
+	return a.loadSubscriptions("")
+}
+
+func (a *App) subscription(id string) (Subscription, error) {
+	subs, err := a.loadSubscriptions(id)
+	if err != nil {
+		return Subscription{}, err
+	}
+	if len(subs) == 0 {
+		return Subscription{}, errors.New("subscription not found")
+	}
+	return subs[0], nil
+}
+
+// loadSubscriptions returns the subscription id, or every subscription when id is empty.
+func (a *App) loadSubscriptions(id string) ([]Subscription, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	rows, err := a.db.Query(`
+		SELECT s.id, s.url, COALESCE(c.summary,''), COALESCE(c.enabled,0), st.last_sync_at, st.last_success_at, COALESCE(st.last_error,'')
+		FROM ics_subscriptions s
+		LEFT JOIN calendars c ON c.account_id = ? AND c.id = s.id
+		LEFT JOIN calendar_sync_state st ON st.account_id = ? AND st.calendar_id = s.id
+		WHERE (? = '' OR s.id = ?)
+		ORDER BY s.created_at ASC, s.id ASC
+	`, subscriptionAccountID, subscriptionAccountID, id, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Subscription
+	for rows.Next() {
+		var s Subscription
+		var enabled int
+		var lastSync, lastSuccess sql.NullTime
+		if err := rows.Scan(&s.ID, &s.URL, &s.Name, &enabled, &lastSync, &lastSuccess, &s.LastError); err != nil {
+			return nil, err
+		}
+		s.Enabled = enabled == 1
+		if lastSync.Valid {
+			s.LastFetchedAt = lastSync.Time.Format(time.RFC3339)
+		}
+		if lastSuccess.Valid {
+			s.LastSuccessAt = lastSuccess.Time.Format(time.RFC3339)
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// refreshSubscriptions fetches the enabled feeds, recording each outcome in the sync
+// state of its calendar. changed reports whether any feed's events were replaced.
+func (a *App) refreshSubscriptions(ctx context.Context) (changed bool, err error) {
+	rows, err := a.db.Query(`
+		SELECT s.id FROM ics_subscriptions s
+		JOIN calendars c ON c.account_id = ? AND c.id = s.id
+		WHERE c.enabled = 1 ORDER BY s.created_at ASC, s.id ASC
+	`, subscriptionAccountID)
+	if err != nil {
+		return false, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return false, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		updated, err := a.refreshSubscription(ctx, id)
+		if err != nil {
+			fmt.Printf("subscription %s: %v\n", id, err)
+		}
+		changed = changed || updated
+	}
+	return changed, nil
+}
+
+// refreshSubscription fetches one feed and replaces its events when it has changed.
+// Feeds that have not changed since the last fetch answer the conditional request with
+// 304 Not Modified.
+func (a *App) refreshSubscription(ctx context.Context, id string) (changed bool, err error) {
+	defer func() {
+		if rerr := a.recordCalendarSync(subscriptionAccountID, id, err); rerr != nil {
+			fmt.Printf("record subscription fetch: %v\n", rerr)
+		}
+	}()
+	var feedURL string
+	var etag, lastModified sql.NullString
+	var stored int
+	if err := a.db.QueryRow(`
+		SELECT url, etag, last_modified, (SELECT COUNT(*) FROM events WHERE account_id = ? AND google_calendar_id = ics_subscriptions.id)
+		FROM ics_subscriptions WHERE id = ?
+	`, subscriptionAccountID, id).Scan(&feedURL, &etag, &lastModified, &stored); err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/calendar, */*;q=0.5")
+	// Without stored events, e.g. after the calendar was turned off and on, fetch in full.
+	if stored > 0 {
+		if etag.String != "" {
+			req.Header.Set("If-None-Match", etag.String)
+		}
+		if lastModified.String != "" {
+			req.Header.Set("If-Modified-Since", lastModified.String)
+		}
+	}
+	resp, err := subscriptionClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("fetch feed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, fmt.Errorf("fetch feed: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSubscriptionBytes+1))
+	if err != nil {
+		return false, fmt.Errorf("fetch feed: %w", err)
+	}
+	if len(data) > maxSubscriptionBytes {
+		return false, fmt.Errorf("feed is larger than %d MB", maxSubscriptionBytes>>20)
+	}
+	root, err := parseICS(bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("parse feed: %w", err)
+	}
+	if err := a.storeSubscriptionEvents(id, root, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// storeSubscriptionEvents replaces the events of feed id with the VEVENTs of root and
+// remembers the validators of the fetched copy. Events that cannot be read are left out.
+func (a *App) storeSubscriptionEvents(id string, root *icsComponent, etag, lastModified string) error {
+	var events []importedEvent
+	series := make(map[string]string)
+	for _, cal := range root.components {
+		for _, c := range cal.components {
+			if cal.name != "VCALENDAR" || c.name != "VEVENT" {
+				continue
+			}
+			ev, err := parseVEvent(c)
+			if err != nil {
+				continue
+			}
+			// Events without a UID are told apart by title and start.
+			ev.uid = firstNonEmpty(ev.uid, ev.Title+"/"+ev.Start)
+			ev.ID = subscriptionEventID(id, ev.uid, ev.recurrenceID)
+			if ev.recurrenceID.IsZero() && ev.Recurrence != "none" {
+				series[ev.uid] = ev.ID
+			}
+			events = append(events, ev)
+		}
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM events WHERE account_id = ? AND google_calendar_id = ?`, subscriptionAccountID, id); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, ev := range events {
+		if seen[ev.ID] {
+			continue
+		}
+		seen[ev.ID] = true
+		e := ev.CalendarEvent
+		e.AccountID, e.GoogleCalendarID, e.ICalUID = subscriptionAccountID, id, ev.uid
+		e.SyncStatus = "subscribed"
+		if !ev.recurrenceID.IsZero() {
+			masterID, ok := series[ev.uid]
+			if !ok {
+				// A changed occurrence of a series the feed does not carry stands alone.
+				if ev.cancelled {
+					continue
+				}
+			} else {
+				e.RecurringEventID, e.OriginalStart = masterID, originalStartKey(ev.recurrenceID)
+				if ev.cancelled {
+					e.SyncStatus = "cancelled"
+				}
+			}
+		} else if ev.cancelled {
+			continue
+		}
+		if _, err := insertEvent(tx, e); err != nil {
+			return fmt.Errorf("store %q: %w", e.Title, err)
+		}
+	}
+	if _, err := tx.Exec(`UPDATE ics_subscriptions SET etag = ?, last_modified = ? WHERE id = ?`, etag, lastModified, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// subscriptionEventID derives a stable row ID for an event of feed id, so reminders
+// already delivered are not repeated when the feed is fetched again.
+func subscriptionEventID(id, uid string, recurrenceID time.Time) string {
+	key := uid
+	if !recurrenceID.IsZero() {
+		key += "/" + originalStartKey(recurrenceID)
+	}
+	sum := sha1.Sum([]byte(key))
+	return id + "-" + hex.EncodeToString(sum[:10])
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// feedServer serves an ICS feed with an ETag, answering conditional requests with
+// 304 Not Modified, and counts the full responses.
+type feedServer struct {
+	mu     sync.Mutex
+	body   string
+	etag   string
+	status int
+	full   int
+}
+
+func (f *feedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status != 0 {
+		http.Error(w, "unavailable", f.status)
+		return
+	}
+	if r.Header.Get("If-None-Match") == f.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	f.full++
+	w.Header().Set("ETag", f.etag)
+	w.Header().Set("Content-Type", "text/calendar")
+	w.Write([]byte(f.body))
+}
+
+func (f *feedServer) set(body, etag string, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.body, f.etag, f.status = body, etag, status
+}
+
+const holidayFeed = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\nUID:newyear@holidays\r\nDTSTART;VALUE=DATE:20250101\r\nSUMMARY:New Year\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:payday@holidays\r\nDTSTART;VALUE=DATE:20250125\r\nRRULE:FREQ=MONTHLY;COUNT=3\r\nSUMMARY:Payday\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:payday@holidays\r\nRECURRENCE-ID;VALUE=DATE:20250225\r\nSTATUS:CANCELLED\r\nDTSTART;VALUE=DATE:20250225\r\nSUMMARY:Payday\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestSubscriptions(t *testing.T) {
+	feed := &feedServer{body: holidayFeed, etag: `"v1"`}
+	srv := httptest.NewServer(feed)
+	defer srv.Close()
+	a := NewApp()
+	if err := a.openDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+
+	if _, err := a.AddSubscription("ftp://example.com/feed.ics", ""); err == nil {
+		t.Fatal("accepted an ftp feed")
+	}
+	sub, err := a.AddSubscription(srv.URL+"/holidays.ics", "Holidays")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.LastError != "" || sub.LastSuccessAt == "" || sub.Name != "Holidays" {
+		t.Fatalf("after adding %+v", sub)
+	}
+	occurrences, err := a.ListOccurrences("2025-01-01T00:00:00Z", "2025-05-01T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, o := range occurrences {
+		titles = append(titles, o.Title+"@"+o.Start[:10])
+	}
+	if got := strings.Join(titles, " "); got != "New Year@2025-01-01 Payday@2025-01-25 Payday@2025-03-25" {
+		t.Fatalf("occurrences %s", got)
+	}
+	if ops := pendingOps(t, a); len(ops) != 0 {
+		t.Fatalf("feed events queued for Google: %v", ops)
+	}
+
+	// Feed events are read-only.
+	events, err := a.ListEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events[0].Title = "renamed"
+	if _, err := a.UpdateEvent(events[0]); !errors.Is(err, errReadOnlyEvent) {
+		t.Fatalf("update: %v", err)
+	}
+	if err := a.DeleteEvent(events[0].ID); !errors.Is(err, errReadOnlyEvent) {
+		t.Fatalf("delete: %v", err)
+	}
+
+	// Unchanged feeds are not downloaded again; changed ones replace their events.
+	if changed, err := a.refreshSubscriptions(context.Background()); err != nil || changed || feed.full != 1 {
+		t.Fatalf("unchanged refresh: changed %v, %d downloads, %v", changed, feed.full, err)
+	}
+	feed.set(strings.Replace(holidayFeed, "New Year", "New Year's Day", 1), `"v2"`, 0)
+	if changed, err := a.refreshSubscriptions(context.Background()); err != nil || !changed {
+		t.Fatalf("changed refresh: changed %v, %v", changed, err)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events WHERE title = 'New Year''s Day' AND sync_status = 'subscribed'`); n != 1 {
+		t.Fatalf("%d renamed events", n)
+	}
+
+	// Fetch errors show up in the sync status and keep the events.
+	feed.set("", `"v2"`, http.StatusServiceUnavailable)
+	if _, err := a.RefreshSubscriptions(); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := a.GetSyncStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].CalendarName != "Holidays" || !strings.Contains(statuses[0].LastError, "503") || !statuses[0].Stale {
+		t.Fatalf("sync status %+v", statuses)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events WHERE account_id = ?`, subscriptionAccountID); n != 3 {
+		t.Fatalf("%d feed events after a failed fetch, want 3", n)
+	}
+
+	if err := a.RemoveSubscription(sub.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, a, `SELECT COUNT(*) FROM events`); n != 0 {
+		t.Fatalf("%d events after unsubscribing", n)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import "sync"
+
+// Keys for the sync runs started from the frontend or the scheduler.
+const (
+	syncKeyFull = "sync"
+	syncKeyPush = "push"
+)
+
+// syncCoordinator serialises sync work. Concurrent runs with the same key are coalesced
+// onto the one already in flight, and each calendar is synced by one goroutine at a time
+// so a pending row can never be pushed twice.
+type syncCoordinator struct {
+	mu        sync.Mutex
+	inflight  map[string]*syncCall
+	calendars map[string]*sync.Mutex
+}
+
+type syncCall struct {
+	done   chan struct{}
+	result GoogleSyncResult
+	err    error
+}
+
+func newSyncCoordinator() *syncCoordinator {
+	return &syncCoordinator{
+		inflight:  make(map[string]*syncCall),
+		calendars: make(map[string]*sync.Mutex),
+	}
+}
+
+// do runs fn unless a run with the same key is in flight, in which case it waits for
+// that run and returns its result.
+func (c *syncCoordinator) do(key string, fn func() (GoogleSyncResult, error)) (GoogleSyncResult, error) {
+	c.mu.Lock()
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.result, call.err
+	}
+	call := &syncCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	call.result, call.err = fn()
+	return call.result, call.err
+}
+
+// busy reports whether a run with the key is in flight.
+func (c *syncCoordinator) busy(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.inflight[key]
+	return ok
+}
+
+// lockCalendar blocks until the calendar is free and returns the matching unlock.
+func (c *syncCoordinator) lockCalendar(cal CalendarInfo) func() {
+	calendarID := cal.ID
+	if cal.Primary {
+		// Push falls back to the "primary" alias before the calendar list is known.
+		calendarID = primaryCalendarAlias
+	}
+	key := cal.AccountID + "\x00" + calendarID
+	c.mu.Lock()
+	m, ok := c.calendars[key]
+	if !ok {
+		m = &sync.Mutex{}
+		c.calendars[key] = m
+	}
+	c.mu.Unlock()
+	m.Lock()
+	return m.Unlock
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSyncCoordinatorCoalescesConcurrentCallers(t *testing.T) {
+	c := newSyncCoordinator()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var runs int32
+
+	const callers = 8
+	results := make([]GoogleSyncResult, callers)
+	joining := make(chan struct{}, callers)
+	var wg sync.WaitGroup
+	run := func(i int) {
+		defer wg.Done()
+		joining <- struct{}{}
+		results[i], _ = c.do(syncKeyFull, func() (GoogleSyncResult, error) {
+			n := atomic.AddInt32(&runs, 1)
+			close(started)
+			<-release
+			return GoogleSyncResult{Pulled: int(n)}, nil
+		})
+	}
+	wg.Add(1)
+	go run(0)
+	<-started
+	for i := 1; i < callers; i++ {
+		wg.Add(1)
+		go run(i)
+	}
+	// The first sync stays blocked until every other caller is on its way in.
+	for i := 0; i < callers; i++ {
+		<-joining
+	}
+	if !c.busy(syncKeyFull) {
+		t.Fatal("expected sync to be in flight")
+	}
+	close(release)
+	wg.Wait()
+
+	if runs != 1 {
+		t.Fatalf("fn ran %d times, want 1", runs)
+	}
+	for i, r := range results {
+		if r.Pulled != 1 {
+			t.Fatalf("caller %d got %+v, want the shared result", i, r)
+		}
+	}
+	if c.busy(syncKeyFull) {
+		t.Fatal("sync still marked in flight")
+	}
+}
+
+func TestSyncCoordinatorSeparateKeysRunIndependently(t *testing.T) {
+	c := newSyncCoordinator()
+	release := make(chan struct{})
+	var running int32
+	var wg sync.WaitGroup
+	for _, key := range []string{syncKeyFull, syncKeyPush} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			c.do(key, func() (GoogleSyncResult, error) {
+				atomic.AddInt32(&running, 1)
+				<-release
+				return GoogleSyncResult{}, nil
+			})
+		}(key)
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&running) == 2 })
+	close(release)
+	wg.Wait()
+}
+
+func TestSyncCoordinatorLockCalendarIsExclusive(t *testing.T) {
+	c := newSyncCoordinator()
+	var active, maxActive int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := c.lockCalendar(CalendarInfo{AccountID: "acct", ID: "cal"})
+			defer unlock()
+			n := atomic.AddInt32(&active, 1)
+			for {
+				m := atomic.LoadInt32(&maxActive)
+				if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&active, -1)
+		}()
+	}
+	wg.Wait()
+	if maxActive != 1 {
+		t.Fatalf("%d goroutines held the calendar at once", maxActive)
+	}
+
+	// Other calendars are not blocked by a held one.
+	unlock := c.lockCalendar(CalendarInfo{AccountID: "acct", ID: "cal"})
+	defer unlock()
+	done := make(chan struct{})
+	go func() {
+		c.lockCalendar(CalendarInfo{AccountID: "acct", ID: "other"})()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("locking another calendar blocked")
+	}
+}
+
+// TestConcurrentSyncPushesNewEventOnce hammers GoogleSync and GooglePush while settings
+// and client config change underneath, and checks the pending row is created only once.
+func TestConcurrentSyncPushesNewEventOnce(t *testing.T) {
+	a := newSyncTestApp(t)
+	api := &fakeCalendarTransport{}
+	a.google.httpClient = &http.Client{Transport: api}
+
+	if _, err := a.CreateEvent(CalendarEvent{
+		Title:      "double click",
+		Start:      "2024-05-01T10:00:00Z",
+		End:        "2024-05-01T11:00:00Z",
+		SyncStatus: "new",
+		AccountID:  defaultAccountID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if _, err := a.GoogleSync(); err != nil {
+				t.Errorf("sync: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := a.GooglePush(); err != nil {
+				t.Errorf("push: %v", err)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if _, err := a.UpdateSettings(AppSettings{ConflictPolicy: ConflictPolicyManual, SyncIntervalMinutes: i}); err != nil {
+				t.Errorf("update settings: %v", err)
+			}
+			_ = a.currentSettings()
+		}(i)
+	}
+	wg.Wait()
+
+	if n := api.creates.Load(); n != 1 {
+		t.Fatalf("event created %d times on Google, want 1", n)
+	}
+	events, err := a.ListEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].SyncStatus != "synced" {
+		t.Fatalf("unexpected local events: %+v", events)
+	}
+}
+
+func TestConcurrentClientConfigAndSettings(t *testing.T) {
+	a := newSyncTestApp(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(4)
+		go func(i int) {
+			defer wg.Done()
+			_ = a.SaveGoogleClientConfig(fmt.Sprintf("client-%d", i), "secret")
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, _ = a.GetGoogleClientConfig()
+			_, _ = a.GoogleAuthURL("state")
+		}()
+		go func() {
+			defer wg.Done()
+			_ = a.SetActiveAccount(defaultAccountID)
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = a.GetSettings()
+			_ = a.activeAccountID()
+		}()
+	}
+	wg.Wait()
+	cfg := a.currentSettings()
+	if !strings.HasPrefix(cfg.GoogleClientID, "client-") || cfg.ActiveAccountID != defaultAccountID {
+		t.Fatalf("settings lost an update: %+v", cfg)
+	}
+}
+
+func newSyncTestApp(t *testing.T) *App {
+	t.Helper()
+	a := newTestApp(t)
+	a.google = NewGoogleSyncService(GoogleOAuthConfig{ClientID: "id", ClientSecret: "secret", RedirectURI: "http://localhost/cb"}, nil)
+	if _, err := a.db.Exec(`INSERT INTO accounts (id, email) VALUES (?, 'me@example.com')`, defaultAccountID); err != nil {
+		t.Fatal(err)
+	}
+	store, err := accountTokenStore(defaultAccountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(OAuthTokens{AccessToken: "token", Expiry: time.Now().Add(time.Hour).Format(time.RFC3339)}); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// fakeCalendarTransport answers the Calendar API calls made during a sync with one
+// empty primary calendar, and counts event inserts.
+type fakeCalendarTransport struct {
+	creates atomic.Int32
+}
+
+func (f *fakeCalendarTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.URL.Path
+	switch {
+	case strings.HasSuffix(path, "/users/me/calendarList"):
+		return jsonResponse(http.StatusOK, map[string]any{
+			"items": []map[string]any{{"id": "me@example.com", "summary": "me", "primary": true, "selected": true, "accessRole": "owner"}},
+		}), nil
+	case strings.HasSuffix(path, "/events") && req.Method == http.MethodGet:
+		return jsonResponse(http.StatusOK, map[string]any{"items": []any{}, "nextSyncToken": "token-1"}), nil
+	case strings.HasSuffix(path, "/events") && req.Method == http.MethodPost:
+		n := f.creates.Add(1)
+		// Widen the window in which a second push could pick up the same row.
+		time.Sleep(20 * time.Millisecond)
+		return jsonResponse(http.StatusOK, map[string]any{
+			"id": fmt.Sprintf("created-%d", n), "etag": "\"1\"", "status": "confirmed", "updated": time.Now().UTC().Format(time.RFC3339),
+		}), nil
+	}
+	return jsonResponse(http.StatusNotFound, map[string]any{"error": map[string]any{"code": 404}}), nil
+}
+
+func jsonResponse(status int, body any) *http.Response {
+	data, _ := json.Marshal(body)
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(string(data))),
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
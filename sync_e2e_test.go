@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"myapp/internal/fakegcal"
+)
+
+// newE2EApp returns an App backed by an in-memory database and syncing one account
+// against srv.
+func newE2EApp(t *testing.T, srv *fakegcal.Server) *App {
+	t.Helper()
+	useTempConfigDir(t)
+	a := NewApp()
+	if err := a.openDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.db.Close() })
+	a.google = newFakeGoogleService(t, srv)
+	a.google.sleep = func(context.Context, time.Duration) error { return nil }
+	if _, err := a.db.Exec(`INSERT INTO accounts (id, email) VALUES (?, ?)`, defaultAccountID, srv.User.Email); err != nil {
+		t.Fatal(err)
+	}
+	store, err := accountTokenStore(defaultAccountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(OAuthTokens{AccessToken: fakegcal.AccessToken, RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour).Format(time.RFC3339)}); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// eventRow is the sync-relevant part of an events row.
+type eventRow struct {
+	ID            string
+	Title         string
+	SyncStatus    string
+	GoogleEventID string
+	GoogleETag    string
+}
+
+// eventRows returns every events row, including ones marked deleted, keyed by title.
+func eventRows(t *testing.T, a *App) map[string]eventRow {
+	t.Helper()
+	rows, err := a.db.Query(`SELECT id, title, sync_status, COALESCE(remote_id,''), COALESCE(remote_etag,'') FROM events`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	out := make(map[string]eventRow)
+	for rows.Next() {
+		var r eventRow
+		if err := rows.Scan(&r.ID, &r.Title, &r.SyncStatus, &r.GoogleEventID, &r.GoogleETag); err != nil {
+			t.Fatal(err)
+		}
+		out[r.Title] = r
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// remoteSummaries returns the summaries of the primary calendar's live events.
+func remoteSummaries(srv *fakegcal.Server) map[string]fakegcal.Event {
+	out := make(map[string]fakegcal.Event)
+	for _, ev := range srv.Events(fakegcal.PrimaryCalendarID) {
+		out[ev.Summary()] = ev
+	}
+	return out
+}
+
+func remoteEvent(summary string) fakegcal.Event {
+	return fakegcal.Event{
+		"summary": summary,
+		"start":   map[string]any{"dateTime": "2024-05-01T09:00:00Z"},
+		"end":     map[string]any{"dateTime": "2024-05-01T10:00:00Z"},
+	}
+}
+
+func mustSync(t *testing.T, a *App) GoogleSyncResult {
+	t.Helper()
+	result, err := a.GoogleSync()
+	if err != nil {
+		t.Fatalf("sync: %v (%+v)", err, result)
+	}
+	return result
+}
+
+// pullRemote puts a remote event on the fake server and syncs it down.
+func pullRemote(t *testing.T, a *App, srv *fakegcal.Server, summary string) fakegcal.Event {
+	t.Helper()
+	ev := srv.PutEvent(fakegcal.PrimaryCalendarID, remoteEvent(summary))
+	mustSync(t, a)
+	return ev
+}
+
+func TestGoogleSyncEndToEnd(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares local and remote state; the final sync runs afterwards.
+		setup func(t *testing.T, a *App, srv *fakegcal.Server)
+		check func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error)
+	}{
+		{
+			name: "pulls a new remote event",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				srv.PutEvent(fakegcal.PrimaryCalendarID, remoteEvent("remote"))
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				remote := remoteSummaries(srv)["remote"]
+				row := eventRows(t, a)["remote"]
+				if err != nil || result.Pulled != 1 || !result.FullSync {
+					t.Fatalf("result %+v, %v", result, err)
+				}
+				if row.SyncStatus != "synced" || row.GoogleEventID != remote.ID() || row.GoogleETag != remote.ETag() {
+					t.Fatalf("row %+v, remote %v", row, remote)
+				}
+			},
+		},
+		{
+			name: "pushes a new local event",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				if _, err := a.CreateEvent(localEvent("local")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				remote, ok := remoteSummaries(srv)["local"]
+				row := eventRows(t, a)["local"]
+				if err != nil || result.Pushed != 1 || !ok {
+					t.Fatalf("result %+v, %v; remote %v", result, err, remote)
+				}
+				if row.SyncStatus != "synced" || row.GoogleEventID != remote.ID() || row.GoogleETag != remote.ETag() {
+					t.Fatalf("row %+v, remote %v", row, remote)
+				}
+			},
+		},
+		{
+			name: "pushes an edit of a synced event",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				pullRemote(t, a, srv, "before")
+				e, err := a.getEvent(eventRows(t, a)["before"].ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				e.Title = "after"
+				if _, err := a.UpdateEvent(e); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				remote := remoteSummaries(srv)
+				row := eventRows(t, a)["after"]
+				if err != nil || result.Pushed != 1 || len(remote) != 1 {
+					t.Fatalf("result %+v, %v; remote %v", result, err, remote)
+				}
+				if row.SyncStatus != "synced" || row.GoogleETag != remote["after"].ETag() {
+					t.Fatalf("row %+v, remote %v", row, remote)
+				}
+				if srv.CountRequests(http.MethodPatch, "/events/"+row.GoogleEventID) != 1 {
+					t.Fatalf("requests %+v", srv.Requests())
+				}
+			},
+		},
+		{
+			name: "deletes a synced event removed locally",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				pullRemote(t, a, srv, "doomed")
+				if err := a.DeleteEvent(eventRows(t, a)["doomed"].ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil || result.Pushed != 1 {
+					t.Fatalf("result %+v, %v", result, err)
+				}
+				if rows := eventRows(t, a); len(rows) != 0 {
+					t.Fatalf("rows left: %+v", rows)
+				}
+				if remote := remoteSummaries(srv); len(remote) != 0 {
+					t.Fatalf("remote left: %v", remote)
+				}
+			},
+		},
+		{
+			name: "removes an event cancelled remotely",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				ev := pullRemote(t, a, srv, "cancelled")
+				srv.RemoveEvent(fakegcal.PrimaryCalendarID, ev.ID())
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil || result.Deleted != 1 || result.FullSync {
+					t.Fatalf("result %+v, %v", result, err)
+				}
+				if rows := eventRows(t, a); len(rows) != 0 {
+					t.Fatalf("rows left: %+v", rows)
+				}
+			},
+		},
+		{
+			name: "ignores a remote event cancelled before it was seen",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				mustSync(t, a)
+				ev := srv.PutEvent(fakegcal.PrimaryCalendarID, remoteEvent("never seen"))
+				srv.RemoveEvent(fakegcal.PrimaryCalendarID, ev.ID())
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rows := eventRows(t, a); len(rows) != 0 {
+					t.Fatalf("rows: %+v", rows)
+				}
+				if n := srv.CountRequests(http.MethodDelete, ""); n != 0 {
+					t.Fatalf("%d delete requests", n)
+				}
+			},
+		},
+		{
+			name: "falls back to a full sync when the sync token expires",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				pullRemote(t, a, srv, "first")
+				srv.ExpireSyncTokens()
+				srv.PutEvent(fakegcal.PrimaryCalendarID, remoteEvent("second"))
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil || !result.FullSync || result.Pulled != 2 {
+					t.Fatalf("result %+v, %v", result, err)
+				}
+				rows := eventRows(t, a)
+				if len(rows) != 2 || rows["second"].SyncStatus != "synced" {
+					t.Fatalf("rows %+v", rows)
+				}
+				if n := countRows(t, a, `SELECT full_sync_count FROM calendar_sync_state WHERE calendar_id = ?`, fakegcal.PrimaryCalendarID); n != 2 {
+					t.Fatalf("full_sync_count = %d, want 2", n)
+				}
+			},
+		},
+		{
+			name: "parks a conflict when the push hits a stale etag",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				pullRemote(t, a, srv, "shared")
+				mustExec(t, a, `UPDATE events SET title = 'mine', sync_status = 'dirty', remote_etag = '"stale"'`)
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil || result.Pushed != 0 {
+					t.Fatalf("result %+v, %v", result, err)
+				}
+				row := eventRows(t, a)["mine"]
+				if row.SyncStatus != "conflict" {
+					t.Fatalf("row %+v", row)
+				}
+				if n := countRows(t, a, `SELECT COUNT(*) FROM event_conflicts WHERE event_id = ?`, row.ID); n != 1 {
+					t.Fatalf("%d conflicts recorded", n)
+				}
+				if _, ok := remoteSummaries(srv)["shared"]; !ok {
+					t.Fatal("remote event overwritten")
+				}
+			},
+		},
+		{
+			name: "deletes an event whose ETag moved without an edit",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				pullRemote(t, a, srv, "moved")
+				if err := a.DeleteEvent(eventRows(t, a)["moved"].ID); err != nil {
+					t.Fatal(err)
+				}
+				mustExec(t, a, `UPDATE events SET remote_etag = '"stale"'`)
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil || result.Pushed != 0 {
+					t.Fatalf("result %+v, %v", result, err)
+				}
+				conditional := false
+				for _, req := range srv.Requests() {
+					conditional = conditional || (req.Method == http.MethodDelete && req.IfMatch == `"stale"`)
+				}
+				if !conditional {
+					t.Fatalf("requests %+v", srv.Requests())
+				}
+				// The deletion is retried against the ETag read back.
+				mustSync(t, a)
+				if _, ok := remoteSummaries(srv)["moved"]; ok {
+					t.Fatal("remote copy kept")
+				}
+				if n := countRows(t, a, `SELECT COUNT(*) FROM events`); n != 0 {
+					t.Fatalf("%d rows left", n)
+				}
+			},
+		},
+		{
+			name: "parks a local deletion of an event edited remotely",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				pullRemote(t, a, srv, "edited")
+				// The pull has not seen the edit; the delete finds it.
+				mustExec(t, a, `UPDATE events SET title = 'before', remote_etag = '"stale"'`)
+				if err := a.DeleteEvent(eventRows(t, a)["before"].ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil || result.Pushed != 0 {
+					t.Fatalf("result %+v, %v", result, err)
+				}
+				conflicts, err := a.ListConflicts()
+				if err != nil || len(conflicts) != 1 || !conflicts[0].LocalDeleted || conflicts[0].Remote.Title != "edited" {
+					t.Fatalf("conflicts %+v, %v", conflicts, err)
+				}
+				if _, ok := remoteSummaries(srv)["edited"]; !ok {
+					t.Fatal("remote copy deleted")
+				}
+				// Keeping the deletion deletes it on the next sync.
+				if _, err := a.ResolveConflict(conflicts[0].EventID, ConflictKeepLocal, nil); err != nil {
+					t.Fatal(err)
+				}
+				mustSync(t, a)
+				if _, ok := remoteSummaries(srv)["edited"]; ok {
+					t.Fatal("remote copy kept")
+				}
+				if n := countRows(t, a, `SELECT COUNT(*) FROM events`); n != 0 {
+					t.Fatalf("%d rows left", n)
+				}
+			},
+		},
+		{
+			name: "parks a conflict when both sides changed",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				ev := pullRemote(t, a, srv, "shared")
+				mustExec(t, a, `UPDATE events SET title = 'mine', sync_status = 'dirty'`)
+				ev["summary"] = "theirs"
+				srv.PutEvent(fakegcal.PrimaryCalendarID, ev)
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				row := eventRows(t, a)["mine"]
+				if row.SyncStatus != "conflict" {
+					t.Fatalf("row %+v", row)
+				}
+				if _, ok := remoteSummaries(srv)["theirs"]; !ok {
+					t.Fatal("remote event overwritten")
+				}
+				if n := srv.CountRequests(http.MethodPatch, ""); n != 0 {
+					t.Fatalf("%d patch requests", n)
+				}
+			},
+		},
+		{
+			name: "applies the remote-wins policy and keeps history",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				cfg := a.currentSettings()
+				cfg.ConflictPolicy = ConflictPolicyRemoteWins
+				a.setSettings(cfg)
+				ev := pullRemote(t, a, srv, "shared")
+				mustExec(t, a, `UPDATE events SET title = 'mine', sync_status = 'dirty'`)
+				ev["summary"] = "theirs"
+				srv.PutEvent(fakegcal.PrimaryCalendarID, ev)
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows := eventRows(t, a)
+				if len(rows) != 1 || rows["theirs"].SyncStatus != "synced" {
+					t.Fatalf("rows %+v", rows)
+				}
+				if n := countRows(t, a, `SELECT COUNT(*) FROM conflict_history WHERE winner = 'remote'`); n != 1 {
+					t.Fatalf("%d history entries", n)
+				}
+			},
+		},
+		{
+			name: "applies the local-wins policy and pushes the local copy",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				cfg := a.currentSettings()
+				cfg.ConflictPolicy = ConflictPolicyLocalWins
+				a.setSettings(cfg)
+				ev := pullRemote(t, a, srv, "shared")
+				mustExec(t, a, `UPDATE events SET title = 'mine', sync_status = 'dirty'`)
+				ev["summary"] = "theirs"
+				srv.PutEvent(fakegcal.PrimaryCalendarID, ev)
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil || result.Pushed != 1 {
+					t.Fatalf("result %+v, %v", result, err)
+				}
+				remote := remoteSummaries(srv)
+				row := eventRows(t, a)["mine"]
+				if len(remote) != 1 || row.SyncStatus != "synced" || row.GoogleETag != remote["mine"].ETag() {
+					t.Fatalf("row %+v, remote %v", row, remote)
+				}
+			},
+		},
+		{
+			name: "recreates an edited event purged remotely",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				ev := pullRemote(t, a, srv, "purged")
+				mustExec(t, a, `UPDATE events SET title = 'revived', sync_status = 'dirty'`)
+				srv.PurgeEvent(fakegcal.PrimaryCalendarID, ev.ID())
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil || result.Pushed != 1 {
+					t.Fatalf("result %+v, %v", result, err)
+				}
+				remote := remoteSummaries(srv)["revived"]
+				row := eventRows(t, a)["revived"]
+				if remote == nil || row.SyncStatus != "synced" || row.GoogleEventID != remote.ID() {
+					t.Fatalf("row %+v, remote %v", row, remote)
+				}
+			},
+		},
+		{
+			name: "does not duplicate an event whose Google ID was not recorded",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				if _, err := a.CreateEvent(localEvent("once")); err != nil {
+					t.Fatal(err)
+				}
+				// Lose the write that maps the row to its new Google event.
+				mustExec(t, a, `CREATE TRIGGER lose_mapping BEFORE UPDATE OF remote_id ON events BEGIN SELECT RAISE(ABORT, 'disk I/O error'); END`)
+				if _, err := a.GoogleSync(); err == nil {
+					t.Fatal("sync recorded the mapping despite the failing write")
+				}
+				mustExec(t, a, `DROP TRIGGER lose_mapping`)
+				mustExec(t, a, `UPDATE events SET title = 'once, edited'`)
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				remote := remoteSummaries(srv)
+				row := eventRows(t, a)["once, edited"]
+				if len(remote) != 1 || remote["once, edited"] == nil {
+					t.Fatalf("remote %v", remote)
+				}
+				if row.SyncStatus != "synced" || row.GoogleEventID != googleEventIDFor(row.ID) {
+					t.Fatalf("row %+v", row)
+				}
+				if n := srv.CountRequests(http.MethodPost, "/events"); n != 1 {
+					t.Fatalf("%d inserts", n)
+				}
+			},
+		},
+		{
+			name: "drops a local deletion that never reached Google",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				if _, err := a.CreateEvent(localEvent("unsent")); err != nil {
+					t.Fatal(err)
+				}
+				mustExec(t, a, `UPDATE events SET sync_status = 'deleted'`)
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rows := eventRows(t, a); len(rows) != 0 {
+					t.Fatalf("rows left: %+v", rows)
+				}
+				if n := srv.CountRequests(http.MethodDelete, ""); n != 0 {
+					t.Fatalf("%d delete requests", n)
+				}
+			},
+		},
+		{
+			name: "pulls every page of a full sync",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				srv.PageSize = 2
+				for _, s := range []string{"a", "b", "c", "d", "e"} {
+					srv.PutEvent(fakegcal.PrimaryCalendarID, remoteEvent(s))
+				}
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil || result.Pulled != 5 {
+					t.Fatalf("result %+v, %v", result, err)
+				}
+				if rows := eventRows(t, a); len(rows) != 5 {
+					t.Fatalf("rows %+v", rows)
+				}
+			},
+		},
+		{
+			name: "retries transient server errors",
+			setup: func(t *testing.T, a *App, srv *fakegcal.Server) {
+				if _, err := a.CreateEvent(localEvent("local")); err != nil {
+					t.Fatal(err)
+				}
+				srv.PutEvent(fakegcal.PrimaryCalendarID, remoteEvent("remote"))
+				srv.FailNext(2, http.StatusServiceUnavailable, "")
+			},
+			check: func(t *testing.T, a *App, srv *fakegcal.Server, result GoogleSyncResult, err error) {
+				if err != nil || result.Pulled != 1 || result.Pushed != 1 {
+					t.Fatalf("result %+v, %v", result, err)
+				}
+				if n := srv.CountRequests(http.MethodPost, "/events"); n != 1 {
+					t.Fatalf("%d inserts", n)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fakegcal.New()
+			defer srv.Close()
+			a := newE2EApp(t, srv)
+			tt.setup(t, a, srv)
+			result, err := a.GoogleSync()
+			tt.check(t, a, srv, result, err)
+		})
+	}
+}
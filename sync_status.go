@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// defaultAccountID identifies the account connected before multi-account support existed.
+const defaultAccountID = "default"
+
+// syncStaleAfter is how long a calendar may go without a successful sync before it is reported stale.
+const syncStaleAfter = time.Hour
+
+// CalendarSyncStatus reports the sync health of one calendar.
+type CalendarSyncStatus struct {
+	AccountID     string `json:"accountId"`
+	CalendarID    string `json:"calendarId"`
+	CalendarName  string `json:"calendarName"`
+	Enabled       bool   `json:"enabled"`
+	HasSyncToken  bool   `json:"hasSyncToken"`
+	LastSyncAt    string `json:"lastSyncAt,omitempty"`
+	LastSuccessAt string `json:"lastSuccessAt,omitempty"`
+	LastError     string `json:"lastError,omitempty"`
+	FullSyncCount int    `json:"fullSyncCount"`
+	Stale         bool   `json:"stale"`
+}
+
+func ensureCalendarSyncStateTable(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS calendar_sync_state (
+		account_id TEXT NOT NULL,
+		calendar_id TEXT NOT NULL,
+		sync_token TEXT,
+		last_sync_at TIMESTAMP,
+		last_success_at TIMESTAMP,
+		last_error TEXT,
+		full_sync_count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (account_id, calendar_id)
+	);
+	`
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	return migrateLegacySyncTokens(db)
+}
+
+// migrateLegacySyncTokens moves the single-calendar sync token into calendar_sync_state.
+// It always belonged to the primary calendar of the first account.
+func migrateLegacySyncTokens(db *sql.DB) error {
+	var token string
+	err := db.QueryRow(`SELECT COALESCE(value,'') FROM sync_state WHERE key = 'google_sync_token'`).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		if _, err := db.Exec(`
+			INSERT INTO calendar_sync_state (account_id, calendar_id, sync_token) VALUES (?, ?, ?)
+			ON CONFLICT(account_id, calendar_id) DO NOTHING
+		`, defaultAccountID, primaryCalendarAlias, token); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(`DELETE FROM sync_state WHERE key = 'google_sync_token'`)
+	return err
+}
+
+// GetSyncStatus returns per-calendar sync state so the UI can point out stale calendars.
+func (a *App) GetSyncStatus() ([]CalendarSyncStatus, error) {
+	if a.db == nil {
+		return nil, errors.New("db not initialised")
+	}
+	rows, err := a.db.Query(`
+		SELECT c.account_id, c.id, c.summary, c.enabled, COALESCE(s.sync_token,''), s.last_sync_at, s.last_success_at, COALESCE(s.last_error,''), COALESCE(s.full_sync_count,0)
+		FROM calendars c
+		LEFT JOIN calendar_sync_state s ON s.calendar_id = c.id AND s.account_id = c.account_id
+		ORDER BY c.account_id ASC, c.is_primary DESC, c.summary COLLATE NOCASE ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var statuses []CalendarSyncStatus
+	for rows.Next() {
+		var st CalendarSyncStatus
+		var enabled int
+		var token string
+		var lastSync, lastSuccess sql.NullTime
+		if err := rows.Scan(&st.AccountID, &st.CalendarID, &st.CalendarName, &enabled, &token, &lastSync, &lastSuccess, &st.LastError, &st.FullSyncCount); err != nil {
+			return nil, err
+		}
+		st.Enabled = enabled == 1
+		st.HasSyncToken = token != ""
+		if lastSync.Valid {
+			st.LastSyncAt = lastSync.Time.Format(time.RFC3339)
+		}
+		if lastSuccess.Valid {
+			st.LastSuccessAt = lastSuccess.Time.Format(time.RFC3339)
+		}
+		st.Stale = st.Enabled && (st.LastError != "" || !lastSuccess.Valid || now.Sub(lastSuccess.Time) > syncStaleAfter)
+		statuses = append(statuses, st)
+	}
+	return statuses, rows.Err()
+}
+
+func (a *App) calendarSyncToken(accountID, calendarID string) (string, error) {
+	if a.db == nil {
+		return "", errors.New("db not initialised")
+	}
+	var token sql.NullString
+	err := a.db.QueryRow(`SELECT sync_token FROM calendar_sync_state WHERE account_id = ? AND calendar_id = ?`, accountID, calendarID).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return token.String, err
+}
+
+// setCalendarSyncToken stores the token returned by a completed pull.
+func (a *App) setCalendarSyncToken(accountID, calendarID, token string, fullSync bool) error {
+	if a.db == nil {
+		return errors.New("db not initialised")
+	}
+	_, err := a.db.Exec(`
+		INSERT INTO calendar_sync_state (account_id, calendar_id, sync_token, full_sync_count) VALUES (?, ?, ?, ?)
+		ON CONFLICT(account_id, calendar_id) DO UPDATE SET
+			sync_token=excluded.sync_token,
+			full_sync_count=calendar_sync_state.full_sync_count + excluded.full_sync_count
+	`, accountID, calendarID, token, boolToInt(fullSync))
+	return err
+}
+
+// resetCalendarSyncToken forces the next sync of the calendar to be a full sync.
+func (a *App) resetCalendarSyncToken(accountID, calendarID string) error {
+	if a.db == nil {
+		return errors.New("db not initialised")
+	}
+	_, err := a.db.Exec(`UPDATE calendar_sync_state SET sync_token = '' WHERE account_id = ? AND calendar_id = ?`, accountID, calendarID)
+	return err
+}
+
+// recordCalendarSync notes the outcome of a sync attempt.
+func (a *App) recordCalendarSync(accountID, calendarID string, syncErr error) error {
+	if a.db == nil {
+		return errors.New("db not initialised")
+	}
+	now := time.Now()
+	if syncErr != nil {
+		_, err := a.db.Exec(`
+			INSERT INTO calendar_sync_state (account_id, calendar_id, last_sync_at, last_error) VALUES (?, ?, ?, ?)
+			ON CONFLICT(account_id, calendar_id) DO UPDATE SET last_sync_at=excluded.last_sync_at, last_error=excluded.last_error
+		`, accountID, calendarID, now, syncErr.Error())
+		return err
+	}
+	_, err := a.db.Exec(`
+		INSERT INTO calendar_sync_state (account_id, calendar_id, last_sync_at, last_success_at, last_error) VALUES (?, ?, ?, ?, '')
+		ON CONFLICT(account_id, calendar_id) DO UPDATE SET last_sync_at=excluded.last_sync_at, last_success_at=excluded.last_success_at, last_error=''
+	`, accountID, calendarID, now, now)
+	return err
+}
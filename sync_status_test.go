@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func syncStatusOf(t *testing.T, a *App, calendarID string) CalendarSyncStatus {
+	t.Helper()
+	statuses, err := a.GetSyncStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range statuses {
+		if st.CalendarID == calendarID {
+			return st
+		}
+	}
+	t.Fatalf("no status for %s in %+v", calendarID, statuses)
+	return CalendarSyncStatus{}
+}
+
+func TestCalendarSyncState(t *testing.T) {
+	a := newTestApp(t)
+	if err := a.storeCalendars(defaultAccountID, []GoogleCalendarListEntry{{ID: "me@example.com", Summary: "Me", Primary: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if st := syncStatusOf(t, a, "me@example.com"); !st.Stale || st.HasSyncToken || st.LastSyncAt != "" {
+		t.Fatalf("never synced %+v", st)
+	}
+
+	// Full syncs are counted; incremental ones only move the token.
+	for _, full := range []bool{true, false, true} {
+		if err := a.setCalendarSyncToken(defaultAccountID, "me@example.com", "token", full); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.recordCalendarSync(defaultAccountID, "me@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	st := syncStatusOf(t, a, "me@example.com")
+	if st.Stale || !st.HasSyncToken || st.FullSyncCount != 2 || st.LastSuccessAt == "" || st.LastError != "" {
+		t.Fatalf("after sync %+v", st)
+	}
+
+	// A failure keeps the last success but makes the calendar stale until the next one.
+	if err := a.recordCalendarSync(defaultAccountID, "me@example.com", errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if failed := syncStatusOf(t, a, "me@example.com"); !failed.Stale || failed.LastError != "boom" || failed.LastSuccessAt != st.LastSuccessAt {
+		t.Fatalf("after failure %+v", failed)
+	}
+	if err := a.recordCalendarSync(defaultAccountID, "me@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	if st := syncStatusOf(t, a, "me@example.com"); st.Stale || st.LastError != "" {
+		t.Fatalf("after recovery %+v", st)
+	}
+
+	if err := a.resetCalendarSyncToken(defaultAccountID, "me@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := a.calendarSyncToken(defaultAccountID, "me@example.com"); token != "" {
+		t.Fatalf("token after reset %q", token)
+	}
+	if st := syncStatusOf(t, a, "me@example.com"); st.HasSyncToken || st.FullSyncCount != 2 {
+		t.Fatalf("after reset %+v", st)
+	}
+
+	// Disabled calendars are never stale.
+	mustExec(t, a, `UPDATE calendars SET enabled = 0`)
+	if err := a.recordCalendarSync(defaultAccountID, "me@example.com", errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if st := syncStatusOf(t, a, "me@example.com"); st.Stale {
+		t.Fatalf("disabled calendar %+v", st)
+	}
+}
+
+func TestLegacySyncTokensMigration(t *testing.T) {
+	useTempConfigDir(t)
+	appDir, err := appConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", filepath.Join(appDir, "events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE sync_state (key TEXT PRIMARY KEY, value TEXT)`,
+		`INSERT INTO sync_state (key, value) VALUES ('google_sync_token', 'primary-token'), ('other', 'kept')`,
+		// Keeps the flattened instances migration, which resets every token, out of the way.
+		`INSERT INTO sync_state (key, value) VALUES ('` + flattenedInstancesKey + `', '1')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	a := NewApp()
+	if err := a.initDB(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+	if token, err := a.calendarSyncToken(defaultAccountID, primaryCalendarAlias); err != nil || token != "primary-token" {
+		t.Fatalf("primary token: %q, %v", token, err)
+	}
+	if v, _ := a.syncStateGet("google_sync_token"); v != "" {
+		t.Fatalf("legacy key left: %q", v)
+	}
+	if v, _ := a.syncStateGet("other"); v != "kept" {
+		t.Fatalf("unrelated key %q", v)
+	}
+}